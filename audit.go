@@ -0,0 +1,311 @@
+package pursuit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Types of entries of the audit log.
+const (
+	AuditTokenCreated    = "token.created"
+	AuditTokenRevoked    = "token.revoked"
+	AuditExportRequested = "export.requested"
+	AuditUserImported    = "user.imported"
+	AuditUserMerged      = "user.merged"
+	AuditLockout         = "security.lockout"
+	// AuditUserMergedFrom is recorded on the user that another user was
+	// merged into, with the other user as subject.
+	AuditUserMergedFrom = "user.merged_from"
+)
+
+// AuditEntry records a security-relevant action on the account of a user.
+// Unlike events, audit entries do not expire. They are stored in
+// audit/{user}/entries under their zero-padded sequence number, outside
+// the documents of the user that clients may write. Each entry is chained
+// to the previous one by its hash, so that entries that are changed or
+// removed later are detected, see verifyAudit. With an audit key, the
+// hash is an HMAC, so that entries cannot be forged without the key
+// either, see UseAuditKey.
+type AuditEntry struct {
+	// Seq numbers the entries of a user from 1.
+	Seq  int64  `firestore:"seq" json:"seq"`
+	Type string `firestore:"type" json:"type"`
+	// Subject is what the action was about, such as the ID of a token or
+	// the path of an export.
+	Subject string `firestore:"subject,omitempty" json:"subject,omitempty"`
+	// Token is the ID of the share token that the request was made with,
+	// and is empty if the user signed in.
+	Token    string `firestore:"token,omitempty" json:"token,omitempty"`
+	SourceIP string `firestore:"sourceIP,omitempty" json:"sourceIP,omitempty"`
+	// Date in milliseconds since the epoch.
+	Date int64 `firestore:"date" json:"date"`
+	// Signed reports whether the hash is an HMAC with the audit key.
+	// Entries recorded before the server had a key are only chained by
+	// SHA-256 hashes.
+	Signed bool `firestore:"signed,omitempty" json:"signed,omitempty"`
+	// Prev is the hash of the previous entry, and empty for the first.
+	Prev string `firestore:"prev" json:"prev"`
+	Hash string `firestore:"hash" json:"hash"`
+}
+
+// AuditHead is stored in audit/{user} and points to the latest entry of
+// the audit log of the user, so that removing entries from the end of the
+// log is detected too.
+type AuditHead struct {
+	Seq  int64  `firestore:"seq"`
+	Hash string `firestore:"hash"`
+}
+
+// AuditLog is a page of the audit log of a user, or the entries of it
+// that match a filter. The whole page is verified either way, together
+// with the entry before it, so that reading all pages verifies the whole
+// log.
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	// Verified reports whether the chain of hashes is intact, and Error
+	// describes where it is broken otherwise.
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+	// Next is the sequence number to read the next page after, and zero
+	// on the last page.
+	Next int64 `json:"next,omitempty"`
+}
+
+// maxAuditEntries limits the number of audit entries that are read at
+// once.
+const maxAuditEntries = 1000
+
+// UseAuditKey makes the storage sign new audit entries with an HMAC with
+// the key, and verify signed entries with it. It must be called before
+// the storage is used.
+func (s *Storage) UseAuditKey(key string) {
+	s.auditKey = []byte(key)
+}
+
+// digest returns the hash of the entry, which covers all of its fields
+// except the hash itself. Signed entries are hashed with an HMAC with the
+// key.
+func (e AuditEntry) digest(key []byte) string {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	if !e.Signed {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditID returns the ID of the document of an entry. Sequence numbers
+// are zero-padded so that IDs sort like them.
+func auditID(seq int64) string {
+	return fmt.Sprintf("%012d", seq)
+}
+
+// chainAudit appends the entry to the log with the head, and returns it
+// with its sequence number and hashes. The entry is signed if there is a
+// key.
+func chainAudit(key []byte, head AuditHead, e AuditEntry) AuditEntry {
+	e.Seq = head.Seq + 1
+	e.Prev = head.Hash
+	e.Signed = len(key) > 0
+	e.Hash = e.digest(key)
+	return e
+}
+
+// verifyAudit checks that the entries, oldest first, form an unbroken
+// chain that continues from prev, and ends at the head unless it is nil.
+// Once an entry is signed, all later entries have to be signed too, so
+// that entries cannot be replaced by unsigned ones.
+func verifyAudit(key []byte, prev AuditHead, entries []AuditEntry, head *AuditHead) error {
+	signed := false
+	for _, e := range entries {
+		switch {
+		case e.Seq != prev.Seq+1:
+			return fmt.Errorf("Entry %d is missing", prev.Seq+1)
+		case e.Prev != prev.Hash:
+			return fmt.Errorf("Entry %d does not follow entry %d", e.Seq, prev.Seq)
+		case signed && !e.Signed:
+			return fmt.Errorf("Entry %d is not signed", e.Seq)
+		case e.Signed && len(key) == 0:
+			return fmt.Errorf("Entry %d cannot be verified without the audit key", e.Seq)
+		case !hmac.Equal([]byte(e.Hash), []byte(e.digest(key))):
+			return fmt.Errorf("Entry %d was changed", e.Seq)
+		}
+		signed = e.Signed
+		prev = AuditHead{e.Seq, e.Hash}
+	}
+	if head != nil && prev != *head {
+		return fmt.Errorf("Entries after %d are missing", prev.Seq)
+	}
+	return nil
+}
+
+// recordAudit appends an entry to the audit log of a user. As with events,
+// failures are logged rather than failing the action.
+func (s Storage) recordAudit(userID string, e AuditEntry) {
+	ref := s.collection("audit").Doc(userID)
+	err := s.transaction("recordAudit", func(tx *firestore.Transaction) error {
+		var head AuditHead
+		doc, err := tx.Get(ref)
+		if doc == nil || doc.Exists() {
+			if err != nil {
+				return err
+			}
+			if err := doc.DataTo(&head); err != nil {
+				return err
+			}
+		}
+		e := chainAudit(s.auditKey, head, e)
+		if err := tx.Create(ref.Collection("entries").Doc(auditID(e.Seq)), e); err != nil {
+			return err
+		}
+		return tx.Set(ref, AuditHead{e.Seq, e.Hash})
+	})
+	if err != nil {
+		logf(s.ctx, severityError, "Error recording audit entry %+v of user %q: %v", e, userID, err)
+	}
+}
+
+// ReadAuditLog returns a page of at most limit entries of the audit log
+// of a user that follow the entry with the sequence number after, and
+// match the type, unless it is empty, and are not older than since, in
+// milliseconds since the epoch. Oldest entries come first. Pages are taken
+// from the log before filtering, so that each can be verified, and may
+// hold fewer entries than the limit even if more follow.
+func (s Storage) ReadAuditLog(userID, entryType string, since, after int64, limit int) (AuditLog, error) {
+	if limit <= 0 || limit > maxAuditEntries {
+		limit = maxAuditEntries
+	}
+	ref := s.collection("audit").Doc(userID)
+	var head AuditHead
+	var before *firestore.DocumentSnapshot
+	var docs []*firestore.DocumentSnapshot
+	err := s.transaction("ReadAuditLog", func(tx *firestore.Transaction) error {
+		head = AuditHead{}
+		before = nil
+		doc, err := tx.Get(ref)
+		if doc == nil || doc.Exists() {
+			if err != nil {
+				return fmt.Errorf("Error reading audit log: %w", err)
+			}
+			if err := doc.DataTo(&head); err != nil {
+				return fmt.Errorf("Error reading audit log: %w", err)
+			}
+		}
+		if after > 0 {
+			// The entry before the page links it to the rest of the log.
+			before, err = tx.Get(ref.Collection("entries").Doc(auditID(after)))
+			if err != nil && (before == nil || before.Exists()) {
+				return fmt.Errorf("Error reading audit entry %d: %w", after, err)
+			}
+		}
+		docs, err = tx.Documents(ref.Collection("entries").Where("seq", ">", after).OrderBy("seq", firestore.Asc).Limit(limit)).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error listing audit entries: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return AuditLog{}, err
+	}
+	entries := make([]AuditEntry, len(docs))
+	for i, doc := range docs {
+		if err := doc.DataTo(&entries[i]); err != nil {
+			return AuditLog{}, fmt.Errorf("Error reading audit entry %q: %w", doc.Ref.ID, err)
+		}
+	}
+	l := AuditLog{Entries: []AuditEntry{}, Verified: true}
+	var end *AuditHead
+	if len(entries) < limit || entries[len(entries)-1].Seq >= head.Seq {
+		end = &head
+	} else {
+		l.Next = entries[len(entries)-1].Seq
+	}
+	var prev AuditHead
+	chain := entries
+	if after > 0 {
+		var e AuditEntry
+		if !before.Exists() {
+			err = fmt.Errorf("Entry %d is missing", after)
+		} else if err = before.DataTo(&e); err != nil {
+			return AuditLog{}, fmt.Errorf("Error reading audit entry %q: %w", before.Ref.ID, err)
+		}
+		prev = AuditHead{after - 1, e.Prev}
+		chain = append([]AuditEntry{e}, entries...)
+	}
+	if err == nil {
+		err = verifyAudit(s.auditKey, prev, chain, end)
+	}
+	if err != nil {
+		l.Verified = false
+		l.Error = err.Error()
+	}
+	for _, e := range entries {
+		if (entryType == "" || e.Type == entryType) && e.Date >= since {
+			l.Entries = append(l.Entries, e)
+		}
+	}
+	return l, nil
+}
+
+// audit records a security-relevant action of a request on the account of
+// a user. Servers without storage, such as in tests, keep no audit log.
+func (s *Server) audit(r *http.Request, userID, entryType, subject string) {
+	if s.storage == nil {
+		return
+	}
+	e := AuditEntry{
+		Type:     entryType,
+		Subject:  subject,
+		SourceIP: sourceIP(r),
+		Date:     time.Now().UnixNano() / 1000 / 1000,
+	}
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		e.Token = l.token
+	}
+	// The entry is written with the context of the server rather than of
+	// the request, so that it is not lost if the client goes away right
+	// after the action.
+	s.storage.recordAudit(userID, e)
+}
+
+// auditLog serves
+// GET /users/{user}/audit?type=...&since=...&after=...&limit=..., a page
+// of the audit log of the user, see AuditLog. The next page is read with
+// its Next as after.
+func (s *Server) auditLog(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	f, err := parseEventFilter(r)
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" && err == nil {
+		after, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || after < 0 {
+			err = invalidField("after", "wanted a sequence number, got %q", v)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	l, err := s.storageFor(r).ReadAuditLog(userID, f.Type, f.Since, after, f.Limit)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, l)
+}
@@ -0,0 +1,93 @@
+package pursuit
+
+import "testing"
+
+func TestVerifyAudit(t *testing.T) {
+	var entries []AuditEntry
+	var head AuditHead
+	for i, typ := range []string{AuditTokenCreated, AuditExportRequested, AuditTokenRevoked} {
+		e := chainAudit(nil, head, AuditEntry{Type: typ, Subject: "t1", Date: int64(i)})
+		entries = append(entries, e)
+		head = AuditHead{e.Seq, e.Hash}
+	}
+	if err := verifyAudit(nil, AuditHead{}, entries, &head); err != nil {
+		t.Fatalf("intact log did not verify: %v", err)
+	}
+	if err := verifyAudit(nil, AuditHead{}, nil, &AuditHead{}); err != nil {
+		t.Errorf("empty log did not verify: %v", err)
+	}
+
+	changed := append([]AuditEntry(nil), entries...)
+	changed[1].Subject = "t2"
+	removed := []AuditEntry{entries[0], entries[2]}
+	for name, c := range map[string]struct {
+		head    AuditHead
+		entries []AuditEntry
+	}{
+		"changed entry":   {head, changed},
+		"removed entry":   {head, removed},
+		"removed last":    {head, entries[:2]},
+		"rehashed entry":  {head, append(entries[:1:1], chainAudit(nil, AuditHead{entries[0].Seq, entries[0].Hash}, AuditEntry{Type: AuditUserImported}), entries[2])},
+		"removed history": {AuditHead{}, entries},
+	} {
+		if err := verifyAudit(nil, AuditHead{}, c.entries, &c.head); err == nil {
+			t.Errorf("log with %s verified", name)
+		}
+	}
+}
+
+func TestVerifyAuditPage(t *testing.T) {
+	var entries []AuditEntry
+	var head AuditHead
+	for i := 0; i < 4; i++ {
+		e := chainAudit(nil, head, AuditEntry{Type: AuditTokenCreated, Date: int64(i)})
+		entries = append(entries, e)
+		head = AuditHead{e.Seq, e.Hash}
+	}
+	// A page after entry 2 is verified together with entry 2.
+	prev := AuditHead{1, entries[1].Prev}
+	if err := verifyAudit(nil, prev, entries[1:3], nil); err != nil {
+		t.Errorf("page did not verify: %v", err)
+	}
+	if err := verifyAudit(nil, prev, entries[1:], &head); err != nil {
+		t.Errorf("last page did not verify: %v", err)
+	}
+	if err := verifyAudit(nil, prev, entries[1:3], &head); err == nil {
+		t.Error("last page without last entry verified")
+	}
+}
+
+func TestVerifyAuditSigned(t *testing.T) {
+	key := []byte("secret")
+	legacy := chainAudit(nil, AuditHead{}, AuditEntry{Type: AuditTokenCreated})
+	signed := chainAudit(key, AuditHead{legacy.Seq, legacy.Hash}, AuditEntry{Type: AuditTokenRevoked})
+	head := AuditHead{signed.Seq, signed.Hash}
+	if !signed.Signed || legacy.Signed {
+		t.Fatalf("entries were %+v, %+v; wanted only the second signed", legacy, signed)
+	}
+	if err := verifyAudit(key, AuditHead{}, []AuditEntry{legacy, signed}, &head); err != nil {
+		t.Errorf("log signed after legacy entry did not verify: %v", err)
+	}
+
+	changed := signed
+	changed.Subject = "t2"
+	for name, c := range map[string]struct {
+		key     []byte
+		entries []AuditEntry
+	}{
+		"wrong key":     {[]byte("guess"), []AuditEntry{legacy, signed}},
+		"missing key":   {nil, []AuditEntry{legacy, signed}},
+		"changed entry": {key, []AuditEntry{legacy, changed}},
+	} {
+		h := AuditHead{c.entries[1].Seq, c.entries[1].Hash}
+		if err := verifyAudit(c.key, AuditHead{}, c.entries, &h); err == nil {
+			t.Errorf("log with %s verified", name)
+		}
+	}
+
+	unsigned := chainAudit(nil, head, AuditEntry{Type: AuditUserImported})
+	h := AuditHead{unsigned.Seq, unsigned.Hash}
+	if err := verifyAudit(key, AuditHead{}, []AuditEntry{legacy, signed, unsigned}, &h); err == nil {
+		t.Error("unsigned entry after signed entry verified")
+	}
+}
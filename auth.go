@@ -0,0 +1,171 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+)
+
+// ErrUnauthenticated is wrapped by errors about requests that do not
+// prove who sent them.
+var ErrUnauthenticated = errors.New("Unauthenticated")
+
+// IDTokenVerifier verifies the ID tokens of signed-in users.
+type IDTokenVerifier interface {
+	// VerifyIDToken returns the UID of the user that the token was issued
+	// to.
+	VerifyIDToken(token string) (string, error)
+}
+
+// firebaseAuth verifies ID tokens issued by Firebase Auth.
+type firebaseAuth struct {
+	client *auth.Client
+}
+
+// NewFirebaseAuth creates an ID token verifier for a particular project.
+func NewFirebaseAuth(projectID string) IDTokenVerifier {
+	ctx := context.Background()
+	conf := &firebase.Config{ProjectID: projectID}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	client, err := app.Auth(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return firebaseAuth{client}
+}
+
+func (a firebaseAuth) VerifyIDToken(token string) (string, error) {
+	t, err := a.client.VerifyIDToken(context.Background(), token)
+	if err != nil {
+		return "", fmt.Errorf("Invalid ID token: %v: %w", err, ErrUnauthenticated)
+	}
+	return t.UID, nil
+}
+
+// isIDToken tells ID tokens, which are JWTs, apart from the secrets of
+// share tokens, which contain no dots.
+func isIDToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// authenticate returns the UID of the user whose ID token the request
+// carries as bearer token. Requests that change data fail once the user
+// exceeds the rate limit, see LimitRate.
+func (s *Server) authenticate(r *http.Request) (string, error) {
+	return s.authenticateToken(r, bearerToken(r))
+}
+
+// authenticateToken returns the UID of the user that the ID token was
+// issued to, as authenticate does.
+func (s *Server) authenticateToken(r *http.Request, token string) (string, error) {
+	switch {
+	case token == "" || !isIDToken(token):
+		return "", fmt.Errorf("Missing ID token: %w", ErrUnauthenticated)
+	case s.auth == nil:
+		return "", fmt.Errorf("ID tokens cannot be verified: %w", ErrUnauthenticated)
+	}
+	uid, err := s.auth.VerifyIDToken(token)
+	if err != nil {
+		return "", err
+	}
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		l.user = uid
+	}
+	if err := s.limitRate(r, uid); err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+// verifyUser checks that the ID token belongs to the user, for requests
+// that need proof of a second account besides the one they are made for.
+func (s *Server) verifyUser(idToken, userID string) error {
+	if s.auth == nil {
+		return fmt.Errorf("ID tokens cannot be verified: %w", ErrUnauthenticated)
+	}
+	uid, err := s.auth.VerifyIDToken(idToken)
+	if err != nil {
+		return err
+	}
+	if uid != userID {
+		return fmt.Errorf("ID token is not of user %q: %w", userID, ErrForbidden)
+	}
+	return nil
+}
+
+// authenticateUser replies with an error and returns false unless the
+// request carries an ID token of the user, or a share token of the user
+// that grants the API scope, see routeScope. Streams also take the ID
+// token as token parameter, since EventSource cannot send headers, see
+// streamToken.
+func (s *Server) authenticateUser(w http.ResponseWriter, r *http.Request, userID, scope string) bool {
+	var uid string
+	var err error
+	if token := bearerToken(r); token != "" && !isIDToken(token) {
+		uid, err = s.authorizeAPI(r, token, scope)
+	} else if token == "" {
+		uid, err = s.authenticateToken(r, streamToken(r))
+	} else {
+		uid, err = s.authenticate(r)
+	}
+	if err == nil && uid != userID {
+		err = fmt.Errorf("Cannot access user %q: %w", userID, ErrForbidden)
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return false
+	}
+	return true
+}
+
+// routeScope returns the API scope that a share token needs for a request
+// below /users/{user}, given the segments of its path. Reading objectives
+// and their history, including exports, needs ScopeObjectivesRead, and
+// submitting check-ins needs ScopeValuesWrite. Integrations, devices,
+// webhooks and goal hooks need ScopeIntegrationsManage, also for reading,
+// as their target URLs are often secrets. Everything else, such as
+// changing objectives, managing tokens or reading the audit log, needs
+// ScopeAdmin.
+func routeScope(method string, parts []string) string {
+	switch {
+	case parts[2] == "strava" || parts[2] == "imports" || parts[2] == "devices":
+		return ScopeIntegrationsManage
+	case len(parts) >= 5 && parts[2] == "objectives" && parts[4] == "webhooks":
+		return ScopeIntegrationsManage
+	case len(parts) >= 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "hooks":
+		return ScopeIntegrationsManage
+	case parts[2] == "tokens" || parts[2] == "requests" || parts[2] == "experiments" || parts[2] == "audit":
+		return ScopeAdmin
+	case parts[2] == "exports":
+		return ScopeObjectivesRead
+	case method == http.MethodGet || method == http.MethodHead:
+		return ScopeObjectivesRead
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "checkins":
+		return ScopeValuesWrite
+	}
+	return ScopeAdmin
+}
+
+// streamToken returns the ID token that a request for a stream of a user
+// carries as token parameter. Only ID tokens are taken, which expire
+// within an hour, and not share tokens, so that URLs which end up in
+// browser history or proxy logs do not carry lasting secrets.
+func streamToken(r *http.Request) string {
+	parts := pathParts(r.URL.Path)
+	if r.Method != http.MethodGet || len(parts) != 3 || parts[2] != "stream" {
+		return ""
+	}
+	if token := r.URL.Query().Get("token"); isIDToken(token) {
+		return token
+	}
+	return ""
+}
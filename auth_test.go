@@ -0,0 +1,143 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAuth accepts ID tokens of the form a.{uid}.c.
+type fakeAuth struct{}
+
+func (fakeAuth) VerifyIDToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if parts[0] != "a" || parts[2] != "c" {
+		return "", ErrUnauthenticated
+	}
+	return parts[1], nil
+}
+
+func TestIsIDToken(t *testing.T) {
+	if !isIDToken("eyJhbGciOi.eyJ1aWQiOi.c2lnbmF0dXJl") {
+		t.Error("JWT was not recognized as ID token")
+	}
+	if isIDToken("0123456789abcdef") || isIDToken(testTokenPrefix+"0123456789abcdef") {
+		t.Error("share token was taken for an ID token")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := &Server{auth: fakeAuth{}}
+	tests := []struct {
+		header string
+		uid    string
+		err    error
+	}{
+		{"Bearer a.alice.c", "alice", nil},
+		{"Bearer x.alice.c", "", ErrUnauthenticated},
+		{"Bearer 0123456789abcdef", "", ErrUnauthenticated},
+		{"", "", ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/templates", nil)
+		r.Header.Set("Authorization", tt.header)
+		uid, err := s.authenticate(r)
+		if uid != tt.uid || !errors.Is(err, tt.err) {
+			t.Errorf("authenticate(%q) = %q, %v; wanted %q, %v", tt.header, uid, err, tt.uid, tt.err)
+		}
+	}
+}
+
+func TestStreamToken(t *testing.T) {
+	tests := []struct {
+		method, url string
+		want        string
+	}{
+		{http.MethodGet, "/users/alice/stream?token=a.alice.c", "a.alice.c"},
+		{http.MethodGet, "/users/alice/stream?token=0123456789abcdef", ""},
+		{http.MethodGet, "/users/alice/events?token=a.alice.c", ""},
+		{http.MethodPost, "/users/alice/stream?token=a.alice.c", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		if got := streamToken(r); got != tt.want {
+			t.Errorf("streamToken(%s %s) = %q; wanted %q", tt.method, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateWithoutVerifier(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/templates", nil)
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	if _, err := (&Server{}).authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("error was %v; wanted ErrUnauthenticated", err)
+	}
+}
+
+func TestUsersRequireOwnIDToken(t *testing.T) {
+	s := &Server{auth: fakeAuth{}}
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer a.bob.c", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users/alice/events", nil)
+		r.Header.Set("Authorization", tt.header)
+		w := httptest.NewRecorder()
+
+		s.users(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("status with %q was %d; wanted %d", tt.header, w.Code, tt.status)
+		}
+	}
+}
+
+func TestGoalRequestRequiresToken(t *testing.T) {
+	s := &Server{auth: fakeAuth{}}
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer a.bob.c", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		body := `{"User": "alice", "Objective": "o", "Goal": "g", "Value": 1}`
+		r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+		r.Header.Set("Authorization", tt.header)
+		w := httptest.NewRecorder()
+
+		s.setGoalValue(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("status with %q was %d; wanted %d", tt.header, w.Code, tt.status)
+		}
+	}
+}
+
+func TestServeHTTPDoesNotRecordSignedInRequests(t *testing.T) {
+	defer captureLog(t)()
+	s, _ := newMemoryServer()
+	// The storage has no Firestore client, so recording a request would
+	// panic.
+	s.storage = &Storage{}
+	s.mux = http.NewServeMux()
+	var uid string
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		uid, _ = s.authenticate(r)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", nil)
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if uid != "alice" {
+		t.Errorf("request was authenticated as %q; wanted alice", uid)
+	}
+}
@@ -0,0 +1,250 @@
+package pursuit
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+)
+
+// GoalValue is a value for a goal in a batch.
+type GoalValue struct {
+	Objective string  `json:"objective"`
+	Goal      string  `json:"goal"`
+	Value     float32 `json:"value"`
+	// Unit of the value, optional.
+	Unit string `json:"unit,omitempty"`
+}
+
+// maxBatchValues bounds the values in a batch, so that a batch usually
+// stays within maxTransactionWrites even if every value becomes a
+// document of a trajectory subcollection. Batches that take more writes,
+// such as those of values for many objectives, are rejected.
+const maxBatchValues = 400
+
+// setBatchValue adds a value of a batch to the objective, and returns the
+// point that was added.
+func (o *Objective) setBatchValue(v GoalValue) (DateValue, error) {
+	value, err := o.ConvertGoalValue(v.Goal, v.Value, v.Unit)
+	if err != nil {
+		return DateValue{}, err
+	}
+	if err := o.SetGoalValue(v.Goal, value); err != nil {
+		return DateValue{}, err
+	}
+	t := o.Goals[v.Goal].Trajectory
+	return t[len(t)-1], nil
+}
+
+// SetGoalValues adds the values of a batch to the trajectories of goals
+// of a user in a single transaction, using the current timestamp. Values
+// that cannot be added, e.g. because their goal does not exist, are
+// skipped and their errors returned at the same index; all other values
+// are added together. The error is about the batch as a whole.
+func (s Storage) SetGoalValues(userID string, values []GoalValue) ([]error, error) {
+	if len(values) > maxBatchValues {
+		return nil, fmt.Errorf("Batch has %d values, at most %d are allowed: %w", len(values), maxBatchValues, ErrInvalidValue)
+	}
+	objectives := s.collection("users").Doc(userID).Collection("objectives")
+	var results []error
+	var points []DateValue
+	var notifications []goalEvent
+	err := s.transaction("SetGoalValues", func(tx *firestore.Transaction) error {
+		results = make([]error, len(values))
+		points = make([]DateValue, len(values))
+		var ids []string
+		index := map[string]int{}
+		for _, v := range values {
+			if _, ok := index[v.Objective]; !ok && v.Objective != "" {
+				index[v.Objective] = len(ids)
+				ids = append(ids, v.Objective)
+			}
+		}
+		refs := make([]*firestore.DocumentRef, len(ids))
+		for i, id := range ids {
+			refs[i] = objectives.Doc(id)
+		}
+		docs, err := tx.GetAll(refs)
+		if err != nil {
+			return fmt.Errorf("Error reading objectives: %w", err)
+		}
+		read := make([]*Objective, len(ids))
+		for i, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			var o Objective
+			if err := doc.DataTo(&o); err != nil {
+				return fmt.Errorf("Error reading objective %q: %w", ids[i], err)
+			}
+			read[i] = &o
+		}
+		// In subcollection mode, the goals of the batch and the goals that
+		// their composites depend on get the values in their
+		// subcollections. inline keeps the values stored inline, and
+		// loaded the lengths of the trajectories read.
+		inline := make([]map[string]Trajectory, len(ids))
+		loaded := make([]map[string]int, len(ids))
+		if s.trajectories {
+			for _, v := range values {
+				j, ok := index[v.Objective]
+				if !ok || read[j] == nil {
+					continue
+				}
+				if inline[j] == nil {
+					inline[j] = map[string]Trajectory{}
+					loaded[j] = map[string]int{}
+				}
+				for _, id := range append([]string{v.Goal}, read[j].compositeGoals(v.Goal)...) {
+					g, ok := read[j].Goals[id]
+					if _, done := inline[j][id]; done || !ok {
+						continue
+					}
+					t, err := s.readTrajectory(tx, userID, v.Objective, id)
+					if err != nil {
+						return err
+					}
+					inline[j][id] = g.Trajectory
+					g.Trajectory = append(append(Trajectory{}, g.Trajectory...), t...)
+					read[j].Goals[id] = g
+					loaded[j][id] = len(g.Trajectory)
+				}
+			}
+		}
+		// changed lists the goals of each objective that got values, and
+		// stored the lengths of their trajectories as they were read.
+		notifications = nil
+		changed := make([][]string, len(ids))
+		stored := make([]map[string]int, len(ids))
+		for i, v := range values {
+			j, ok := index[v.Objective]
+			if !ok || read[j] == nil {
+				results[i] = fmt.Errorf("No such objective: %q: %w", v.Objective, ErrNotFound)
+				continue
+			}
+			n := len(read[j].Goals[v.Goal].Trajectory)
+			points[i], results[i] = read[j].setBatchValue(v)
+			if results[i] != nil {
+				continue
+			}
+			if stored[j] == nil {
+				stored[j] = map[string]int{}
+			}
+			if _, ok := stored[j][v.Goal]; !ok {
+				stored[j][v.Goal] = n
+				changed[j] = append(changed[j], v.Goal)
+			}
+		}
+		writes := 0
+		for j, goals := range changed {
+			var updates []firestore.Update
+			composites := map[string]bool{}
+			for _, goalID := range goals {
+				for _, id := range read[j].recomputeComposites(goalID) {
+					composites[id] = true
+				}
+			}
+			for id := range composites {
+				c := read[j].Goals[id]
+				if s.trajectories {
+					for _, p := range c.Trajectory[loaded[j][id]:] {
+						if err := tx.Create(s.trajectoryRef(userID, ids[j], id).NewDoc(), p); err != nil {
+							return err
+						}
+						writes++
+					}
+					continue
+				}
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"goals", id}, Value: c})
+			}
+			for _, goalID := range goals {
+				g := read[j].Goals[goalID]
+				if m := g.updateMilestone(); m > 0 {
+					notifications = append(notifications, goalEvent{g, newMilestoneEvent(ids[j], goalID, g, m)})
+				}
+				for _, kind := range g.updateRecords() {
+					notifications = append(notifications, goalEvent{g, newRecordEvent(ids[j], goalID, g, kind)})
+				}
+				if s.trajectories {
+					for _, p := range g.Trajectory[stored[j][goalID]:] {
+						if err := tx.Create(s.trajectoryRef(userID, ids[j], goalID).NewDoc(), p); err != nil {
+							return err
+						}
+						writes++
+					}
+					g.Trajectory = inline[j][goalID]
+				}
+				updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"goals", goalID}, Value: g})
+			}
+			if len(updates) > 0 {
+				if err := tx.Update(refs[j], updates); err != nil {
+					return err
+				}
+				writes++
+			}
+		}
+		if writes > maxTransactionWrites {
+			return fmt.Errorf("Batch takes %d writes, at most %d fit into a transaction; send fewer values at once: %w", writes, maxTransactionWrites, ErrInvalidValue)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if results[i] == nil {
+			s.recordEvent(userID, Event{
+				Type:      EventGoalSet,
+				Objective: v.Objective,
+				Goal:      v.Goal,
+				Value:     points[i].Value,
+				Date:      points[i].Date,
+			})
+		}
+	}
+	for _, n := range notifications {
+		s.notifyGoalEvent(userID, n.goal, n.event)
+	}
+	return results, nil
+}
+
+// SetGoalValues adds the values of a batch together, see
+// Storage.SetGoalValues.
+func (m *memoryGoalStore) SetGoalValues(userID string, values []GoalValue) ([]error, error) {
+	if len(values) > maxBatchValues {
+		return nil, fmt.Errorf("Batch has %d values, at most %d are allowed: %w", len(values), maxBatchValues, ErrInvalidValue)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]error, len(values))
+	updated := map[string]Objective{}
+	for i, v := range values {
+		o, ok := updated[v.Objective]
+		if !ok {
+			o, ok = m.objectives[userID][v.Objective]
+			if !ok {
+				results[i] = fmt.Errorf("No such objective: %q: %w", v.Objective, ErrNotFound)
+				continue
+			}
+			o = copyObjective(o)
+			updated[v.Objective] = o
+		}
+		_, results[i] = o.setBatchValue(v)
+	}
+	done := map[string]bool{}
+	for i, v := range values {
+		if results[i] != nil || done[v.Objective+"/"+v.Goal] {
+			continue
+		}
+		done[v.Objective+"/"+v.Goal] = true
+		o := updated[v.Objective]
+		g := o.Goals[v.Goal]
+		g.updateMilestone()
+		g.updateRecords()
+		o.Goals[v.Goal] = g
+		o.recomputeComposites(v.Goal)
+	}
+	for id, o := range updated {
+		m.objectives[userID][id] = o
+	}
+	return results, nil
+}
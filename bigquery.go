@@ -0,0 +1,285 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// BigQuerySync streams new trajectory points and the metadata of their
+// goals into BigQuery through the insertAll API. The dataset must contain
+// two tables:
+//
+//	points: environment STRING, user STRING, objective STRING,
+//	        goal STRING, type STRING, date TIMESTAMP, value FLOAT,
+//	        delta FLOAT
+//	goals:  environment STRING, user STRING, objective STRING,
+//	        goal STRING, name STRING, unit STRING, stage STRING,
+//	        target FLOAT, start TIMESTAMP, end TIMESTAMP, synced TIMESTAMP
+//
+// Points are taken from goal events. The goals table is append-only, a
+// row is added whenever a goal has new points, so the latest row of a goal
+// by synced holds its current metadata.
+type BigQuerySync struct {
+	client  *http.Client
+	baseURL string
+	project string
+	dataset string
+	// token returns an OAuth access token for BigQuery.
+	token func() (string, error)
+}
+
+// NewBigQuerySync creates a sync into a dataset of a project. It
+// authenticates as the service account of the Cloud Run service.
+func NewBigQuerySync(project, dataset string) *BigQuerySync {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &BigQuerySync{
+		client:  client,
+		baseURL: "https://bigquery.googleapis.com/bigquery/v2",
+		project: project,
+		dataset: dataset,
+		token:   metadataToken(client),
+	}
+}
+
+// metadataToken returns a function that fetches access tokens of the
+// default service account from the metadata server, and caches them until
+// shortly before they expire.
+func metadataToken(client *http.Client) func() (string, error) {
+	var mu sync.Mutex
+	var token string
+	var expires time.Time
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Now().Before(expires) {
+			return token, nil
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Error fetching access token: %s", resp.Status)
+		}
+		var t struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int    `json:"expires_in"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+			return "", err
+		}
+		token = t.AccessToken
+		expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+		return token, nil
+	}
+}
+
+// bigQueryRow is a row of a streaming insert. Rows with the same insert
+// ID are deduplicated by BigQuery on a best-effort basis.
+type bigQueryRow struct {
+	InsertID string                 `json:"insertId"`
+	JSON     map[string]interface{} `json:"json"`
+}
+
+// insert streams rows into a table of the dataset.
+func (b *BigQuerySync) insert(table string, rows []bigQueryRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	token, err := b.token()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"rows": rows})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll", b.baseURL, b.project, b.dataset, table)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error inserting into %s: %s", table, resp.Status)
+	}
+	var result struct {
+		InsertErrors []struct {
+			Index int `json:"index"`
+		} `json:"insertErrors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.InsertErrors) > 0 {
+		return fmt.Errorf("Error inserting %d of %d rows into %s", len(result.InsertErrors), len(rows), table)
+	}
+	return nil
+}
+
+// userEvent is an event together with the user it belongs to.
+type userEvent struct {
+	User string
+	EventEntry
+}
+
+// bigQueryTimestamp converts milliseconds since the epoch into a BigQuery
+// timestamp, in seconds.
+func bigQueryTimestamp(ms int64) float64 {
+	return float64(ms) / 1000
+}
+
+// pointRows converts goal events into rows of the points table. Other
+// events are skipped.
+func pointRows(env string, events []userEvent) []bigQueryRow {
+	rows := []bigQueryRow{}
+	for _, e := range events {
+		if e.Type != EventGoalSet && e.Type != EventGoalIncremented {
+			continue
+		}
+		rows = append(rows, bigQueryRow{e.User + "/" + e.ID, map[string]interface{}{
+			"environment": env,
+			"user":        e.User,
+			"objective":   e.Objective,
+			"goal":        e.Goal,
+			"type":        e.Type,
+			"date":        bigQueryTimestamp(e.Date),
+			"value":       e.Value,
+			"delta":       e.Delta,
+		}})
+	}
+	return rows
+}
+
+// goalRow converts the metadata of a goal into a row of the goals table.
+func goalRow(env, userID, objectiveID, goalID string, g Goal, now int64) bigQueryRow {
+	return bigQueryRow{fmt.Sprintf("%s/%s/%s/%d", userID, objectiveID, goalID, now), map[string]interface{}{
+		"environment": env,
+		"user":        userID,
+		"objective":   objectiveID,
+		"goal":        goalID,
+		"name":        g.Name,
+		"unit":        g.Unit,
+		"stage":       g.Stage,
+		"target":      g.Target,
+		"start":       bigQueryTimestamp(g.Start),
+		"end":         bigQueryTimestamp(g.End),
+		"synced":      bigQueryTimestamp(now),
+	}}
+}
+
+// SyncBigQuery streams the events since the previous run into BigQuery,
+// together with the metadata of the goals that changed, and returns the
+// number of synced points. Each run syncs at most maxEvents events, so
+// backlogs are caught up over several runs.
+func (s Storage) SyncBigQuery(b *BigQuerySync) (int, error) {
+	cursor := s.collection("sync").Doc("bigquery")
+	var since int64
+	err := s.do("SyncBigQuery", func(ctx context.Context) error {
+		doc, err := cursor.Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var c struct {
+			Synced int64 `firestore:"synced" json:"synced"`
+		}
+		if err := doc.DataTo(&c); err != nil {
+			return err
+		}
+		since = c.Synced
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error reading sync cursor: %w", err)
+	}
+	// Events at the date of the cursor are synced again, in case more
+	// events with the same date arrived after the previous run. Their
+	// insert IDs let BigQuery drop the duplicate points, and their goals
+	// are not synced again.
+	q := s.client.CollectionGroup("events").Where("date", ">=", since).OrderBy("date", firestore.Asc).Limit(maxEvents)
+	var docs []*firestore.DocumentSnapshot
+	err = s.do("SyncBigQuery", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error listing events: %w", err)
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	events := make([]userEvent, 0, len(docs))
+	var latest int64
+	for _, doc := range docs {
+		var e Event
+		if err := doc.DataTo(&e); err != nil {
+			return 0, fmt.Errorf("Error reading event %q: %w", doc.Ref.ID, err)
+		}
+		latest = e.Date
+		if s.inNamespace(doc.Ref) {
+			events = append(events, userEvent{doc.Ref.Parent.Parent.ID, EventEntry{doc.Ref.ID, e}})
+		}
+	}
+
+	points := pointRows(s.Environment(), events)
+	if err := b.insert("points", points); err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	goals := []bigQueryRow{}
+	seen := map[goalKey]bool{}
+	objectives := map[[2]string]Objective{}
+	for _, e := range events {
+		k := goalKey{User: e.User, Objective: e.Objective, Goal: e.Goal}
+		if e.Goal == "" || e.Date <= since || seen[k] {
+			continue
+		}
+		seen[k] = true
+		o, ok := objectives[[2]string{e.User, e.Objective}]
+		if !ok {
+			// Objectives may have been deleted since the event, in which
+			// case only the points are synced.
+			if o, err = s.readObjective(e.User, e.Objective); err != nil {
+				logf(s.ctx, severityError, "Error reading objective %s/%s for BigQuery: %v", e.User, e.Objective, err)
+			}
+			objectives[[2]string{e.User, e.Objective}] = o
+		}
+		if g, ok := o.Goals[e.Goal]; ok {
+			goals = append(goals, goalRow(s.Environment(), e.User, e.Objective, e.Goal, g, now))
+		}
+	}
+	if err := b.insert("goals", goals); err != nil {
+		return 0, err
+	}
+
+	err = s.do("SyncBigQuery", func(ctx context.Context) error {
+		_, err := cursor.Set(ctx, map[string]interface{}{"synced": latest})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error updating sync cursor: %w", err)
+	}
+	return len(points), nil
+}
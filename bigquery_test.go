@@ -0,0 +1,76 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPointRows(t *testing.T) {
+	events := []userEvent{
+		{"u", EventEntry{"a", Event{Type: EventGoalSet, Objective: "o", Goal: "g", Value: 2, Date: 1500}}},
+		{"u", EventEntry{"b", Event{Type: EventSecurityLockout, Objective: "o", Date: 2000}}},
+	}
+
+	rows := pointRows("prod", events)
+
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows; wanted 1", len(rows))
+	}
+	if rows[0].InsertID != "u/a" || rows[0].JSON["date"] != 1.5 || rows[0].JSON["value"] != float32(2) || rows[0].JSON["environment"] != "prod" {
+		t.Errorf("row was %+v", rows[0])
+	}
+}
+
+func TestBigQueryInsert(t *testing.T) {
+	var path, authorization string
+	var body struct {
+		Rows []bigQueryRow `json:"rows"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"kind": "bigquery#tableDataInsertAllResponse"}`))
+	}))
+	defer srv.Close()
+	b := &BigQuerySync{
+		client:  srv.Client(),
+		baseURL: srv.URL,
+		project: "p",
+		dataset: "d",
+		token:   func() (string, error) { return "secret", nil },
+	}
+
+	err := b.insert("goals", []bigQueryRow{goalRow("prod", "u", "o", "g", Goal{Name: "Run"}, 1000)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/projects/p/datasets/d/tables/goals/insertAll" {
+		t.Errorf("path was %q", path)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("authorization was %q", authorization)
+	}
+	if len(body.Rows) != 1 || body.Rows[0].JSON["name"] != "Run" {
+		t.Errorf("rows were %+v", body.Rows)
+	}
+}
+
+func TestBigQueryInsertErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"insertErrors": [{"index": 0, "errors": [{"reason": "invalid"}]}]}`))
+	}))
+	defer srv.Close()
+	b := &BigQuerySync{
+		client:  srv.Client(),
+		baseURL: srv.URL,
+		token:   func() (string, error) { return "secret", nil },
+	}
+
+	if err := b.insert("points", []bigQueryRow{{"a", map[string]interface{}{}}}); err == nil {
+		t.Errorf("insert succeeded despite insert errors")
+	}
+}
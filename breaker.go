@@ -0,0 +1,137 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnavailableError is returned by Storage without contacting Firestore
+// while the circuit breaker of an operation is open.
+type UnavailableError struct {
+	Operation  string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Firestore unavailable for %s, retry after %v", e.Operation, e.RetryAfter)
+}
+
+// circuitBreakers keep track of failures of Firestore operations. Once an
+// operation failed threshold times in a row due to an outage, further
+// calls fail fast until the cooldown has passed. The first call after the
+// cooldown probes Firestore again and reopens the circuit if it fails,
+// while other calls keep failing fast until the probe is done.
+type circuitBreakers struct {
+	threshold int
+	cooldown  time.Duration
+	// timeout bounds each Firestore operation so that a brownout trips
+	// the circuit quickly rather than piling up waiting requests.
+	timeout time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	// probing is set while a call probes whether the operation recovered.
+	probing bool
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{
+		threshold: 5,
+		cooldown:  30 * time.Second,
+		timeout:   10 * time.Second,
+		now:       time.Now,
+		circuits:  map[string]*circuit{},
+	}
+}
+
+// call runs f unless the circuit of the operation is open. Failures after
+// the caller's context ended, e.g. because a client went away, say
+// nothing about Firestore and are not recorded.
+func (b *circuitBreakers) call(ctx context.Context, op string, f func() error) error {
+	wait, probe := b.admit(op)
+	if wait > 0 {
+		return &UnavailableError{op, wait}
+	}
+	if probe {
+		defer b.endProbe(op)
+	}
+	err := f()
+	if ctx.Err() == nil {
+		b.record(op, isOutage(err))
+	}
+	return err
+}
+
+// admit returns how long calls of the operation have to wait, and whether
+// the call that may go ahead probes the operation after the cooldown.
+// Calls wait for the bounded duration of a running probe.
+func (b *circuitBreakers) admit(op string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[op]
+	if !ok {
+		return 0, false
+	}
+	if wait := c.openUntil.Sub(b.now()); wait > 0 {
+		return wait, false
+	}
+	if c.failures < b.threshold {
+		return 0, false
+	}
+	if c.probing {
+		return b.timeout, false
+	}
+	c.probing = true
+	return 0, true
+}
+
+func (b *circuitBreakers) endProbe(op string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuits[op].probing = false
+}
+
+func (b *circuitBreakers) record(op string, outage bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[op]
+	if !ok {
+		c = &circuit{}
+		b.circuits[op] = c
+	}
+	if !outage {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= b.threshold {
+		c.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// isOutage reports whether err indicates that Firestore is unavailable or
+// overloaded, as opposed to errors caused by the request itself.
+func isOutage(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+			return true
+		}
+	}
+	return false
+}
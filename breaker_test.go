@@ -0,0 +1,163 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestBreakers(clock *fakeClock) *circuitBreakers {
+	b := newCircuitBreakers()
+	b.threshold = 2
+	b.cooldown = time.Minute
+	b.now = clock.now
+	return b
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	b := newTestBreakers(&fakeClock{})
+	outage := status.Error(codes.Unavailable, "brownout")
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	called := false
+	err := b.call(context.Background(), "read", func() error {
+		called = true
+		return nil
+	})
+
+	var u *UnavailableError
+	if !errors.As(err, &u) {
+		t.Fatalf("wanted UnavailableError, got %v", err)
+	}
+	if called {
+		t.Errorf("operation was called while circuit was open")
+	}
+	if u.RetryAfter != time.Minute {
+		t.Errorf("retry after %v; wanted %v", u.RetryAfter, time.Minute)
+	}
+}
+
+func TestCircuitBreakerIsPerOperation(t *testing.T) {
+	b := newTestBreakers(&fakeClock{})
+	outage := status.Error(codes.Unavailable, "brownout")
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	err := b.call(context.Background(), "write", func() error { return nil })
+
+	if err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresRequestErrors(t *testing.T) {
+	b := newTestBreakers(&fakeClock{})
+	notFound := fmt.Errorf("Error reading objective: %w", status.Error(codes.NotFound, "missing"))
+
+	b.call(context.Background(), "read", func() error { return notFound })
+	b.call(context.Background(), "read", func() error { return notFound })
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	if err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+}
+
+func TestCircuitBreakerDetectsWrappedOutages(t *testing.T) {
+	b := newTestBreakers(&fakeClock{})
+	outage := fmt.Errorf("Error reading objective: %w", status.Error(codes.DeadlineExceeded, "slow"))
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	var u *UnavailableError
+	if !errors.As(err, &u) {
+		t.Errorf("wanted UnavailableError, got %v", err)
+	}
+}
+
+func TestCircuitBreakerCloses(t *testing.T) {
+	clock := &fakeClock{}
+	b := newTestBreakers(clock)
+	outage := status.Error(codes.Unavailable, "brownout")
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	clock.t = clock.t.Add(time.Minute)
+	probe := b.call(context.Background(), "read", func() error { return nil })
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	if probe != nil || err != nil {
+		t.Errorf("wanted no errors after cooldown, got %v, %v", probe, err)
+	}
+}
+
+func TestCircuitBreakerReopensAfterFailedProbe(t *testing.T) {
+	clock := &fakeClock{}
+	b := newTestBreakers(clock)
+	outage := status.Error(codes.Unavailable, "brownout")
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	clock.t = clock.t.Add(time.Minute)
+	b.call(context.Background(), "read", func() error { return outage })
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	var u *UnavailableError
+	if !errors.As(err, &u) {
+		t.Errorf("wanted UnavailableError, got %v", err)
+	}
+}
+
+func TestCircuitBreakerProbesOnce(t *testing.T) {
+	clock := &fakeClock{}
+	b := newTestBreakers(clock)
+	outage := status.Error(codes.Unavailable, "brownout")
+
+	b.call(context.Background(), "read", func() error { return outage })
+	b.call(context.Background(), "read", func() error { return outage })
+	clock.t = clock.t.Add(time.Minute)
+	var concurrent error
+	probe := b.call(context.Background(), "read", func() error {
+		concurrent = b.call(context.Background(), "read", func() error { return nil })
+		return nil
+	})
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	var u *UnavailableError
+	if !errors.As(concurrent, &u) {
+		t.Errorf("wanted UnavailableError during probe, got %v", concurrent)
+	}
+	if probe != nil || err != nil {
+		t.Errorf("wanted no errors after probe, got %v, %v", probe, err)
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledCalls(t *testing.T) {
+	b := newTestBreakers(&fakeClock{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	b.call(ctx, "read", func() error { return context.DeadlineExceeded })
+	b.call(ctx, "read", func() error { return context.DeadlineExceeded })
+	err := b.call(context.Background(), "read", func() error { return nil })
+
+	if err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package pursuit
+
+import (
+	"fmt"
+)
+
+// Build information of the binary, set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/jeadorf/pursuit.Commit=$(git rev-parse HEAD)
+//	  -X github.com/jeadorf/pursuit.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+//	  -X github.com/jeadorf/pursuit.Channel=stable"
+var (
+	// Commit is the git SHA that the binary was built from.
+	Commit string
+	// BuildTime is when the binary was built, in RFC 3339.
+	BuildTime string
+	// Channel is the release channel, such as "stable" or "beta". Binaries
+	// built without ldflags are on the "dev" channel.
+	Channel string
+)
+
+// BuildInfo describes a build of the server or the CLI.
+type BuildInfo struct {
+	Commit     string `json:"commit,omitempty"`
+	BuildTime  string `json:"buildTime,omitempty"`
+	Channel    string `json:"channel"`
+	APIVersion int    `json:"apiVersion"`
+}
+
+// CurrentBuildInfo returns the build information of the running binary.
+func CurrentBuildInfo() BuildInfo {
+	channel := Channel
+	if channel == "" {
+		channel = "dev"
+	}
+	return BuildInfo{
+		Commit:     Commit,
+		BuildTime:  BuildTime,
+		Channel:    channel,
+		APIVersion: APIVersion,
+	}
+}
+
+// CheckAPIVersion reports whether a binary of this build can talk to a
+// peer that speaks the given version of the wire format.
+func CheckAPIVersion(peer int) error {
+	switch {
+	case peer < APIVersion:
+		return fmt.Errorf("Server speaks API version %d, which is older than version %d of this build", peer, APIVersion)
+	case peer > APIVersion:
+		return fmt.Errorf("Server speaks API version %d, which is newer than version %d of this build", peer, APIVersion)
+	}
+	return nil
+}
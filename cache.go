@@ -0,0 +1,75 @@
+package pursuit
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// publicReadTTL is how long public endpoints serve an objective from
+// memory before reading it again. Changes show up on shared views and
+// charts with at most this delay.
+const publicReadTTL = 30 * time.Second
+
+// maxCachedObjectives bounds the memory used by the read cache.
+const maxCachedObjectives = 10000
+
+// readCacheStats counts hits, misses and evictions of read caches, and is
+// served with the other expvars under /debug/vars.
+var readCacheStats = expvar.NewMap("readCache")
+
+// readCache keeps objectives read by public endpoints, such as shared
+// views and charts, so that embedding them on popular pages does not
+// cause a Firestore read per page view.
+type readCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[[2]string]cachedObjective
+}
+
+type cachedObjective struct {
+	objective Objective
+	// updated is the update time of the document, which identifies the
+	// version of the objective, e.g. in ETags.
+	updated time.Time
+	expires time.Time
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, now: time.Now, entries: map[[2]string]cachedObjective{}}
+}
+
+// objective returns the objective from the cache, or loads it if it is
+// missing or expired. It also returns the update time of the objective.
+func (c *readCache) objective(userID, objectiveID string, load func() (Objective, time.Time, error)) (Objective, time.Time, error) {
+	key := [2]string{userID, objectiveID}
+	now := c.now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		readCacheStats.Add("hits", 1)
+		return e.objective, e.updated, nil
+	}
+	readCacheStats.Add("misses", 1)
+	o, updated, err := load()
+	if err != nil {
+		return Objective{}, time.Time{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedObjectives {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+				readCacheStats.Add("evictions", 1)
+			}
+		}
+	}
+	if len(c.entries) < maxCachedObjectives {
+		c.entries[key] = cachedObjective{o, updated, now.Add(c.ttl)}
+	}
+	return o, updated, nil
+}
@@ -0,0 +1,53 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newReadCache(30 * time.Second)
+	c.now = func() time.Time { return now }
+	loads := 0
+	load := func() (Objective, time.Time, error) {
+		loads++
+		return Objective{Name: "Fitness"}, time.Unix(500, int64(loads)), nil
+	}
+
+	o, updated, err := c.objective("u", "o", load)
+	if err != nil || o.Name != "Fitness" || updated != time.Unix(500, 1) {
+		t.Fatalf("objective() = %+v, %v, %v", o, updated, err)
+	}
+	now = now.Add(29 * time.Second)
+	if _, updated, _ := c.objective("u", "o", load); loads != 1 || updated != time.Unix(500, 1) {
+		t.Errorf("objective was loaded %d times before it expired", loads)
+	}
+	c.objective("u", "other", load)
+	if loads != 2 {
+		t.Errorf("other objective was served from the cache")
+	}
+	now = now.Add(time.Second)
+	if _, updated, _ := c.objective("u", "o", load); loads != 3 || updated != time.Unix(500, 3) {
+		t.Errorf("expired objective was not loaded again")
+	}
+}
+
+func TestReadCacheError(t *testing.T) {
+	c := newReadCache(time.Minute)
+	failing := func() (Objective, time.Time, error) {
+		return Objective{}, time.Time{}, ErrNotFound
+	}
+	if _, _, err := c.objective("u", "o", failing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error was %v; wanted ErrNotFound", err)
+	}
+	loaded := false
+	c.objective("u", "o", func() (Objective, time.Time, error) {
+		loaded = true
+		return Objective{}, time.Time{}, nil
+	})
+	if !loaded {
+		t.Error("error was cached")
+	}
+}
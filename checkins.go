@@ -0,0 +1,319 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Kinds of check-in questions.
+const (
+	// CheckInScale questions are answered with a score from 1 to 5, such
+	// as the confidence that the objective will be achieved.
+	CheckInScale = "scale"
+	// CheckInText questions are answered with free text, such as the
+	// biggest blocker.
+	CheckInText = "text"
+)
+
+// maxCheckInScore is the highest score of answers to scale questions; the
+// lowest is 1.
+const maxCheckInScore = 5
+
+// maxCheckInTextLength bounds the length of answers to text questions, in
+// bytes.
+const maxCheckInTextLength = 2000
+
+// CheckInQuestion is a question of the weekly check-in of an objective.
+type CheckInQuestion struct {
+	ID   string `firestore:"id" json:"id"`
+	Text string `firestore:"text" json:"text"`
+	// Kind is CheckInScale or CheckInText.
+	Kind string `firestore:"kind" json:"kind"`
+}
+
+// CheckInAnswer answers a check-in question with a score or a text,
+// depending on the kind of the question.
+type CheckInAnswer struct {
+	Score int    `firestore:"score,omitempty" json:"score,omitempty"`
+	Text  string `firestore:"text,omitempty" json:"text,omitempty"`
+}
+
+// CheckIn for Firestore serialization/deserialization. A check-in holds
+// the answers to the check-in questions of an objective in a week, by
+// question ID. Check-ins are stored in
+// users/{user}/objectives/{objective}/checkIns/{week}, so that answering
+// again in the same week replaces the answers.
+type CheckIn struct {
+	// Week is the ISO week of the check-in, such as 2025-W07.
+	Week    string                   `firestore:"week" json:"week"`
+	Answers map[string]CheckInAnswer `firestore:"answers" json:"answers"`
+	// PrivateNote is encrypted by the client, see EncryptedNote.
+	PrivateNote *EncryptedNote `firestore:"privateNote,omitempty" json:"privateNote,omitempty"`
+	// Updated in milliseconds since the epoch.
+	Updated int64 `firestore:"updated" json:"updated"`
+}
+
+// checkInWeek returns the ISO week of a date in milliseconds since the
+// epoch, in UTC.
+func checkInWeek(ms int64) string {
+	year, week := time.Unix(0, ms*int64(time.Millisecond)).UTC().ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// validateCheckInQuestions checks that the questions have unique IDs,
+// texts and known kinds.
+func validateCheckInQuestions(questions []CheckInQuestion) error {
+	ids := map[string]bool{}
+	for _, q := range questions {
+		switch {
+		case q.ID == "":
+			return fmt.Errorf("Missing check-in question ID: %w", ErrInvalidValue)
+		case ids[q.ID]:
+			return fmt.Errorf("Duplicate check-in question %q: %w", q.ID, ErrInvalidValue)
+		case q.Text == "":
+			return fmt.Errorf("Check-in question %q: missing text: %w", q.ID, ErrInvalidValue)
+		case q.Kind != CheckInScale && q.Kind != CheckInText:
+			return fmt.Errorf("Check-in question %q: unknown kind %q: %w", q.ID, q.Kind, ErrInvalidValue)
+		}
+		ids[q.ID] = true
+	}
+	return nil
+}
+
+// validateCheckInAnswers checks that the answers answer questions of the
+// objective in the way their kind asks for. Questions may be left
+// unanswered, but not all of them.
+func validateCheckInAnswers(o Objective, answers map[string]CheckInAnswer) error {
+	if len(o.CheckInQuestions) == 0 {
+		return fmt.Errorf("Objective has no check-in questions: %w", ErrInvalidValue)
+	}
+	if len(answers) == 0 {
+		return fmt.Errorf("Missing answers: %w", ErrInvalidValue)
+	}
+	kinds := map[string]string{}
+	for _, q := range o.CheckInQuestions {
+		kinds[q.ID] = q.Kind
+	}
+	for id, a := range answers {
+		switch kinds[id] {
+		case CheckInScale:
+			if a.Score < 1 || a.Score > maxCheckInScore || a.Text != "" {
+				return fmt.Errorf("Question %q: wanted a score from 1 to %d: %w", id, maxCheckInScore, ErrInvalidValue)
+			}
+		case CheckInText:
+			if a.Text == "" || a.Score != 0 {
+				return fmt.Errorf("Question %q: wanted a text: %w", id, ErrInvalidValue)
+			}
+			if len(a.Text) > maxCheckInTextLength {
+				return fmt.Errorf("Question %q: answer longer than %d bytes: %w", id, maxCheckInTextLength, ErrInvalidValue)
+			}
+		default:
+			return fmt.Errorf("No such check-in question: %q: %w", id, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// CheckInScore is the score of an answer to a scale question in a week.
+type CheckInScore struct {
+	Week  string `json:"week"`
+	Score int    `json:"score"`
+}
+
+// CheckInTrend is how the answers to a scale question developed over the
+// weeks.
+type CheckInTrend struct {
+	Question string         `json:"question"`
+	Text     string         `json:"text"`
+	Scores   []CheckInScore `json:"scores"`
+	Average  float32        `json:"average"`
+	// Change is the latest score minus the one before, or 0 if there are
+	// fewer than two.
+	Change int `json:"change"`
+}
+
+// CheckInTrends returns the trends of the scale questions, in the order
+// of the questions, from check-ins sorted by week. Questions without
+// answers have no scores.
+func CheckInTrends(questions []CheckInQuestion, checkIns []CheckIn) []CheckInTrend {
+	trends := []CheckInTrend{}
+	for _, q := range questions {
+		if q.Kind != CheckInScale {
+			continue
+		}
+		t := CheckInTrend{Question: q.ID, Text: q.Text, Scores: []CheckInScore{}}
+		sum := 0
+		for _, c := range checkIns {
+			a, ok := c.Answers[q.ID]
+			if !ok {
+				continue
+			}
+			t.Scores = append(t.Scores, CheckInScore{c.Week, a.Score})
+			sum += a.Score
+		}
+		if n := len(t.Scores); n > 0 {
+			t.Average = float32(roundTo(float32(sum)/float32(n), 1))
+			if n > 1 {
+				t.Change = t.Scores[n-1].Score - t.Scores[n-2].Score
+			}
+		}
+		trends = append(trends, t)
+	}
+	return trends
+}
+
+// checkInsRef returns the check-ins of an objective of a user.
+func (s Storage) checkInsRef(userID, objectiveID string) *firestore.CollectionRef {
+	return s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID).Collection("checkIns")
+}
+
+// SubmitCheckIn stores the answers to the check-in questions of an
+// objective of a user for the week of the given date, together with an
+// optional private note, replacing the check-in of that week.
+func (s Storage) SubmitCheckIn(userID, objectiveID string, answers map[string]CheckInAnswer, note *EncryptedNote, now int64) (CheckIn, error) {
+	if note != nil {
+		if err := note.validate(); err != nil {
+			return CheckIn{}, err
+		}
+	}
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	c := CheckIn{Week: checkInWeek(now), Answers: answers, PrivateNote: note, Updated: now}
+	err := s.transaction("SubmitCheckIn", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if err := validateCheckInAnswers(o, answers); err != nil {
+			return err
+		}
+		return tx.Set(s.checkInsRef(userID, objectiveID).Doc(c.Week), c)
+	})
+	if err != nil {
+		return CheckIn{}, err
+	}
+	return c, nil
+}
+
+// ListCheckIns returns the check-ins of the latest weeks of an objective
+// of a user, at most limit, sorted by week.
+func (s Storage) ListCheckIns(userID, objectiveID string, limit int) ([]CheckIn, error) {
+	q := s.checkInsRef(userID, objectiveID).OrderBy("week", firestore.Desc).Limit(limit)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListCheckIns", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing check-ins: %w", err)
+	}
+	checkIns := make([]CheckIn, len(docs))
+	for i, doc := range docs {
+		if err := doc.DataTo(&checkIns[len(docs)-1-i]); err != nil {
+			return nil, fmt.Errorf("Error reading check-in %q: %w", doc.Ref.ID, err)
+		}
+	}
+	return checkIns, nil
+}
+
+// deleteCheckIns deletes the check-ins of an objective of a user.
+func (s Storage) deleteCheckIns(userID, objectiveID string) error {
+	var refs []*firestore.DocumentRef
+	err := s.do("deleteCheckIns", func(ctx context.Context) (err error) {
+		refs, err = s.checkInsRef(userID, objectiveID).DocumentRefs(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing check-ins: %w", err)
+	}
+	for _, ref := range refs {
+		err := s.do("deleteCheckIns", func(ctx context.Context) error {
+			_, err := ref.Delete(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error deleting check-in %q: %w", ref.ID, err)
+		}
+	}
+	return nil
+}
+
+// DigestCheckIn is the summary of the check-ins of an objective in a
+// digest.
+type DigestCheckIn struct {
+	Objective string
+	// Due is true if the objective has not been checked in this week.
+	Due bool
+	// Week is the week of the latest check-in, if any.
+	Week    string
+	Answers []DigestAnswer
+}
+
+// DigestAnswer is the latest answer to a check-in question in a digest.
+type DigestAnswer struct {
+	Question string
+	Answer   string
+	// Change is the change of the score since the check-in before, for
+	// scale questions.
+	Change int
+}
+
+// newDigestCheckIn summarizes the latest check-ins of an objective,
+// sorted by week, at the given date.
+func newDigestCheckIn(o Objective, checkIns []CheckIn, now int64) DigestCheckIn {
+	d := DigestCheckIn{Objective: o.Name, Due: true, Answers: []DigestAnswer{}}
+	if len(checkIns) == 0 {
+		return d
+	}
+	latest := checkIns[len(checkIns)-1]
+	d.Due = latest.Week != checkInWeek(now)
+	d.Week = latest.Week
+	trends := map[string]CheckInTrend{}
+	for _, t := range CheckInTrends(o.CheckInQuestions, checkIns) {
+		trends[t.Question] = t
+	}
+	for _, q := range o.CheckInQuestions {
+		a, ok := latest.Answers[q.ID]
+		if !ok {
+			continue
+		}
+		answer := DigestAnswer{Question: q.Text, Answer: a.Text}
+		if q.Kind == CheckInScale {
+			answer.Answer = strconv.Itoa(a.Score) + "/" + strconv.Itoa(maxCheckInScore)
+			answer.Change = trends[q.ID].Change
+		}
+		d.Answers = append(d.Answers, answer)
+	}
+	return d
+}
+
+// DigestCheckIns summarizes the latest check-ins of the objectives of a
+// user that have check-in questions, sorted by objective name.
+func (s Storage) DigestCheckIns(userID string, objectives []ObjectiveEntry, now int64) ([]DigestCheckIn, error) {
+	digests := []DigestCheckIn{}
+	for _, o := range objectives {
+		if len(o.CheckInQuestions) == 0 {
+			continue
+		}
+		checkIns, err := s.ListCheckIns(userID, o.ID, 2)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, newDigestCheckIn(o.Objective, checkIns, now))
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].Objective < digests[j].Objective
+	})
+	return digests, nil
+}
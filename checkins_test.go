@@ -0,0 +1,130 @@
+package pursuit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func checkInObjective() Objective {
+	return Objective{
+		Name: "Fitness",
+		CheckInQuestions: []CheckInQuestion{
+			{ID: "confidence", Text: "Confidence?", Kind: CheckInScale},
+			{ID: "blocker", Text: "Biggest blocker?", Kind: CheckInText},
+		},
+	}
+}
+
+func TestCheckInWeek(t *testing.T) {
+	// 2021-01-03 is a Sunday in the last ISO week of 2020.
+	if w := checkInWeek(1609675200000); w != "2020-W53" {
+		t.Errorf("week was %q; wanted 2020-W53", w)
+	}
+	if w := checkInWeek(1609675200000 + day); w != "2021-W01" {
+		t.Errorf("week was %q; wanted 2021-W01", w)
+	}
+}
+
+func TestValidateCheckInQuestions(t *testing.T) {
+	for _, questions := range [][]CheckInQuestion{
+		{{ID: "", Text: "Confidence?", Kind: CheckInScale}},
+		{{ID: "q", Text: "", Kind: CheckInScale}},
+		{{ID: "q", Text: "Confidence?", Kind: "stars"}},
+		{{ID: "q", Text: "Confidence?", Kind: CheckInScale}, {ID: "q", Text: "Blocker?", Kind: CheckInText}},
+	} {
+		if err := validateCheckInQuestions(questions); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("questions %+v were accepted: %v", questions, err)
+		}
+	}
+	if err := validateObjective(checkInObjective()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateCheckInAnswers(t *testing.T) {
+	o := checkInObjective()
+	valid := map[string]CheckInAnswer{"confidence": {Score: 4}, "blocker": {Text: "Knee"}}
+	if err := validateCheckInAnswers(o, valid); err != nil {
+		t.Error(err)
+	}
+	if err := validateCheckInAnswers(o, map[string]CheckInAnswer{"confidence": {Score: 1}}); err != nil {
+		t.Errorf("partial answers were rejected: %v", err)
+	}
+	for _, answers := range []map[string]CheckInAnswer{
+		{},
+		{"confidence": {Score: 0}},
+		{"confidence": {Score: 6}},
+		{"confidence": {Score: 3, Text: "Fine"}},
+		{"blocker": {}},
+		{"blocker": {Text: strings.Repeat("x", maxCheckInTextLength+1)}},
+		{"mood": {Score: 3}},
+	} {
+		if err := validateCheckInAnswers(o, answers); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("answers %+v were accepted: %v", answers, err)
+		}
+	}
+	if err := validateCheckInAnswers(Objective{Name: "Fitness"}, valid); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("answers to an objective without questions were accepted: %v", err)
+	}
+}
+
+func TestCheckInTrends(t *testing.T) {
+	checkIns := []CheckIn{
+		{Week: "2025-W01", Answers: map[string]CheckInAnswer{"confidence": {Score: 2}}},
+		{Week: "2025-W02", Answers: map[string]CheckInAnswer{"blocker": {Text: "Knee"}}},
+		{Week: "2025-W03", Answers: map[string]CheckInAnswer{"confidence": {Score: 5}}},
+	}
+
+	trends := CheckInTrends(checkInObjective().CheckInQuestions, checkIns)
+
+	if len(trends) != 1 {
+		t.Fatalf("trends were %+v; wanted only confidence", trends)
+	}
+	c := trends[0]
+	if len(c.Scores) != 2 || c.Scores[1] != (CheckInScore{"2025-W03", 5}) || c.Average != 3.5 || c.Change != 3 {
+		t.Errorf("trend was %+v", c)
+	}
+}
+
+func TestNewDigestCheckIn(t *testing.T) {
+	o := checkInObjective()
+	checkIns := []CheckIn{
+		{Week: "2020-W52", Answers: map[string]CheckInAnswer{"confidence": {Score: 4}}},
+		{Week: "2020-W53", Answers: map[string]CheckInAnswer{"confidence": {Score: 3}, "blocker": {Text: "Knee"}}},
+	}
+
+	d := newDigestCheckIn(o, checkIns, 1609675200000)
+
+	if d.Due || d.Week != "2020-W53" || len(d.Answers) != 2 {
+		t.Fatalf("check-in was %+v", d)
+	}
+	if d.Answers[0] != (DigestAnswer{"Confidence?", "3/5", -1}) || d.Answers[1].Answer != "Knee" {
+		t.Errorf("answers were %+v", d.Answers)
+	}
+	if d := newDigestCheckIn(o, checkIns, 1609675200000+day); !d.Due {
+		t.Errorf("check-in of the last week was not due")
+	}
+	if d := newDigestCheckIn(o, nil, 0); !d.Due || len(d.Answers) != 0 {
+		t.Errorf("check-in without answers was %+v", d)
+	}
+}
+
+func TestRenderDigestCheckIns(t *testing.T) {
+	d := NewDigest("u", digestObjectives(), 5*day)
+	d.CheckIns = []DigestCheckIn{{
+		Objective: "Fitness",
+		Due:       true,
+		Answers:   []DigestAnswer{{Question: "Confidence?", Answer: "3/5", Change: -1}},
+	}}
+
+	for _, html := range []bool{false, true} {
+		text, err := RenderDigest(d, html)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(text, "Check-in Fitness") || !strings.Contains(text, "3/5</b> (-1)") && !strings.Contains(text, "Confidence? 3/5 (-1)") {
+			t.Errorf("digest did not show the check-in:\n%s", text)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jeadorf/pursuit"
+)
+
+// subcommand runs the subcommand of a command that is named by the first
+// argument, or prints the usage of the command.
+func subcommand(name string, args []string, commands map[string]func([]string), usage string) {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "Usage: pursuit %s %s\n", name, usage)
+		os.Exit(2)
+	}
+	commands[args[0]](args[1:])
+}
+
+// requireFlags exits with the usage of the flag set unless all of the
+// values are set.
+func requireFlags(fs *flag.FlagSet, values ...*string) {
+	for _, v := range values {
+		if *v == "" {
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+}
+
+// objective runs pursuit objective create and list.
+func objective(args []string) {
+	subcommand("objective", args, map[string]func([]string){
+		"create": createObjective,
+		"list":   listObjectives,
+	}, "create|list [flags]")
+}
+
+// goal runs pursuit goal create, list, set and increment. Objectives and
+// goals may be named by their slugs.
+func goal(args []string) {
+	subcommand("goal", args, map[string]func([]string){
+		"create":    createGoal,
+		"list":      listGoals,
+		"set":       func(args []string) { changeGoalValue("set", args) },
+		"increment": func(args []string) { changeGoalValue("increment", args) },
+	}, "create|list|set|increment [flags]")
+}
+
+// createObjective creates an objective, under a generated ID and slug
+// unless -id is given, and prints its ID and slug.
+func createObjective(args []string) {
+	fs := flag.NewFlagSet("objective create", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the objective")
+	id := fs.String("id", "", "ID of the objective, generated if empty")
+	name := fs.String("name", "", "name of the objective")
+	description := fs.String("description", "", "description of the objective")
+	fs.Parse(args)
+	requireFlags(fs, user, name)
+
+	storage := newStorage()
+	checkEnvironment(storage)
+	o := pursuit.Objective{Name: *name, Description: *description}
+	var slug string
+	var err error
+	if *id != "" {
+		err = storage.CreateObjective(*user, *id, o)
+	} else {
+		*id, slug, err = storage.AddObjective(*user, o)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	json.NewEncoder(os.Stdout).Encode(map[string]string{"id": *id, "slug": slug})
+}
+
+// listObjectives prints one JSON object per objective of a user.
+func listObjectives(args []string) {
+	fs := flag.NewFlagSet("objective list", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the objectives")
+	fs.Parse(args)
+	requireFlags(fs, user)
+
+	objectives, err := newStorage().ListObjectives(*user)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, o := range objectives {
+		enc.Encode(o)
+	}
+}
+
+// createGoal adds a goal to an objective, under a generated ID and slug
+// unless -id is given, and prints its ID and slug.
+func createGoal(args []string) {
+	fs := flag.NewFlagSet("goal create", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the goal")
+	objectiveRef := fs.String("objective", "", "ID or slug of the objective")
+	id := fs.String("id", "", "ID of the goal, generated if empty")
+	name := fs.String("name", "", "name of the goal")
+	target := fs.Float64("target", 0, "target value of the goal")
+	unit := fs.String("unit", "", "unit of the goal, e.g. km")
+	fs.Parse(args)
+	requireFlags(fs, user, objectiveRef, name)
+
+	storage := newStorage()
+	checkEnvironment(storage)
+	objectiveID, _, err := storage.ResolveIDs(*user, *objectiveRef, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := pursuit.Goal{Name: *name, Target: float32(*target), Unit: *unit}
+	var slug string
+	if *id != "" {
+		err = storage.CreateGoal(*user, objectiveID, *id, g)
+	} else {
+		*id, slug, err = storage.AddGoal(*user, objectiveID, g)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	json.NewEncoder(os.Stdout).Encode(map[string]string{"id": *id, "slug": slug})
+}
+
+// listGoals prints one JSON object per goal of a user.
+func listGoals(args []string) {
+	fs := flag.NewFlagSet("goal list", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the goals")
+	fs.Parse(args)
+	requireFlags(fs, user)
+
+	goals, err := newStorage().ListGoals(*user)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, g := range goals {
+		enc.Encode(g)
+	}
+}
+
+// changeGoalValue sets or increments the value of a goal, as op says.
+func changeGoalValue(op string, args []string) {
+	fs := flag.NewFlagSet("goal "+op, flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the goal")
+	objectiveRef := fs.String("objective", "", "ID or slug of the objective")
+	goalRef := fs.String("goal", "", "ID or slug of the goal")
+	value := fs.Float64("value", 0, "value to set, or to add for increment")
+	unit := fs.String("unit", "", "unit of the value, the unit of the goal if empty")
+	fs.Parse(args)
+	requireFlags(fs, user, objectiveRef, goalRef)
+
+	storage := newStorage()
+	checkEnvironment(storage)
+	objectiveID, goalID, err := storage.ResolveIDs(*user, *objectiveRef, *goalRef)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if op == "set" {
+		err = storage.SetGoalValue(*user, objectiveID, goalID, float32(*value), *unit)
+	} else {
+		err = storage.IncrementGoalValue(*user, objectiveID, goalID, float32(*value), *unit)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,369 @@
+// Command pursuit administers the objectives stored in Firestore.
+//
+// Usage:
+//
+//	pursuit [-project id] [-emulator host:port] [-credentials file] [-env name] <command> [flags]
+//
+// The project defaults to the GOOGLE_CLOUD_PROJECT environment variable,
+// and to the production project if that is not set either. Likewise, the
+// emulator defaults to FIRESTORE_EMULATOR_HOST.
+//
+// Commands that change objectives refuse to run against a database that
+// is labeled prod, or not labeled at all, unless -env prod is given. They
+// also refuse if -env does not match the label of the database.
+//
+// The commands are:
+//
+//	migrate      upgrade all objectives to a schema version
+//	fsck         check all objectives for violated invariants
+//	apply        apply a YAML spec of objectives and goals to a user
+//	diff         show the changes that apply would make
+//	label        label the database with the environment given by -env
+//	rename       rename goals and rewrite the references to them
+//	objective    create and list objectives of a user
+//	goal         create, list and update goals of a user
+//	experiments  compare the variants of the running experiments
+//	version      print the build information, and check it against a server
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeadorf/pursuit"
+)
+
+var (
+	project  = flag.String("project", envOr("GOOGLE_CLOUD_PROJECT", "pursuit-284716"), "Firebase project ID")
+	emulator = flag.String("emulator", os.Getenv("FIRESTORE_EMULATOR_HOST"), "host:port of a Firestore emulator to use instead of Firestore")
+	creds    = flag.String("credentials", "", "path of a service account key, application default credentials if empty")
+	env      = flag.String("env", "", "environment of the database, required to be prod to change production")
+)
+
+// envOr returns the value of the environment variable, or def if it is not
+// set.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// newStorage connects to the Firestore database selected by the flags.
+func newStorage() *pursuit.Storage {
+	storage, err := pursuit.NewStorageWithConfig(pursuit.StorageConfig{
+		ProjectID:       *project,
+		EmulatorHost:    *emulator,
+		CredentialsFile: *creds,
+		Environment:     *env,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return storage
+}
+
+// checkEnvironment exits unless the environment given by -env allows to
+// change the database of the storage.
+func checkEnvironment(storage *pursuit.Storage) {
+	label, err := storage.DatabaseEnvironment()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := pursuit.CheckEnvironment(label, *env); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "migrate":
+		migrate(args)
+	case "fsck":
+		fsck(args)
+	case "apply":
+		apply(args, false)
+	case "diff":
+		apply(args, true)
+	case "label":
+		label()
+	case "rename":
+		rename(args)
+	case "objective":
+		objective(args)
+	case "goal":
+		goal(args)
+	case "experiments":
+		experiments(args)
+	case "version":
+		version(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pursuit [-project id] [-emulator host:port] [-credentials file] [-env name] <command> [flags]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  migrate      upgrade all objectives to a schema version\n")
+	fmt.Fprintf(os.Stderr, "  fsck         check all objectives for violated invariants\n")
+	fmt.Fprintf(os.Stderr, "  apply        apply a YAML spec of objectives and goals to a user\n")
+	fmt.Fprintf(os.Stderr, "  diff         show the changes that apply would make\n")
+	fmt.Fprintf(os.Stderr, "  label        label the database with the environment given by -env\n")
+	fmt.Fprintf(os.Stderr, "  rename       rename goals and rewrite the references to them\n")
+	fmt.Fprintf(os.Stderr, "  objective    create and list objectives of a user\n")
+	fmt.Fprintf(os.Stderr, "  goal         create, list and update goals of a user\n")
+	fmt.Fprintf(os.Stderr, "  experiments  compare the variants of the running experiments\n")
+	fmt.Fprintf(os.Stderr, "  version      print the build information, and check it against a server\n\n")
+	flag.PrintDefaults()
+}
+
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.String("to", fmt.Sprintf("v%d", pursuit.LatestSchemaVersion), "target schema version, e.g. v2")
+	workers := fs.Int("workers", 4, "number of users migrated in parallel")
+	rate := fs.Float64("rate", 50, "maximum objectives written per second, 0 for no limit")
+	pageSize := fs.Int("page-size", 100, "number of users migrated between checkpoints")
+	autoApprove := fs.Bool("auto-approve", false, "migrate without asking for approval")
+	fs.Parse(args)
+
+	version, err := pursuit.ParseSchemaVersion(*to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage := newStorage()
+	checkEnvironment(storage)
+	plan, err := storage.PlanMigration(version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(plan)
+	if plan.Empty() || !approve(*autoApprove) {
+		return
+	}
+	opts := pursuit.MigrateOptions{
+		To:       version,
+		Workers:  *workers,
+		Rate:     *rate,
+		PageSize: *pageSize,
+	}
+	err = storage.Migrate(opts, func(c pursuit.MigrationCheckpoint) {
+		log.Printf("v%d: %d users, %d objectives migrated, cursor %q", version, c.Users, c.Migrated, c.Cursor)
+	})
+	if err != nil {
+		log.Fatalf("Migration interrupted, rerun to resume: %v", err)
+	}
+	log.Printf("v%d: done", version)
+}
+
+// fsck writes one JSON object per problem to standard output and exits
+// with status 1 if any problem was left unrepaired.
+func fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "repair problems where possible")
+	fs.Parse(args)
+
+	storage := newStorage()
+	if *repair {
+		checkEnvironment(storage)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	unrepaired := 0
+	err := storage.Fsck(*repair, func(p pursuit.Problem) {
+		if !p.Repaired {
+			unrepaired++
+		}
+		enc.Encode(p)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if unrepaired > 0 {
+		os.Exit(1)
+	}
+}
+
+// apply prints the plan of the spec. Unless dryRun, as for pursuit diff,
+// it then applies the plan once it is approved.
+func apply(args []string, dryRun bool) {
+	name := "apply"
+	if dryRun {
+		name = "diff"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the objectives")
+	file := fs.String("f", "", "path of the YAML spec")
+	var autoApprove *bool
+	if !dryRun {
+		autoApprove = fs.Bool("auto-approve", false, "apply without asking for approval")
+	}
+	fs.Parse(args)
+	if *user == "" || *file == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	spec, err := pursuit.ParseSpec(data)
+	if err != nil {
+		log.Fatalf("Invalid spec %s: %v", *file, err)
+	}
+	storage := newStorage()
+	plan, err := storage.PlanSpec(*user, spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(plan.Plan())
+	if dryRun || plan.Plan().Empty() {
+		return
+	}
+	checkEnvironment(storage)
+	if !approve(*autoApprove) {
+		return
+	}
+	if err := storage.ApplySpecPlan(plan); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Apply complete")
+}
+
+// label labels the database with the environment given by -env. Labels
+// of production databases cannot be changed, so that they stay guarded.
+func label() {
+	if *env == "" {
+		log.Fatal("Missing -env")
+	}
+	storage := newStorage()
+	current, err := storage.DatabaseEnvironment()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if current == pursuit.EnvProduction && *env != pursuit.EnvProduction {
+		log.Fatalf("Refusing to relabel the %s database as %s", current, *env)
+	}
+	if err := storage.LabelDatabase(*env); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Labeled the database as %s", *env)
+}
+
+// rename changes the IDs and slugs of goals of a user, given as arguments
+// of the form objective/goal=id, objective/goal=@slug or
+// objective/goal=id@slug. It prints what would change, and renames the
+// goals once approved.
+func rename(args []string) {
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	user := fs.String("user", "", "ID of the user who owns the goals")
+	autoApprove := fs.Bool("auto-approve", false, "rename without asking for approval")
+	fs.Parse(args)
+	if *user == "" || fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: pursuit rename -user id objective/goal=id@slug...\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var renames []pursuit.GoalRename
+	for _, arg := range fs.Args() {
+		r, err := pursuit.ParseGoalRename(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		renames = append(renames, r)
+	}
+	storage := newStorage()
+	checkEnvironment(storage)
+	report, err := storage.RenameGoals(*user, renames, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report)
+	if !approve(*autoApprove) {
+		return
+	}
+	if _, err := storage.RenameGoals(*user, renames, false); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Rename complete")
+}
+
+// experiments writes the results of the running experiments as JSON to
+// standard output.
+func experiments(args []string) {
+	fs := flag.NewFlagSet("experiments", flag.ExitOnError)
+	days := fs.Int("days", 30, "number of days of events to count")
+	fs.Parse(args)
+
+	since := time.Now().AddDate(0, 0, -*days).UnixNano() / 1000 / 1000
+	results, err := newStorage().ExperimentResults(since)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(results)
+}
+
+// version prints the build information of the CLI. With -server, it also
+// prints that of the server, and warns if the server speaks another
+// version of the API.
+func version(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	server := fs.String("server", "", "base URL of a server to check, e.g. https://api.example.com")
+	fs.Parse(args)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.Encode(pursuit.CurrentBuildInfo())
+	if *server == "" {
+		return
+	}
+	resp, err := http.Get(strings.TrimSuffix(*server, "/") + "/version")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error reading the version of %s: %s", *server, resp.Status)
+	}
+	var info pursuit.BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		log.Fatalf("Error reading the version of %s: %v", *server, err)
+	}
+	enc.Encode(info)
+	if err := pursuit.CheckAPIVersion(info.APIVersion); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// approve asks for approval of a plan on standard input, unless
+// autoApprove is set. Anything but "yes" cancels.
+func approve(autoApprove bool) bool {
+	if autoApprove {
+		return true
+	}
+	fmt.Print("\nDo you want to perform these actions? Only 'yes' will be accepted to approve.\n\n  Enter a value: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(line) != "yes" {
+		log.Printf("Cancelled")
+		return false
+	}
+	return true
+}
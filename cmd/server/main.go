@@ -0,0 +1,243 @@
+// Command server serves the pursuit HTTP API.
+//
+// If the environment variable PPROF_TOKEN is set, runtime profiles are
+// served under /debug/pprof/, and expvars such as cache statistics under
+// /debug/vars, to requests that present the token as a bearer token.
+//
+// If the environment variable COALESCE_WINDOW is set to a duration such
+// as "10s", increments of the same goal within that window are written
+// as a single point on the trajectory.
+//
+// If the environment variable RATE_LIMIT is set to a number, each user may
+// make that many requests per minute that change data, in bursts of up to
+// RATE_LIMIT_BURST requests, which defaults to RATE_LIMIT. Further
+// requests are rejected with 429 Too Many Requests.
+//
+// If the environment variable TRUSTED_PROXIES is set to a number, the
+// address of clients is taken from X-Forwarded-For as appended by that
+// many proxies, such as 2 behind a load balancer in front of Cloud Run.
+// It defaults to 1, for the front end of Cloud Run.
+//
+// If the environment variable DIGEST_TEMPLATES is set to a directory,
+// digests are rendered with the Go templates digest.txt and digest.html
+// from that directory. Templates that are missing or invalid fall back to
+// the built-in ones.
+//
+// If the environment variable BIGQUERY_DATASET is set, /tasks/bigquerysync
+// streams trajectories into the tables points and goals of that dataset.
+//
+// If the environment variable EXPORT_BUCKET is set, POST
+// /users/{user}/exports writes exports into that Cloud Storage bucket in
+// the background, and hands them out as URLs signed by the service
+// account in EXPORT_SERVICE_ACCOUNT.
+//
+// If the environment variable SNAPSHOT_BUCKET is set,
+// /tasks/publishsnapshots writes snapshots of shared objectives and badges
+// of their goals into that public Cloud Storage bucket, under
+// shared/{token}/{objective}.json and shared/{token}/{objective}/{goal}.json,
+// where {token} is the hex SHA-256 hash of the secret of the share token.
+//
+// If the environment variable IMPORTERS is set to a comma-separated list
+// of source=path pairs, such as "fitbit=/bin/import-fitbit", imports from
+// those sources run the executables, see pursuit.ExecImporter. The
+// sources exist and habitica are built in, see pursuit.NewExistImporter
+// and pursuit.NewHabiticaImporter. /tasks/import runs all imports.
+//
+// If the environment variable STRAVA_CLIENT_ID is set, users can connect
+// their Strava accounts with the client secret in STRAVA_CLIENT_SECRET,
+// and Strava redirects to STRAVA_REDIRECT_URL, the public URL of
+// /strava/callback. Events of the webhook subscription with the ID in
+// STRAVA_SUBSCRIPTION_ID are received at /webhooks/strava, whose handshake
+// must present STRAVA_VERIFY_TOKEN.
+//
+// GET / reports the revision in K_REVISION, which Cloud Run sets, as the
+// version of the service.
+//
+// If the environment variable ENVIRONMENT is set, such as to "staging",
+// events and exported data are labeled with it instead of "prod".
+//
+// If the environment variable GOOGLE_CLOUD_PROJECT is set, the server uses
+// the Firebase project with that ID instead of the production project.
+//
+// If the environment variable FIRESTORE_EMULATOR_HOST is set, the server
+// uses the Firestore emulator at that host:port instead of Firestore.
+//
+// If the environment variable TRAJECTORY_SUBCOLLECTION is set to "true",
+// new values of goals are stored in a subcollection per goal instead of
+// inline in the objective, see pursuit.Storage.UseTrajectorySubcollection.
+//
+// Scheduled jobs under /tasks/ only run for callers that present the
+// token in the environment variable TASK_TOKEN as bearer token, or, if
+// SCHEDULER_SERVICE_ACCOUNT is set, an OIDC token that Cloud Scheduler
+// issued for that service account with the audience in
+// SCHEDULER_AUDIENCE, such as the URL of the service.
+//
+// If the environment variable AUDIT_KEY is set, entries of audit logs are
+// signed with an HMAC with that key, so that they cannot be forged by
+// anyone who can write to Firestore but does not know the key, see
+// pursuit.Storage.UseAuditKey.
+//
+// If the environment variable SANDBOX is set to "true", all data is kept
+// in the sandbox namespace, which /tasks/purgesandbox deletes. Share
+// tokens created in the sandbox start with test_ and are rejected
+// elsewhere.
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jeadorf/pursuit"
+)
+
+// defaultProjectID is the Firebase project of the production deployment.
+const defaultProjectID = "pursuit-284716"
+
+func main() {
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if projectID == "" {
+		projectID = defaultProjectID
+	}
+	storage, err := pursuit.NewStorageWithConfig(pursuit.StorageConfig{
+		ProjectID:    projectID,
+		Environment:  os.Getenv("ENVIRONMENT"),
+		EmulatorHost: os.Getenv("FIRESTORE_EMULATOR_HOST"),
+	})
+	if err != nil {
+		pursuit.LogFatalf("%v", err)
+	}
+	if os.Getenv("SANDBOX") == "true" {
+		storage.UseNamespace(pursuit.SandboxNamespace)
+	}
+	if os.Getenv("TRAJECTORY_SUBCOLLECTION") == "true" {
+		storage.UseTrajectorySubcollection()
+	}
+	if key := os.Getenv("AUDIT_KEY"); key != "" {
+		storage.UseAuditKey(key)
+	}
+
+	server := pursuit.NewServer(storage)
+	server.SetVersion(os.Getenv("K_REVISION"))
+	server.UseIDTokenVerifier(pursuit.NewFirebaseAuth(projectID))
+	server.UsePushSender(pursuit.NewFCMSender(projectID))
+	if window := os.Getenv("COALESCE_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			pursuit.LogFatalf("Invalid COALESCE_WINDOW: %v", err)
+		}
+		server.CoalesceIncrements(d)
+	}
+	if limit := os.Getenv("RATE_LIMIT"); limit != "" {
+		perMinute, err := strconv.Atoi(limit)
+		if err != nil || perMinute <= 0 {
+			pursuit.LogFatalf("Invalid RATE_LIMIT: %q", limit)
+		}
+		burst := perMinute
+		if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+			burst, err = strconv.Atoi(v)
+			if err != nil || burst <= 0 {
+				pursuit.LogFatalf("Invalid RATE_LIMIT_BURST: %q", v)
+			}
+		}
+		server.LimitRate(perMinute, burst)
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			pursuit.LogFatalf("Invalid TRUSTED_PROXIES: %q", v)
+		}
+		server.TrustProxies(n)
+	}
+	if dir := os.Getenv("DIGEST_TEMPLATES"); dir != "" {
+		templates, errs := pursuit.LoadDigestTemplates(dir)
+		for _, err := range errs {
+			pursuit.LogErrorf("Error loading digest templates: %v", err)
+		}
+		server.UseDigestTemplates(templates)
+	}
+	if bucket := os.Getenv("EXPORT_BUCKET"); bucket != "" {
+		server.UseExportBucket(pursuit.NewExportBucket(bucket, os.Getenv("EXPORT_SERVICE_ACCOUNT")))
+	}
+	if bucket := os.Getenv("SNAPSHOT_BUCKET"); bucket != "" {
+		server.PublishSnapshots(pursuit.NewExportBucket(bucket, ""))
+	}
+	if dataset := os.Getenv("BIGQUERY_DATASET"); dataset != "" {
+		server.SyncBigQuery(pursuit.NewBigQuerySync(projectID, dataset))
+	}
+	pursuit.RegisterImporter("exist", pursuit.NewExistImporter)
+	pursuit.RegisterImporter("habitica", pursuit.NewHabiticaImporter)
+	if list := os.Getenv("IMPORTERS"); list != "" {
+		for _, importer := range strings.Split(list, ",") {
+			i := strings.Index(importer, "=")
+			if i <= 0 {
+				pursuit.LogFatalf("Invalid IMPORTERS: %q", importer)
+			}
+			pursuit.RegisterImporter(importer[:i], pursuit.ExecImporter(importer[i+1:]))
+		}
+	}
+	if id := os.Getenv("STRAVA_CLIENT_ID"); id != "" {
+		// The subscription is only known after its handshake, so events
+		// are rejected until it is set.
+		var subscription int64
+		if v := os.Getenv("STRAVA_SUBSCRIPTION_ID"); v != "" {
+			var err error
+			if subscription, err = strconv.ParseInt(v, 10, 64); err != nil {
+				pursuit.LogFatalf("Invalid STRAVA_SUBSCRIPTION_ID: %v", err)
+			}
+		}
+		pursuit.RegisterWebhookVerifier("strava", &pursuit.StravaVerifier{
+			VerifyToken:    os.Getenv("STRAVA_VERIFY_TOKEN"),
+			SubscriptionID: subscription,
+		})
+		server.UseStrava(pursuit.NewStrava(id, os.Getenv("STRAVA_CLIENT_SECRET"), os.Getenv("STRAVA_REDIRECT_URL")))
+	}
+
+	if token := os.Getenv("TASK_TOKEN"); token != "" {
+		server.UseTaskToken(token)
+	}
+	if email := os.Getenv("SCHEDULER_SERVICE_ACCOUNT"); email != "" {
+		server.UseSchedulerIdentity(os.Getenv("SCHEDULER_AUDIENCE"), email)
+	}
+
+	var handler http.Handler = server
+	if token := os.Getenv("PPROF_TOKEN"); token != "" {
+		mux := http.NewServeMux()
+		debug := pursuit.DebugHandler(token)
+		mux.Handle("/debug/pprof/", debug)
+		mux.Handle("/debug/vars", debug)
+		mux.Handle("/", handler)
+		handler = mux
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	srv := pursuit.NewHTTPServer(":"+port, handler)
+
+	// Cloud Run sends SIGTERM before stopping an instance, which leaves
+	// time to write pending increments.
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		if err := srv.Shutdown(context.Background()); err != nil {
+			pursuit.LogErrorf("Error shutting down: %v", err)
+		}
+		server.Flush()
+		close(done)
+	}()
+
+	pursuit.LogInfof("Listening on port %s", port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		pursuit.LogFatalf("%v", err)
+	}
+	<-done
+}
@@ -0,0 +1,84 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// goalKey identifies a goal across users and objectives, together with
+// the unit of its increments. Increments in different units are written
+// separately.
+type goalKey struct {
+	User      string
+	Objective string
+	Goal      string
+	Unit      string
+}
+
+// incrementCoalescer sums up increments of the same goal that arrive
+// within a window and writes them as a single point on the trajectory,
+// which saves Firestore writes for bursty sources like webhooks.
+type incrementCoalescer struct {
+	window time.Duration
+	write  func(k goalKey, delta float32) error
+
+	mu      sync.Mutex
+	pending map[goalKey]float32
+}
+
+func newIncrementCoalescer(window time.Duration, write func(k goalKey, delta float32) error) *incrementCoalescer {
+	return &incrementCoalescer{
+		window:  window,
+		write:   write,
+		pending: map[goalKey]float32{},
+	}
+}
+
+// add schedules an increment. The first increment of a goal starts the
+// window after which all increments of the goal are written.
+func (c *incrementCoalescer) add(k goalKey, delta float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[k]; !ok {
+		time.AfterFunc(c.window, func() { c.flush(k) })
+	}
+	c.pending[k] += delta
+}
+
+// flush writes the pending increments of a goal. If the write fails, the
+// increments are scheduled again so that the total is preserved, unless
+// retrying cannot succeed.
+func (c *incrementCoalescer) flush(k goalKey) {
+	c.mu.Lock()
+	delta, ok := c.pending[k]
+	delete(c.pending, k)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	err := c.write(k, delta)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidValue):
+		logf(context.Background(), severityError, "Error writing coalesced increment of %+v, dropping it: %v", k, err)
+	default:
+		logf(context.Background(), severityWarning, "Error writing coalesced increment of %+v, retrying: %v", k, err)
+		c.add(k, delta)
+	}
+}
+
+// flushAll writes all pending increments immediately, e.g. before the
+// server shuts down.
+func (c *incrementCoalescer) flushAll() {
+	c.mu.Lock()
+	var keys []goalKey
+	for k := range c.pending {
+		keys = append(keys, k)
+	}
+	c.mu.Unlock()
+	for _, k := range keys {
+		c.flush(k)
+	}
+}
@@ -0,0 +1,91 @@
+package pursuit
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedWrites struct {
+	mu     sync.Mutex
+	writes map[goalKey][]float32
+	err    error
+}
+
+func (r *recordedWrites) write(k goalKey, delta float32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.writes[k] = append(r.writes[k], delta)
+	return nil
+}
+
+func TestCoalesceIncrements(t *testing.T) {
+	r := &recordedWrites{writes: map[goalKey][]float32{}}
+	c := newIncrementCoalescer(time.Hour, r.write)
+	a := goalKey{"u", "o", "a", ""}
+	b := goalKey{"u", "o", "b", ""}
+
+	c.add(a, 1)
+	c.add(a, 2)
+	c.add(b, 5)
+	c.flushAll()
+
+	if len(r.writes[a]) != 1 || r.writes[a][0] != 3 {
+		t.Errorf("writes of a were %v; wanted [3]", r.writes[a])
+	}
+	if len(r.writes[b]) != 1 || r.writes[b][0] != 5 {
+		t.Errorf("writes of b were %v; wanted [5]", r.writes[b])
+	}
+}
+
+func TestCoalesceIncrementsAfterWindow(t *testing.T) {
+	r := &recordedWrites{writes: map[goalKey][]float32{}}
+	c := newIncrementCoalescer(10*time.Millisecond, r.write)
+	k := goalKey{"u", "o", "g", ""}
+
+	c.add(k, 1)
+	c.add(k, 1)
+	time.Sleep(100 * time.Millisecond)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.writes[k]) != 1 || r.writes[k][0] != 2 {
+		t.Errorf("writes were %v; wanted [2]", r.writes[k])
+	}
+}
+
+func TestCoalesceIncrementsRetainsFailedWrites(t *testing.T) {
+	r := &recordedWrites{writes: map[goalKey][]float32{}, err: errors.New("unavailable")}
+	c := newIncrementCoalescer(time.Hour, r.write)
+	k := goalKey{"u", "o", "g", ""}
+
+	c.add(k, 1)
+	c.flushAll()
+	r.err = nil
+	c.add(k, 2)
+	c.flushAll()
+
+	if len(r.writes[k]) != 1 || r.writes[k][0] != 3 {
+		t.Errorf("writes were %v; wanted [3]", r.writes[k])
+	}
+}
+
+func TestCoalesceIncrementsDropsInvalidWrites(t *testing.T) {
+	r := &recordedWrites{writes: map[goalKey][]float32{}, err: ErrInvalidValue}
+	c := newIncrementCoalescer(time.Hour, r.write)
+	k := goalKey{"u", "o", "g", ""}
+
+	c.add(k, 1)
+	c.flushAll()
+	r.err = nil
+	c.add(k, 2)
+	c.flushAll()
+
+	if len(r.writes[k]) != 1 || r.writes[k][0] != 2 {
+		t.Errorf("writes were %v; wanted [2]", r.writes[k])
+	}
+}
@@ -0,0 +1,122 @@
+package pursuit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Component is a goal of the same objective whose progress contributes
+// to a composite goal with a weight.
+type Component struct {
+	Goal   string  `firestore:"goal" json:"goal"`
+	Weight float32 `firestore:"weight" json:"weight"`
+}
+
+// IsComposite reports whether the value of the goal is the weighted sum
+// of its components.
+func (g Goal) IsComposite() bool {
+	return len(g.Components) > 0
+}
+
+// checkComponents describes what is wrong with the components of a goal
+// of the objective, or returns an empty string. Components must be other
+// goals of the objective that are not composites themselves, and have
+// positive weights.
+func (o Objective) checkComponents(goalID string) string {
+	for _, c := range o.Goals[goalID].Components {
+		component, ok := o.Goals[c.Goal]
+		switch {
+		case c.Goal == goalID:
+			return "goal is a component of itself"
+		case !ok:
+			return fmt.Sprintf("component %q does not exist", c.Goal)
+		case component.IsComposite():
+			return fmt.Sprintf("component %q is a composite", c.Goal)
+		case !(c.Weight > 0) || !isFinite(c.Weight):
+			return fmt.Sprintf("component %q has weight %v", c.Goal, c.Weight)
+		}
+	}
+	return ""
+}
+
+// compositeValue returns the value of a composite goal of the objective,
+// which is the sum of the progress of its components in percent times
+// their weights. Components without values contribute 0.
+func (o Objective) compositeValue(g Goal) float32 {
+	var value float32
+	for _, c := range g.Components {
+		p := o.Goals[c.Goal].Progress()
+		if math.IsNaN(float64(p)) || math.IsInf(float64(p), 0) {
+			continue
+		}
+		value += c.Weight * 100 * p
+	}
+	return value
+}
+
+// compositeGoals returns the IDs of the composite goals that goalID is a
+// component of, and of their components, which recomputing the
+// composites depends on. It returns them sorted, without duplicates.
+func (o Objective) compositeGoals(goalID string) []string {
+	seen := map[string]bool{}
+	for id, g := range o.Goals {
+		uses := false
+		for _, c := range g.Components {
+			uses = uses || c.Goal == goalID
+		}
+		if !uses {
+			continue
+		}
+		seen[id] = true
+		for _, c := range g.Components {
+			seen[c.Goal] = true
+		}
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// recomputeComposites adds the current value of each composite goal that
+// goalID is a component of to its trajectory, unless the value did not
+// change. It returns the IDs of the composite goals that changed, sorted.
+func (o *Objective) recomputeComposites(goalID string) []string {
+	var changed []string
+	for id, g := range o.Goals {
+		uses := false
+		for _, c := range g.Components {
+			uses = uses || c.Goal == goalID
+		}
+		if !uses {
+			continue
+		}
+		value := o.compositeValue(g)
+		if n := len(g.Trajectory); n > 0 && g.Trajectory[n-1].Value == value {
+			continue
+		}
+		if err := g.SetValue(value); err != nil {
+			continue
+		}
+		o.Goals[id] = g
+		changed = append(changed, id)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// valueGoal returns the goal of the objective whose value is to be
+// changed. Composite goals cannot be changed directly.
+func (o Objective) valueGoal(goalID string) (Goal, error) {
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return Goal{}, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	if g.IsComposite() {
+		return Goal{}, fmt.Errorf("Goal %q is a composite, whose value follows its components: %w", goalID, ErrInvalidValue)
+	}
+	return g, nil
+}
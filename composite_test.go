@@ -0,0 +1,103 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fitnessIndex() Objective {
+	return Objective{Name: "Fitness", Goals: map[string]Goal{
+		"distance":  {Name: "Distance", Target: 100, Trajectory: Trajectory{{Date: 1, Value: 0}}},
+		"elevation": {Name: "Elevation", Target: 1000, Trajectory: Trajectory{{Date: 1, Value: 0}}},
+		"index": {Name: "Fitness index", Target: 100, Components: []Component{
+			{Goal: "distance", Weight: 0.5},
+			{Goal: "elevation", Weight: 0.5},
+		}},
+	}}
+}
+
+func TestRecomputeComposites(t *testing.T) {
+	o := fitnessIndex()
+	if err := o.SetGoalValue("distance", 50); err != nil {
+		t.Fatal(err)
+	}
+	if changed := o.recomputeComposites("distance"); len(changed) != 1 || changed[0] != "index" {
+		t.Errorf("changed composites were %v; wanted index", changed)
+	}
+	if v := o.Goals["index"].Current(); v != 25 {
+		t.Errorf("index was %v; wanted 25", v)
+	}
+	if changed := o.recomputeComposites("distance"); len(changed) != 0 {
+		t.Errorf("unchanged composite was recomputed: %v", changed)
+	}
+	if err := o.SetGoalValue("index", 80); err == nil {
+		t.Errorf("value of a composite was set directly")
+	}
+}
+
+func TestCheckComponents(t *testing.T) {
+	tests := map[string][]Component{
+		"goal is a component of itself":      {{Goal: "index", Weight: 1}},
+		`component "swim" does not exist`:    {{Goal: "swim", Weight: 1}},
+		`component "nested" is a composite`:  {{Goal: "nested", Weight: 1}},
+		`component "distance" has weight -1`: {{Goal: "distance", Weight: -1}},
+	}
+	for want, components := range tests {
+		o := fitnessIndex()
+		o.Goals["nested"] = Goal{Name: "Nested", Target: 1, Components: []Component{{Goal: "distance", Weight: 1}}}
+		g := o.Goals["index"]
+		g.Components = components
+		o.Goals["index"] = g
+		if got := o.checkComponents("index"); got != want {
+			t.Errorf("problem was %q; wanted %q", got, want)
+		}
+	}
+	o := fitnessIndex()
+	if err := validateObjective(o); err != nil {
+		t.Errorf("valid composite was rejected: %v", err)
+	}
+}
+
+func TestSetGoalValueHandlerComposite(t *testing.T) {
+	s, goals := newMemoryServer()
+	goals.PutObjective("alice", "fitness", fitnessIndex())
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"objective": "fitness", "goal": "elevation", "value": 500}`, http.StatusNoContent},
+		{`{"objective": "fitness", "goal": "index", "value": 99}`, http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer a.alice.c")
+		w := httptest.NewRecorder()
+		s.setGoalValue(w, r)
+		if w.Code != tt.want {
+			t.Errorf("status of %s was %d; wanted %d", tt.body, w.Code, tt.want)
+		}
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if tr := o.Goals["index"].Trajectory; len(tr) != 1 || tr[0].Value != 25 {
+		t.Errorf("index trajectory was %+v; wanted 25", tr)
+	}
+}
+
+func TestCompositeGoals(t *testing.T) {
+	o := Objective{Goals: map[string]Goal{
+		"run":    {},
+		"swim":   {},
+		"read":   {},
+		"sports": {Components: []Component{{Goal: "run", Weight: 0.5}, {Goal: "swim", Weight: 0.5}}},
+		"all":    {Components: []Component{{Goal: "sports", Weight: 0.5}, {Goal: "read", Weight: 0.5}}},
+	}}
+
+	if got, want := o.compositeGoals("run"), []string{"run", "sports", "swim"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("goals of run were %v; wanted %v", got, want)
+	}
+	if got := o.compositeGoals("all"); len(got) != 0 {
+		t.Errorf("goals of all were %v; wanted none", got)
+	}
+}
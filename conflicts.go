@@ -0,0 +1,230 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// MetadataEdit changes a text field of an objective, or of one of its
+// goals if Goal is set. Offline clients queue edits and replay them once
+// they are online again. Base is the value of the field that the client
+// saw when the edit was made.
+type MetadataEdit struct {
+	Goal  string
+	Field string
+	Base  string
+	Value string
+}
+
+// Conflict is an edit that was not applied, because the field was changed
+// by another client since the edit was made. Conflicts are stored in
+// users/{user}/conflicts until they are resolved.
+type Conflict struct {
+	Objective string `firestore:"objective" json:"objective"`
+	Goal      string `firestore:"goal,omitempty" json:"goal,omitempty"`
+	Field     string `firestore:"field" json:"field"`
+	Base      string `firestore:"base" json:"base"`
+	// Local is the value of the edit, and Remote the value that was stored
+	// when the edit was replayed.
+	Local  string `firestore:"local" json:"local"`
+	Remote string `firestore:"remote" json:"remote"`
+	// Created in milliseconds since the epoch.
+	Created int64 `firestore:"created" json:"created"`
+}
+
+// ConflictEntry is a conflict together with its ID.
+type ConflictEntry struct {
+	ID string `json:"id"`
+	Conflict
+}
+
+// metadata returns the value of a text field of the objective or of one of
+// its goals.
+func (o Objective) metadata(goalID, field string) (string, error) {
+	if goalID == "" {
+		switch field {
+		case "name":
+			return o.Name, nil
+		case "description":
+			return o.Description, nil
+		}
+		return "", fmt.Errorf("Unknown field of objective: %q: %w", field, ErrInvalidValue)
+	}
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return "", fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	switch field {
+	case "name":
+		return g.Name, nil
+	case "unit":
+		return g.Unit, nil
+	case "stage":
+		return g.Stage, nil
+	}
+	return "", fmt.Errorf("Unknown field of goal: %q: %w", field, ErrInvalidValue)
+}
+
+// setMetadata changes a text field of the objective or of one of its
+// goals.
+func (o *Objective) setMetadata(goalID, field, value string) error {
+	if _, err := o.metadata(goalID, field); err != nil {
+		return err
+	}
+	if goalID == "" {
+		switch field {
+		case "name":
+			o.Name = value
+		case "description":
+			o.Description = value
+		}
+		return nil
+	}
+	g := o.Goals[goalID]
+	switch field {
+	case "name":
+		g.Name = value
+	case "unit":
+		g.Unit = value
+	case "stage":
+		if !validStage(value) {
+			return fmt.Errorf("Unknown stage: %q: %w", value, ErrInvalidValue)
+		}
+		g.Stage = value
+	}
+	o.Goals[goalID] = g
+	return nil
+}
+
+// ApplyEdit applies the edit unless the field was changed to a different
+// value since the edit was made, in which case the edit is returned as a
+// conflict and the objective is left unchanged.
+func (o *Objective) ApplyEdit(objectiveID string, e MetadataEdit, now int64) (*Conflict, error) {
+	current, err := o.metadata(e.Goal, e.Field)
+	if err != nil {
+		return nil, err
+	}
+	if current != e.Base && current != e.Value {
+		return &Conflict{
+			Objective: objectiveID,
+			Goal:      e.Goal,
+			Field:     e.Field,
+			Base:      e.Base,
+			Local:     e.Value,
+			Remote:    current,
+			Created:   now,
+		}, nil
+	}
+	return nil, o.setMetadata(e.Goal, e.Field, e.Value)
+}
+
+// ApplyEdits applies queued edits of an objective in order, and stores and
+// returns the edits that conflict with changes by other clients.
+func (s Storage) ApplyEdits(userID, objectiveID string, edits []MetadataEdit) ([]ConflictEntry, error) {
+	user := s.collection("users").Doc(userID)
+	ref := user.Collection("objectives").Doc(objectiveID)
+	now := time.Now().UnixNano() / 1000 / 1000
+	var conflicts []ConflictEntry
+	err := s.transaction("ApplyEdits", func(tx *firestore.Transaction) error {
+		conflicts = []ConflictEntry{}
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var objective Objective
+		if err := doc.DataTo(&objective); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		for _, e := range edits {
+			c, err := objective.ApplyEdit(objectiveID, e, now)
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				continue
+			}
+			cref := user.Collection("conflicts").NewDoc()
+			if err := tx.Create(cref, *c); err != nil {
+				return err
+			}
+			conflicts = append(conflicts, ConflictEntry{cref.ID, *c})
+		}
+		return tx.Set(ref, objective)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// ListConflicts returns the unresolved conflicts of a user, oldest first.
+func (s Storage) ListConflicts(userID string) ([]ConflictEntry, error) {
+	q := s.collection("users").Doc(userID).Collection("conflicts").OrderBy("created", firestore.Asc)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListConflicts", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing conflicts: %w", err)
+	}
+	conflicts := make([]ConflictEntry, 0, len(docs))
+	for _, doc := range docs {
+		var c Conflict
+		if err := doc.DataTo(&c); err != nil {
+			return nil, fmt.Errorf("Error reading conflict %q: %w", doc.Ref.ID, err)
+		}
+		conflicts = append(conflicts, ConflictEntry{doc.Ref.ID, c})
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict sets the field of the conflict to the chosen value, which
+// is usually either the local or the remote version, and removes the
+// conflict.
+func (s Storage) ResolveConflict(userID, id, value string) error {
+	user := s.collection("users").Doc(userID)
+	cref := user.Collection("conflicts").Doc(id)
+	return s.transaction("ResolveConflict", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(cref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such conflict: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading conflict: %w", err)
+		}
+		var c Conflict
+		if err := doc.DataTo(&c); err != nil {
+			return fmt.Errorf("Error reading conflict: %w", err)
+		}
+		ref := user.Collection("objectives").Doc(c.Objective)
+		doc, err = tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			// The objective was deleted, so there is nothing left to resolve.
+			return tx.Delete(cref)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var objective Objective
+		if err := doc.DataTo(&objective); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if err := objective.setMetadata(c.Goal, c.Field, value); errors.Is(err, ErrNotFound) {
+			return tx.Delete(cref)
+		} else if err != nil {
+			return err
+		}
+		if err := tx.Set(ref, objective); err != nil {
+			return err
+		}
+		return tx.Delete(cref)
+	})
+}
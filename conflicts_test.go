@@ -0,0 +1,68 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func conflictTestObjective() Objective {
+	return Objective{
+		Name: "Health",
+		Goals: map[string]Goal{
+			"g": {Name: "Run", Unit: "km"},
+		},
+	}
+}
+
+func TestApplyEdit(t *testing.T) {
+	o := conflictTestObjective()
+
+	c, err := o.ApplyEdit("o", MetadataEdit{Goal: "g", Field: "name", Base: "Run", Value: "Jog"}, 1)
+
+	if err != nil || c != nil {
+		t.Fatalf("edit returned %v, %v; wanted no conflict", c, err)
+	}
+	if o.Goals["g"].Name != "Jog" {
+		t.Errorf("name was %q; wanted Jog", o.Goals["g"].Name)
+	}
+}
+
+func TestApplyEditConflict(t *testing.T) {
+	o := conflictTestObjective()
+	o.Goals["g"] = Goal{Name: "Sprint"}
+
+	c, err := o.ApplyEdit("o", MetadataEdit{Goal: "g", Field: "name", Base: "Run", Value: "Jog"}, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Conflict{Objective: "o", Goal: "g", Field: "name", Base: "Run", Local: "Jog", Remote: "Sprint", Created: 1}
+	if c == nil || *c != want {
+		t.Errorf("conflict was %+v; wanted %+v", c, want)
+	}
+	if o.Goals["g"].Name != "Sprint" {
+		t.Errorf("conflicting edit was applied")
+	}
+}
+
+func TestApplyEditSameValue(t *testing.T) {
+	o := conflictTestObjective()
+	o.Name = "Fitness"
+
+	c, err := o.ApplyEdit("o", MetadataEdit{Field: "name", Base: "Health", Value: "Fitness"}, 1)
+
+	if err != nil || c != nil {
+		t.Errorf("identical edits returned %v, %v; wanted no conflict", c, err)
+	}
+}
+
+func TestApplyEditInvalid(t *testing.T) {
+	o := conflictTestObjective()
+
+	if _, err := o.ApplyEdit("o", MetadataEdit{Goal: "g", Field: "target"}, 1); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("edit of unknown field returned %v; wanted ErrInvalidValue", err)
+	}
+	if _, err := o.ApplyEdit("o", MetadataEdit{Goal: "x", Field: "name"}, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("edit of unknown goal returned %v; wanted ErrNotFound", err)
+	}
+}
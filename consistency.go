@@ -0,0 +1,33 @@
+package pursuit
+
+import "math"
+
+// consistencyWeeks is the number of trailing weeks over which digests
+// measure the consistency of goals.
+const consistencyWeeks = 4
+
+// Consistency measures how regularly values are logged for the goal: it
+// is the fraction of the days of the trailing weeks up to and including
+// the day of now on which the goal got values. Days before the start or
+// after the end of the goal are not expected to have values. Days are in
+// UTC. It is NaN if no day is expected.
+func (g Goal) Consistency(now int64, weeks int) float32 {
+	last, _ := recordPeriods(now)
+	first := last - int64(7*weeks-1)*day
+	if start, _ := recordPeriods(g.Start); start > first {
+		first = start
+	}
+	if g.End != 0 && g.End <= now {
+		last, _ = recordPeriods(g.End - 1)
+	}
+	if last < first {
+		return float32(math.NaN())
+	}
+	logged := map[int64]bool{}
+	for _, p := range g.Trajectory {
+		if d, _ := recordPeriods(p.Date); d >= first && d <= last {
+			logged[d] = true
+		}
+	}
+	return float32(len(logged)) / float32((last-first)/day+1)
+}
@@ -0,0 +1,37 @@
+package pursuit
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConsistency(t *testing.T) {
+	g := Goal{Trajectory: Trajectory{
+		{Date: 0, Value: 1},
+		{Date: 20 * day, Value: 2},
+		{Date: 21*day + 1, Value: 3},
+		{Date: 21*day + 2, Value: 4},
+		{Date: 27 * day, Value: 5},
+	}}
+	for _, tc := range []struct {
+		start, end int64
+		weeks      int
+		want       float32
+	}{
+		// Days 21 and 27 out of days 21 to 27.
+		{0, 0, 1, 2.0 / 7},
+		// Days 0, 20, 21 and 27 out of days 0 to 27.
+		{0, 0, 4, 4.0 / 28},
+		// Days 20 and 21 out of days 15 to 21.
+		{15 * day, 22 * day, 4, 2.0 / 7},
+	} {
+		g.Start, g.End = tc.start, tc.end
+		if c := g.Consistency(27*day+5, tc.weeks); math.Abs(float64(c-tc.want)) > 1e-6 {
+			t.Errorf("consistency over %d weeks from %d to %d was %v; wanted %v", tc.weeks, tc.start, tc.end, c, tc.want)
+		}
+	}
+	g.Start = 30 * day
+	if c := g.Consistency(27*day, 4); !math.IsNaN(float64(c)) {
+		t.Errorf("consistency before the start was %v; wanted NaN", c)
+	}
+}
@@ -0,0 +1,60 @@
+package pursuit
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvDateFormat is ISO 8601 in UTC with milliseconds, which spreadsheets
+// recognize as dates.
+const csvDateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// objectiveRows flattens the trajectories of the goals of an objective,
+// or of one of its goals if goalID is not empty, ordered by goal and date.
+func objectiveRows(objectiveID string, o Objective, goalID string) ([]TrajectoryRow, error) {
+	if goalID != "" {
+		g, ok := o.Goals[goalID]
+		if !ok {
+			return nil, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+		}
+		o.Goals = map[string]Goal{goalID: g}
+	}
+	return trajectoryRows([]ObjectiveEntry{{objectiveID, o}}), nil
+}
+
+// WriteCSV writes the rows as CSV with a header and the columns objective,
+// goal, name, unit, date and value. Dates are in ISO 8601.
+func WriteCSV(w io.Writer, rows []TrajectoryRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"objective", "goal", "name", "unit", "date", "value"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Objective,
+			r.Goal,
+			r.Name,
+			r.Unit,
+			time.Unix(0, r.Date*int64(time.Millisecond)).UTC().Format(csvDateFormat),
+			strconv.FormatFloat(float64(r.Value), 'g', -1, 32),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportCSV writes the trajectories of the goals of an objective of a
+// user as CSV, see WriteCSV. If goalID is not empty, only the trajectory
+// of that goal is written.
+func (s Storage) ExportCSV(w io.Writer, userID, objectiveID, goalID string) error {
+	o, err := s.GetObjective(userID, objectiveID)
+	if err != nil {
+		return err
+	}
+	rows, err := objectiveRows(objectiveID, o, goalID)
+	if err != nil {
+		return err
+	}
+	return WriteCSV(w, rows)
+}
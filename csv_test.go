@@ -0,0 +1,63 @@
+package pursuit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	rows := []TrajectoryRow{
+		{"fitness", "run", "Run, outside", "km", 1600000000123, 5.5},
+		{"fitness", "swim", "Swim", "", 0, 1e6},
+	}
+	var b bytes.Buffer
+	if err := WriteCSV(&b, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "objective,goal,name,unit,date,value\n" +
+		"fitness,run,\"Run, outside\",km,2020-09-13T12:26:40.123Z,5.5\n" +
+		"fitness,swim,Swim,,1970-01-01T00:00:00.000Z,1e+06\n"
+	if b.String() != want {
+		t.Errorf("CSV was\n%s\nwanted\n%s", b.String(), want)
+	}
+}
+
+func TestExportCSVHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run":  {Name: "Run", Unit: "km", Trajectory: Trajectory{{Date: 0, Value: 1}, {Date: day, Value: 3}}},
+		"swim": {Name: "Swim", Trajectory: Trajectory{{Date: 0, Value: 2}}},
+	}})
+
+	for path, want := range map[string]string{
+		"/users/alice/objectives/fitness/export.csv": "objective,goal,name,unit,date,value\n" +
+			"fitness,run,Run,km,1970-01-01T00:00:00.000Z,1\n" +
+			"fitness,run,Run,km,1970-01-02T00:00:00.000Z,3\n" +
+			"fitness,swim,Swim,,1970-01-01T00:00:00.000Z,2\n",
+		"/users/alice/objectives/fitness/goals/swim/export.csv": "objective,goal,name,unit,date,value\n" +
+			"fitness,swim,Swim,,1970-01-01T00:00:00.000Z,2\n",
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer a.alice.c")
+		w := httptest.NewRecorder()
+
+		s.users(w, r)
+
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("GET %s was %d:\n%s", path, w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Errorf("GET %s had content type %q", path, ct)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/users/alice/objectives/fitness/goals/bike/export.csv", nil)
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+	s.users(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("export of a missing goal was %d; wanted 404", w.Code)
+	}
+}
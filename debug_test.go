@@ -0,0 +1,60 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	h := DebugHandler("secret")
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status was %d; wanted %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDebugHandlerUnauthorized(t *testing.T) {
+	h := DebugHandler("secret")
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+	r.Header.Set("Authorization", "Bearer guess")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status was %d; wanted %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDebugHandlerWithoutToken(t *testing.T) {
+	h := DebugHandler("")
+	r := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status was %d; wanted %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDebugHandlerVars(t *testing.T) {
+	h := DebugHandler("secret")
+	r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"readCache"`) {
+		t.Errorf("vars were %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,291 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+)
+
+// maxDescriptionEdits is how many of the latest edits of a description
+// are kept to transform concurrent edits against. Clients whose edits are
+// based on an older revision have to read the description again.
+const maxDescriptionEdits = 100
+
+// DescriptionEdit is an edit of the description of an objective. Edits
+// are stored in users/{user}/objectives/{objective}/descriptionEdits under
+// their zero-padded revision.
+type DescriptionEdit struct {
+	// Revision numbers the edits of a description from 1. Revision 0 is
+	// the empty description.
+	Revision int64    `firestore:"revision" json:"revision"`
+	Edit     TextEdit `firestore:"edit" json:"edit"`
+	// Length and Hash describe the description after the edit, so that
+	// changes of the description by other means than edits are detected.
+	Length int    `firestore:"length" json:"-"`
+	Hash   string `firestore:"hash" json:"-"`
+	// Date in milliseconds since the epoch.
+	Date int64 `firestore:"date" json:"date"`
+}
+
+// Description is the description of an objective at a revision.
+type Description struct {
+	Revision int64  `json:"revision"`
+	Text     string `json:"text"`
+}
+
+// descriptionHash returns the hash that DescriptionEdit stores of a
+// description.
+func descriptionHash(text string) string {
+	h := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(h[:])
+}
+
+// rebaseDescription returns the edit that turns the description after
+// the latest edit, if any, into the text, or nil if it already is the
+// text. Descriptions that are replaced as a whole, such as by updating the
+// objective, are thereby recorded as edits too.
+func rebaseDescription(latest *DescriptionEdit, text string) *DescriptionEdit {
+	if latest == nil {
+		latest = &DescriptionEdit{Hash: descriptionHash("")}
+	}
+	if latest.Hash == descriptionHash(text) {
+		return nil
+	}
+	var e TextEdit
+	if latest.Length > 0 {
+		e = e.add(TextOp{Delete: latest.Length})
+	}
+	if text != "" {
+		e = e.add(TextOp{Insert: text})
+	}
+	return &DescriptionEdit{
+		Revision: latest.Revision + 1,
+		Edit:     e,
+		Length:   utf8.RuneCountInString(text),
+		Hash:     descriptionHash(text),
+	}
+}
+
+// transformDescriptionEdit transforms an edit based on a revision against
+// the later edits, oldest first, so that it applies after them.
+func transformDescriptionEdit(base int64, e TextEdit, later []DescriptionEdit) (TextEdit, error) {
+	for _, c := range later {
+		if c.Revision <= base {
+			continue
+		}
+		var err error
+		if _, e, err = transformText(c.Edit, e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// descriptionEditID returns the ID of the document of an edit, which sorts
+// like the revisions.
+func descriptionEditID(revision int64) string {
+	return fmt.Sprintf("%012d", revision)
+}
+
+func (s Storage) descriptionEditsRef(userID, objectiveID string) *firestore.CollectionRef {
+	return s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID).Collection("descriptionEdits")
+}
+
+// readDescription reads the description of an objective together with the
+// latest edits of it, oldest first, within a transaction.
+func (s Storage) readDescription(tx *firestore.Transaction, userID, objectiveID string) (Objective, []DescriptionEdit, error) {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	doc, err := tx.Get(ref)
+	if doc != nil && !doc.Exists() {
+		return Objective{}, nil, fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+	}
+	if err != nil {
+		return Objective{}, nil, fmt.Errorf("Error reading objective: %w", err)
+	}
+	var o Objective
+	if err := doc.DataTo(&o); err != nil {
+		return Objective{}, nil, fmt.Errorf("Error reading objective: %w", err)
+	}
+	q := s.descriptionEditsRef(userID, objectiveID).OrderBy("revision", firestore.Desc).Limit(maxDescriptionEdits)
+	docs, err := tx.Documents(q).GetAll()
+	if err != nil {
+		return Objective{}, nil, fmt.Errorf("Error listing description edits: %w", err)
+	}
+	edits := make([]DescriptionEdit, len(docs))
+	for i, doc := range docs {
+		if err := doc.DataTo(&edits[len(docs)-1-i]); err != nil {
+			return Objective{}, nil, fmt.Errorf("Error reading description edit %q: %w", doc.Ref.ID, err)
+		}
+	}
+	return o, edits, nil
+}
+
+// GetDescription returns the description of an objective at its latest
+// revision.
+func (s Storage) GetDescription(userID, objectiveID string) (Description, error) {
+	var d Description
+	err := s.transaction("GetDescription", func(tx *firestore.Transaction) error {
+		o, edits, err := s.readDescription(tx, userID, objectiveID)
+		if err != nil {
+			return err
+		}
+		var latest *DescriptionEdit
+		if len(edits) > 0 {
+			latest = &edits[len(edits)-1]
+		}
+		d = Description{Text: o.Description}
+		if latest != nil {
+			d.Revision = latest.Revision
+		}
+		if e := rebaseDescription(latest, o.Description); e != nil {
+			d.Revision = e.Revision
+		}
+		return nil
+	})
+	return d, err
+}
+
+// EditDescription applies an edit to the description of an objective. The
+// edit is based on the description at the base revision, and is
+// transformed against the edits since, so that concurrent edits by several
+// clients are merged rather than overwrite each other. It returns the
+// edit as applied, and the description after it.
+func (s Storage) EditDescription(userID, objectiveID string, base int64, e TextEdit) (DescriptionEdit, Description, error) {
+	if err := e.validate(); err != nil {
+		return DescriptionEdit{}, Description{}, err
+	}
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	var applied DescriptionEdit
+	var d Description
+	err := s.transaction("EditDescription", func(tx *firestore.Transaction) error {
+		o, edits, err := s.readDescription(tx, userID, objectiveID)
+		if err != nil {
+			return err
+		}
+		var latest *DescriptionEdit
+		if len(edits) > 0 {
+			latest = &edits[len(edits)-1]
+		}
+		rebase := rebaseDescription(latest, o.Description)
+		if rebase != nil {
+			edits = append(edits, *rebase)
+			latest = rebase
+		}
+		var revision, oldest int64
+		if latest != nil {
+			revision, oldest = latest.Revision, edits[0].Revision-1
+		}
+		if base > revision {
+			return fmt.Errorf("No such revision of the description: %d: %w", base, ErrInvalidValue)
+		}
+		if base < oldest {
+			return fmt.Errorf("Revision %d of the description is too old, read it again: %w", base, ErrInvalidValue)
+		}
+		transformed, err := transformDescriptionEdit(base, e, edits)
+		if err != nil {
+			return err
+		}
+		text, err := transformed.apply(o.Description)
+		if err != nil {
+			return err
+		}
+		applied = DescriptionEdit{
+			Revision: revision + 1,
+			Edit:     transformed,
+			Length:   utf8.RuneCountInString(text),
+			Hash:     descriptionHash(text),
+			Date:     time.Now().UnixNano() / 1000 / 1000,
+		}
+		d = Description{applied.Revision, text}
+
+		if rebase != nil {
+			rebase.Date = applied.Date
+			if err := tx.Create(s.descriptionEditsRef(userID, objectiveID).Doc(descriptionEditID(rebase.Revision)), *rebase); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(s.descriptionEditsRef(userID, objectiveID).Doc(descriptionEditID(applied.Revision)), applied); err != nil {
+			return err
+		}
+		// Keep the latest edits, including the one just created.
+		for i := 0; i < len(edits)+1-maxDescriptionEdits; i++ {
+			if err := tx.Delete(s.descriptionEditsRef(userID, objectiveID).Doc(descriptionEditID(edits[i].Revision))); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "description", Value: text}})
+	})
+	if err != nil {
+		return DescriptionEdit{}, Description{}, err
+	}
+	return applied, d, nil
+}
+
+// deleteDescriptionEdits deletes the edits of the description of an
+// objective.
+func (s Storage) deleteDescriptionEdits(userID, objectiveID string) error {
+	var refs []*firestore.DocumentRef
+	err := s.do("deleteDescriptionEdits", func(ctx context.Context) (err error) {
+		refs, err = s.descriptionEditsRef(userID, objectiveID).DocumentRefs(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing description edits: %w", err)
+	}
+	for _, ref := range refs {
+		err := s.do("deleteDescriptionEdits", func(ctx context.Context) error {
+			_, err := ref.Delete(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error deleting description edit %q: %w", ref.ID, err)
+		}
+	}
+	return nil
+}
+
+// description serves /users/{user}/objectives/{objective}/description.
+// GET returns the Description at its latest revision. POST applies an
+// edit of the form {"base": revision, "edit": [ops]}, see TextEdit, and
+// returns the description after it together with the edit as applied, so
+// that clients can transform their pending edits against it.
+func (s *Server) description(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	switch r.Method {
+	case http.MethodGet:
+		d, err := s.storageFor(r).GetDescription(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, d)
+	case http.MethodPost:
+		var req struct {
+			Base int64
+			Edit TextEdit
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		e, d, err := s.storageFor(r).EditDescription(userID, objectiveID, req.Base, req.Edit)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"revision": d.Revision,
+			"text":     d.Text,
+			"edit":     e.Edit,
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
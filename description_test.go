@@ -0,0 +1,46 @@
+package pursuit
+
+import (
+	"testing"
+)
+
+func TestRebaseDescription(t *testing.T) {
+	if e := rebaseDescription(nil, ""); e != nil {
+		t.Errorf("empty description without edits was rebased: %+v", e)
+	}
+	first := rebaseDescription(nil, "Run")
+	if first == nil || first.Revision != 1 || mustApply(t, first.Edit, "") != "Run" {
+		t.Fatalf("description without edits was rebased to %+v", first)
+	}
+	if e := rebaseDescription(first, "Run"); e != nil {
+		t.Errorf("unchanged description was rebased: %+v", e)
+	}
+	// The description was replaced by updating the objective.
+	second := rebaseDescription(first, "Walk")
+	if second == nil || second.Revision != 2 || mustApply(t, second.Edit, "Run") != "Walk" {
+		t.Errorf("replaced description was rebased to %+v", second)
+	}
+}
+
+func TestTransformDescriptionEdit(t *testing.T) {
+	// Revision 1 is "Run", and two clients edit it concurrently.
+	edits := []DescriptionEdit{
+		{Revision: 1, Edit: TextEdit{{Insert: "Run"}}},
+		{Revision: 2, Edit: TextEdit{{Insert: "Slowly "}, {Retain: 3}}},
+	}
+	e, err := transformDescriptionEdit(1, TextEdit{{Retain: 3}, {Insert: " 5k"}}, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustApply(t, e, "Slowly Run"); got != "Slowly Run 5k" {
+		t.Errorf("transformed edit gave %q; wanted %q", got, "Slowly Run 5k")
+	}
+	// Edits based on the latest revision are not transformed.
+	e, err = transformDescriptionEdit(2, TextEdit{{Delete: 7}, {Retain: 3}}, edits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mustApply(t, e, "Slowly Run"); got != "Run" {
+		t.Errorf("edit of the latest revision gave %q; wanted %q", got, "Run")
+	}
+}
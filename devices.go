@@ -0,0 +1,169 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Device is a device of a user that is registered for push notifications
+// through Firebase Cloud Messaging. Devices are stored in
+// users/{user}/devices.
+type Device struct {
+	// Token is the FCM registration token of the device. It is not
+	// included in responses, as it allows sending messages to the device.
+	Token    string `firestore:"token" json:"-"`
+	Label    string `firestore:"label,omitempty" json:"label,omitempty"`
+	Platform string `firestore:"platform,omitempty" json:"platform,omitempty"`
+	// Reminders reports whether reminders are sent to the device.
+	Reminders bool `firestore:"reminders" json:"reminders"`
+	// Created and LastSeen in milliseconds since the epoch.
+	Created  int64 `firestore:"created" json:"created"`
+	LastSeen int64 `firestore:"lastSeen" json:"lastSeen"`
+}
+
+// DeviceEntry is a device together with its ID.
+type DeviceEntry struct {
+	ID string `json:"id"`
+	Device
+}
+
+// DeviceUpdate changes the settings of a device. Nil fields are left
+// unchanged.
+type DeviceUpdate struct {
+	Label     *string
+	Reminders *bool
+}
+
+// deviceID derives the ID of a device from its registration token, so
+// that registering the same device again updates it.
+func deviceID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:8])
+}
+
+// RegisterDevice adds a device of a user, or updates its label and
+// platform if it is already registered. New devices receive reminders.
+func (s Storage) RegisterDevice(userID, token, label, platform string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("Missing token: %w", ErrInvalidValue)
+	}
+	id := deviceID(token)
+	ref := s.collection("users").Doc(userID).Collection("devices").Doc(id)
+	now := time.Now().UnixNano() / 1000 / 1000
+	err := s.transaction("RegisterDevice", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return tx.Create(ref, Device{
+				Token:     token,
+				Label:     label,
+				Platform:  platform,
+				Reminders: true,
+				Created:   now,
+				LastSeen:  now,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading device: %w", err)
+		}
+		updates := []firestore.Update{{Path: "lastSeen", Value: now}}
+		if label != "" {
+			updates = append(updates, firestore.Update{Path: "label", Value: label})
+		}
+		if platform != "" {
+			updates = append(updates, firestore.Update{Path: "platform", Value: platform})
+		}
+		return tx.Update(ref, updates)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ListDevices returns the registered devices of a user.
+func (s Storage) ListDevices(userID string) ([]DeviceEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListDevices", func(ctx context.Context) (err error) {
+		docs, err = s.collection("users").Doc(userID).Collection("devices").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing devices: %w", err)
+	}
+	devices := make([]DeviceEntry, 0, len(docs))
+	for _, doc := range docs {
+		var d Device
+		if err := doc.DataTo(&d); err != nil {
+			return nil, fmt.Errorf("Error reading device %q: %w", doc.Ref.ID, err)
+		}
+		devices = append(devices, DeviceEntry{doc.Ref.ID, d})
+	}
+	return devices, nil
+}
+
+// UpdateDevice changes the label of a device or whether it receives
+// reminders.
+func (s Storage) UpdateDevice(userID, id string, u DeviceUpdate) error {
+	var updates []firestore.Update
+	if u.Label != nil {
+		updates = append(updates, firestore.Update{Path: "label", Value: *u.Label})
+	}
+	if u.Reminders != nil {
+		updates = append(updates, firestore.Update{Path: "reminders", Value: *u.Reminders})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	ref := s.collection("users").Doc(userID).Collection("devices").Doc(id)
+	return s.transaction("UpdateDevice", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such device: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading device: %w", err)
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
+// RevokeDevice removes a device, so that it no longer receives push
+// notifications.
+func (s Storage) RevokeDevice(userID, id string) error {
+	ref := s.collection("users").Doc(userID).Collection("devices").Doc(id)
+	return s.transaction("RevokeDevice", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such device: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading device: %w", err)
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// ReminderTokens returns the registration tokens of the devices of a user
+// that receive reminders.
+func (s Storage) ReminderTokens(userID string) ([]string, error) {
+	devices, err := s.ListDevices(userID)
+	if err != nil {
+		return nil, err
+	}
+	return reminderTokens(devices), nil
+}
+
+func reminderTokens(devices []DeviceEntry) []string {
+	var tokens []string
+	for _, d := range devices {
+		if d.Reminders {
+			tokens = append(tokens, d.Token)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,39 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDeviceIDIsStable(t *testing.T) {
+	if deviceID("fcm-token") != deviceID("fcm-token") || deviceID("fcm-token") == deviceID("other") {
+		t.Errorf("device IDs are not derived from tokens")
+	}
+	if strings.Contains(deviceID("fcm-token"), "fcm") {
+		t.Errorf("device ID contains the token")
+	}
+}
+
+func TestReminderTokens(t *testing.T) {
+	devices := []DeviceEntry{
+		{"a", Device{Token: "pixel", Label: "Pixel 8", Reminders: true}},
+		{"b", Device{Token: "ipad", Label: "iPad", Reminders: false}},
+	}
+
+	tokens := reminderTokens(devices)
+
+	if len(tokens) != 1 || tokens[0] != "pixel" {
+		t.Errorf("tokens were %v; wanted [pixel]", tokens)
+	}
+}
+
+func TestDeviceOmitsTokenFromJSON(t *testing.T) {
+	b, err := json.Marshal(DeviceEntry{"a", Device{Token: "secret", Label: "iPad"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Errorf("device JSON %s contains the token", b)
+	}
+}
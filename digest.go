@@ -0,0 +1,236 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Digest summarizes the goals of a user that are in progress.
+type Digest struct {
+	User string
+	// Date in milliseconds since the epoch.
+	Date  int64
+	Goals []DigestGoal
+	// CheckIns summarize the check-ins of objectives with check-in
+	// questions, see Storage.DigestCheckIns.
+	CheckIns []DigestCheckIn
+	// Variants of the experiments for the user, see GetExperiments.
+	Variants map[string]string
+}
+
+// DigestGoal is the summary of a goal in a digest.
+type DigestGoal struct {
+	Objective string
+	Name      string
+	Unit      string
+	Current   float32
+	Target    float32
+	// Progress and Planned are fractions of the way from the baseline to
+	// the target.
+	Progress float32
+	Planned  float32
+	OnTrack  bool
+	DaysLeft int
+	// Consistency is the fraction of the days of the last
+	// consistencyWeeks weeks on which values were logged.
+	Consistency float32
+}
+
+// NewDigest summarizes the goals of the objectives that are in progress at
+// the given date, i.e. that have started, have not ended yet, and are not
+// paused, completed or archived. Goals whose digest notifications are
+// muted are left out.
+func NewDigest(userID string, objectives []ObjectiveEntry, now int64) Digest {
+	d := Digest{User: userID, Date: now, Goals: []DigestGoal{}}
+	for _, o := range objectives {
+		for _, g := range o.Goals {
+			if g.isInactive() || now < g.Start || now >= g.End || len(g.Trajectory) == 0 || g.IsMuted(NotificationDigest, now) {
+				continue
+			}
+			d.Goals = append(d.Goals, DigestGoal{
+				Objective:   o.Name,
+				Name:        g.Name,
+				Unit:        g.Unit,
+				Current:     float32(roundTo(g.Current(), 1)),
+				Target:      g.Target,
+				Progress:    g.Progress(),
+				Planned:     g.PlannedProgress(now),
+				OnTrack:     g.IsOnTrack(now),
+				DaysLeft:    int((g.End - now) / day),
+				Consistency: g.Consistency(now, consistencyWeeks),
+			})
+		}
+	}
+	sort.Slice(d.Goals, func(i, j int) bool {
+		if d.Goals[i].Objective != d.Goals[j].Objective {
+			return d.Goals[i].Objective < d.Goals[j].Objective
+		}
+		return d.Goals[i].Name < d.Goals[j].Name
+	})
+	return d
+}
+
+var digestFuncs = map[string]interface{}{
+	"percent": func(f float32) string {
+		return fmt.Sprintf("%.0f%%", 100*f)
+	},
+	"date": func(ms int64) string {
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("Monday, January 2, 2006")
+	},
+	"change": func(change int) string {
+		if change == 0 {
+			return ""
+		}
+		return fmt.Sprintf(" (%+d)", change)
+	},
+}
+
+const digestText = `Your goals on {{date .Date}}
+{{range .Goals}}
+{{.Objective}}: {{.Name}}
+  {{percent .Progress}} done, {{percent .Planned}} planned, {{if .OnTrack}}on track{{else}}behind{{end}}
+  {{.Current}} of {{.Target}}{{with .Unit}} {{.}}{{end}}, {{.DaysLeft}} days left
+{{if ne (index $.Variants "digest-consistency") "hidden"}}  logged on {{percent .Consistency}} of recent days
+{{end}}{{else}}
+No goals in progress.
+{{end}}{{range .CheckIns}}
+Check-in {{.Objective}}{{if .Due}} (due this week){{end}}
+{{range .Answers}}  {{.Question}} {{.Answer}}{{change .Change}}
+{{else}}  No answers yet.
+{{end}}{{end}}`
+
+const digestHTML = `<!DOCTYPE html>
+<html>
+<body>
+<h1>Your goals on {{date .Date}}</h1>
+{{range .Goals}}
+<h2>{{.Objective}}: {{.Name}}</h2>
+<p>
+  {{percent .Progress}} done, {{percent .Planned}} planned,
+  {{if .OnTrack}}<span style="color: green">on track</span>{{else}}<span style="color: firebrick">behind</span>{{end}}<br>
+  {{.Current}} of {{.Target}}{{with .Unit}} {{.}}{{end}}, {{.DaysLeft}} days left
+  {{if ne (index $.Variants "digest-consistency") "hidden"}}<br>
+  logged on {{percent .Consistency}} of recent days{{end}}
+</p>
+{{else}}
+<p>No goals in progress.</p>
+{{end}}
+{{range .CheckIns}}
+<h2>Check-in {{.Objective}}{{if .Due}} <span style="color: firebrick">(due this week)</span>{{end}}</h2>
+<p>
+{{range .Answers}}  {{.Question}} <b>{{.Answer}}</b>{{change .Change}}<br>
+{{else}}  No answers yet.
+{{end}}</p>
+{{end}}
+</body>
+</html>
+`
+
+// DigestTemplates render digests as plain text and as HTML.
+type DigestTemplates struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+// DefaultDigestTemplates are the built-in templates.
+var DefaultDigestTemplates = &DigestTemplates{
+	text: template.Must(template.New("digest.txt").Funcs(digestFuncs).Parse(digestText)),
+	html: htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestFuncs).Parse(digestHTML)),
+}
+
+// LoadDigestTemplates reads digest.txt and digest.html from a directory,
+// so that deployments can brand their digests. Each template is validated
+// by rendering a sample digest. Templates that are missing or invalid fall
+// back to the built-in ones, and the errors of invalid ones are returned.
+func LoadDigestTemplates(dir string) (*DigestTemplates, []error) {
+	t := &DigestTemplates{text: DefaultDigestTemplates.text, html: DefaultDigestTemplates.html}
+	var errs []error
+	sample := sampleDigest()
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "digest.txt")); err == nil {
+		text, err := template.New("digest.txt").Funcs(digestFuncs).Parse(string(b))
+		if err == nil {
+			err = text.Execute(ioutil.Discard, sample)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid digest.txt, using the default: %w", err))
+		} else {
+			t.text = text
+		}
+	} else if !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "digest.html")); err == nil {
+		html, err := htmltemplate.New("digest.html").Funcs(digestFuncs).Parse(string(b))
+		if err == nil {
+			err = html.Execute(ioutil.Discard, sample)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid digest.html, using the default: %w", err))
+		} else {
+			t.html = html
+		}
+	} else if !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	return t, errs
+}
+
+// sampleDigest has a goal with all fields set, to validate templates.
+func sampleDigest() Digest {
+	return Digest{
+		User: "user",
+		Date: 0,
+		Goals: []DigestGoal{{
+			Objective:   "Objective",
+			Name:        "Goal",
+			Unit:        "km",
+			Current:     50,
+			Target:      100,
+			Progress:    0.5,
+			Planned:     0.4,
+			OnTrack:     true,
+			DaysLeft:    7,
+			Consistency: 0.75,
+		}},
+		CheckIns: []DigestCheckIn{{
+			Objective: "Objective",
+			Due:       true,
+			Week:      "1970-W01",
+			Answers:   []DigestAnswer{{Question: "Confidence?", Answer: "4/5", Change: 1}},
+		}},
+	}
+}
+
+// Render renders a digest as plain text or, if html is true, as an HTML
+// document. If a custom template fails, the digest is rendered with the
+// built-in template instead.
+func (t *DigestTemplates) Render(d Digest, html bool) (string, error) {
+	var b strings.Builder
+	var err error
+	if html {
+		err = t.html.Execute(&b, d)
+	} else {
+		err = t.text.Execute(&b, d)
+	}
+	if err != nil && t != DefaultDigestTemplates {
+		logf(context.Background(), severityWarning, "Error rendering digest with custom template, using the default: %v", err)
+		return DefaultDigestTemplates.Render(d, html)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error rendering digest: %w", err)
+	}
+	return b.String(), nil
+}
+
+// RenderDigest renders a digest with the built-in templates.
+func RenderDigest(d Digest, html bool) (string, error) {
+	return DefaultDigestTemplates.Render(d, html)
+}
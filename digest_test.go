@@ -0,0 +1,160 @@
+package pursuit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func digestObjectives() []ObjectiveEntry {
+	return []ObjectiveEntry{{"o", Objective{
+		Name: "Fitness",
+		Goals: map[string]Goal{
+			"run": {
+				Name:       "Run",
+				Unit:       "km",
+				Start:      0,
+				End:        10 * day,
+				Target:     100,
+				Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 5 * day, Value: 60}},
+			},
+			"swim": {
+				Name:       "Swim <fast>",
+				Start:      0,
+				End:        10 * day,
+				Target:     10,
+				Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 5 * day, Value: 2}},
+			},
+			"old": {
+				Name:       "Archived",
+				Stage:      "archived",
+				Start:      0,
+				End:        10 * day,
+				Target:     10,
+				Trajectory: Trajectory{{Date: 0, Value: 0}},
+			},
+			"done": {
+				Name:       "Ended",
+				Start:      0,
+				End:        day,
+				Target:     10,
+				Trajectory: Trajectory{{Date: 0, Value: 0}},
+			},
+		},
+	}}}
+}
+
+func TestNewDigest(t *testing.T) {
+	d := NewDigest("u", digestObjectives(), 5*day)
+
+	if len(d.Goals) != 2 {
+		t.Fatalf("digest had %d goals; wanted 2", len(d.Goals))
+	}
+	run := d.Goals[0]
+	// Run got values on days 0 and 5 of 6.
+	if run.Name != "Run" || !run.OnTrack || run.DaysLeft != 5 || run.Progress != 0.6 || run.Consistency != float32(2)/6 {
+		t.Errorf("run was %+v", run)
+	}
+	if d.Goals[1].OnTrack {
+		t.Errorf("swim was on track")
+	}
+}
+
+func TestNewDigestLeavesOutMutedGoals(t *testing.T) {
+	objectives := digestObjectives()
+	swim := objectives[0].Goals["swim"]
+	swim.Mute = &Mute{Kinds: []string{NotificationDigest}}
+	objectives[0].Goals["swim"] = swim
+
+	d := NewDigest("u", objectives, 5*day)
+
+	if len(d.Goals) != 1 || d.Goals[0].Name != "Run" {
+		t.Errorf("digest goals were %+v; wanted only Run", d.Goals)
+	}
+}
+
+func TestRenderDigestText(t *testing.T) {
+	text, err := RenderDigest(NewDigest("u", digestObjectives(), 5*day), false)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"Fitness: Run", "60% done, 50% planned, on track", "60 of 100 km, 5 days left", "logged on 33% of recent days", "Swim <fast>"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestRenderDigestHTMLEscapes(t *testing.T) {
+	html, err := RenderDigest(NewDigest("u", digestObjectives(), 5*day), true)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(html, "<fast>") || !strings.Contains(html, "Swim &lt;fast&gt;") {
+		t.Errorf("goal name was not escaped:\n%s", html)
+	}
+}
+
+func TestRenderEmptyDigest(t *testing.T) {
+	text, _ := RenderDigest(NewDigest("u", nil, 0), false)
+
+	if !strings.Contains(text, "No goals in progress.") {
+		t.Errorf("empty digest was %q", text)
+	}
+}
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadDigestTemplates(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"digest.txt": "ACME goals:{{range .Goals}} {{.Name}}{{end}}",
+	})
+	defer os.RemoveAll(dir)
+
+	templates, errs := LoadDigestTemplates(dir)
+
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	text, _ := templates.Render(NewDigest("u", digestObjectives(), 5*day), false)
+	if text != "ACME goals: Run Swim <fast>" {
+		t.Errorf("digest was %q", text)
+	}
+	html, _ := templates.Render(NewDigest("u", digestObjectives(), 5*day), true)
+	if !strings.Contains(html, "Your goals on") {
+		t.Errorf("missing HTML template did not fall back to the default:\n%s", html)
+	}
+}
+
+func TestLoadDigestTemplatesFallsBackOnInvalidTemplates(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"digest.txt":  "{{.Nonexistent}}",
+		"digest.html": "{{range}}",
+	})
+	defer os.RemoveAll(dir)
+
+	templates, errs := LoadDigestTemplates(dir)
+
+	if len(errs) != 2 {
+		t.Errorf("got errors %v; wanted 2", errs)
+	}
+	text, err := templates.Render(NewDigest("u", digestObjectives(), 5*day), false)
+	if err != nil || !strings.Contains(text, "Your goals on") {
+		t.Errorf("invalid template did not fall back to the default: %q, %v", text, err)
+	}
+}
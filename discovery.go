@@ -0,0 +1,102 @@
+package pursuit
+
+import (
+	"net/http"
+)
+
+// APIRoot describes a deployment of the API, so that clients can adapt to
+// deployments with different features.
+type APIRoot struct {
+	// Version of the service, which is empty if it is unknown.
+	Version string `json:"version,omitempty"`
+	// APIVersions lists the versions of the wire format that the service
+	// speaks, see APIVersion.
+	APIVersions []int `json:"apiVersions"`
+	// Environment labels the data of the deployment, such as "prod".
+	Environment string `json:"environment"`
+	// Features tells which optional features are enabled.
+	Features map[string]bool `json:"features"`
+	// Importers lists the data sources that imports can use.
+	Importers []string `json:"importers"`
+	// Links maps the names of endpoints to their path templates.
+	Links map[string]string `json:"links"`
+}
+
+// apiLinks are the endpoints that the API root links to.
+var apiLinks = map[string]string{
+	"templates":         "/templates{?category}",
+	"objectives":        "/users/{user}/objectives",
+	"objective":         "/users/{user}/objectives/{objective}",
+	"goal":              "/users/{user}/objectives/{objective}/goals/{goal}",
+	"webhooks":          "/users/{user}/objectives/{objective}/webhooks",
+	"checkins":          "/users/{user}/objectives/{objective}/checkins{?weeks}",
+	"reviews":           "/users/{user}/objectives/{objective}/reviews",
+	"description":       "/users/{user}/objectives/{objective}/description",
+	"goals":             "/users/{user}/goals",
+	"goalHooks":         "/users/{user}/objectives/{objective}/goals/{goal}/hooks",
+	"events":            "/users/{user}/events{?type,goal,since,limit}",
+	"stream":            "/users/{user}/stream{?token,viewing,name}",
+	"search":            "/users/{user}/search{?q,limit}",
+	"conflicts":         "/users/{user}/conflicts",
+	"devices":           "/users/{user}/devices",
+	"experiments":       "/users/{user}/experiments",
+	"tokens":            "/users/{user}/tokens",
+	"audit":             "/users/{user}/audit{?type,since,after,limit}",
+	"imports":           "/users/{user}/imports",
+	"strava":            "/users/{user}/strava",
+	"exports":           "/users/{user}/exports",
+	"job":               "/jobs/{job}",
+	"version":           "/version",
+	"shared":            "/shared/objectives/{objective}{?token}",
+	"sharedStream":      "/shared/objectives/{objective}/stream{?token,name}",
+	"sharedDescription": "/shared/objectives/{objective}/description{?token}",
+	"setValue":          "/setgoalvalue",
+	"increment":         "/incrementgoalvalue",
+	"incrementIf":       "/incrementgoalvalueifstale",
+	"batchSet":          "/batchsetgoalvalues",
+	"setStage":          "/setgoalstage",
+}
+
+// apiRoot describes the deployment of the server.
+func (s *Server) apiRoot() APIRoot {
+	return APIRoot{
+		Version:     s.version,
+		APIVersions: []int{APIVersion},
+		Environment: s.storage.Environment(),
+		Features: map[string]bool{
+			"signIn":        s.auth != nil,
+			"push":          s.push != nil,
+			"coalescing":    s.coalescer != nil,
+			"customDigests": s.digests != DefaultDigestTemplates,
+			"bigquery":      s.bigquery != nil,
+			"exports":       s.exports != nil,
+			"strava":        s.strava != nil,
+			"sandbox":       s.storage.Sandbox(),
+		},
+		Importers: importerSources(),
+		Links:     apiLinks,
+	}
+}
+
+// root serves GET /, which describes the deployment, see APIRoot. It
+// needs no authentication. Other paths that no handler matches are not
+// found.
+func (s *Server) root(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiRoot())
+}
+
+// buildInfo serves GET /version, the build information of the server. It
+// needs no authentication.
+func (s *Server) buildInfo(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, CurrentBuildInfo())
+}
@@ -0,0 +1,80 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIRoot(t *testing.T) {
+	s := &Server{storage: &Storage{}, auth: fakeAuth{}, exports: &ExportBucket{}, digests: DefaultDigestTemplates, version: "pursuit-00042"}
+	w := httptest.NewRecorder()
+
+	s.root(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var root APIRoot
+	if err := json.NewDecoder(w.Body).Decode(&root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Version != "pursuit-00042" || root.Environment != EnvProduction {
+		t.Errorf("root was %+v", root)
+	}
+	if len(root.APIVersions) != 1 || root.APIVersions[0] != APIVersion {
+		t.Errorf("API versions were %v; wanted [%d]", root.APIVersions, APIVersion)
+	}
+	for feature, want := range map[string]bool{"signIn": true, "exports": true, "bigquery": false, "customDigests": false} {
+		if got := root.Features[feature]; got != want {
+			t.Errorf("feature %s was %v; wanted %v", feature, got, want)
+		}
+	}
+	if root.Links["goal"] != "/users/{user}/objectives/{objective}/goals/{goal}" {
+		t.Errorf("link to goal was %q", root.Links["goal"])
+	}
+}
+
+func TestAPIRootUnknownPath(t *testing.T) {
+	s := &Server{storage: &Storage{}}
+	w := httptest.NewRecorder()
+
+	s.root(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status was %d; wanted 404", w.Code)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	Commit, Channel = "abc123", ""
+	defer func() { Commit = "" }()
+	w := httptest.NewRecorder()
+
+	(&Server{}).buildInfo(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var info BuildInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	want := BuildInfo{Commit: "abc123", Channel: "dev", APIVersion: APIVersion}
+	if info != want {
+		t.Errorf("build info was %+v; wanted %+v", info, want)
+	}
+}
+
+func TestCheckAPIVersion(t *testing.T) {
+	if err := CheckAPIVersion(APIVersion); err != nil {
+		t.Errorf("same version was rejected: %v", err)
+	}
+	if err := CheckAPIVersion(APIVersion - 1); err == nil {
+		t.Errorf("older version was accepted")
+	}
+	if err := CheckAPIVersion(APIVersion + 1); err == nil {
+		t.Errorf("newer version was accepted")
+	}
+}
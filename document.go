@@ -1,45 +1,143 @@
 package pursuit
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"time"
 )
 
+// ErrInvalidValue is wrapped by errors about values that cannot be added
+// to a trajectory, such as NaN or infinity.
+var ErrInvalidValue = errors.New("Invalid value")
+
 // Objective for Firestore serialization/deserialization.
 type Objective struct {
-	Name        string          `firestore:"name,omitempty"`
-	Description string          `firestore:"description,omitempty"`
-	Goals       map[string]Goal `firestore:"goals,omitempty"`
+	Name          string          `firestore:"name,omitempty" json:"name,omitempty"`
+	Description   string          `firestore:"description,omitempty" json:"description,omitempty"`
+	Goals         map[string]Goal `firestore:"goals,omitempty" json:"goals,omitempty"`
+	SchemaVersion int             `firestore:"schemaVersion,omitempty" json:"schemaVersion,omitempty"`
+	// Slug names the objective in place of its ID, see AddObjective.
+	Slug string `firestore:"slug,omitempty" json:"slug,omitempty"`
+	// CheckInQuestions are asked every week, see SubmitCheckIn.
+	CheckInQuestions []CheckInQuestion `firestore:"checkInQuestions,omitempty" json:"checkInQuestions,omitempty"`
 }
 
 // Goal for Firestore serialization/deserialization.
 type Goal struct {
-	Name       string     `firestore:"name,omitempty"`
-	Stage      string     `firestore:"stage,omitempty"`
-	Start      int64      `firestore:"start,omitempty"`
-	End        int64      `firestore:"end,omitempty"`
-	Target     float32    `firestore:"target,omitempty"`
-	Unit       string     `firestore:"unit,omitempty"`
-	Trajectory Trajectory `firestore:"trajectory,omitempty"`
+	Name        string     `firestore:"name,omitempty" json:"name,omitempty"`
+	Stage       string     `firestore:"stage,omitempty" json:"stage,omitempty"`
+	Start       int64      `firestore:"start,omitempty" json:"start,omitempty"`
+	End         int64      `firestore:"end,omitempty" json:"end,omitempty"`
+	Target      float32    `firestore:"target,omitempty" json:"target,omitempty"`
+	Unit        string     `firestore:"unit,omitempty" json:"unit,omitempty"`
+	Aggregation string     `firestore:"aggregation,omitempty" json:"aggregation,omitempty"`
+	Plan        []float32  `firestore:"plan,omitempty" json:"plan,omitempty"`
+	Trajectory  Trajectory `firestore:"trajectory,omitempty" json:"trajectory,omitempty"`
+	// InputUnit is the unit of values sent by integrations, and InputScale
+	// converts them into Unit.
+	InputUnit  string  `firestore:"inputUnit,omitempty" json:"inputUnit,omitempty"`
+	InputScale float32 `firestore:"inputScale,omitempty" json:"inputScale,omitempty"`
+	// Mute silences notifications about the goal.
+	Mute *Mute `firestore:"mute,omitempty" json:"mute,omitempty"`
+	// SourcePolicy decides whether imported values replace manual ones,
+	// see SourcePolicyIntegrationWins. SourceReadings holds the latest
+	// value of each data source, for SourcePolicyMergeBySource.
+	SourcePolicy   string             `firestore:"sourcePolicy,omitempty" json:"sourcePolicy,omitempty"`
+	SourceReadings map[string]float32 `firestore:"sourceReadings,omitempty" json:"sourceReadings,omitempty"`
+	// Slug names the goal in place of its ID, see AddGoal.
+	Slug string `firestore:"slug,omitempty" json:"slug,omitempty"`
+	// Milestone is the highest of Milestones that the goal reached, so
+	// that each is notified once.
+	Milestone int `firestore:"milestone,omitempty" json:"milestone,omitempty"`
+	// Records are the personal records of the goal, see updateRecords.
+	Records *Records `firestore:"records,omitempty" json:"records,omitempty"`
+	// Components make the goal a composite, whose value is the weighted
+	// sum of the progress of other goals of the objective in percent. It
+	// is recomputed whenever a component gets a value, and cannot be set
+	// directly.
+	Components []Component `firestore:"components,omitempty" json:"components,omitempty"`
+	// PrivateNote is encrypted by the client, see EncryptedNote.
+	PrivateNote *EncryptedNote `firestore:"privateNote,omitempty" json:"privateNote,omitempty"`
+}
+
+// Kinds of notifications about goals. Milestone notifications are the
+// milestone and record events sent to notification hooks, and goal
+// hook notifications the events sent to the hooks of the goal.
+const (
+	NotificationOffTrack  = "off-track"
+	NotificationReminder  = "reminder"
+	NotificationDigest    = "digest"
+	NotificationMilestone = "milestone"
+	NotificationGoalHook  = "goal-hook"
+)
+
+// Mute silences some or all kinds of notifications about a goal, e.g.
+// off-track alerts for running until May 1.
+type Mute struct {
+	// Kinds of notifications that are muted. All kinds are muted if empty.
+	Kinds []string `firestore:"kinds,omitempty" json:"kinds,omitempty"`
+	// Until in milliseconds since the epoch. The mute does not expire if
+	// zero.
+	Until int64 `firestore:"until,omitempty" json:"until,omitempty"`
+}
+
+// IsMuted reports whether notifications of the given kind about the goal
+// are muted at the given date.
+func (g Goal) IsMuted(kind string, now int64) bool {
+	if g.Mute == nil || (g.Mute.Until != 0 && now >= g.Mute.Until) {
+		return false
+	}
+	if len(g.Mute.Kinds) == 0 {
+		return true
+	}
+	for _, k := range g.Mute.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
 }
 
+// week in milliseconds, the unit of the plan of a goal.
+const week = 7 * 24 * 60 * 60 * 1000
+
+// Aggregations determine how the values on the trajectory of a goal are
+// combined into its current value. An empty aggregation is the same as
+// AggregationLatest.
+const (
+	// AggregationLatest uses the latest value, e.g. for cumulative goals.
+	AggregationLatest = "latest"
+	// AggregationMaximum uses the best value since the start of the goal,
+	// e.g. for personal records.
+	AggregationMaximum = "max"
+	// AggregationAverage uses the mean of the values since the start of
+	// the goal, e.g. for a resting heart rate.
+	AggregationAverage = "average"
+)
+
 // Trajectory for Firestore serialization/deserialization.
 type Trajectory []DateValue
 
 // DateValue for Firestore serialization/deserialization.
 type DateValue struct {
-	Date  int64   `firestore:"date"`
-	Value float32 `firestore:"value"`
+	Date  int64   `firestore:"date" json:"date"`
+	Value float32 `firestore:"value" json:"value"`
+	// Source is the data source that imported the value, and empty for
+	// manual values.
+	Source string `firestore:"source,omitempty" json:"source,omitempty"`
 }
 
 // SetGoalValue adds a new value to the trajectory of the goal,
 // using the current timestamp.
 func (o *Objective) SetGoalValue(goalID string, value float32) error {
-	g, ok := o.Goals[goalID]
-	if !ok {
-		return fmt.Errorf("No such goal: %q", goalID)
+	g, err := o.valueGoal(goalID)
+	if err != nil {
+		return err
+	}
+	if err := g.SetValue(value); err != nil {
+		return err
 	}
-	g.SetValue(value)
 	o.Goals[goalID] = g
 	return nil
 }
@@ -47,32 +145,213 @@ func (o *Objective) SetGoalValue(goalID string, value float32) error {
 // IncrementGoalValue adds a new value to the trajectory of the goal,
 // using the current timestamp.
 func (o *Objective) IncrementGoalValue(goalID string, delta float32) error {
+	g, err := o.valueGoal(goalID)
+	if err != nil {
+		return err
+	}
+	if err := g.IncrementValue(delta); err != nil {
+		return err
+	}
+	o.Goals[goalID] = g
+	return nil
+}
+
+// MuteGoal mutes notifications about the goal, or unmutes them if m is
+// nil.
+func (o *Objective) MuteGoal(goalID string, m *Mute) error {
 	g, ok := o.Goals[goalID]
 	if !ok {
-		return fmt.Errorf("No such goal: %q", goalID)
+		return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
 	}
-	g.IncrementValue(delta)
+	g.Mute = m
 	o.Goals[goalID] = g
 	return nil
 }
 
+// IncrementGoalValueIfStale increments the value of the goal unless the
+// latest value on its trajectory is more recent than maxAge. It reports
+// whether the value was incremented.
+func (o *Objective) IncrementGoalValueIfStale(goalID string, delta float32, maxAge time.Duration) (bool, error) {
+	g, err := o.valueGoal(goalID)
+	if err != nil {
+		return false, err
+	}
+	incremented, err := g.IncrementValueIfStale(delta, maxAge)
+	if err != nil {
+		return false, err
+	}
+	o.Goals[goalID] = g
+	return incremented, nil
+}
+
 // SetValue adds a new value to the trajectory of the goal,
-// using the current timestamp.
-func (g *Goal) SetValue(value float32) {
+// using the current timestamp. The value must be finite.
+func (g *Goal) SetValue(value float32) error {
+	if !isFinite(value) {
+		return fmt.Errorf("Value %v is not finite: %w", value, ErrInvalidValue)
+	}
 	p := DateValue{
 		Date:  time.Now().UnixNano() / 1000 / 1000,
 		Value: value,
 	}
 	g.Trajectory = append(g.Trajectory, p)
+	return nil
 }
 
 // IncrementValue adds a delta to the latest value on the trajectory
-// of a goal, using the current timestamp.
-func (g *Goal) IncrementValue(delta float32) {
-	previous := g.Trajectory[len(g.Trajectory)-1]
+// of a goal, using the current timestamp. Increments of a goal without
+// values start from 0, so the first increment sets the value to the
+// delta. Neither the delta, the latest value, nor their sum may be NaN or
+// infinite, so that a single invalid value cannot poison all later
+// increments.
+func (g *Goal) IncrementValue(delta float32) error {
+	if !isFinite(delta) {
+		return fmt.Errorf("Delta %v is not finite: %w", delta, ErrInvalidValue)
+	}
+	var previous DateValue
+	if len(g.Trajectory) > 0 {
+		previous = g.Trajectory[len(g.Trajectory)-1]
+	}
+	if !isFinite(previous.Value) {
+		return fmt.Errorf("Latest value %v is not finite, the trajectory needs to be repaired: %w", previous.Value, ErrInvalidValue)
+	}
 	p := DateValue{
 		Date:  time.Now().UnixNano() / 1000 / 1000,
 		Value: previous.Value + delta,
 	}
+	if !isFinite(p.Value) {
+		return fmt.Errorf("Value %v + %v overflows: %w", previous.Value, delta, ErrInvalidValue)
+	}
 	g.Trajectory = append(g.Trajectory, p)
+	return nil
+}
+
+// IncrementValueIfStale increments the latest value on the trajectory
+// only if that value is older than maxAge. A goal without values is
+// always stale. It reports whether the value was incremented.
+func (g *Goal) IncrementValueIfStale(delta float32, maxAge time.Duration) (bool, error) {
+	now := time.Now().UnixNano() / 1000 / 1000
+	if len(g.Trajectory) > 0 && now-g.Trajectory[len(g.Trajectory)-1].Date < maxAge.Milliseconds() {
+		return false, nil
+	}
+	if err := g.IncrementValue(delta); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Baseline is the value of the goal at its start date.
+func (g Goal) Baseline() float32 {
+	return g.Trajectory.At(g.Start)
+}
+
+// Current combines the values on the trajectory according to the
+// aggregation of the goal. The maximum and the average only take the
+// values after the start into account, and are the baseline until there
+// are any. It is NaN if the trajectory is empty.
+func (g Goal) Current() float32 {
+	if len(g.Trajectory) == 0 {
+		return float32(math.NaN())
+	}
+	switch g.Aggregation {
+	case AggregationMaximum:
+		max := float32(math.Inf(-1))
+		for _, p := range g.Trajectory {
+			if p.Date > g.Start && p.Value > max {
+				max = p.Value
+			}
+		}
+		if math.IsInf(float64(max), -1) {
+			return g.Baseline()
+		}
+		return max
+	case AggregationAverage:
+		var sum float32
+		var n int
+		for _, p := range g.Trajectory {
+			if p.Date > g.Start {
+				sum += p.Value
+				n++
+			}
+		}
+		if n == 0 {
+			return g.Baseline()
+		}
+		return sum / float32(n)
+	default:
+		return g.Trajectory[len(g.Trajectory)-1].Value
+	}
+}
+
+// Progress is the fraction of the way from the baseline to the target
+// that the current value has covered.
+func (g Goal) Progress() float32 {
+	baseline := g.Baseline()
+	return (g.Current() - baseline) / (g.Target - baseline)
+}
+
+// At returns the value of the trajectory at the given date, interpolating
+// linearly between points and extrapolating constantly beyond the first
+// and last point. It is NaN if the trajectory is empty.
+func (t Trajectory) At(date int64) float32 {
+	if len(t) == 0 {
+		return float32(math.NaN())
+	}
+	if date <= t[0].Date {
+		return t[0].Value
+	}
+	if date >= t[len(t)-1].Date {
+		return t[len(t)-1].Value
+	}
+	i := 0
+	for i < len(t)-1 && t[i+1].Date <= date {
+		i++
+	}
+	p0, p1 := t[i], t[i+1]
+	return p0.Value + float32(date-p0.Date)*(p1.Value-p0.Value)/float32(p1.Date-p0.Date)
+}
+
+// PlannedProgress is the progress expected by the given date. If the goal
+// has a plan, the planned amounts for each week since the start of the
+// goal are added up, interpolating within the current week. Otherwise, or
+// if there is no distance from the baseline to the target to plan for,
+// such as while the trajectory is empty, the progress is expected to be
+// linear in time.
+func (g Goal) PlannedProgress(date int64) float32 {
+	if g.End <= g.Start {
+		return 1
+	}
+	if date > g.End {
+		date = g.End
+	}
+	if date <= g.Start {
+		return 0
+	}
+	distance := math.Abs(float64(g.Target - g.Baseline()))
+	if len(g.Plan) == 0 || distance == 0 || math.IsNaN(distance) {
+		return float32(date-g.Start) / float32(g.End-g.Start)
+	}
+	weeks := float32(date-g.Start) / week
+	var amount float32
+	for i, a := range g.Plan {
+		if float32(i+1) <= weeks {
+			amount += a
+		} else {
+			if float32(i) < weeks {
+				amount += (weeks - float32(i)) * a
+			}
+			break
+		}
+	}
+	return amount / float32(distance)
+}
+
+// IsOnTrack reports whether the progress of the goal is at least the
+// planned progress by the given date.
+func (g Goal) IsOnTrack(date int64) bool {
+	return g.Progress() >= g.PlannedProgress(date)
+}
+
+func isFinite(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
 }
@@ -1,7 +1,10 @@
 package pursuit
 
 import (
+	"errors"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestUpdate(t *testing.T) {
@@ -88,3 +91,336 @@ func TestIncrementGoalValueNotExists(t *testing.T) {
 		t.Errorf("wanted error, got none")
 	}
 }
+
+func TestIncrementWithoutValues(t *testing.T) {
+	g := Goal{}
+
+	if err := g.IncrementValue(5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(g.Trajectory) != 1 || g.Trajectory[0].Value != 5 {
+		t.Errorf("trajectory was %+v; wanted a single entry of 5", g.Trajectory)
+	}
+}
+
+func TestIncrementIfStaleWithoutValues(t *testing.T) {
+	g := Goal{}
+
+	incremented, err := g.IncrementValueIfStale(5, time.Hour)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !incremented {
+		t.Errorf("goal without values was not incremented")
+	}
+	if len(g.Trajectory) != 1 || g.Trajectory[0].Value != 5 {
+		t.Errorf("trajectory was %+v; wanted a single entry of 5", g.Trajectory)
+	}
+}
+
+func TestIncrementIfStale(t *testing.T) {
+	g := Goal{
+		Trajectory: Trajectory{{Date: 0, Value: 123}},
+	}
+
+	incremented, err := g.IncrementValueIfStale(5, time.Hour)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !incremented {
+		t.Errorf("stale value was not incremented")
+	}
+	if g.Trajectory[1].Value != 128 {
+		t.Errorf("last entry was %f; wanted 128", g.Trajectory[1].Value)
+	}
+}
+
+func TestIncrementIfStaleRecent(t *testing.T) {
+	g := Goal{}
+
+	g.SetValue(123)
+	incremented, err := g.IncrementValueIfStale(5, time.Hour)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if incremented {
+		t.Errorf("recent value was incremented")
+	}
+	if len(g.Trajectory) != 1 {
+		t.Errorf("trajectory has %d entries; wanted 1", len(g.Trajectory))
+	}
+}
+
+func TestIncrementGoalValueIfStaleNotExists(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{},
+	}
+
+	_, err := o.IncrementGoalValueIfStale("abc", 5, time.Hour)
+
+	if err == nil {
+		t.Errorf("wanted error, got none")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 5, Value: 12}},
+	}
+
+	if g.Progress() != 0.12 {
+		t.Errorf("progress was %f; wanted 0.12", g.Progress())
+	}
+}
+
+func TestProgressMaximum(t *testing.T) {
+	g := Goal{
+		Start:       0,
+		Target:      20,
+		Aggregation: AggregationMaximum,
+		Trajectory:  Trajectory{{Date: 0, Value: 10}, {Date: 5, Value: 15}, {Date: 6, Value: 12}},
+	}
+
+	if g.Current() != 15 {
+		t.Errorf("current was %f; wanted 15", g.Current())
+	}
+	if g.Progress() != 0.5 {
+		t.Errorf("progress was %f; wanted 0.5", g.Progress())
+	}
+}
+
+func TestProgressMaximumIgnoresValuesBeforeStart(t *testing.T) {
+	g := Goal{
+		Start:       10,
+		Target:      20,
+		Aggregation: AggregationMaximum,
+		Trajectory:  Trajectory{{Date: 0, Value: 25}, {Date: 10, Value: 10}, {Date: 15, Value: 15}},
+	}
+
+	if g.Current() != 15 {
+		t.Errorf("current was %f; wanted 15", g.Current())
+	}
+	if g.Progress() != 0.5 {
+		t.Errorf("progress was %f; wanted 0.5", g.Progress())
+	}
+	g.Trajectory = g.Trajectory[:2]
+	if g.Current() != 10 {
+		t.Errorf("current without values after start was %f; wanted 10", g.Current())
+	}
+}
+
+func TestProgressAverage(t *testing.T) {
+	g := Goal{
+		Start:       0,
+		Target:      50,
+		Aggregation: AggregationAverage,
+		Trajectory:  Trajectory{{Date: 0, Value: 70}, {Date: 5, Value: 62}, {Date: 6, Value: 58}},
+	}
+
+	if g.Current() != 60 {
+		t.Errorf("current was %f; wanted 60", g.Current())
+	}
+	if g.Progress() != 0.5 {
+		t.Errorf("progress was %f; wanted 0.5", g.Progress())
+	}
+}
+
+func TestProgressAverageWithoutReadings(t *testing.T) {
+	g := Goal{
+		Start:       0,
+		Target:      50,
+		Aggregation: AggregationAverage,
+		Trajectory:  Trajectory{{Date: 0, Value: 70}},
+	}
+
+	if g.Progress() != 0 {
+		t.Errorf("progress was %f; wanted 0", g.Progress())
+	}
+}
+
+func TestTrajectoryAt(t *testing.T) {
+	tr := Trajectory{{Date: 10, Value: 100}, {Date: 20, Value: 200}}
+
+	for _, c := range []struct {
+		date int64
+		want float32
+	}{
+		{0, 100},
+		{10, 100},
+		{15, 150},
+		{20, 200},
+		{30, 200},
+	} {
+		if got := tr.At(c.date); got != c.want {
+			t.Errorf("value at %d was %f; wanted %f", c.date, got, c.want)
+		}
+	}
+}
+
+func TestPlannedProgressLinear(t *testing.T) {
+	g := Goal{Start: 0, End: 10, Target: 100}
+
+	if p := g.PlannedProgress(4); p != 0.4 {
+		t.Errorf("planned progress was %f; wanted 0.4", p)
+	}
+	if p := g.PlannedProgress(20); p != 1 {
+		t.Errorf("planned progress after end was %f; wanted 1", p)
+	}
+}
+
+func TestPlannedProgressWeekly(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        4 * week,
+		Target:     130,
+		Plan:       []float32{30, 35, 40, 25},
+		Trajectory: Trajectory{{Date: 0, Value: 0}},
+	}
+
+	if p := g.PlannedProgress(2 * week); p != 0.5 {
+		t.Errorf("planned progress after two weeks was %f; wanted 0.5", p)
+	}
+	if p := g.PlannedProgress(2*week + week/2); p != 85.0/130 {
+		t.Errorf("planned progress in third week was %f; wanted %f", p, 85.0/130)
+	}
+}
+
+func TestPlannedProgressWithoutDistance(t *testing.T) {
+	g := Goal{Start: 0, End: 4 * week, Target: 130, Plan: []float32{30, 35, 40, 25}}
+
+	if p := g.PlannedProgress(week); p != 0.25 {
+		t.Errorf("planned progress with empty trajectory was %f; wanted 0.25", p)
+	}
+	g.Trajectory = Trajectory{{Date: 0, Value: 130}}
+	if p := g.PlannedProgress(week); p != 0.25 {
+		t.Errorf("planned progress with baseline at target was %f; wanted 0.25", p)
+	}
+}
+
+func TestIsOnTrackWithPlan(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        4 * week,
+		Target:     100,
+		Plan:       []float32{10, 40, 40, 10},
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: week, Value: 15}},
+	}
+
+	if !g.IsOnTrack(week) {
+		t.Errorf("goal ahead of plan is not on track")
+	}
+	if g.IsOnTrack(2 * week) {
+		t.Errorf("goal behind plan is on track")
+	}
+}
+
+func TestSetValueRejectsNaN(t *testing.T) {
+	g := Goal{}
+
+	err := g.SetValue(float32(math.NaN()))
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+	if len(g.Trajectory) != 0 {
+		t.Errorf("trajectory has %d entries; wanted 0", len(g.Trajectory))
+	}
+}
+
+func TestIncrementValueRejectsInfiniteDelta(t *testing.T) {
+	g := Goal{Trajectory: Trajectory{{Date: 0, Value: 1}}}
+
+	err := g.IncrementValue(float32(math.Inf(1)))
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+	if len(g.Trajectory) != 1 {
+		t.Errorf("trajectory has %d entries; wanted 1", len(g.Trajectory))
+	}
+}
+
+func TestIncrementValueRejectsOverflow(t *testing.T) {
+	g := Goal{Trajectory: Trajectory{{Date: 0, Value: math.MaxFloat32}}}
+
+	err := g.IncrementValue(math.MaxFloat32)
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestIncrementValueRejectsPoisonedTrajectory(t *testing.T) {
+	g := Goal{Trajectory: Trajectory{{Date: 0, Value: float32(math.NaN())}}}
+
+	err := g.IncrementValue(1)
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestSetGoalValueRejectsNaN(t *testing.T) {
+	o := Objective{Goals: map[string]Goal{"g": {}}}
+
+	err := o.SetGoalValue("g", float32(math.NaN()))
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+	if len(o.Goals["g"].Trajectory) != 0 {
+		t.Errorf("trajectory has %d entries; wanted 0", len(o.Goals["g"].Trajectory))
+	}
+}
+
+func TestIsMuted(t *testing.T) {
+	g := Goal{Mute: &Mute{Kinds: []string{NotificationOffTrack}, Until: 100}}
+
+	if !g.IsMuted(NotificationOffTrack, 99) {
+		t.Errorf("off-track alerts were not muted")
+	}
+	if g.IsMuted(NotificationOffTrack, 100) {
+		t.Errorf("off-track alerts were muted after the mute expired")
+	}
+	if g.IsMuted(NotificationDigest, 99) {
+		t.Errorf("digests were muted")
+	}
+}
+
+func TestIsMutedIndefinitely(t *testing.T) {
+	g := Goal{Mute: &Mute{}}
+
+	if !g.IsMuted(NotificationReminder, 1<<60) {
+		t.Errorf("reminders were not muted")
+	}
+	if (Goal{}).IsMuted(NotificationReminder, 0) {
+		t.Errorf("goal without mute was muted")
+	}
+}
+
+func TestMuteGoalNotExists(t *testing.T) {
+	o := Objective{Goals: map[string]Goal{}}
+
+	err := o.MuteGoal("abc", &Mute{})
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v; wanted ErrNotFound", err)
+	}
+}
+
+func TestIsMutedHooks(t *testing.T) {
+	g := Goal{Mute: &Mute{Kinds: []string{NotificationMilestone}}}
+
+	if !g.IsMuted(NotificationMilestone, 0) {
+		t.Errorf("milestones were not muted")
+	}
+	if g.IsMuted(NotificationGoalHook, 0) {
+		t.Errorf("goal hooks were muted along with milestones")
+	}
+}
@@ -0,0 +1,81 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+)
+
+// Environments that storage, events and exported data are labeled with.
+const (
+	EnvProduction = "prod"
+	EnvStaging    = "staging"
+)
+
+// Environment returns the environment that the storage runs in, which is
+// production unless configured otherwise.
+func (s Storage) Environment() string {
+	if s.environment == "" {
+		return EnvProduction
+	}
+	return s.environment
+}
+
+// DatabaseEnvironment returns the environment that the database is
+// labeled with, or an empty string if it has no label.
+func (s Storage) DatabaseEnvironment() (string, error) {
+	var env string
+	err := s.do("DatabaseEnvironment", func(ctx context.Context) error {
+		doc, err := s.collection("config").Doc("environment").Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var label struct {
+			Name string `firestore:"name" json:"name"`
+		}
+		if err := doc.DataTo(&label); err != nil {
+			return err
+		}
+		env = label.Name
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading environment label: %w", err)
+	}
+	return env, nil
+}
+
+// LabelDatabase labels the database with an environment.
+func (s Storage) LabelDatabase(env string) error {
+	if env == "" {
+		return fmt.Errorf("Missing environment: %w", ErrInvalidValue)
+	}
+	err := s.do("LabelDatabase", func(ctx context.Context) error {
+		_, err := s.collection("config").Doc("environment").Set(ctx, map[string]interface{}{"name": env})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error writing environment label: %w", err)
+	}
+	return nil
+}
+
+// CheckEnvironment guards destructive commands. It fails unless the
+// environment that the user confirmed matches the label of the database.
+// Production must always be confirmed, other environments only need to
+// match if one was confirmed. Unlabeled databases count as production, so
+// that the guardrail errs on the side of caution.
+func CheckEnvironment(database, confirmed string) error {
+	if database == "" {
+		database = EnvProduction
+	}
+	switch {
+	case confirmed != "" && confirmed != database:
+		return fmt.Errorf("Database is labeled %q, not %q: %w", database, confirmed, ErrForbidden)
+	case database == EnvProduction && confirmed != EnvProduction:
+		return fmt.Errorf("Refusing to change the %s database without confirming its environment: %w", database, ErrForbidden)
+	}
+	return nil
+}
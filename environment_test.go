@@ -0,0 +1,53 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestNewStorageWithClient(t *testing.T) {
+	client := &firestore.Client{}
+	if s := NewStorageWithClient(client, ""); s.client != client || s.Environment() != EnvProduction {
+		t.Errorf("storage was %+v; wanted the client in production", s)
+	}
+	if s := NewStorageWithClient(client, EnvStaging); s.Environment() != EnvStaging {
+		t.Errorf("environment was %q; wanted %q", s.Environment(), EnvStaging)
+	}
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	tests := []struct {
+		database, confirmed string
+		ok                  bool
+	}{
+		{EnvProduction, EnvProduction, true},
+		{EnvProduction, "", false},
+		{EnvProduction, EnvStaging, false},
+		{"", "", false},
+		{"", EnvProduction, true},
+		{"", EnvStaging, false},
+		{EnvStaging, "", true},
+		{EnvStaging, EnvStaging, true},
+		{EnvStaging, EnvProduction, false},
+	}
+	for _, tt := range tests {
+		err := CheckEnvironment(tt.database, tt.confirmed)
+		if tt.ok && err != nil {
+			t.Errorf("CheckEnvironment(%q, %q) = %v; wanted no error", tt.database, tt.confirmed, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrForbidden) {
+			t.Errorf("CheckEnvironment(%q, %q) = %v; wanted ErrForbidden", tt.database, tt.confirmed, err)
+		}
+	}
+}
+
+func TestStorageEnvironment(t *testing.T) {
+	if env := (Storage{}).Environment(); env != EnvProduction {
+		t.Errorf("environment of unconfigured storage was %q", env)
+	}
+	if env := (Storage{environment: EnvStaging}).Environment(); env != EnvStaging {
+		t.Errorf("environment was %q; wanted %q", env, EnvStaging)
+	}
+}
@@ -0,0 +1,263 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Types of events that are emitted when goals change.
+const (
+	EventGoalSet         = "goal.set"
+	EventGoalIncremented = "goal.incremented"
+)
+
+// eventRetention is how long events are kept. Firestore deletes expired
+// events through a TTL policy on expireAt, see firestore.indexes.json.
+const eventRetention = 30 * 24 * time.Hour
+
+// maxEvents limits the number of events that are listed or replayed at
+// once.
+const maxEvents = 1000
+
+// Event records a change of a goal. Events are stored in
+// users/{user}/events and sent as JSON to webhook targets when replayed.
+type Event struct {
+	Type      string  `firestore:"type" json:"type"`
+	Objective string  `firestore:"objective" json:"objective"`
+	Goal      string  `firestore:"goal" json:"goal"`
+	Value     float32 `firestore:"value" json:"value"`
+	Delta     float32 `firestore:"delta,omitempty" json:"delta,omitempty"`
+	// Milestone is the percentage of the target that a goal reached, for
+	// EventGoalMilestone.
+	Milestone int `firestore:"milestone,omitempty" json:"milestone,omitempty"`
+	// Record is the kind of record that a goal broke, and RecordValue its
+	// new value, for EventGoalRecordBroken.
+	Record      string  `firestore:"record,omitempty" json:"record,omitempty"`
+	RecordValue float32 `firestore:"recordValue,omitempty" json:"recordValue,omitempty"`
+	// Date in milliseconds since the epoch.
+	Date     int64     `firestore:"date" json:"date"`
+	ExpireAt time.Time `firestore:"expireAt" json:"-"`
+	// Environment in which the event happened, e.g. EnvProduction.
+	Environment string `firestore:"environment,omitempty" json:"environment,omitempty"`
+}
+
+// EventEntry is an event together with its ID.
+type EventEntry struct {
+	ID string `json:"id"`
+	Event
+}
+
+// EventFilter selects events of a user. Empty fields match all events.
+type EventFilter struct {
+	Type string
+	Goal string
+	// Since in milliseconds since the epoch.
+	Since int64
+	Limit int
+}
+
+// newGoalEvent describes the latest change of a goal.
+func newGoalEvent(eventType, objectiveID, goalID string, g Goal, delta float32) Event {
+	latest := g.Trajectory[len(g.Trajectory)-1]
+	return Event{
+		Type:      eventType,
+		Objective: objectiveID,
+		Goal:      goalID,
+		Value:     latest.Value,
+		Delta:     delta,
+		Date:      latest.Date,
+	}
+}
+
+// recordEvent persists an event and passes it on to the handler of the
+// storage, if any. Events are a side channel, so failures are logged
+// rather than failing the change that emitted the event.
+func (s Storage) recordEvent(userID string, e Event) {
+	e.ExpireAt = time.Unix(0, e.Date*int64(time.Millisecond)).Add(eventRetention)
+	e.Environment = s.Environment()
+	ref := s.collection("users").Doc(userID).Collection("events").NewDoc()
+	err := s.do("recordEvent", func(ctx context.Context) error {
+		_, err := ref.Create(ctx, e)
+		return err
+	})
+	if err != nil {
+		logf(s.ctx, severityError, "Error recording event %+v of user %q: %v", e, userID, err)
+		return
+	}
+	if s.events != nil {
+		s.events(userID, EventEntry{ref.ID, e})
+	}
+}
+
+// ListEvents returns the events of a user that match the filter, oldest
+// first. Events older than the retention period are never returned, even
+// if Firestore did not delete them yet.
+func (s Storage) ListEvents(userID string, f EventFilter) ([]EventEntry, error) {
+	since := time.Now().Add(-eventRetention).UnixNano() / 1000 / 1000
+	if f.Since > since {
+		since = f.Since
+	}
+	limit := f.Limit
+	if limit <= 0 || limit > maxEvents {
+		limit = maxEvents
+	}
+	q := s.collection("users").Doc(userID).Collection("events").Where("date", ">=", since)
+	if f.Type != "" {
+		q = q.Where("type", "==", f.Type)
+	}
+	if f.Goal != "" {
+		q = q.Where("goal", "==", f.Goal)
+	}
+	q = q.OrderBy("date", firestore.Asc).Limit(limit)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListEvents", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing events: %w", err)
+	}
+	events := make([]EventEntry, 0, len(docs))
+	for _, doc := range docs {
+		var e Event
+		if err := doc.DataTo(&e); err != nil {
+			return nil, fmt.Errorf("Error reading event %q: %w", doc.Ref.ID, err)
+		}
+		events = append(events, EventEntry{doc.Ref.ID, e})
+	}
+	return events, nil
+}
+
+// ReplayReport lists which events were delivered to a webhook target.
+type ReplayReport struct {
+	Target    string   `json:"target"`
+	Delivered []string `json:"delivered"`
+	Failed    []string `json:"failed"`
+}
+
+// validateTarget checks that a webhook target is an absolute HTTP(S) URL
+// that does not name an internal host. Names that resolve to internal
+// addresses are refused when they are dialed, see newWebhookClient.
+func validateTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid target: %q: %w", target, ErrInvalidValue)
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); (ip != nil && !publicAddress(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("Target %q is an internal host: %w", target, ErrInvalidValue)
+	}
+	return nil
+}
+
+// internalNetworks are the ranges of addresses that are not reachable
+// from the internet, besides loopback, link-local and multicast ones.
+var internalNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}()
+
+// publicAddress reports whether the address is reachable from the
+// internet, unlike loopback, link-local, such as the metadata server at
+// 169.254.169.254, and private addresses.
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range internalNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns a client for targets that users give, such as
+// webhooks and metric exports. It refuses to connect to addresses that
+// are not public, so that targets cannot reach internal services. The
+// address is checked when it is dialed, after the host was resolved, so
+// that names and redirects that lead to internal addresses are refused
+// too.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("Refusing to connect to internal address %s: %w", host, ErrForbidden)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// replayEvents posts each event to the target in order. Events that fail
+// are reported and do not stop the replay of later events.
+func replayEvents(client *http.Client, target string, events []EventEntry) ReplayReport {
+	report := ReplayReport{Target: target, Delivered: []string{}, Failed: []string{}}
+	for _, e := range events {
+		if err := deliverEvent(client, target, e, true); err != nil {
+			logf(context.Background(), severityError, "Error replaying event %q to %q: %v", e.ID, target, err)
+			report.Failed = append(report.Failed, e.ID)
+			continue
+		}
+		report.Delivered = append(report.Delivered, e.ID)
+	}
+	return report
+}
+
+// deliverEvent posts an event to a webhook target. Replayed events are
+// marked as such, so that targets can tell them from new ones.
+func deliverEvent(client *http.Client, target string, e EventEntry, replay bool) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Pursuit-Event", e.Type)
+	if replay {
+		req.Header.Set("X-Pursuit-Replay", "true")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return nil
+}
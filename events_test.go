@@ -0,0 +1,101 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewGoalEvent(t *testing.T) {
+	g := Goal{Trajectory: Trajectory{{Date: 1, Value: 10}, {Date: 2, Value: 15}}}
+
+	e := newGoalEvent(EventGoalIncremented, "o", "g", g, 5)
+
+	want := Event{Type: EventGoalIncremented, Objective: "o", Goal: "g", Value: 15, Delta: 5, Date: 2}
+	if e != want {
+		t.Errorf("event was %+v; wanted %+v", e, want)
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	for target, valid := range map[string]bool{
+		"https://example.com/hook": true,
+		"http://localhost:8080/":   false,
+		"ftp://example.com/hook":   false,
+		"/hook":                    false,
+		"https://":                 false,
+	} {
+		if err := validateTarget(target); (err == nil) != valid {
+			t.Errorf("validateTarget(%q) was %v; wanted valid=%v", target, err, valid)
+		}
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	var received []EventEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e EventEntry
+		json.NewDecoder(r.Body).Decode(&e)
+		if r.Header.Get("X-Pursuit-Replay") != "true" {
+			t.Errorf("replay header was missing")
+		}
+		if e.ID == "b" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received = append(received, e)
+	}))
+	defer srv.Close()
+	events := []EventEntry{
+		{"a", Event{Type: EventGoalSet, Goal: "g", Value: 1}},
+		{"b", Event{Type: EventGoalSet, Goal: "g", Value: 2}},
+		{"c", Event{Type: EventGoalSet, Goal: "g", Value: 3}},
+	}
+
+	report := replayEvents(srv.Client(), srv.URL, events)
+
+	if len(report.Delivered) != 2 || report.Delivered[0] != "a" || report.Delivered[1] != "c" {
+		t.Errorf("delivered %v; wanted [a c]", report.Delivered)
+	}
+	if len(report.Failed) != 1 || report.Failed[0] != "b" {
+		t.Errorf("failed %v; wanted [b]", report.Failed)
+	}
+	if len(received) != 2 || received[1].Value != 3 || received[1].Type != EventGoalSet {
+		t.Errorf("received %+v; wanted events a and c", received)
+	}
+}
+
+func TestValidateTargetRejectsInternalHosts(t *testing.T) {
+	for _, target := range []string{
+		"http://169.254.169.254/computeMetadata/v1/",
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://localhost/hook",
+		"https://10.0.0.7/hook",
+		"https://192.168.1.1/hook",
+	} {
+		if err := validateTarget(target); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("target %q got %v; wanted ErrInvalidValue", target, err)
+		}
+	}
+	if err := validateTarget("https://8.8.8.8/hook"); err != nil {
+		t.Errorf("public target was rejected: %v", err)
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	_, err := newWebhookClient(time.Second).Get(srv.URL)
+
+	if !errors.Is(err, ErrForbidden) || called {
+		t.Errorf("request to %s got %v; wanted ErrForbidden", srv.URL, err)
+	}
+}
@@ -0,0 +1,184 @@
+package pursuit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// existMaxDays is how many days of values Exist returns at most.
+const existMaxDays = 31
+
+// NewExistImporter creates an importer for the attributes of a user of
+// Exist, see https://developer.exist.io/. It is an ImporterFactory that
+// takes the config "token", a token of the user with read access to the
+// attributes and, for exports, write access, and optionally "attributes",
+// a comma-separated list of the attributes to import, which are all
+// otherwise. Metrics are the names of attributes, such as "steps".
+//
+// Exist keeps one value per attribute and day. Days are imported once
+// they are over, as increments of the goals. Exports add the changes of
+// goals to the values of the days they happened on, in UTC. Exist only
+// takes values for attributes that the client owns, so exports acquire
+// them first.
+func NewExistImporter(config map[string]string) (Importer, error) {
+	if config["token"] == "" {
+		return nil, fmt.Errorf("Missing Exist token: %w", ErrInvalidValue)
+	}
+	return &existImporter{
+		token:      config["token"],
+		attributes: config["attributes"],
+		client:     &http.Client{Timeout: 10 * time.Second},
+		apiURL:     "https://exist.io/api/2",
+		now:        time.Now,
+	}, nil
+}
+
+type existImporter struct {
+	token      string
+	attributes string
+	client     *http.Client
+	apiURL     string
+	now        func() time.Time
+}
+
+// existDate is the format of dates in Exist.
+const existDate = "2006-01-02"
+
+// do sends a request to the API of Exist and decodes the response into v,
+// unless it is nil.
+func (e *existImporter) do(method, url string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error calling Exist: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Error calling Exist: %s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Error reading response of Exist: %v", err)
+	}
+	return nil
+}
+
+func (e *existImporter) Fetch(since int64) ([]Measurement, error) {
+	// Values of today are still changing.
+	const day = 24 * time.Hour
+	last := e.now().UTC().Truncate(day).Add(-day)
+	first := last.AddDate(0, 0, 1-existMaxDays)
+	if d := time.Unix(0, since*int64(time.Millisecond)).UTC().Truncate(day); d.After(first) {
+		first = d
+	}
+	days := int(last.Sub(first)/day) + 1
+	if days <= 0 {
+		return nil, nil
+	}
+	q := url.Values{
+		"days":     {strconv.Itoa(days)},
+		"date_max": {last.Format(existDate)},
+		"limit":    {"100"},
+	}
+	if e.attributes != "" {
+		q.Set("attributes", e.attributes)
+	}
+	var measurements []Measurement
+	for next := e.apiURL + "/attributes/with-values/?" + q.Encode(); next != ""; {
+		var page struct {
+			Next    string `json:"next"`
+			Results []struct {
+				Name   string `json:"name"`
+				Values []struct {
+					Date  string   `json:"date"`
+					Value *float32 `json:"value"`
+				} `json:"values"`
+			} `json:"results"`
+		}
+		if err := e.do(http.MethodGet, next, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, a := range page.Results {
+			for _, v := range a.Values {
+				if v.Value == nil {
+					continue
+				}
+				date, err := time.Parse(existDate, v.Date)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid date of Exist attribute %s: %q", a.Name, v.Date)
+				}
+				measurements = append(measurements, Measurement{
+					ID:        a.Name + "/" + v.Date,
+					Metric:    a.Name,
+					Date:      date.UnixNano() / 1000 / 1000,
+					Value:     *v.Value,
+					Increment: true,
+				})
+			}
+		}
+		next = page.Next
+	}
+	return measurements, nil
+}
+
+// existValue is a value of an attribute on a day.
+type existValue struct {
+	Name  string  `json:"name"`
+	Date  string  `json:"date"`
+	Value float32 `json:"value"`
+}
+
+func (e *existImporter) Export(measurements []Measurement) (int, error) {
+	var acquire []map[string]string
+	acquired := map[string]bool{}
+	var values []existValue
+	for _, m := range measurements {
+		if !acquired[m.Metric] {
+			acquire = append(acquire, map[string]string{"name": m.Metric})
+			acquired[m.Metric] = true
+		}
+		date := time.Unix(0, m.Date*int64(time.Millisecond)).UTC().Format(existDate)
+		values = append(values, existValue{m.Metric, date, m.Value})
+	}
+	if err := e.do(http.MethodPost, e.apiURL+"/attributes/acquire/", acquire, nil); err != nil {
+		return 0, err
+	}
+	var result struct {
+		Failed []struct {
+			Name  string `json:"name"`
+			Error string `json:"error"`
+		} `json:"failed"`
+	}
+	if err := e.do(http.MethodPost, e.apiURL+"/attributes/increment/", values, &result); err != nil {
+		return 0, err
+	}
+	// Values that Exist rejected are not sent again, as those that it
+	// took would be added twice.
+	if len(result.Failed) > 0 {
+		return len(measurements), fmt.Errorf("Exist rejected the value of %s: %s", result.Failed[0].Name, result.Failed[0].Error)
+	}
+	return len(measurements), nil
+}
@@ -0,0 +1,97 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestExistImporter(t *testing.T, apiURL string) *existImporter {
+	imp, err := NewExistImporter(map[string]string{"token": "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := imp.(*existImporter)
+	e.apiURL = apiURL
+	e.now = func() time.Time { return time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC) }
+	return e
+}
+
+func TestExistFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		if r.URL.Path != "/attributes/with-values/" || q.Get("date_max") != "2024-03-09" || q.Get("days") != "2" {
+			t.Errorf("request was %s", r.URL)
+		}
+		w.Write([]byte(`{"next": null, "results": [{"name": "steps", "values": [
+			{"date": "2024-03-09", "value": 8000},
+			{"date": "2024-03-08", "value": null}
+		]}]}`))
+	}))
+	defer srv.Close()
+	e := newTestExistImporter(t, srv.URL)
+
+	got, err := e.Fetch(time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC).UnixNano() / 1000 / 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Measurement{{
+		ID:        "steps/2024-03-09",
+		Metric:    "steps",
+		Date:      time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC).UnixNano() / 1000 / 1000,
+		Value:     8000,
+		Increment: true,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("measurements were %+v; wanted %+v", got, want)
+	}
+}
+
+func TestExistExport(t *testing.T) {
+	var acquired []map[string]string
+	var incremented []existValue
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/attributes/acquire/":
+			json.NewDecoder(r.Body).Decode(&acquired)
+		case "/attributes/increment/":
+			json.NewDecoder(r.Body).Decode(&incremented)
+			w.Write([]byte(`{"success": [], "failed": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	e := newTestExistImporter(t, srv.URL)
+	date := time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC).UnixNano() / 1000 / 1000
+
+	n, err := e.Export([]Measurement{
+		{Metric: "pages", Date: date, Value: 20},
+		{Metric: "pages", Date: date, Value: 5},
+	})
+
+	if err != nil || n != 2 {
+		t.Fatalf("Export returned %d, %v", n, err)
+	}
+	if want := []map[string]string{{"name": "pages"}}; !reflect.DeepEqual(acquired, want) {
+		t.Errorf("acquired %v; wanted %v", acquired, want)
+	}
+	want := []existValue{{"pages", "2024-03-09", 20}, {"pages", "2024-03-09", 5}}
+	if !reflect.DeepEqual(incremented, want) {
+		t.Errorf("incremented %v; wanted %v", incremented, want)
+	}
+}
+
+func TestNewExistImporterWithoutToken(t *testing.T) {
+	if _, err := NewExistImporter(map[string]string{}); err == nil {
+		t.Errorf("importer without token was created")
+	}
+}
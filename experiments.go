@@ -0,0 +1,189 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Experiment compares variants of a feature among users who opted in to
+// experiments. Each user is assigned one variant per experiment, and the
+// variants are compared by how many of the success events their users
+// emit.
+type Experiment struct {
+	ID          string
+	Description string
+	// Variants of the feature. The first is the control, which users who
+	// did not opt in get.
+	Variants []string
+	// Events are the types of the events that count towards the success
+	// of a variant.
+	Events []string
+}
+
+// Experiments that are running.
+var Experiments = []Experiment{
+	{
+		ID:          "digest-consistency",
+		Description: "Whether showing how consistently goals are logged in digests makes users log more often.",
+		Variants:    []string{"shown", "hidden"},
+		Events:      []string{EventGoalSet, EventGoalIncremented},
+	},
+}
+
+// ExperimentsState is stored in the profile of a user, in the field
+// experiments, to remember whether the user opted in.
+type ExperimentsState struct {
+	OptIn bool `firestore:"optIn,omitempty" json:"optIn"`
+}
+
+// ExperimentResult compares the variants of an experiment.
+type ExperimentResult struct {
+	Experiment string          `json:"experiment"`
+	Variants   []VariantResult `json:"variants"`
+}
+
+// VariantResult is how the users assigned to a variant did.
+type VariantResult struct {
+	Variant string `json:"variant"`
+	Users   int    `json:"users"`
+	Events  int    `json:"events"`
+	// EventsPerUser is the success metric of the variant.
+	EventsPerUser float32 `json:"eventsPerUser"`
+}
+
+// assign returns the variant of the experiment of a user who opted in. The
+// assignment is a hash of the user and the experiment, so that it is
+// stable without being stored, and independent between experiments.
+func (e Experiment) assign(userID string) string {
+	h := sha256.Sum256([]byte(e.ID + "/" + userID))
+	return e.Variants[binary.BigEndian.Uint64(h[:8])%uint64(len(e.Variants))]
+}
+
+// experimentVariants returns the variant of each experiment for a user.
+func experimentVariants(experiments []Experiment, userID string, state ExperimentsState) map[string]string {
+	variants := map[string]string{}
+	for _, e := range experiments {
+		if state.OptIn {
+			variants[e.ID] = e.assign(userID)
+		} else {
+			variants[e.ID] = e.Variants[0]
+		}
+	}
+	return variants
+}
+
+// GetExperiments returns whether a user opted in to experiments, and the
+// variant of each experiment for the user.
+func (s Storage) GetExperiments(userID string) (ExperimentsState, map[string]string, error) {
+	var profile struct {
+		Experiments ExperimentsState `firestore:"experiments"`
+	}
+	err := s.do("GetExperiments", func(ctx context.Context) error {
+		doc, err := s.collection("users").Doc(userID).Get(ctx)
+		if doc == nil || doc.Exists() {
+			if err != nil {
+				return err
+			}
+			return doc.DataTo(&profile)
+		}
+		return nil
+	})
+	if err != nil {
+		return ExperimentsState{}, nil, fmt.Errorf("Error reading profile: %w", err)
+	}
+	return profile.Experiments, experimentVariants(Experiments, userID, profile.Experiments), nil
+}
+
+// SetExperimentsOptIn lets a user opt in to or out of experiments.
+func (s Storage) SetExperimentsOptIn(userID string, optIn bool) error {
+	ref := s.collection("users").Doc(userID)
+	err := s.do("SetExperimentsOptIn", func(ctx context.Context) error {
+		_, err := ref.Set(ctx, map[string]interface{}{
+			"experiments": map[string]interface{}{"optIn": optIn},
+		}, firestore.MergeAll)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating profile: %w", err)
+	}
+	return nil
+}
+
+// ExperimentResults compares the variants of the running experiments by
+// the success events that the users who opted in emitted since the given
+// date, in milliseconds since the epoch. Events are only kept for the
+// retention period, and at most maxEvents of each type are counted per
+// user.
+func (s Storage) ExperimentResults(since int64) ([]ExperimentResult, error) {
+	q := s.collection("users").Where("experiments.optIn", "==", true)
+	var users []*firestore.DocumentSnapshot
+	err := s.do("ExperimentResults", func(ctx context.Context) (err error) {
+		users, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing users: %w", err)
+	}
+	// counts holds the number of events of each type of each user.
+	counts := map[string]map[string]int{}
+	for _, doc := range users {
+		n, err := s.countEvents(doc.Ref.ID, since)
+		if err != nil {
+			logf(s.ctx, severityError, "Error counting events of user %q: %v", doc.Ref.ID, err)
+			continue
+		}
+		counts[doc.Ref.ID] = n
+	}
+	return experimentResults(Experiments, counts), nil
+}
+
+// countEvents returns the number of events of each type that counts
+// towards the success of an experiment that a user emitted since the
+// given date.
+func (s Storage) countEvents(userID string, since int64) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, e := range Experiments {
+		for _, t := range e.Events {
+			if _, ok := counts[t]; ok {
+				continue
+			}
+			events, err := s.ListEvents(userID, EventFilter{Type: t, Since: since})
+			if err != nil {
+				return nil, err
+			}
+			counts[t] = len(events)
+		}
+	}
+	return counts, nil
+}
+
+// experimentResults aggregates the numbers of events of each type of each
+// user who opted in by the variants of the experiments.
+func experimentResults(experiments []Experiment, counts map[string]map[string]int) []ExperimentResult {
+	results := make([]ExperimentResult, len(experiments))
+	for i, e := range experiments {
+		variants := map[string]*VariantResult{}
+		results[i] = ExperimentResult{Experiment: e.ID, Variants: make([]VariantResult, len(e.Variants))}
+		for j, v := range e.Variants {
+			results[i].Variants[j].Variant = v
+			variants[v] = &results[i].Variants[j]
+		}
+		for userID, events := range counts {
+			v := variants[e.assign(userID)]
+			v.Users++
+			for _, t := range e.Events {
+				v.Events += events[t]
+			}
+		}
+		for j := range results[i].Variants {
+			if v := &results[i].Variants[j]; v.Users > 0 {
+				v.EventsPerUser = float32(roundTo(float32(v.Events)/float32(v.Users), 2))
+			}
+		}
+	}
+	return results
+}
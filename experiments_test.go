@@ -0,0 +1,76 @@
+package pursuit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExperimentAssign(t *testing.T) {
+	e := Experiment{ID: "e", Variants: []string{"a", "b"}}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		v := e.assign(userID)
+		if e.assign(userID) != v {
+			t.Fatalf("assignment of %s was not stable", userID)
+		}
+		counts[v]++
+	}
+	if counts["a"] < 400 || counts["b"] < 400 {
+		t.Errorf("assignments were %v; wanted an even split", counts)
+	}
+}
+
+func TestExperimentVariants(t *testing.T) {
+	experiments := []Experiment{{ID: "e", Variants: []string{"control", "x", "y"}}}
+	for i := 0; i < 10; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		if v := experimentVariants(experiments, userID, ExperimentsState{})["e"]; v != "control" {
+			t.Errorf("user who did not opt in got %q", v)
+		}
+		if v := experimentVariants(experiments, userID, ExperimentsState{OptIn: true})["e"]; v != experiments[0].assign(userID) {
+			t.Errorf("user who opted in got %q", v)
+		}
+	}
+}
+
+func TestExperimentResults(t *testing.T) {
+	e := Experiment{ID: "e", Variants: []string{"a", "b"}, Events: []string{EventGoalSet, EventGoalIncremented}}
+	counts := map[string]map[string]int{}
+	want := map[string]VariantResult{}
+	for i := 0; i < 10; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		counts[userID] = map[string]int{EventGoalSet: i, EventGoalIncremented: 1, EventGoalMilestone: 5}
+		w := want[e.assign(userID)]
+		w.Users++
+		w.Events += i + 1
+		want[e.assign(userID)] = w
+	}
+
+	results := experimentResults([]Experiment{e}, counts)
+
+	if len(results) != 1 || results[0].Experiment != "e" || len(results[0].Variants) != 2 {
+		t.Fatalf("results were %+v", results)
+	}
+	for _, v := range results[0].Variants {
+		w := want[v.Variant]
+		if v.Users != w.Users || v.Events != w.Events || v.EventsPerUser != float32(roundTo(float32(w.Events)/float32(w.Users), 2)) {
+			t.Errorf("variant %s was %+v; wanted %+v", v.Variant, v, w)
+		}
+	}
+}
+
+func TestRenderDigestVariant(t *testing.T) {
+	d := NewDigest("u", digestObjectives(), 5*day)
+	d.Variants = map[string]string{"digest-consistency": "hidden"}
+	for _, html := range []bool{false, true} {
+		text, err := RenderDigest(d, html)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(text, "recent days") {
+			t.Errorf("digest showed the consistency:\n%s", text)
+		}
+	}
+}
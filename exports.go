@@ -0,0 +1,269 @@
+package pursuit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportBucket writes exports into a Cloud Storage bucket and hands them
+// out as V4 signed URLs, so that large exports are downloaded from Cloud
+// Storage instead of streaming through a request to Cloud Run. The
+// service account of the Cloud Run service has no private key, so URLs
+// are signed with the IAM Credentials signBlob API. This requires the
+// service account to have roles/iam.serviceAccountTokenCreator on itself.
+// Exports are never deleted by the server; the bucket should have a
+// lifecycle rule that deletes objects under exports/ after a day.
+type ExportBucket struct {
+	client    *http.Client
+	apiURL    string
+	uploadURL string
+	iamURL    string
+	host      string
+	bucket    string
+	// serviceAccount is the email of the service account that signs URLs.
+	serviceAccount string
+	// token returns an OAuth access token for Cloud Storage and IAM.
+	token func() (string, error)
+}
+
+// NewExportBucket creates an export bucket, whose URLs are signed by the
+// service account with the given email. It authenticates as the service
+// account of the Cloud Run service.
+func NewExportBucket(bucket, serviceAccount string) *ExportBucket {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	return &ExportBucket{
+		client:         client,
+		apiURL:         "https://storage.googleapis.com/storage/v1",
+		uploadURL:      "https://storage.googleapis.com/upload/storage/v1",
+		iamURL:         "https://iamcredentials.googleapis.com/v1",
+		host:           "storage.googleapis.com",
+		bucket:         bucket,
+		serviceAccount: serviceAccount,
+		token:          metadataToken(client),
+	}
+}
+
+// Export is a finished export, which can be downloaded from URL until
+// Expires, in milliseconds since the epoch.
+type Export struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+// exportURLTTL is how long the URL of an export can be used.
+const exportURLTTL = time.Hour
+
+// ExportParquet writes the trajectories of a user as a Parquet file into
+// the bucket, and returns a signed URL for it.
+func (b *ExportBucket) ExportParquet(userID string, rows []TrajectoryRow, now time.Time) (Export, error) {
+	name := fmt.Sprintf("exports/%s/%d.parquet", userID, now.UnixNano()/1000/1000)
+	err := b.upload(name, "application/vnd.apache.parquet", func(w io.Writer) error {
+		return WriteParquet(w, rows)
+	})
+	if err != nil {
+		return Export{}, err
+	}
+	u, err := b.signedURL(name, "pursuit.parquet", now)
+	if err != nil {
+		return Export{}, err
+	}
+	return Export{u, now.Add(exportURLTTL).UnixNano() / 1000 / 1000}, nil
+}
+
+// upload streams the output of write into an object of the bucket.
+func (b *ExportBucket) upload(name, contentType string, write func(w io.Writer) error) error {
+	token, err := b.token()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	u := fmt.Sprintf("%s/b/%s/o?uploadType=media&name=%s", b.uploadURL, url.PathEscape(b.bucket), url.QueryEscape(name))
+	req, err := http.NewRequest(http.MethodPost, u, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error uploading %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error uploading %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+// list returns the names of the objects of the bucket under prefix.
+func (b *ExportBucket) list(prefix string) ([]string, error) {
+	token, err := b.token()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	pageToken := ""
+	for {
+		u := fmt.Sprintf("%s/b/%s/o?fields=items(name),nextPageToken&prefix=%s", b.apiURL, url.PathEscape(b.bucket), url.QueryEscape(prefix))
+		if pageToken != "" {
+			u += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing %s: %w", prefix, err)
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Error listing %s: %s", prefix, resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		if page.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// delete deletes an object of the bucket. Objects that do not exist are
+// ignored.
+func (b *ExportBucket) delete(name string) error {
+	token, err := b.token()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/b/%s/o/%s", b.apiURL, url.PathEscape(b.bucket), url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error deleting %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Error deleting %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+// signedURL returns a V4 signed URL that downloads an object of the bucket
+// as filename, see
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually.
+func (b *ExportBucket) signedURL(name, filename string, now time.Time) (string, error) {
+	now = now.UTC()
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+	timestamp := now.Format("20060102T150405Z")
+	query := map[string]string{
+		"X-Goog-Algorithm":             "GOOG4-RSA-SHA256",
+		"X-Goog-Credential":            b.serviceAccount + "/" + scope,
+		"X-Goog-Date":                  timestamp,
+		"X-Goog-Expires":               strconv.Itoa(int(exportURLTTL / time.Second)),
+		"X-Goog-SignedHeaders":         "host",
+		"response-content-disposition": fmt.Sprintf("attachment; filename=%q", filename),
+	}
+	path := "/" + url.PathEscape(b.bucket)
+	for _, segment := range strings.Split(name, "/") {
+		path += "/" + url.PathEscape(segment)
+	}
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		path,
+		canonicalQuery(query),
+		"host:" + b.host,
+		"",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	signature, err := b.signBlob([]byte("GOOG4-RSA-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])))
+	if err != nil {
+		return "", err
+	}
+	return "https://" + b.host + path + "?" + canonicalQuery(query) + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// canonicalQuery encodes query parameters sorted by name, with spaces as
+// %20 rather than +, as V4 signatures require.
+func canonicalQuery(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	escape := func(s string) string {
+		return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = escape(k) + "=" + escape(query[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// signBlob signs data with the key of the service account.
+func (b *ExportBucket) signBlob(data []byte) ([]byte, error) {
+	token, err := b.token()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("%s/projects/-/serviceAccounts/%s:signBlob", b.iamURL, url.PathEscape(b.serviceAccount))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error signing URL: %s", resp.Status)
+	}
+	var result struct {
+		SignedBlob string `json:"signedBlob"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.SignedBlob)
+}
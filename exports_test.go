@@ -0,0 +1,127 @@
+package pursuit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	got := canonicalQuery(map[string]string{"b": "x y", "a": "1/2"})
+	if want := "a=1%2F2&b=x%20y"; got != want {
+		t.Errorf("got %q; wanted %q", got, want)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	var uploaded, signed string
+	var size int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/upload/b/bucket/o"):
+			uploaded = r.URL.Query().Get("name")
+			body, _ := ioutil.ReadAll(r.Body)
+			size = len(body)
+		case r.URL.Path == "/iam/projects/-/serviceAccounts/sa@example.com:signBlob":
+			var req struct{ Payload string }
+			json.NewDecoder(r.Body).Decode(&req)
+			payload, _ := base64.StdEncoding.DecodeString(req.Payload)
+			signed = string(payload)
+			json.NewEncoder(w).Encode(map[string]string{"signedBlob": base64.StdEncoding.EncodeToString([]byte{0xab, 0xcd})})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	b := &ExportBucket{
+		client:         srv.Client(),
+		uploadURL:      srv.URL + "/upload",
+		iamURL:         srv.URL + "/iam",
+		host:           "storage.googleapis.com",
+		bucket:         "bucket",
+		serviceAccount: "sa@example.com",
+		token:          func() (string, error) { return "secret", nil },
+	}
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	rows := []TrajectoryRow{{Objective: "o", Goal: "g", Date: 1000, Value: 2}}
+
+	e, err := b.ExportParquet("u", rows, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != "exports/u/1619870400000.parquet" || size == 0 {
+		t.Errorf("uploaded %d bytes to %q", size, uploaded)
+	}
+	if !strings.HasPrefix(signed, "GOOG4-RSA-SHA256\n20210501T120000Z\n20210501/auto/storage/goog4_request\n") {
+		t.Errorf("signed %q", signed)
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "storage.googleapis.com" || u.Path != "/bucket/exports/u/1619870400000.parquet" {
+		t.Errorf("URL was %q", e.URL)
+	}
+	q := u.Query()
+	if q.Get("X-Goog-Signature") != "abcd" || q.Get("X-Goog-Credential") != "sa@example.com/20210501/auto/storage/goog4_request" || q.Get("X-Goog-Expires") != "3600" {
+		t.Errorf("query was %v", q)
+	}
+	if e.Expires != now.Add(time.Hour).UnixNano()/1000/1000 {
+		t.Errorf("expires was %d", e.Expires)
+	}
+}
+
+func TestExportBucketListAndDelete(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/b/bucket/o":
+			if r.URL.Query().Get("prefix") != "shared/" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.URL.Query().Get("pageToken") == "" {
+				w.Write([]byte(`{"items": [{"name": "shared/a/o.json"}], "nextPageToken": "next"}`))
+			} else {
+				w.Write([]byte(`{"items": [{"name": "shared/a/o/g.json"}]}`))
+			}
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/b/bucket/o/"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/b/bucket/o/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	b := &ExportBucket{
+		client: srv.Client(),
+		apiURL: srv.URL + "/api",
+		bucket: "bucket",
+		token:  func() (string, error) { return "secret", nil },
+	}
+
+	names, err := b.list("shared/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "shared/a/o.json" || names[1] != "shared/a/o/g.json" {
+		t.Errorf("names were %v", names)
+	}
+	if err := b.delete("shared/a/o.json"); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "shared/a/o.json" {
+		t.Errorf("deleted %v; wanted shared/a/o.json", deleted)
+	}
+}
@@ -0,0 +1,185 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// FieldMask lists the fields of an objective that an update changes, as
+// dot-separated Firestore paths such as "description" or
+// "goals.run.target". Goal IDs in paths cannot contain dots.
+type FieldMask []string
+
+// validate rejects empty masks and masks with overlapping paths, which
+// Firestore rejects as well.
+func (m FieldMask) validate() error {
+	if len(m) == 0 {
+		return fmt.Errorf("Empty field mask: %w", ErrInvalidValue)
+	}
+	for i, a := range m {
+		for _, b := range m[i+1:] {
+			if a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".") {
+				return fmt.Errorf("Overlapping fields in mask: %q and %q: %w", a, b, ErrInvalidValue)
+			}
+		}
+	}
+	return nil
+}
+
+func (m FieldMask) contains(path string) bool {
+	for _, p := range m {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateObjectiveFields sets the fields in the mask of an objective of a
+// user to the given values, and clears the fields in the mask that have no
+// value. Values are given in their JSON form, e.g. a trajectory as a list
+// of objects with date and value. Unlike UpdateObjective, fields that are
+// not in the mask are left alone, including fields that this version of
+// the server does not know about.
+func (s Storage) UpdateObjectiveFields(userID, objectiveID string, mask FieldMask, values map[string]interface{}) error {
+	return s.updateObjectiveFields("UpdateObjectiveFields", userID, objectiveID, mask, values, nil)
+}
+
+// updateObjectiveFields is UpdateObjectiveFields with a check of the
+// objective as read, before the update is applied.
+func (s Storage) updateObjectiveFields(op, userID, objectiveID string, mask FieldMask, values map[string]interface{}, check func(o Objective) error) error {
+	if err := mask.validate(); err != nil {
+		return err
+	}
+	if err := s.checkTrajectoryMask(mask); err != nil {
+		return err
+	}
+	if err := checkSlugMask(mask); err != nil {
+		return err
+	}
+	for path := range values {
+		if !mask.contains(path) {
+			return fmt.Errorf("Field %q is not in the mask: %w", path, ErrInvalidValue)
+		}
+	}
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	return s.transaction(op, func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if check != nil {
+			if err := check(o); err != nil {
+				return err
+			}
+		}
+		updates := make([]firestore.Update, 0, len(mask))
+		for _, path := range mask {
+			value, ok := values[path]
+			typed, err := setObjectiveField(&o, path, value, !ok)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				typed = firestore.Delete
+			}
+			updates = append(updates, firestore.Update{FieldPath: strings.Split(path, "."), Value: typed})
+		}
+		if err := validateObjective(o); err != nil {
+			return err
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
+// setObjectiveField sets the field at the path of the objective to the
+// value, or clears it, and returns the value converted into the type of
+// the field. The schema version cannot be set.
+func setObjectiveField(o *Objective, path string, value interface{}, clear bool) (interface{}, error) {
+	if path == "schemaVersion" {
+		return nil, fmt.Errorf("Field %q cannot be updated: %w", path, ErrInvalidValue)
+	}
+	var typed interface{}
+	if err := setField(reflect.ValueOf(o).Elem(), strings.Split(path, "."), value, clear, &typed); err != nil {
+		return nil, fmt.Errorf("Field %q: %w", path, err)
+	}
+	return typed, nil
+}
+
+func setField(v reflect.Value, parts []string, value interface{}, clear bool, typed *interface{}) error {
+	if len(parts) == 0 {
+		if clear {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("%v: %w", err, ErrInvalidValue)
+		}
+		p := reflect.New(v.Type())
+		if err := json.Unmarshal(b, p.Interface()); err != nil {
+			return fmt.Errorf("%v: %w", err, ErrInvalidValue)
+		}
+		v.Set(p.Elem())
+		*typed = v.Interface()
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setField(v.Elem(), parts, value, clear, typed)
+	case reflect.Struct:
+		i, ok := firestoreField(v.Type(), parts[0])
+		if !ok {
+			return fmt.Errorf("Unknown field %q: %w", parts[0], ErrInvalidValue)
+		}
+		return setField(v.Field(i), parts[1:], value, clear, typed)
+	case reflect.Map:
+		if parts[0] == "" {
+			return fmt.Errorf("Missing key: %w", ErrInvalidValue)
+		}
+		key := reflect.ValueOf(parts[0])
+		if len(parts) == 1 && clear {
+			v.SetMapIndex(key, reflect.Value{})
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setField(elem, parts[1:], value, clear, typed); err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+	return fmt.Errorf("Unknown field %q: %w", parts[0], ErrInvalidValue)
+}
+
+// firestoreField returns the index of the field of the struct type that is
+// stored under the given name in Firestore.
+func firestoreField(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("firestore"), ",")[0]
+		if tag == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFieldMaskValidate(t *testing.T) {
+	if err := (FieldMask{"name", "goals.run.target", "goals.runner"}).validate(); err != nil {
+		t.Errorf("valid mask was rejected: %v", err)
+	}
+	for _, m := range []FieldMask{nil, {"goals.run", "goals.run.target"}, {"name", "name"}} {
+		if err := m.validate(); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("mask %v: error was %v; wanted ErrInvalidValue", m, err)
+		}
+	}
+}
+
+func TestSetObjectiveField(t *testing.T) {
+	o := Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 10, Mute: &Mute{Until: 5}},
+	}}
+
+	typed, err := setObjectiveField(&o, "goals.run.target", 20.0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed != float32(20) || o.Goals["run"].Target != 20 || o.Goals["run"].Name != "Run" {
+		t.Errorf("got %#v and goal %+v", typed, o.Goals["run"])
+	}
+
+	if _, err := setObjectiveField(&o, "goals.run.mute", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if o.Goals["run"].Mute != nil {
+		t.Errorf("mute was not cleared")
+	}
+
+	if _, err := setObjectiveField(&o, "goals.swim.trajectory", []interface{}{map[string]interface{}{"Date": 1, "Value": 2}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if tr := o.Goals["swim"].Trajectory; len(tr) != 1 || tr[0].Value != 2 {
+		t.Errorf("trajectory was %+v", tr)
+	}
+
+	for _, path := range []string{"schemaVersion", "color", "goals.run.color", "name.first"} {
+		if _, err := setObjectiveField(&o, path, "x", false); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("path %q: error was %v; wanted ErrInvalidValue", path, err)
+		}
+	}
+	if _, err := setObjectiveField(&o, "goals.run.target", "high", false); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("error was %v; wanted ErrInvalidValue", err)
+	}
+}
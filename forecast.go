@@ -0,0 +1,196 @@
+package pursuit
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// day in milliseconds.
+const day = 24 * 60 * 60 * 1000
+
+const (
+	// forecastWindow is how far back the trajectory is used to estimate
+	// the rate of progress.
+	forecastWindow = 8 * 7 * 24 * time.Hour
+	// forecastSeasonalDays is how many days of history are needed to
+	// estimate the progress on each day of the week.
+	forecastSeasonalDays = 14
+	// forecastHorizon limits how many days are projected.
+	forecastHorizon = 5 * 366
+	// forecastZ is the number of standard deviations of the daily progress
+	// between the expected and the optimistic or pessimistic forecast. It
+	// corresponds to a confidence of 80%.
+	forecastZ = 1.28
+)
+
+// Forecast predicts when a goal reaches its target.
+type Forecast struct {
+	// Completion is the date in milliseconds since the epoch by which the
+	// target is expected to be reached, or zero if it will not be reached
+	// within five years at the current rate.
+	Completion int64 `json:"completion"`
+	// Optimistic and Pessimistic bound the completion date, based on the
+	// variance of the daily progress. They are zero like Completion if the
+	// target is not reached in time.
+	Optimistic  int64 `json:"optimistic"`
+	Pessimistic int64 `json:"pessimistic"`
+	// Rate is the average progress per day, in the unit of the goal, and
+	// Deviation its standard deviation.
+	Rate      float64 `json:"rate"`
+	Deviation float64 `json:"deviation"`
+	// Weekdays is the average progress on each day of the week, starting
+	// on Sunday. It is only set if the forecast is seasonal.
+	Weekdays []float64 `json:"weekdays,omitempty"`
+	// Seasonal reports whether the forecast accounts for the day of the
+	// week, which needs at least two weeks of history. Otherwise, the
+	// forecast is a linear extrapolation of the rate.
+	Seasonal bool `json:"seasonal"`
+}
+
+// ForecastGoal predicts when the goal reaches its target, based on the
+// progress recorded over the past weeks. Progress is attributed to the
+// day of the week on which it was recorded in the given location, so that
+// habits like never running on Mondays are reflected in the forecast.
+func ForecastGoal(g Goal, now int64, loc *time.Location) (Forecast, error) {
+	if g.Aggregation != "" && g.Aggregation != AggregationLatest {
+		return Forecast{}, fmt.Errorf("Cannot forecast goals with aggregation %q: %w", g.Aggregation, ErrInvalidValue)
+	}
+	if len(g.Trajectory) == 0 {
+		return Forecast{}, fmt.Errorf("Cannot forecast goals without values: %w", ErrInvalidValue)
+	}
+
+	from := now - forecastWindow.Milliseconds()
+	if from < g.Start {
+		from = g.Start
+	}
+	if from < g.Trajectory[0].Date {
+		from = g.Trajectory[0].Date
+	}
+	var sums [7]float64
+	var total float64
+	daily := map[time.Time]float64{}
+	for i := 1; i < len(g.Trajectory); i++ {
+		p := g.Trajectory[i]
+		if p.Date < from || p.Date > now {
+			continue
+		}
+		d := float64(p.Value - g.Trajectory[i-1].Value)
+		t := toTime(p.Date, loc)
+		sums[t.Weekday()] += d
+		daily[startOfDay(t)] += d
+		total += d
+	}
+	var counts [7]int
+	days := 0
+	today := startOfDay(toTime(now, loc))
+	for t := startOfDay(toTime(from, loc)); !t.After(today); t = t.AddDate(0, 0, 1) {
+		counts[t.Weekday()]++
+		days++
+	}
+
+	f := Forecast{Rate: total / float64(days)}
+	var squares float64
+	for t := startOfDay(toTime(from, loc)); !t.After(today); t = t.AddDate(0, 0, 1) {
+		d := daily[t] - f.Rate
+		squares += d * d
+	}
+	f.Deviation = math.Sqrt(squares / float64(days))
+	current := float64(g.Trajectory[len(g.Trajectory)-1].Value)
+	remaining := float64(g.Target) - current
+	direction := 1.0
+	if float64(g.Target) < float64(g.Baseline()) || (g.Target == g.Baseline() && remaining < 0) {
+		direction = -1
+	}
+	if remaining*direction <= 0 {
+		f.Completion = now
+		f.Optimistic = now
+		f.Pessimistic = now
+		return f, nil
+	}
+	f.Optimistic, f.Pessimistic = forecastBand(remaining*direction, f.Rate*direction, f.Deviation, now)
+
+	if days < forecastSeasonalDays {
+		if f.Rate*direction > 0 {
+			f.Completion = forecastDate(now, remaining/f.Rate)
+		}
+		f.Optimistic, f.Completion, f.Pessimistic = orderForecast(f.Optimistic, f.Completion, f.Pessimistic)
+		return f, nil
+	}
+
+	f.Seasonal = true
+	f.Weekdays = make([]float64, 7)
+	for i := range sums {
+		f.Weekdays[i] = sums[i] / float64(counts[i])
+	}
+	value := current
+	t := today
+	for i := 0; i < forecastHorizon; i++ {
+		t = t.AddDate(0, 0, 1)
+		value += f.Weekdays[t.Weekday()]
+		if (float64(g.Target)-value)*direction <= 0 {
+			f.Completion = t.UnixNano() / 1000 / 1000
+			break
+		}
+	}
+	f.Optimistic, f.Completion, f.Pessimistic = orderForecast(f.Optimistic, f.Completion, f.Pessimistic)
+	return f, nil
+}
+
+// forecastBand estimates the optimistic and pessimistic completion dates.
+// After n days, the progress is expected to be n*rate, with a standard
+// deviation of sqrt(n)*deviation if days are independent. The bounds are
+// the numbers of days after which the progress minus, or plus, forecastZ
+// standard deviations covers the remaining amount. Both the remaining
+// amount and the rate are in the direction of the target.
+func forecastBand(remaining, rate, deviation float64, now int64) (optimistic, pessimistic int64) {
+	spread := forecastZ * deviation
+	// Solve rate*x^2 -/+ spread*x - remaining = 0 for x = sqrt(n).
+	if rate > 0 {
+		root := math.Sqrt(spread*spread + 4*rate*remaining)
+		x := (-spread + root) / (2 * rate)
+		optimistic = forecastDate(now, x*x)
+		x = (spread + root) / (2 * rate)
+		pessimistic = forecastDate(now, x*x)
+	} else if spread > 0 {
+		// Without progress on average, only the variance can reach the
+		// target.
+		x := remaining / spread
+		optimistic = forecastDate(now, x*x)
+	}
+	return optimistic, pessimistic
+}
+
+// forecastDate returns the date that is the given number of days after
+// now, or zero if it is beyond the horizon.
+func forecastDate(now int64, days float64) int64 {
+	if days > forecastHorizon {
+		return 0
+	}
+	return now + int64(days*day)
+}
+
+// orderForecast makes sure that the optimistic date is not later than the
+// expected date, and the expected date not later than the pessimistic
+// date, as the expected date may account for the day of the week. Zero
+// dates are never reached, i.e. later than all other dates.
+func orderForecast(optimistic, expected, pessimistic int64) (int64, int64, int64) {
+	later := func(a, b int64) bool {
+		return a == 0 && b != 0 || a != 0 && b != 0 && a > b
+	}
+	if later(optimistic, expected) {
+		optimistic = expected
+	}
+	if later(expected, pessimistic) {
+		pessimistic = expected
+	}
+	return optimistic, expected, pessimistic
+}
+
+func toTime(date int64, loc *time.Location) time.Time {
+	return time.Unix(0, date*int64(time.Millisecond)).In(loc)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,180 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / 1000 / 1000
+}
+
+// neverOnMondays runs 10 km at noon on every day but Mondays, from
+// Sunday, January 3rd until Sunday, January 31st 2021.
+func neverOnMondays() Goal {
+	start := time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+	g := Goal{
+		Start:      millis(start),
+		End:        millis(start.AddDate(0, 3, 0)),
+		Trajectory: Trajectory{{Date: millis(start), Value: 0}},
+	}
+	var value float32
+	for d := start; d.Day() <= 31 && d.Month() == time.January; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == time.Monday {
+			continue
+		}
+		value += 10
+		g.Trajectory = append(g.Trajectory, DateValue{Date: millis(d.Add(12 * time.Hour)), Value: value})
+	}
+	g.Target = value + 25
+	return g
+}
+
+func TestForecastGoalSeasonal(t *testing.T) {
+	g := neverOnMondays()
+	now := millis(time.Date(2021, 1, 31, 18, 0, 0, 0, time.UTC))
+
+	f, err := ForecastGoal(g, now, time.UTC)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !f.Seasonal {
+		t.Errorf("forecast was not seasonal")
+	}
+	if f.Weekdays[time.Monday] != 0 || f.Weekdays[time.Tuesday] != 10 {
+		t.Errorf("weekdays were %v; wanted 0 on Mondays and 10 on Tuesdays", f.Weekdays)
+	}
+	want := millis(time.Date(2021, 2, 4, 0, 0, 0, 0, time.UTC))
+	if f.Completion != want {
+		t.Errorf("completion was %v; wanted %v", toTime(f.Completion, time.UTC), toTime(want, time.UTC))
+	}
+}
+
+func TestForecastGoalLinear(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 2 * day, Value: 30}},
+	}
+
+	f, err := ForecastGoal(g, 2*day, time.UTC)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Seasonal {
+		t.Errorf("forecast of three days was seasonal")
+	}
+	if f.Rate != 10 {
+		t.Errorf("rate was %f; wanted 10", f.Rate)
+	}
+	if f.Completion != 9*day {
+		t.Errorf("completion was %d; wanted %d", f.Completion, 9*day)
+	}
+}
+
+func TestForecastGoalDecreasing(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		Target:     70,
+		Trajectory: Trajectory{{Date: 0, Value: 80}, {Date: 2 * day, Value: 77}},
+	}
+
+	f, err := ForecastGoal(g, 2*day, time.UTC)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Completion != 9*day {
+		t.Errorf("completion was %d; wanted %d", f.Completion, 9*day)
+	}
+}
+
+func TestForecastGoalWithoutProgress(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 10}, {Date: day, Value: 10}},
+	}
+
+	f, err := ForecastGoal(g, day, time.UTC)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Completion != 0 {
+		t.Errorf("completion was %d; wanted none", f.Completion)
+	}
+}
+
+func TestForecastGoalComplete(t *testing.T) {
+	g := Goal{Target: 10, Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: day, Value: 12}}}
+
+	f, _ := ForecastGoal(g, 2*day, time.UTC)
+
+	if f.Completion != 2*day {
+		t.Errorf("completion was %d; wanted %d", f.Completion, 2*day)
+	}
+}
+
+func TestForecastGoalAggregated(t *testing.T) {
+	g := Goal{Aggregation: AggregationMaximum, Trajectory: Trajectory{{Date: 0, Value: 1}}}
+
+	_, err := ForecastGoal(g, 0, time.UTC)
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestForecastGoalBand(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 2 * day, Value: 30}},
+	}
+
+	f, _ := ForecastGoal(g, 2*day, time.UTC)
+
+	if f.Deviation == 0 {
+		t.Errorf("deviation of uneven progress was 0")
+	}
+	if !(f.Optimistic < f.Completion && f.Completion < f.Pessimistic) {
+		t.Errorf("forecast was %+v; wanted optimistic < completion < pessimistic", f)
+	}
+}
+
+func TestForecastGoalBandOfSteadyProgress(t *testing.T) {
+	g := Goal{
+		Start:  0,
+		Target: 100,
+		Trajectory: Trajectory{
+			{Date: 0, Value: 0},
+			{Date: day / 2, Value: 10},
+			{Date: 3 * day / 2, Value: 20},
+			{Date: 5 * day / 2, Value: 30},
+		},
+	}
+
+	f, _ := ForecastGoal(g, 5*day/2, time.UTC)
+
+	if f.Deviation != 0 {
+		t.Errorf("deviation of steady progress was %f; wanted 0", f.Deviation)
+	}
+	if f.Optimistic != f.Completion || f.Pessimistic != f.Completion {
+		t.Errorf("forecast was %+v; wanted no band", f)
+	}
+}
+
+func TestOrderForecast(t *testing.T) {
+	o, e, p := orderForecast(5, 3, 0)
+	if o != 3 || e != 3 || p != 0 {
+		t.Errorf("ordered forecast was %d, %d, %d; wanted 3, 3, 0", o, e, p)
+	}
+	o, e, p = orderForecast(0, 0, 4)
+	if o != 0 || e != 0 || p != 0 {
+		t.Errorf("ordered forecast was %d, %d, %d; wanted 0, 0, 0", o, e, p)
+	}
+}
@@ -0,0 +1,142 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Kinds of problems found by CheckObjective.
+const (
+	ProblemUnsortedTrajectory = "unsorted-trajectory"
+	ProblemNonFiniteValue     = "non-finite-value"
+	ProblemZeroTarget         = "zero-target"
+	ProblemBrokenComponent    = "broken-component"
+)
+
+// Problem is a violation of an invariant of an objective document.
+type Problem struct {
+	User      string `json:"user"`
+	Objective string `json:"objective"`
+	Goal      string `json:"goal,omitempty"`
+	Kind      string `json:"kind"`
+	Detail    string `json:"detail"`
+	Repaired  bool   `json:"repaired"`
+}
+
+// CheckObjective returns the problems of the objective. If repair is
+// true, problems that can be repaired without guessing are repaired in
+// place: trajectories are sorted by date and non-finite values are
+// removed. Zero targets are only reported.
+func CheckObjective(o *Objective, repair bool) []Problem {
+	var problems []Problem
+	ids := make([]string, 0, len(o.Goals))
+	for id := range o.Goals {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		g := o.Goals[id]
+		if !sort.SliceIsSorted(g.Trajectory, func(i, j int) bool {
+			return g.Trajectory[i].Date < g.Trajectory[j].Date
+		}) {
+			problems = append(problems, Problem{
+				Goal:     id,
+				Kind:     ProblemUnsortedTrajectory,
+				Detail:   "trajectory is not sorted by date",
+				Repaired: repair,
+			})
+			if repair {
+				sort.SliceStable(g.Trajectory, func(i, j int) bool {
+					return g.Trajectory[i].Date < g.Trajectory[j].Date
+				})
+			}
+		}
+		var finite Trajectory
+		for _, p := range g.Trajectory {
+			if isFinite(p.Value) {
+				finite = append(finite, p)
+				continue
+			}
+			problems = append(problems, Problem{
+				Goal:     id,
+				Kind:     ProblemNonFiniteValue,
+				Detail:   fmt.Sprintf("value %v at date %d", p.Value, p.Date),
+				Repaired: repair,
+			})
+		}
+		if repair {
+			g.Trajectory = finite
+		}
+		if detail := o.checkComponents(id); detail != "" {
+			problems = append(problems, Problem{
+				Goal:   id,
+				Kind:   ProblemBrokenComponent,
+				Detail: detail,
+			})
+		}
+		if g.Target == 0 || !isFinite(g.Target) {
+			problems = append(problems, Problem{
+				Goal:   id,
+				Kind:   ProblemZeroTarget,
+				Detail: fmt.Sprintf("target is %v", g.Target),
+			})
+		}
+		o.Goals[id] = g
+	}
+	return problems
+}
+
+// Fsck checks the objectives of all users and reports each problem. If
+// repair is true, repairable problems are written back to Firestore.
+func (s Storage) Fsck(repair bool, report func(Problem)) error {
+	var users []*firestore.DocumentRef
+	err := s.do("Fsck", func(ctx context.Context) (err error) {
+		users, err = s.collection("users").DocumentRefs(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing users: %w", err)
+	}
+	for _, user := range users {
+		var refs []*firestore.DocumentRef
+		err := s.do("Fsck", func(ctx context.Context) (err error) {
+			refs, err = user.Collection("objectives").DocumentRefs(ctx).GetAll()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error listing objectives of user %q: %w", user.ID, err)
+		}
+		for _, ref := range refs {
+			var problems []Problem
+			err := s.transaction("Fsck", func(tx *firestore.Transaction) error {
+				doc, err := tx.Get(ref)
+				if err != nil {
+					return err
+				}
+				var o Objective
+				if err := doc.DataTo(&o); err != nil {
+					return err
+				}
+				problems = CheckObjective(&o, repair)
+				for _, p := range problems {
+					if p.Repaired {
+						return tx.Set(ref, o)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("Error checking objective %q of user %q: %w", ref.ID, user.ID, err)
+			}
+			for _, p := range problems {
+				p.User = user.ID
+				p.Objective = ref.ID
+				report(p)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package pursuit
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckObjective(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{
+			"abc": {
+				Target:     100,
+				Trajectory: Trajectory{{Date: 0, Value: 1}, {Date: 1, Value: 2}},
+			},
+		},
+	}
+
+	problems := CheckObjective(&o, false)
+
+	if len(problems) != 0 {
+		t.Errorf("wanted no problems, got %v", problems)
+	}
+}
+
+func TestCheckObjectiveUnsorted(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{
+			"abc": {
+				Target:     100,
+				Trajectory: Trajectory{{Date: 2, Value: 3}, {Date: 1, Value: 2}},
+			},
+		},
+	}
+
+	problems := CheckObjective(&o, true)
+
+	if len(problems) != 1 || problems[0].Kind != ProblemUnsortedTrajectory || !problems[0].Repaired {
+		t.Errorf("wanted repaired unsorted trajectory, got %v", problems)
+	}
+	if o.Goals["abc"].Trajectory[0].Date != 1 {
+		t.Errorf("trajectory was not sorted: %v", o.Goals["abc"].Trajectory)
+	}
+}
+
+func TestCheckObjectiveNonFinite(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{
+			"abc": {
+				Target: 100,
+				Trajectory: Trajectory{
+					{Date: 0, Value: 1},
+					{Date: 1, Value: float32(math.NaN())},
+					{Date: 2, Value: float32(math.Inf(1))},
+				},
+			},
+		},
+	}
+
+	problems := CheckObjective(&o, true)
+
+	if len(problems) != 2 || problems[0].Kind != ProblemNonFiniteValue {
+		t.Errorf("wanted two non-finite values, got %v", problems)
+	}
+	if len(o.Goals["abc"].Trajectory) != 1 {
+		t.Errorf("non-finite values were not removed: %v", o.Goals["abc"].Trajectory)
+	}
+}
+
+func TestCheckObjectiveWithoutRepair(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{
+			"abc": {
+				Target:     100,
+				Trajectory: Trajectory{{Date: 0, Value: float32(math.NaN())}},
+			},
+		},
+	}
+
+	problems := CheckObjective(&o, false)
+
+	if len(problems) != 1 || problems[0].Repaired {
+		t.Errorf("wanted one unrepaired problem, got %v", problems)
+	}
+	if len(o.Goals["abc"].Trajectory) != 1 {
+		t.Errorf("trajectory was modified without repair")
+	}
+}
+
+func TestCheckObjectiveZeroTarget(t *testing.T) {
+	o := Objective{
+		Goals: map[string]Goal{
+			"abc": {Trajectory: Trajectory{{Date: 0, Value: 0}}},
+		},
+	}
+
+	problems := CheckObjective(&o, true)
+
+	if len(problems) != 1 || problems[0].Kind != ProblemZeroTarget || problems[0].Repaired {
+		t.Errorf("wanted unrepaired zero target, got %v", problems)
+	}
+}
@@ -5,5 +5,8 @@ go 1.13
 require (
 	cloud.google.com/go/firestore v1.5.0
 	firebase.google.com/go v3.13.0+incompatible
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
 	golang.org/x/tools v0.1.1 // indirect
+	google.golang.org/api v0.40.0
+	google.golang.org/grpc v1.35.0
 )
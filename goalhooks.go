@@ -0,0 +1,194 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// maxGoalHooks limits the number of hooks of a goal.
+const maxGoalHooks = 10
+
+// GoalHook for Firestore serialization/deserialization. A goal hook is an
+// outbound webhook that a user attached to a goal, such as a training
+// spreadsheet that records every run. Events of the goal are POSTed to
+// its URL as they are recorded, like replayed events but without the
+// X-Pursuit-Replay header. Hooks are stored in users/{user}/goalHooks.
+type GoalHook struct {
+	Objective string `firestore:"objective" json:"objective"`
+	Goal      string `firestore:"goal" json:"goal"`
+	URL       string `firestore:"url" json:"url"`
+	// Events are the types of events that are sent, e.g. EventGoalSet.
+	// All events of the goal are sent if it is empty.
+	Events []string `firestore:"events,omitempty" json:"events,omitempty"`
+	// Created in milliseconds since the epoch.
+	Created int64 `firestore:"created" json:"created"`
+}
+
+// GoalHookEntry is a goal hook together with its ID.
+type GoalHookEntry struct {
+	ID string `json:"id"`
+	GoalHook
+}
+
+// validate checks the URL and the event types of the hook.
+func (h GoalHook) validate() error {
+	if err := validateTarget(h.URL); err != nil {
+		return err
+	}
+	for _, t := range h.Events {
+		switch t {
+		case EventGoalSet, EventGoalIncremented, EventGoalMilestone, EventGoalRecordBroken:
+		default:
+			return fmt.Errorf("Unknown event type: %q: %w", t, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the event is sent to the hook.
+func (h GoalHook) matches(e Event) bool {
+	if e.Objective != h.Objective || e.Goal != h.Goal {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvents calls f with each event once it was recorded. The server
+// uses it to send the events of goals to their hooks. It must be called
+// before the storage is used.
+func (s *Storage) HandleEvents(f func(userID string, e EventEntry)) {
+	s.events = f
+}
+
+// goalHooks returns the query for the hooks of a goal of a user.
+func (s Storage) goalHooks(userID, objectiveID, goalID string) firestore.Query {
+	return s.collection("users").Doc(userID).Collection("goalHooks").
+		Where("objective", "==", objectiveID).Where("goal", "==", goalID)
+}
+
+// CreateGoalHook attaches a hook to an existing goal of a user.
+func (s Storage) CreateGoalHook(userID, objectiveID, goalID string, h GoalHook) (GoalHookEntry, error) {
+	h.Objective, h.Goal = objectiveID, goalID
+	h.Created = time.Now().UnixNano() / 1000 / 1000
+	if err := h.validate(); err != nil {
+		return GoalHookEntry{}, err
+	}
+	o, err := s.GetObjective(userID, objectiveID)
+	if err != nil {
+		return GoalHookEntry{}, err
+	}
+	if _, ok := o.Goals[goalID]; !ok {
+		return GoalHookEntry{}, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	hooks, err := s.ListGoalHooks(userID, objectiveID, goalID)
+	if err != nil {
+		return GoalHookEntry{}, err
+	}
+	if len(hooks) >= maxGoalHooks {
+		return GoalHookEntry{}, fmt.Errorf("Goal has %d hooks already: %w", len(hooks), ErrInvalidValue)
+	}
+	ref := s.collection("users").Doc(userID).Collection("goalHooks").NewDoc()
+	err = s.do("CreateGoalHook", func(ctx context.Context) error {
+		_, err := ref.Create(ctx, h)
+		return err
+	})
+	if err != nil {
+		return GoalHookEntry{}, fmt.Errorf("Error creating goal hook: %w", err)
+	}
+	return GoalHookEntry{ref.ID, h}, nil
+}
+
+// ListGoalHooks returns the hooks of a goal of a user.
+func (s Storage) ListGoalHooks(userID, objectiveID, goalID string) ([]GoalHookEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListGoalHooks", func(ctx context.Context) (err error) {
+		docs, err = s.goalHooks(userID, objectiveID, goalID).Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing goal hooks: %w", err)
+	}
+	hooks := make([]GoalHookEntry, 0, len(docs))
+	for _, doc := range docs {
+		var h GoalHook
+		if err := doc.DataTo(&h); err != nil {
+			return nil, fmt.Errorf("Error reading goal hook %q: %w", doc.Ref.ID, err)
+		}
+		hooks = append(hooks, GoalHookEntry{doc.Ref.ID, h})
+	}
+	return hooks, nil
+}
+
+// DeleteGoalHook deletes a hook of a goal of a user.
+func (s Storage) DeleteGoalHook(userID, objectiveID, goalID, id string) error {
+	ref := s.collection("users").Doc(userID).Collection("goalHooks").Doc(id)
+	return s.transaction("DeleteGoalHook", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such goal hook: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading goal hook: %w", err)
+		}
+		var h GoalHook
+		if err := doc.DataTo(&h); err != nil {
+			return fmt.Errorf("Error reading goal hook: %w", err)
+		}
+		if h.Objective != objectiveID || h.Goal != goalID {
+			return fmt.Errorf("No such goal hook: %q: %w", id, ErrNotFound)
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// runGoalHooks sends an event of a goal to the hooks of the goal that
+// match it, unless the goal mutes hook notifications. Each delivery runs
+// as a background job, which is retried if it fails. Failures are
+// logged, since hooks must not fail the change that emitted the event.
+func (s *Server) runGoalHooks(userID string, e EventEntry) {
+	if e.Goal == "" {
+		return
+	}
+	hooks, err := s.storage.ListGoalHooks(userID, e.Objective, e.Goal)
+	if err != nil {
+		logf(context.Background(), severityError, "Error listing goal hooks of user %q: %v", userID, err)
+		return
+	}
+	var matching []GoalHookEntry
+	for _, h := range hooks {
+		if h.matches(e.Event) {
+			matching = append(matching, h)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+	o, err := s.storage.GetObjective(userID, e.Objective)
+	if err != nil {
+		logf(context.Background(), severityError, "Error reading objective %q of user %q: %v", e.Objective, userID, err)
+		return
+	}
+	if o.Goals[e.Goal].IsMuted(NotificationGoalHook, e.Date) {
+		return
+	}
+	for _, h := range matching {
+		h := h
+		_, err := s.jobs.enqueue("goalhook", userID, func(progress func(float64)) (interface{}, error) {
+			return nil, deliverEvent(s.webhooks, h.URL, e, false)
+		})
+		if err != nil {
+			logf(context.Background(), severityError, "Error queueing goal hook %q of user %q: %v", h.ID, userID, err)
+		}
+	}
+}
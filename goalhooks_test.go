@@ -0,0 +1,60 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoalHookMatches(t *testing.T) {
+	all := GoalHook{Objective: "fitness", Goal: "run"}
+	sets := GoalHook{Objective: "fitness", Goal: "run", Events: []string{EventGoalSet}}
+	for _, c := range []struct {
+		hook GoalHook
+		e    Event
+		want bool
+	}{
+		{all, Event{Type: EventGoalIncremented, Objective: "fitness", Goal: "run"}, true},
+		{all, Event{Type: EventGoalIncremented, Objective: "fitness", Goal: "swim"}, false},
+		{all, Event{Type: EventGoalIncremented, Objective: "work", Goal: "run"}, false},
+		{sets, Event{Type: EventGoalSet, Objective: "fitness", Goal: "run"}, true},
+		{sets, Event{Type: EventGoalIncremented, Objective: "fitness", Goal: "run"}, false},
+	} {
+		if got := c.hook.matches(c.e); got != c.want {
+			t.Errorf("hook %+v matched %+v: %v; wanted %v", c.hook, c.e, got, c.want)
+		}
+	}
+}
+
+func TestGoalHookValidate(t *testing.T) {
+	if err := (GoalHook{URL: "https://example.com/hook", Events: []string{EventGoalIncremented}}).validate(); err != nil {
+		t.Errorf("valid hook was rejected: %v", err)
+	}
+	if err := (GoalHook{URL: "/hook"}).validate(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("hook with relative URL got %v; wanted ErrInvalidValue", err)
+	}
+	if err := (GoalHook{URL: "https://example.com/hook", Events: []string{EventSecurityLockout}}).validate(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("hook for lockouts got %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestDeliverEventMarksReplays(t *testing.T) {
+	var replays []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replays = append(replays, r.Header.Get("X-Pursuit-Replay"))
+	}))
+	defer srv.Close()
+	e := EventEntry{"a", Event{Type: EventGoalIncremented, Goal: "run", Value: 5, Delta: 1}}
+
+	if err := deliverEvent(srv.Client(), srv.URL, e, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverEvent(srv.Client(), srv.URL, e, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replays) != 2 || replays[0] != "" || replays[1] != "true" {
+		t.Errorf("replay headers were %q; wanted only the replay marked", replays)
+	}
+}
@@ -0,0 +1,195 @@
+package pursuit
+
+import (
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+)
+
+// GoalChange changes the definition of a goal. Fields that are nil are
+// left unchanged.
+type GoalChange struct {
+	Name   *string  `json:"name,omitempty"`
+	Target *float32 `json:"target,omitempty"`
+	// Unit renames the unit of the goal. If the old and the new unit are
+	// convertible, e.g. km and mi, the target, plan and trajectory are
+	// converted as well.
+	Unit *string `json:"unit,omitempty"`
+}
+
+// GoalEntry is a goal together with its ID, the ID of its objective and
+// its current value, which is missing if the goal has no values yet.
+type GoalEntry struct {
+	Objective string   `json:"objective"`
+	ID        string   `json:"id"`
+	Current   *float32 `json:"current,omitempty"`
+	Goal
+}
+
+// goalEntries lists the goals of the objectives, ordered by objective and
+// goal ID.
+func goalEntries(objectives []ObjectiveEntry) []GoalEntry {
+	goals := []GoalEntry{}
+	for _, o := range objectives {
+		ids := make([]string, 0, len(o.Goals))
+		for id := range o.Goals {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			g := o.Goals[id]
+			e := GoalEntry{Objective: o.ID, ID: id, Goal: g}
+			if len(g.Trajectory) > 0 {
+				current := g.Current()
+				e.Current = &current
+			}
+			goals = append(goals, e)
+		}
+	}
+	return goals
+}
+
+// ListGoals returns the goals of all objectives of a user, ordered by
+// objective and goal ID.
+func (s Storage) ListGoals(userID string) ([]GoalEntry, error) {
+	objectives, err := s.ListObjectives(userID)
+	if err != nil {
+		return nil, err
+	}
+	return goalEntries(objectives), nil
+}
+
+// AddGoal adds a new goal to the objective.
+func (o *Objective) AddGoal(goalID string, g Goal) error {
+	if _, ok := o.Goals[goalID]; ok {
+		return fmt.Errorf("Goal %q: %w", goalID, ErrAlreadyExists)
+	}
+	if o.Goals == nil {
+		o.Goals = map[string]Goal{}
+	}
+	o.Goals[goalID] = g
+	return nil
+}
+
+// ChangeGoal changes the definition of a goal of the objective.
+func (o *Objective) ChangeGoal(goalID string, c GoalChange) error {
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	if c.Name != nil {
+		g.Name = *c.Name
+	}
+	if c.Unit != nil && *c.Unit != g.Unit {
+		g.changeUnit(*c.Unit)
+	}
+	if c.Target != nil {
+		g.Target = *c.Target
+	}
+	o.Goals[goalID] = g
+	return nil
+}
+
+// changeUnit sets the unit of the goal, and converts its values if the old
+// unit can be converted into the new one.
+func (g *Goal) changeUnit(unit string) {
+	factor, err := conversionFactor(g.Unit, unit)
+	if err == nil && g.Unit != "" {
+		g.Target *= factor
+		for i := range g.Plan {
+			g.Plan[i] *= factor
+		}
+		for i := range g.Trajectory {
+			g.Trajectory[i].Value *= factor
+		}
+		if g.Records != nil {
+			r := *g.Records
+			for _, record := range []**Record{&r.BestDay, &r.BestWeek} {
+				if *record != nil {
+					*record = &Record{(*record).Date, (*record).Value * factor}
+				}
+			}
+			g.Records = &r
+		}
+	}
+	g.Unit = unit
+	if g.InputUnit != "" {
+		if scale, err := conversionFactor(g.InputUnit, unit); err == nil {
+			g.InputScale = scale
+		} else {
+			g.InputUnit = ""
+			g.InputScale = 0
+		}
+	}
+}
+
+// DeleteGoal removes a goal from the objective.
+func (o *Objective) DeleteGoal(goalID string) error {
+	if _, ok := o.Goals[goalID]; !ok {
+		return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	delete(o.Goals, goalID)
+	return nil
+}
+
+// CreateGoal adds a new goal to an existing objective of a user.
+func (s Storage) CreateGoal(userID, objectiveID, goalID string, g Goal) error {
+	if goalID == "" {
+		return fmt.Errorf("Missing goal ID: %w", ErrInvalidValue)
+	}
+	return s.modifyObjective("CreateGoal", userID, objectiveID, func(o *Objective) error {
+		return o.AddGoal(goalID, g)
+	})
+}
+
+// ChangeGoal changes the definition of a goal of a user. If trajectories
+// are kept in subcollections, units cannot be changed into convertible
+// units, since that would rewrite every value.
+func (s Storage) ChangeGoal(userID, objectiveID, goalID string, c GoalChange) error {
+	return s.modifyObjective("ChangeGoal", userID, objectiveID, func(o *Objective) error {
+		if g, ok := o.Goals[goalID]; ok && s.trajectories && c.Unit != nil && g.Unit != "" && *c.Unit != g.Unit {
+			if _, err := conversionFactor(g.Unit, *c.Unit); err == nil {
+				return fmt.Errorf("Cannot convert the trajectory of goal %q into %s: %w", goalID, *c.Unit, ErrInvalidValue)
+			}
+		}
+		return o.ChangeGoal(goalID, c)
+	})
+}
+
+// DeleteGoal deletes a goal of a user, together with its trajectory.
+func (s Storage) DeleteGoal(userID, objectiveID, goalID string) error {
+	err := s.modifyObjective("DeleteGoal", userID, objectiveID, func(o *Objective) error {
+		return o.DeleteGoal(goalID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.deleteTrajectories(userID, objectiveID, goalID)
+}
+
+// modifyObjective applies f to an existing objective of a user in a
+// transaction, and stores the result if it is valid.
+func (s Storage) modifyObjective(op, userID, objectiveID string, f func(o *Objective) error) error {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	return s.transaction(op, func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if err := f(&o); err != nil {
+			return err
+		}
+		if err := validateObjective(o); err != nil {
+			return err
+		}
+		return tx.Set(ref, o)
+	})
+}
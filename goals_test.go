@@ -0,0 +1,68 @@
+package pursuit
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAddGoal(t *testing.T) {
+	o := Objective{Name: "Fitness"}
+	if err := o.AddGoal("run", Goal{Name: "Run"}); err != nil {
+		t.Fatal(err)
+	}
+	if o.Goals["run"].Name != "Run" {
+		t.Errorf("goal was not added: %+v", o.Goals)
+	}
+	if err := o.AddGoal("run", Goal{Name: "Jog"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("error was %v; wanted ErrAlreadyExists", err)
+	}
+}
+
+func TestChangeGoal(t *testing.T) {
+	o := Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 10, Unit: "km", Plan: []float32{5}, Trajectory: Trajectory{{Date: 1, Value: 2}}},
+	}}
+	name := "Jog"
+	if err := o.ChangeGoal("run", GoalChange{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if g := o.Goals["run"]; g.Name != "Jog" || g.Target != 10 || g.Unit != "km" {
+		t.Errorf("got goal %+v, wanted only the name to change", g)
+	}
+
+	unit := "m"
+	if err := o.ChangeGoal("run", GoalChange{Unit: &unit}); err != nil {
+		t.Fatal(err)
+	}
+	g := o.Goals["run"]
+	if g.Unit != "m" || math.Abs(float64(g.Target-10000)) > 0.01 || math.Abs(float64(g.Plan[0]-5000)) > 0.01 || math.Abs(float64(g.Trajectory[0].Value-2000)) > 0.01 {
+		t.Errorf("got goal %+v, wanted values converted into m", g)
+	}
+
+	unit = "laps"
+	target := float32(25)
+	if err := o.ChangeGoal("run", GoalChange{Unit: &unit, Target: &target}); err != nil {
+		t.Fatal(err)
+	}
+	if g := o.Goals["run"]; g.Unit != "laps" || g.Target != 25 || g.Trajectory[0].Value != 2000 {
+		t.Errorf("got goal %+v, wanted unit and target changed without conversion", g)
+	}
+
+	if err := o.ChangeGoal("swim", GoalChange{Name: &name}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error was %v; wanted ErrNotFound", err)
+	}
+}
+
+func TestDeleteGoal(t *testing.T) {
+	o := Objective{Name: "Fitness", Goals: map[string]Goal{"run": {Name: "Run"}}}
+	if err := o.DeleteGoal("run"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := o.Goals["run"]; ok {
+		t.Errorf("goal was not deleted")
+	}
+	if err := o.DeleteGoal("run"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error was %v; wanted ErrNotFound", err)
+	}
+}
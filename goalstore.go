@@ -0,0 +1,187 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// goalStore reads objectives and changes the values of their goals. The
+// server uses it for the goal endpoints, so that they can be tested
+// against memoryGoalStore instead of Firestore.
+type goalStore interface {
+	ListObjectives(userID string) ([]ObjectiveEntry, error)
+	readObjective(userID, objectiveID string) (Objective, error)
+	SetGoalValue(userID, objectiveID, goalID string, value float32, unit string) error
+	IncrementGoalValue(userID, objectiveID, goalID string, delta float32, unit string) error
+	IncrementGoalValueIfStale(userID, objectiveID, goalID string, delta float32, unit string, maxAge time.Duration) (bool, error)
+	MuteGoal(userID, objectiveID, goalID string, m *Mute) error
+	SetGoalStage(userID, objectiveID, goalID, stage string) error
+	SetGoalValues(userID string, values []GoalValue) ([]error, error)
+	// withContext returns the store with its operations bound to ctx.
+	withContext(ctx context.Context) goalStore
+	// withIdempotencyKey returns the store with changes of goals
+	// applied once per key.
+	withIdempotencyKey(key string) goalStore
+	// resolveIDs returns the IDs of an objective and a goal that are
+	// named by ID or by slug.
+	resolveIDs(userID, objectiveRef, goalRef string) (string, string, error)
+}
+
+func (s Storage) withContext(ctx context.Context) goalStore {
+	return s.WithContext(ctx)
+}
+
+// memoryGoalStore is a goalStore that keeps objectives in memory. Like
+// Storage, it keeps the milestones and records of goals up to date, but
+// it does not record or notify events, and keeps trajectories inline.
+type memoryGoalStore struct {
+	*memoryGoals
+	// key is the idempotency key of changes, see withIdempotencyKey.
+	key string
+}
+
+// memoryGoals is the state of a memoryGoalStore, which views with
+// idempotency keys share.
+type memoryGoals struct {
+	mu         sync.Mutex
+	objectives map[string]map[string]Objective
+	// keys are the records of idempotency keys by user and key.
+	keys map[string]map[string]idempotencyRecord
+}
+
+// newMemoryGoalStore creates an empty in-memory goal store.
+func newMemoryGoalStore() *memoryGoalStore {
+	return &memoryGoalStore{memoryGoals: &memoryGoals{
+		objectives: map[string]map[string]Objective{},
+		keys:       map[string]map[string]idempotencyRecord{},
+	}}
+}
+
+// PutObjective stores an objective of a user, replacing any objective
+// with the same ID.
+func (m *memoryGoalStore) PutObjective(userID, objectiveID string, o Objective) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objectives[userID] == nil {
+		m.objectives[userID] = map[string]Objective{}
+	}
+	m.objectives[userID][objectiveID] = copyObjective(o)
+}
+
+// withContext returns the store itself, as its operations cannot block.
+func (m *memoryGoalStore) withContext(ctx context.Context) goalStore {
+	return m
+}
+
+func (m *memoryGoalStore) ListObjectives(userID string) ([]ObjectiveEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objectives := make([]ObjectiveEntry, 0, len(m.objectives[userID]))
+	for id, o := range m.objectives[userID] {
+		objectives = append(objectives, ObjectiveEntry{id, copyObjective(o)})
+	}
+	sort.Slice(objectives, func(i, j int) bool {
+		return objectives[i].ID < objectives[j].ID
+	})
+	return objectives, nil
+}
+
+func (m *memoryGoalStore) readObjective(userID, objectiveID string) (Objective, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objectives[userID][objectiveID]
+	if !ok {
+		return Objective{}, fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+	}
+	return copyObjective(o), nil
+}
+
+// update applies f to a copy of an objective, updates the milestone and
+// records of the goal, recomputes its composite goals, and stores the
+// copy if f succeeds. It returns the
+// result that f reported. Like Storage.updateGoal, it applies a change
+// with an idempotency key once, and returns the result of the first
+// change for the same change again.
+func (m *memoryGoalStore) update(op, userID, objectiveID, goalID, payload string, f func(o *Objective) (bool, error)) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objectives[userID][objectiveID]
+	if !ok {
+		return false, fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+	}
+	request := op + "/" + objectiveID + "/" + goalID
+	if r, ok := m.keys[userID][m.key]; ok && m.key != "" {
+		if err := r.check(request, payload); err != nil {
+			return false, err
+		}
+		return r.Changed, nil
+	}
+	o = copyObjective(o)
+	changed, err := f(&o)
+	if err != nil {
+		return false, err
+	}
+	if g, ok := o.Goals[goalID]; ok {
+		g.updateMilestone()
+		g.updateRecords()
+		o.Goals[goalID] = g
+	}
+	o.recomputeComposites(goalID)
+	m.objectives[userID][objectiveID] = o
+	if m.key != "" {
+		if m.keys[userID] == nil {
+			m.keys[userID] = map[string]idempotencyRecord{}
+		}
+		m.keys[userID][m.key] = idempotencyRecord{Request: request, Payload: payload, Changed: changed}
+	}
+	return changed, nil
+}
+
+func (m *memoryGoalStore) SetGoalValue(userID, objectiveID, goalID string, value float32, unit string) error {
+	_, err := m.update("SetGoalValue", userID, objectiveID, goalID, idempotencyPayload(value, unit), func(o *Objective) (bool, error) {
+		value, err := o.ConvertGoalValue(goalID, value, unit)
+		if err != nil {
+			return false, err
+		}
+		return true, o.SetGoalValue(goalID, value)
+	})
+	return err
+}
+
+func (m *memoryGoalStore) IncrementGoalValue(userID, objectiveID, goalID string, delta float32, unit string) error {
+	_, err := m.update("IncrementGoalValue", userID, objectiveID, goalID, idempotencyPayload(delta, unit), func(o *Objective) (bool, error) {
+		delta, err := o.ConvertGoalValue(goalID, delta, unit)
+		if err != nil {
+			return false, err
+		}
+		return true, o.IncrementGoalValue(goalID, delta)
+	})
+	return err
+}
+
+func (m *memoryGoalStore) IncrementGoalValueIfStale(userID, objectiveID, goalID string, delta float32, unit string, maxAge time.Duration) (bool, error) {
+	return m.update("IncrementGoalValueIfStale", userID, objectiveID, goalID, idempotencyPayload(delta, unit, maxAge), func(o *Objective) (bool, error) {
+		delta, err := o.ConvertGoalValue(goalID, delta, unit)
+		if err != nil {
+			return false, err
+		}
+		return o.IncrementGoalValueIfStale(goalID, delta, maxAge)
+	})
+}
+
+func (m *memoryGoalStore) MuteGoal(userID, objectiveID, goalID string, mute *Mute) error {
+	_, err := m.update("MuteGoal", userID, objectiveID, goalID, idempotencyPayload(mute), func(o *Objective) (bool, error) {
+		return true, o.MuteGoal(goalID, mute)
+	})
+	return err
+}
+
+func (m *memoryGoalStore) SetGoalStage(userID, objectiveID, goalID, stage string) error {
+	_, err := m.update("SetGoalStage", userID, objectiveID, goalID, idempotencyPayload(stage), func(o *Objective) (bool, error) {
+		return true, o.SetGoalStage(goalID, stage)
+	})
+	return err
+}
@@ -0,0 +1,222 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMemoryServer() (*Server, *memoryGoalStore) {
+	goals := newMemoryGoalStore()
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 100, Unit: "km"},
+	}})
+	return &Server{goals: goals, auth: fakeAuth{}}, goals
+}
+
+func TestMemoryGoalStoreCopies(t *testing.T) {
+	_, goals := newMemoryServer()
+	o, err := goals.readObjective("alice", "fitness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Goals["run"] = Goal{Name: "Jog"}
+	if o, _ := goals.readObjective("alice", "fitness"); o.Goals["run"].Name != "Run" {
+		t.Errorf("change to a read objective leaked into the store")
+	}
+	if _, err := goals.readObjective("alice", "reading"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error was %v; wanted ErrNotFound", err)
+	}
+}
+
+func TestSetGoalValueHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	body := `{"objective": "fitness", "goal": "run", "value": 5000, "unit": "m"}`
+	r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.setGoalValue(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if tr := o.Goals["run"].Trajectory; len(tr) != 1 || tr[0].Value != 5 {
+		t.Errorf("trajectory was %+v; wanted 5 km", tr)
+	}
+}
+
+func TestMemoryGoalStoreUpdatesMilestonesAndRecords(t *testing.T) {
+	goals := newMemoryGoalStore()
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 100, Trajectory: Trajectory{{Date: 0, Value: 0}}},
+	}})
+
+	if err := goals.SetGoalValue("alice", "fitness", "run", 50, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := goals.readObjective("alice", "fitness")
+	if g := o.Goals["run"]; g.Milestone != 50 || g.Records.BestDay == nil {
+		t.Errorf("goal was %+v; wanted milestone 50 and best day", g)
+	}
+}
+
+func TestIncrementGoalValueHandlerWithoutValues(t *testing.T) {
+	s, goals := newMemoryServer()
+	body := `{"objective": "fitness", "goal": "run", "delta": 3}`
+	r := httptest.NewRequest(http.MethodPost, "/incrementgoalvalue", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.incrementGoalValue(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if tr := o.Goals["run"].Trajectory; len(tr) != 1 || tr[0].Value != 3 {
+		t.Errorf("trajectory was %+v; wanted 3 km", tr)
+	}
+}
+
+func TestIncrementGoalValueHandlerUnknownGoal(t *testing.T) {
+	s, _ := newMemoryServer()
+	body := `{"objective": "fitness", "goal": "swim", "delta": 1}`
+	r := httptest.NewRequest(http.MethodPost, "/incrementgoalvalue", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.incrementGoalValue(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status was %d; wanted 404", w.Code)
+	}
+}
+
+func TestSetGoalValueHandlerLongIdempotencyKey(t *testing.T) {
+	s, goals := newMemoryServer()
+	body := `{"objective": "fitness", "goal": "run", "value": 5}`
+	r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	r.Header.Set(IdempotencyKeyHeader, strings.Repeat("k", maxIdempotencyKey+1))
+	w := httptest.NewRecorder()
+
+	s.setGoalValue(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status was %d; wanted 400", w.Code)
+	}
+	if o, _ := goals.readObjective("alice", "fitness"); len(o.Goals["run"].Trajectory) != 0 {
+		t.Errorf("value was added despite the invalid key")
+	}
+}
+
+func TestListGoalsHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	goals.PutObjective("alice", "reading", Objective{Name: "Reading", Goals: map[string]Goal{
+		"books": {Name: "Books", Target: 12, Trajectory: Trajectory{{Date: 1, Value: 3}}},
+	}})
+	r := httptest.NewRequest(http.MethodGet, "/users/alice/goals", nil)
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.users(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var got []GoalEntry
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Objective != "fitness" || got[0].ID != "run" || got[1].ID != "books" {
+		t.Fatalf("goals were %+v; wanted fitness/run and reading/books", got)
+	}
+	if got[0].Current != nil {
+		t.Errorf("current value of run was %v; wanted none", *got[0].Current)
+	}
+	if got[1].Current == nil || *got[1].Current != 3 {
+		t.Errorf("current value of books was %v; wanted 3", got[1].Current)
+	}
+}
+
+func TestBatchSetGoalValuesHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	body := `{"updates": [
+		{"objective": "fitness", "goal": "run", "value": 3},
+		{"objective": "fitness", "goal": "swim", "value": 1},
+		{"objective": "reading", "goal": "books", "value": 2},
+		{"objective": "fitness", "goal": "run", "value": 4000, "unit": "m"}
+	]}`
+	r := httptest.NewRequest(http.MethodPost, "/batchsetgoalvalues", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.batchSetGoalValues(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Results []BatchResult
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{http.StatusNoContent, http.StatusNotFound, http.StatusNotFound, http.StatusNoContent}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("results were %+v; wanted %d", resp.Results, len(want))
+	}
+	for i, status := range want {
+		if resp.Results[i].Status != status {
+			t.Errorf("result %d was %+v; wanted status %d", i, resp.Results[i], status)
+		}
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if tr := o.Goals["run"].Trajectory; len(tr) != 2 || tr[0].Value != 3 || tr[1].Value != 4 {
+		t.Errorf("trajectory was %+v; wanted 3 km and 4 km", tr)
+	}
+}
+
+func TestIncrementGoalValueIfStaleReplaysResult(t *testing.T) {
+	s, goals := newMemoryServer()
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/incrementgoalvalueifstale", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer a.alice.c")
+		r.Header.Set(IdempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		s.incrementGoalValueIfStale(w, r)
+		return w
+	}
+	recent := DateValue{Date: time.Now().UnixNano() / 1000 / 1000, Value: 1}
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 100, Unit: "km", Trajectory: []DateValue{recent}},
+	}})
+	body := `{"objective": "fitness", "goal": "run", "delta": 1, "maxAge": 3600}`
+
+	if w := post(body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"incremented":false`) {
+		t.Fatalf("first request got %d: %s; wanted not incremented", w.Code, w.Body)
+	}
+	// The replay returns the first result even though the goal is stale
+	// by now, and does not change the goal.
+	old := DateValue{Date: recent.Date - 2*3600*1000, Value: 1}
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 100, Unit: "km", Trajectory: []DateValue{old}},
+	}})
+	if w := post(body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"incremented":false`) {
+		t.Errorf("replay got %d: %s; wanted not incremented", w.Code, w.Body)
+	}
+	if o, _ := goals.readObjective("alice", "fitness"); len(o.Goals["run"].Trajectory) != 1 {
+		t.Errorf("replay changed the trajectory to %+v", o.Goals["run"].Trajectory)
+	}
+
+	if w := post(`{"objective": "fitness", "goal": "run", "delta": 2, "maxAge": 3600}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another delta got %d: %s; wanted 422", w.Code, w.Body)
+	}
+}
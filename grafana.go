@@ -0,0 +1,130 @@
+package pursuit
+
+import (
+	"sort"
+	"time"
+)
+
+// The Grafana types follow the protocol of the SimpleJSON data source,
+// see https://github.com/grafana/simple-json-datasource. Targets are goal
+// IDs.
+
+// GrafanaTarget is a goal that can be queried.
+type GrafanaTarget struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// GrafanaRange is the time range of a dashboard.
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaQuery asks for the trajectories of goals within a range.
+type GrafanaQuery struct {
+	Range   GrafanaRange         `json:"range"`
+	Targets []GrafanaQueryTarget `json:"targets"`
+}
+
+// GrafanaQueryTarget names a goal to query.
+type GrafanaQueryTarget struct {
+	Target string `json:"target"`
+}
+
+// GrafanaSeries is the trajectory of a goal. Each data point is a value
+// and a date in milliseconds since the epoch.
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaAnnotationQuery asks for annotations within a range. The query
+// of the annotation may name a goal to only annotate that goal.
+type GrafanaAnnotationQuery struct {
+	Range      GrafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// GrafanaAnnotation marks the start or end of a goal.
+type GrafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	Title      string      `json:"title"`
+	Tags       []string    `json:"tags"`
+}
+
+// grafanaSearch lists the goals of the objective, ordered by name.
+func grafanaSearch(o Objective) []GrafanaTarget {
+	targets := make([]GrafanaTarget, 0, len(o.Goals))
+	for id, g := range o.Goals {
+		targets = append(targets, GrafanaTarget{grafanaName(id, g), id})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Text != targets[j].Text {
+			return targets[i].Text < targets[j].Text
+		}
+		return targets[i].Value < targets[j].Value
+	})
+	return targets
+}
+
+// grafanaQuery returns the points of the trajectories of the targeted
+// goals within the range. Unknown goals have no points.
+func grafanaQuery(o Objective, q GrafanaQuery) []GrafanaSeries {
+	from := q.Range.From.UnixNano() / 1000 / 1000
+	to := q.Range.To.UnixNano() / 1000 / 1000
+	series := make([]GrafanaSeries, 0, len(q.Targets))
+	for _, t := range q.Targets {
+		s := GrafanaSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		if g, ok := o.Goals[t.Target]; ok {
+			s.Target = grafanaName(t.Target, g)
+			for _, p := range g.Trajectory {
+				if p.Date >= from && p.Date <= to {
+					s.Datapoints = append(s.Datapoints, [2]float64{float64(p.Value), float64(p.Date)})
+				}
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// grafanaAnnotations marks the starts and ends of goals within the range.
+func grafanaAnnotations(o Objective, q GrafanaAnnotationQuery) []GrafanaAnnotation {
+	from := q.Range.From.UnixNano() / 1000 / 1000
+	to := q.Range.To.UnixNano() / 1000 / 1000
+	annotations := []GrafanaAnnotation{}
+	for _, t := range grafanaSearch(o) {
+		if q.Annotation.Query != "" && q.Annotation.Query != t.Value {
+			continue
+		}
+		g := o.Goals[t.Value]
+		for _, a := range []struct {
+			date  int64
+			title string
+		}{{g.Start, "Start of " + t.Text}, {g.End, "End of " + t.Text}} {
+			if a.date == 0 || a.date < from || a.date > to {
+				continue
+			}
+			annotations = append(annotations, GrafanaAnnotation{
+				Annotation: q.Annotation,
+				Time:       a.date,
+				Title:      a.title,
+				Tags:       []string{t.Value},
+			})
+		}
+	}
+	return annotations
+}
+
+// grafanaName is the name under which a goal is shown in Grafana.
+func grafanaName(goalID string, g Goal) string {
+	if g.Name == "" {
+		return goalID
+	}
+	return g.Name
+}
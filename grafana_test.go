@@ -0,0 +1,70 @@
+package pursuit
+
+import (
+	"testing"
+	"time"
+)
+
+func grafanaTestObjective() Objective {
+	return Objective{Goals: map[string]Goal{
+		"b": {Name: "Swim", Start: 1000, End: 5000, Trajectory: Trajectory{{Date: 1000, Value: 0}, {Date: 2000, Value: 1}, {Date: 4000, Value: 3}}},
+		"a": {Name: "Run", Start: 0, End: 9000, Trajectory: Trajectory{{Date: 0, Value: 0}}},
+		"c": {},
+	}}
+}
+
+func grafanaTestRange(from, to int64) GrafanaRange {
+	return GrafanaRange{
+		From: time.Unix(0, from*int64(time.Millisecond)),
+		To:   time.Unix(0, to*int64(time.Millisecond)),
+	}
+}
+
+func TestGrafanaSearch(t *testing.T) {
+	targets := grafanaSearch(grafanaTestObjective())
+
+	want := []GrafanaTarget{{"Run", "a"}, {"Swim", "b"}, {"c", "c"}}
+	if len(targets) != len(want) {
+		t.Fatalf("targets were %v; wanted %v", targets, want)
+	}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("target %d was %v; wanted %v", i, targets[i], want[i])
+		}
+	}
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	q := GrafanaQuery{
+		Range:   grafanaTestRange(1500, 4000),
+		Targets: []GrafanaQueryTarget{{"b"}, {"x"}},
+	}
+
+	series := grafanaQuery(grafanaTestObjective(), q)
+
+	if len(series) != 2 {
+		t.Fatalf("got %d series; wanted 2", len(series))
+	}
+	if series[0].Target != "Swim" || len(series[0].Datapoints) != 2 ||
+		series[0].Datapoints[0] != [2]float64{1, 2000} || series[0].Datapoints[1] != [2]float64{3, 4000} {
+		t.Errorf("series was %+v", series[0])
+	}
+	if series[1].Target != "x" || len(series[1].Datapoints) != 0 {
+		t.Errorf("series of unknown goal was %+v", series[1])
+	}
+}
+
+func TestGrafanaAnnotations(t *testing.T) {
+	q := GrafanaAnnotationQuery{Range: grafanaTestRange(500, 6000)}
+
+	annotations := grafanaAnnotations(grafanaTestObjective(), q)
+
+	if len(annotations) != 2 || annotations[0].Title != "Start of Swim" || annotations[1].Title != "End of Swim" {
+		t.Errorf("annotations were %+v", annotations)
+	}
+
+	q.Annotation.Query = "a"
+	if annotations := grafanaAnnotations(grafanaTestObjective(), q); len(annotations) != 0 {
+		t.Errorf("annotations of goal a were %+v; wanted none in range", annotations)
+	}
+}
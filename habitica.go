@@ -0,0 +1,121 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// NewHabiticaImporter creates an importer for the habits of a user of
+// Habitica, see https://habitica.com/apidoc/. It is an ImporterFactory
+// that takes the configs "user" and "key", the user ID and the API token
+// of the user. Metrics are the aliases of habits, or their IDs for habits
+// without an alias.
+//
+// Habitica keeps the number of times that a habit was scored up per day.
+// Days are imported once they are over, as increments of the goals by
+// that number. Exports score the habit up once for each increase of a
+// goal.
+func NewHabiticaImporter(config map[string]string) (Importer, error) {
+	if config["user"] == "" || config["key"] == "" {
+		return nil, fmt.Errorf("Missing Habitica user or key: %w", ErrInvalidValue)
+	}
+	return &habiticaImporter{
+		user:   config["user"],
+		key:    config["key"],
+		client: &http.Client{Timeout: 10 * time.Second},
+		apiURL: "https://habitica.com/api/v3",
+		now:    time.Now,
+	}, nil
+}
+
+type habiticaImporter struct {
+	user   string
+	key    string
+	client *http.Client
+	apiURL string
+	now    func() time.Time
+}
+
+// do sends a request to the API of Habitica and decodes the data of the
+// response into v, unless it is nil.
+func (h *habiticaImporter) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, h.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-api-user", h.user)
+	req.Header.Set("x-api-key", h.key)
+	// Habitica asks third-party tools to identify themselves.
+	req.Header.Set("x-client", h.user+"-pursuit")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error calling Habitica: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Error calling Habitica: %s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	body := struct {
+		Data interface{} `json:"data"`
+	}{v}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("Error reading response of Habitica: %v", err)
+	}
+	return nil
+}
+
+func (h *habiticaImporter) Fetch(since int64) ([]Measurement, error) {
+	var habits []struct {
+		ID      string `json:"id"`
+		Alias   string `json:"alias"`
+		History []struct {
+			// Date in milliseconds since the epoch.
+			Date     int64 `json:"date"`
+			ScoredUp int   `json:"scoredUp"`
+		} `json:"history"`
+	}
+	if err := h.do(http.MethodGet, "/tasks/user?type=habits", &habits); err != nil {
+		return nil, err
+	}
+	// Scores of today are still changing.
+	today := h.now().UTC().Truncate(24*time.Hour).UnixNano() / 1000 / 1000
+	var measurements []Measurement
+	for _, habit := range habits {
+		metric := habit.Alias
+		if metric == "" {
+			metric = habit.ID
+		}
+		for _, day := range habit.History {
+			if day.Date < since || day.Date >= today || day.ScoredUp == 0 {
+				continue
+			}
+			measurements = append(measurements, Measurement{
+				ID:        habit.ID + "/" + strconv.FormatInt(day.Date, 10),
+				Metric:    metric,
+				Date:      day.Date,
+				Value:     float32(day.ScoredUp),
+				Increment: true,
+			})
+		}
+	}
+	return measurements, nil
+}
+
+func (h *habiticaImporter) Export(measurements []Measurement) (int, error) {
+	for i, m := range measurements {
+		if m.Value <= 0 {
+			continue
+		}
+		if err := h.do(http.MethodPost, "/tasks/"+url.PathEscape(m.Metric)+"/score/up", nil); err != nil {
+			return i, err
+		}
+	}
+	return len(measurements), nil
+}
@@ -0,0 +1,89 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestHabiticaImporter(t *testing.T, apiURL string) *habiticaImporter {
+	imp, err := NewHabiticaImporter(map[string]string{"user": "u", "key": "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi := imp.(*habiticaImporter)
+	hi.apiURL = apiURL
+	hi.now = func() time.Time { return time.Unix(0, 3*24*int64(time.Hour)+int64(time.Hour)) }
+	return hi
+}
+
+func TestHabiticaFetch(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-user") != "u" || r.Header.Get("x-api-key") != "k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/tasks/user" || r.URL.Query().Get("type") != "habits" {
+			t.Errorf("request was %s", r.URL)
+		}
+		w.Write([]byte(`{"success": true, "data": [
+			{"id": "h1", "alias": "floss", "history": [
+				{"date": 0, "scoredUp": 1},
+				{"date": 86400000, "scoredUp": 2},
+				{"date": 172800000, "scoredUp": 0},
+				{"date": 259200000, "scoredUp": 1}
+			]},
+			{"id": "h2", "history": [{"date": 172800001, "scoredUp": 3}]}
+		]}`))
+	}))
+	defer srv.Close()
+	h := newTestHabiticaImporter(t, srv.URL)
+
+	got, err := h.Fetch(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Today, the fourth day, is not over yet.
+	want := []Measurement{
+		{ID: "h1/86400000", Metric: "floss", Date: day, Value: 2, Increment: true},
+		{ID: "h2/172800001", Metric: "h2", Date: 2*day + 1, Value: 3, Increment: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("measurements were %+v; wanted %+v", got, want)
+	}
+}
+
+func TestHabiticaExport(t *testing.T) {
+	var scored []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method was %s", r.Method)
+		}
+		if r.URL.Path == "/tasks/broken/score/up" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		scored = append(scored, r.URL.Path)
+		w.Write([]byte(`{"success": true, "data": {}}`))
+	}))
+	defer srv.Close()
+	h := newTestHabiticaImporter(t, srv.URL)
+
+	n, err := h.Export([]Measurement{
+		{Metric: "floss", Value: 1},
+		{Metric: "floss", Value: -1},
+		{Metric: "broken", Value: 1},
+		{Metric: "floss", Value: 1},
+	})
+
+	if err == nil || n != 2 {
+		t.Errorf("Export returned %d, %v; wanted 2 and an error", n, err)
+	}
+	if want := []string{"/tasks/floss/score/up"}; !reflect.DeepEqual(scored, want) {
+		t.Errorf("scored %v; wanted %v", scored, want)
+	}
+}
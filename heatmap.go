@@ -0,0 +1,82 @@
+package pursuit
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Heatmap is the activity on a goal per day of a year, as in a calendar
+// heatmap.
+type Heatmap struct {
+	Year int          `json:"year"`
+	Days []HeatmapDay `json:"days"`
+	// Max is the highest value of a day.
+	Max float32 `json:"max"`
+}
+
+// HeatmapDay is the activity on a goal on a day.
+type HeatmapDay struct {
+	// Date in the time zone of the heatmap, such as 2025-01-31.
+	Date  string  `json:"date"`
+	Value float32 `json:"value"`
+	// Level from 0 to 4 ranks the value among the days with activity: 0
+	// means none, and 1 to 4 are the quartiles of the other days.
+	Level int `json:"level"`
+}
+
+// NewHeatmap computes the activity on a goal on each day of a year in
+// the time zone. For goals with the latest aggregation, which accumulate
+// values, the activity of a day is how much the value changed on that
+// day, in either direction. For other goals, whose values are separate
+// measurements, it is the number of values of that day.
+func NewHeatmap(g Goal, year int, loc *time.Location) Heatmap {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	n := time.Date(year, time.December, 31, 0, 0, 0, 0, loc).YearDay()
+	values := make([]float32, n)
+	for i, p := range g.Trajectory {
+		t := time.Unix(0, p.Date*int64(time.Millisecond)).In(loc)
+		if t.Year() != year {
+			continue
+		}
+		day := t.YearDay() - 1
+		switch {
+		case g.Aggregation != "" && g.Aggregation != AggregationLatest:
+			values[day]++
+		case i > 0:
+			values[day] += float32(math.Abs(float64(p.Value - g.Trajectory[i-1].Value)))
+		}
+	}
+
+	var active []float32
+	for _, v := range values {
+		if v > 0 {
+			active = append(active, v)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i] < active[j] })
+	var quartiles []float32
+	if len(active) > 0 {
+		for _, q := range []int{1, 2, 3} {
+			quartiles = append(quartiles, active[(len(active)-1)*q/4])
+		}
+	}
+
+	h := Heatmap{Year: year, Days: make([]HeatmapDay, n)}
+	for i, v := range values {
+		d := HeatmapDay{Date: start.AddDate(0, 0, i).Format("2006-01-02"), Value: float32(roundTo(v, 2))}
+		if v > 0 {
+			d.Level = 1
+			for _, q := range quartiles {
+				if v > q {
+					d.Level++
+				}
+			}
+		}
+		if d.Value > h.Max {
+			h.Max = d.Value
+		}
+		h.Days[i] = d
+	}
+	return h
+}
@@ -0,0 +1,58 @@
+package pursuit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewHeatmap(t *testing.T) {
+	jan := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC).UnixNano() / 1000 / 1000
+	g := Goal{Trajectory: Trajectory{
+		{Date: jan - 365*day, Value: 0},
+		{Date: jan, Value: 1},
+		{Date: jan + 1, Value: 3},
+		{Date: jan + day, Value: 2},
+		{Date: jan + 2*day, Value: 12},
+		{Date: jan + 59*day, Value: 16},
+	}}
+
+	h := NewHeatmap(g, 2024, time.UTC)
+
+	if len(h.Days) != 366 || h.Days[0].Date != "2024-01-01" || h.Days[365].Date != "2024-12-31" {
+		t.Fatalf("heatmap had %d days from %s", len(h.Days), h.Days[0].Date)
+	}
+	for i, want := range []HeatmapDay{
+		{"2024-01-01", 3, 2},
+		{"2024-01-02", 1, 1},
+		{"2024-01-03", 10, 4},
+		{"2024-01-04", 0, 0},
+	} {
+		if h.Days[i] != want {
+			t.Errorf("day %d was %+v; wanted %+v", i, h.Days[i], want)
+		}
+	}
+	if d := h.Days[59]; d.Date != "2024-02-29" || d.Value != 4 || d.Level != 3 {
+		t.Errorf("leap day was %+v", d)
+	}
+	if h.Max != 10 {
+		t.Errorf("max was %v; wanted 10", h.Max)
+	}
+}
+
+func TestNewHeatmapCountsMeasurements(t *testing.T) {
+	jan := time.Date(2025, time.January, 1, 23, 30, 0, 0, time.UTC).UnixNano() / 1000 / 1000
+	g := Goal{Aggregation: AggregationMaximum, Trajectory: Trajectory{
+		{Date: jan, Value: 50},
+		{Date: jan + 1, Value: 40},
+	}}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	h := NewHeatmap(g, 2025, berlin)
+
+	if len(h.Days) != 365 || h.Days[0].Value != 0 || h.Days[1] != (HeatmapDay{"2025-01-02", 2, 1}) {
+		t.Errorf("days were %+v", h.Days[:2])
+	}
+}
@@ -0,0 +1,82 @@
+package pursuit
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Timeouts of the HTTP server. There is no write timeout, since
+// subscriptions hold responses open for a long time.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+)
+
+// maxConcurrentStreams limits the requests in flight on one HTTP/2
+// connection, which also bounds the subscriptions per connection.
+const maxConcurrentStreams = 250
+
+// connectionStats counts the connections of the HTTP server, and is
+// served with the other expvars under /debug/vars. HTTP/2 connections
+// are taken over from net/http and are counted as hijacked.
+var connectionStats = expvar.NewMap("connections")
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// NewHTTPServer returns a server for the handler on the address. It
+// speaks HTTP/2 without TLS (h2c), as Cloud Run terminates TLS, as well
+// as HTTP/1.1 with keep-alives.
+func NewHTTPServer(addr string, handler http.Handler) *http.Server {
+	h2 := &http2.Server{
+		MaxConcurrentStreams: maxConcurrentStreams,
+		IdleTimeout:          idleTimeout,
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h2c.NewHandler(handler, h2),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+		ConnState:         newConnTracker().track,
+	}
+}
+
+// connTracker keeps the number of open connections by state.
+type connTracker struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{states: map[net.Conn]http.ConnState{}}
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.states[c]; ok {
+		connectionStats.Add(prev.String(), -1)
+	}
+	switch state {
+	case http.StateNew:
+		connectionStats.Add("accepted", 1)
+	case http.StateHijacked:
+		connectionStats.Add("hijacked", 1)
+	}
+	if state == http.StateHijacked || state == http.StateClosed {
+		delete(t.states, c)
+		return
+	}
+	t.states[c] = state
+	connectionStats.Add(state.String(), 1)
+}
@@ -0,0 +1,62 @@
+package pursuit
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHTTPServerSpeaksH2C(t *testing.T) {
+	srv := NewHTTPServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("protocol was %s; wanted HTTP/2", resp.Proto)
+	}
+}
+
+func TestConnTracker(t *testing.T) {
+	tracker := newConnTracker()
+	c, _ := net.Pipe()
+	open := func(state string) int64 {
+		if v, ok := connectionStats.Get(state).(interface{ Value() int64 }); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := open("active")
+
+	tracker.track(c, http.StateNew)
+	tracker.track(c, http.StateActive)
+	if got := open("active") - before; got != 1 {
+		t.Errorf("active connections changed by %d; wanted 1", got)
+	}
+	tracker.track(c, http.StateClosed)
+	if got := open("active") - before; got != 0 {
+		t.Errorf("active connections changed by %d after close; wanted 0", got)
+	}
+	if len(tracker.states) != 0 {
+		t.Errorf("closed connection is still tracked")
+	}
+}
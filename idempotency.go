@@ -0,0 +1,123 @@
+package pursuit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// IdempotencyKeyHeader carries a key chosen by the client that identifies
+// a change, so that a retried request is applied only once.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrKeyReused is wrapped by errors about idempotency keys that were
+// already used for a different change.
+var ErrKeyReused = errors.New("Idempotency key reused")
+
+// maxIdempotencyKey bounds the length of idempotency keys.
+const maxIdempotencyKey = 255
+
+// idempotencyRetention is how long idempotency keys are remembered.
+// Firestore deletes expired keys through a TTL policy on expireAt, see
+// firestore.indexes.json.
+const idempotencyRetention = 24 * time.Hour
+
+// idempotencyRecord remembers a change that was made with an idempotency
+// key. Records are stored in users/{user}/idempotency/{id}, where the ID
+// is the SHA-256 hash of the key.
+type idempotencyRecord struct {
+	// Request identifies the operation and the goal that the key was used
+	// for, so that a key cannot be reused for a different change.
+	Request string `firestore:"request"`
+	// Payload is the hash of the arguments of the change, so that a key
+	// cannot be reused for the same change with other values.
+	Payload string `firestore:"payload"`
+	// Changed is the result that the change reported, e.g. whether a
+	// conditional increment incremented the value.
+	Changed  bool      `firestore:"changed"`
+	ExpireAt time.Time `firestore:"expireAt"`
+}
+
+// replayedError is returned by updateGoal for a change whose idempotency
+// key was already used for the same change.
+type replayedError struct {
+	changed bool
+}
+
+func (e *replayedError) Error() string {
+	return "Change was already applied"
+}
+
+// idempotencyPayload returns the hash of the arguments of a change, see
+// idempotencyRecord. Arguments that are not valid JSON, such as NaN, are
+// hashed as printed.
+func idempotencyPayload(args ...interface{}) string {
+	b, err := json.Marshal(args)
+	if err != nil {
+		b = []byte(fmt.Sprint(args...))
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// check returns an error wrapping ErrKeyReused unless the record is of
+// the same change.
+func (r idempotencyRecord) check(request, payload string) error {
+	if r.Request != request {
+		return fmt.Errorf("Idempotency key was used for another change: %w", ErrKeyReused)
+	}
+	if r.Payload != payload {
+		return fmt.Errorf("Idempotency key was used for the same change with other values: %w", ErrKeyReused)
+	}
+	return nil
+}
+
+// WithIdempotencyKey returns a copy of the storage that applies changes of
+// goals only once per key: a change with a key that was used for the same
+// change before succeeds without changing the goal again, and a change
+// with a key that was used for another change is rejected. Keys are
+// remembered per user for a day.
+func (s Storage) WithIdempotencyKey(key string) *Storage {
+	s.idempotencyKey = key
+	return &s
+}
+
+func (s Storage) withIdempotencyKey(key string) goalStore {
+	return s.WithIdempotencyKey(key)
+}
+
+// readIdempotencyKey reads the record of the idempotency key of the
+// storage within a transaction. It returns a nil record if the key was
+// not used yet, and a nil reference if the storage has no key.
+func (s Storage) readIdempotencyKey(tx *firestore.Transaction, userID, request, payload string) (*firestore.DocumentRef, *idempotencyRecord, error) {
+	if s.idempotencyKey == "" {
+		return nil, nil, nil
+	}
+	ref := s.collection("users").Doc(userID).Collection("idempotency").Doc(tokenID(s.idempotencyKey))
+	doc, err := tx.Get(ref)
+	if doc != nil && !doc.Exists() {
+		return ref, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading idempotency key: %w", err)
+	}
+	var r idempotencyRecord
+	if err := doc.DataTo(&r); err != nil {
+		return nil, nil, fmt.Errorf("Error reading idempotency key: %w", err)
+	}
+	if err := r.check(request, payload); err != nil {
+		return nil, nil, err
+	}
+	return ref, &r, nil
+}
+
+// withIdempotencyKey returns a view of the store that applies changes of
+// goals once per key, as Storage does.
+func (m *memoryGoalStore) withIdempotencyKey(key string) goalStore {
+	return &memoryGoalStore{memoryGoals: m.memoryGoals, key: key}
+}
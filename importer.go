@@ -0,0 +1,476 @@
+package pursuit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Measurement is a value reported by a data source, such as the steps
+// counted by a fitness tracker on a day.
+type Measurement struct {
+	// ID identifies the measurement within its data source, so that
+	// measurements fetched twice are imported once.
+	ID string `json:"id"`
+	// Metric is the name of the measured quantity in the data source,
+	// which imports map to goals.
+	Metric string `json:"metric"`
+	// Date in milliseconds since the epoch.
+	Date  int64   `json:"date"`
+	Value float32 `json:"value"`
+	// Unit of the value, which may be empty if it is in the unit of the
+	// goal.
+	Unit string `json:"unit,omitempty"`
+	// Increment adds the value to the goal instead of setting it.
+	Increment bool `json:"increment,omitempty"`
+}
+
+// Importer fetches measurements from a data source.
+type Importer interface {
+	// Fetch returns the measurements taken at or after since, in
+	// milliseconds since the epoch.
+	Fetch(since int64) ([]Measurement, error)
+}
+
+// Exporter is implemented by importers whose data source also takes
+// values, so that values flow both ways.
+type Exporter interface {
+	// Export sends measurements to the data source, oldest first. They
+	// are increments: their values are the changes of goals. It returns
+	// how many of them were sent, so that those that were not are sent
+	// again by the next run.
+	Export(measurements []Measurement) (int, error)
+}
+
+// ImporterFactory creates an importer from the configuration of an
+// import, such as the credentials for the data source.
+type ImporterFactory func(config map[string]string) (Importer, error)
+
+var importers = struct {
+	sync.Mutex
+	m map[string]ImporterFactory
+}{m: map[string]ImporterFactory{}}
+
+// RegisterImporter makes a data source available to imports under the
+// given name. It panics if the name is registered twice.
+func RegisterImporter(source string, f ImporterFactory) {
+	importers.Lock()
+	defer importers.Unlock()
+	if _, ok := importers.m[source]; ok {
+		panic("pursuit: importer registered twice: " + source)
+	}
+	importers.m[source] = f
+}
+
+// importerSources returns the names of the registered data sources in
+// alphabetical order.
+func importerSources() []string {
+	importers.Lock()
+	defer importers.Unlock()
+	sources := make([]string, 0, len(importers.m))
+	for source := range importers.m {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func lookupImporter(source string) (ImporterFactory, bool) {
+	importers.Lock()
+	defer importers.Unlock()
+	f, ok := importers.m[source]
+	return f, ok
+}
+
+// ExecImporter returns a factory for importers that run an executable,
+// so that data sources can be added without rebuilding the server. The
+// executable is called with the since date in milliseconds as its only
+// argument and the configuration of the import as a JSON object on
+// standard input. It prints one measurement per line as JSON objects with
+// the fields id, metric, date, value and optionally unit and increment.
+func ExecImporter(path string) ImporterFactory {
+	return func(config map[string]string) (Importer, error) {
+		return execImporter{path, config}, nil
+	}
+}
+
+type execImporter struct {
+	path   string
+	config map[string]string
+}
+
+func (e execImporter) Fetch(since int64) ([]Measurement, error) {
+	stdin, err := json.Marshal(e.config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.path, strconv.FormatInt(since, 10))
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error running %s: %v: %s", e.path, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	var measurements []Measurement
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var m Measurement
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, fmt.Errorf("Invalid measurement from %s: %q: %v", e.path, line, err)
+		}
+		measurements = append(measurements, m)
+	}
+	return measurements, scanner.Err()
+}
+
+// ImportTarget is the goal that the measurements of a metric are
+// imported into.
+type ImportTarget struct {
+	Objective string `firestore:"objective" json:"objective"`
+	Goal      string `firestore:"goal" json:"goal"`
+}
+
+// Import for Firestore serialization/deserialization. An import
+// periodically fetches measurements from a data source and adds them to
+// the trajectories of goals. Imports are stored in users/{user}/imports.
+type Import struct {
+	// Source is the name of a registered importer.
+	Source string            `firestore:"source" json:"source"`
+	Config map[string]string `firestore:"config" json:"-"`
+	// Goals maps the metrics of the data source to goals. Measurements of
+	// other metrics are ignored.
+	Goals map[string]ImportTarget `firestore:"goals" json:"goals"`
+	// Exports maps metrics of the data source to goals whose new values
+	// are sent to them, if the importer is an Exporter. Values that were
+	// imported from the data source are not sent back. A metric cannot be
+	// both imported and exported, as its exported values would be
+	// imported again. Exported is the date of the latest exported value.
+	Exports  map[string]ImportTarget `firestore:"exports,omitempty" json:"exports,omitempty"`
+	Exported int64                   `firestore:"exported,omitempty" json:"exported,omitempty"`
+	// Imported is the date of the latest imported measurement, in
+	// milliseconds since the epoch, and Seen holds the IDs of the
+	// measurements imported at that date.
+	Imported int64    `firestore:"imported" json:"imported"`
+	Seen     []string `firestore:"seen" json:"seen"`
+	// Count is the number of measurements imported so far, and Synced the
+	// date of the latest successful run.
+	Count  int64 `firestore:"count" json:"count"`
+	Synced int64 `firestore:"synced" json:"synced"`
+	// Error describes why the latest run failed, and is empty if it
+	// succeeded. Failed is the date of that run.
+	Error  string `firestore:"error" json:"error"`
+	Failed int64  `firestore:"failed" json:"failed"`
+}
+
+// ImportEntry is an import together with the user that configured it.
+type ImportEntry struct {
+	User string `json:"user"`
+	ID   string `json:"id"`
+	Import
+}
+
+// newMeasurements returns the measurements that were not imported yet,
+// oldest first.
+func (imp Import) newMeasurements(measurements []Measurement) []Measurement {
+	seen := make(map[string]bool, len(imp.Seen))
+	for _, id := range imp.Seen {
+		seen[id] = true
+	}
+	var fresh []Measurement
+	for _, m := range measurements {
+		if m.Date < imp.Imported || seen[m.ID] {
+			continue
+		}
+		seen[m.ID] = true
+		fresh = append(fresh, m)
+	}
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].Date < fresh[j].Date
+	})
+	return fresh
+}
+
+// advance moves the cursor of the import past the measurement.
+func (imp *Import) advance(m Measurement) {
+	if m.Date > imp.Imported {
+		imp.Imported = m.Date
+		imp.Seen = nil
+	}
+	imp.Seen = append(imp.Seen, m.ID)
+}
+
+// ListImports returns the imports configured by all users.
+func (s Storage) ListImports() ([]ImportEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListImports", func(ctx context.Context) (err error) {
+		docs, err = s.client.CollectionGroup("imports").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing imports: %w", err)
+	}
+	imports := make([]ImportEntry, 0, len(docs))
+	for _, doc := range docs {
+		if !s.inNamespace(doc.Ref) {
+			continue
+		}
+		var imp Import
+		if err := doc.DataTo(&imp); err != nil {
+			return nil, fmt.Errorf("Error reading import %q: %w", doc.Ref.ID, err)
+		}
+		imports = append(imports, ImportEntry{doc.Ref.Parent.Parent.ID, doc.Ref.ID, imp})
+	}
+	return imports, nil
+}
+
+// RunImport fetches the measurements of the import since the previous
+// run and adds them to the mapped goals, as of now, according to their
+// source policies. It stops at the first
+// measurement that cannot be added, so that it is retried by the next
+// run, and records the error on the import. It returns the number of
+// imported measurements.
+func (s Storage) RunImport(e ImportEntry) (int, error) {
+	return s.syncImport(&e)
+}
+
+// syncImport runs the import and updates it with the outcome.
+func (s Storage) syncImport(e *ImportEntry) (int, error) {
+	n, err := s.runImport(e.User, &e.Import)
+	if err == nil {
+		err = s.runExport(e.User, &e.Import)
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	e.Count += int64(n)
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+		e.Failed = now
+	} else {
+		e.Synced = now
+	}
+	ref := s.collection("users").Doc(e.User).Collection("imports").Doc(e.ID)
+	uerr := s.do("RunImport", func(ctx context.Context) error {
+		_, err := ref.Update(ctx, []firestore.Update{
+			{Path: "imported", Value: e.Imported},
+			{Path: "seen", Value: e.Seen},
+			{Path: "exported", Value: e.Exported},
+			{Path: "count", Value: e.Count},
+			{Path: "synced", Value: e.Synced},
+			{Path: "error", Value: e.Error},
+			{Path: "failed", Value: e.Failed},
+		})
+		return err
+	})
+	if err == nil && uerr != nil {
+		err = fmt.Errorf("Error updating import: %w", uerr)
+	}
+	return n, err
+}
+
+// ImportStatus shows how an import is doing, so that users can find out
+// why data stopped flowing. Dates are in milliseconds since the epoch.
+type ImportStatus struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	// Synced is the date of the latest successful run.
+	Synced int64 `json:"synced"`
+	// Count is the number of measurements imported so far.
+	Count int64 `json:"count"`
+	// Error describes why the latest run failed, if it did. Failed is the
+	// date of that run.
+	Error  string `json:"error,omitempty"`
+	Failed int64  `json:"failed,omitempty"`
+	// Next is the date of the next scheduled run.
+	Next int64 `json:"next"`
+}
+
+// importInterval is how often /tasks/import runs. The Cloud Scheduler job
+// is expected to trigger it at multiples of the interval, e.g. with the
+// schedule "*/15 * * * *".
+const importInterval = 15 * time.Minute
+
+func (e ImportEntry) status(now time.Time) ImportStatus {
+	return ImportStatus{
+		ID:     e.ID,
+		Source: e.Source,
+		Synced: e.Synced,
+		Count:  e.Count,
+		Error:  e.Error,
+		Failed: e.Failed,
+		Next:   now.Truncate(importInterval).Add(importInterval).UnixNano() / 1000 / 1000,
+	}
+}
+
+// ListImportStatus returns the status of the imports of a user. It leaves
+// out their configuration, which may hold credentials.
+func (s Storage) ListImportStatus(userID string) ([]ImportStatus, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListImportStatus", func(ctx context.Context) (err error) {
+		docs, err = s.collection("users").Doc(userID).Collection("imports").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing imports: %w", err)
+	}
+	now := time.Now()
+	statuses := make([]ImportStatus, 0, len(docs))
+	for _, doc := range docs {
+		var imp Import
+		if err := doc.DataTo(&imp); err != nil {
+			return nil, fmt.Errorf("Error reading import %q: %w", doc.Ref.ID, err)
+		}
+		statuses = append(statuses, ImportEntry{userID, doc.Ref.ID, imp}.status(now))
+	}
+	return statuses, nil
+}
+
+// SyncImport runs an import of a user right away and returns its status.
+// A failed run is reported in the status rather than as an error.
+func (s Storage) SyncImport(userID, importID string) (ImportStatus, error) {
+	ref := s.collection("users").Doc(userID).Collection("imports").Doc(importID)
+	var imp Import
+	err := s.do("SyncImport", func(ctx context.Context) error {
+		doc, err := ref.Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such import: %q: %w", importID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading import: %w", err)
+		}
+		return doc.DataTo(&imp)
+	})
+	if err != nil {
+		return ImportStatus{}, err
+	}
+	e := ImportEntry{userID, importID, imp}
+	if _, err := s.syncImport(&e); err != nil {
+		logf(s.ctx, severityError, "Error importing %s/%s from %s: %v", userID, importID, e.Source, err)
+	}
+	return e.status(time.Now()), nil
+}
+
+func (s Storage) runImport(userID string, imp *Import) (int, error) {
+	factory, ok := lookupImporter(imp.Source)
+	if !ok {
+		return 0, fmt.Errorf("No such data source: %q: %w", imp.Source, ErrNotFound)
+	}
+	importer, err := factory(imp.Config)
+	if err != nil {
+		return 0, err
+	}
+	measurements, err := importer.Fetch(imp.Imported)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range imp.newMeasurements(measurements) {
+		if t, ok := imp.Goals[m.Metric]; ok {
+			_, err = s.ImportGoalValue(userID, t.Objective, t.Goal, imp.Source, m.Value, m.Unit, m.Increment)
+			if err != nil {
+				return n, fmt.Errorf("Error importing %s %q into %s/%s: %w", m.Metric, m.ID, t.Objective, t.Goal, err)
+			}
+			n++
+		}
+		imp.advance(m)
+	}
+	return n, nil
+}
+
+// exportMeasurements returns the changes of the goal after the date as
+// increments of the metric, oldest first. Values of the source are left
+// out, but still count as the previous value of the next change.
+func exportMeasurements(g Goal, metric, source string, after int64) []Measurement {
+	var measurements []Measurement
+	var prev float32
+	for i, v := range g.Trajectory {
+		delta := v.Value - prev
+		prev = v.Value
+		if v.Date <= after || v.Source == source || delta == 0 {
+			continue
+		}
+		measurements = append(measurements, Measurement{
+			ID:        fmt.Sprintf("%s/%d", metric, i),
+			Metric:    metric,
+			Date:      v.Date,
+			Value:     delta,
+			Unit:      g.Unit,
+			Increment: true,
+		})
+	}
+	return measurements
+}
+
+// runExport sends the changes of goals since the previous run to the
+// data source of the import, if it takes values, and moves the cursor of
+// the import past them.
+func (s Storage) runExport(userID string, imp *Import) error {
+	if len(imp.Exports) == 0 {
+		return nil
+	}
+	for metric := range imp.Exports {
+		if _, ok := imp.Goals[metric]; ok {
+			return fmt.Errorf("Metric %q is both imported and exported: %w", metric, ErrInvalidValue)
+		}
+	}
+	// The first run only starts the cursor, so that the values from
+	// before the export was set up are not sent.
+	if imp.Exported == 0 {
+		imp.Exported = time.Now().UnixNano() / 1000 / 1000
+		return nil
+	}
+	factory, ok := lookupImporter(imp.Source)
+	if !ok {
+		return fmt.Errorf("No such data source: %q: %w", imp.Source, ErrNotFound)
+	}
+	importer, err := factory(imp.Config)
+	if err != nil {
+		return err
+	}
+	exporter, ok := importer.(Exporter)
+	if !ok {
+		return fmt.Errorf("Data source %q does not take values: %w", imp.Source, ErrInvalidValue)
+	}
+	objectives := map[string]Objective{}
+	var measurements []Measurement
+	for metric, t := range imp.Exports {
+		o, ok := objectives[t.Objective]
+		if !ok {
+			if o, err = s.readObjective(userID, t.Objective); err != nil {
+				return fmt.Errorf("Error exporting %s/%s to %s: %w", t.Objective, t.Goal, metric, err)
+			}
+			objectives[t.Objective] = o
+		}
+		g, ok := o.Goals[t.Goal]
+		if !ok {
+			return fmt.Errorf("Error exporting %s/%s to %s: No such goal: %w", t.Objective, t.Goal, metric, ErrNotFound)
+		}
+		measurements = append(measurements, exportMeasurements(g, metric, imp.Source, imp.Exported)...)
+	}
+	if len(measurements) == 0 {
+		return nil
+	}
+	sort.SliceStable(measurements, func(i, j int) bool {
+		return measurements[i].Date < measurements[j].Date
+	})
+	n, err := exporter.Export(measurements)
+	if n > 0 {
+		imp.Exported = measurements[n-1].Date
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package pursuit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewMeasurements(t *testing.T) {
+	imp := Import{Imported: 2000, Seen: []string{"b"}}
+	measurements := []Measurement{
+		{ID: "d", Date: 3000},
+		{ID: "a", Date: 1000},
+		{ID: "b", Date: 2000},
+		{ID: "c", Date: 2000},
+		{ID: "c", Date: 2000},
+	}
+
+	var got []string
+	for _, m := range imp.newMeasurements(measurements) {
+		got = append(got, m.ID)
+	}
+
+	if want := []string{"c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("new measurements were %v; wanted %v", got, want)
+	}
+}
+
+func TestImportAdvance(t *testing.T) {
+	imp := Import{Imported: 2000, Seen: []string{"b"}}
+
+	imp.advance(Measurement{ID: "c", Date: 2000})
+	if imp.Imported != 2000 || !reflect.DeepEqual(imp.Seen, []string{"b", "c"}) {
+		t.Errorf("import was %+v after a measurement at the cursor", imp)
+	}
+	imp.advance(Measurement{ID: "d", Date: 3000})
+	if imp.Imported != 3000 || !reflect.DeepEqual(imp.Seen, []string{"d"}) {
+		t.Errorf("import was %+v after a newer measurement", imp)
+	}
+}
+
+func TestRegisterImporterTwice(t *testing.T) {
+	f := func(map[string]string) (Importer, error) { return nil, nil }
+	RegisterImporter("test-twice", f)
+	defer func() {
+		if recover() == nil {
+			t.Error("registering an importer twice did not panic")
+		}
+	}()
+	RegisterImporter("test-twice", f)
+}
+
+func TestExecImporter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir, err := ioutil.TempDir("", "importer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "import")
+	script := `#!/bin/sh
+cat > ` + filepath.Join(dir, "config") + `
+echo '{"id": "a", "metric": "steps", "date": '$1', "value": 2.5}'
+echo
+echo '{"id": "b", "metric": "km", "date": 2000, "value": 1, "unit": "mi", "increment": true}'
+`
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	importer, err := ExecImporter(path)(map[string]string{"token": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := importer.Fetch(1000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Measurement{
+		{ID: "a", Metric: "steps", Date: 1000, Value: 2.5},
+		{ID: "b", Metric: "km", Date: 2000, Value: 1, Unit: "mi", Increment: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("measurements were %+v; wanted %+v", got, want)
+	}
+	config, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != `{"token":"secret"}` {
+		t.Errorf("config was %s", config)
+	}
+}
+
+func TestExecImporterFailure(t *testing.T) {
+	importer, _ := ExecImporter(filepath.Join(os.TempDir(), "no-such-importer"))(nil)
+	if _, err := importer.Fetch(0); err == nil {
+		t.Error("Fetch() succeeded for a missing executable")
+	}
+}
+
+func TestImportStatus(t *testing.T) {
+	e := ImportEntry{"u", "strava", Import{
+		Source: "strava",
+		Config: map[string]string{"token": "secret"},
+		Count:  12,
+		Synced: 1000,
+		Error:  "Error running strava: exit status 1",
+		Failed: 2000,
+	}}
+	now := time.Date(2026, 1, 1, 10, 7, 0, 0, time.UTC)
+
+	got := e.status(now)
+
+	want := ImportStatus{
+		ID:     "strava",
+		Source: "strava",
+		Synced: 1000,
+		Count:  12,
+		Error:  "Error running strava: exit status 1",
+		Failed: 2000,
+		Next:   time.Date(2026, 1, 1, 10, 15, 0, 0, time.UTC).UnixNano() / 1000 / 1000,
+	}
+	if got != want {
+		t.Errorf("status was %+v; wanted %+v", got, want)
+	}
+}
+
+func TestExportMeasurements(t *testing.T) {
+	g := Goal{Unit: "km", Trajectory: Trajectory{
+		{Date: 1000, Value: 5},
+		{Date: 2000, Value: 8},
+		{Date: 3000, Value: 10, Source: "exist"},
+		{Date: 4000, Value: 10},
+		{Date: 5000, Value: 12},
+	}}
+
+	got := exportMeasurements(g, "distance", "exist", 1000)
+
+	want := []Measurement{
+		{ID: "distance/1", Metric: "distance", Date: 2000, Value: 3, Unit: "km", Increment: true},
+		{ID: "distance/4", Metric: "distance", Date: 5000, Value: 2, Unit: "km", Increment: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("measurements were %+v; wanted %+v", got, want)
+	}
+}
@@ -0,0 +1,264 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// States of background jobs.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job for Firestore serialization/deserialization. A job is a long-running
+// operation that runs in the background after the request that started
+// it returned its ID, e.g. syncing an import. Jobs are stored in
+// backgroundJobs/{job}. Dates are in milliseconds since the epoch.
+type Job struct {
+	Kind string `firestore:"kind" json:"kind"`
+	// User who started the job, and who may see its status.
+	User     string `firestore:"user" json:"user"`
+	State    string `firestore:"state" json:"state"`
+	Attempts int    `firestore:"attempts" json:"attempts"`
+	// Progress is the fraction of the work that is done, between 0 and 1.
+	Progress float64 `firestore:"progress" json:"progress"`
+	// Result is set once the job succeeded, and Error once it failed.
+	Result  interface{} `firestore:"result,omitempty" json:"result,omitempty"`
+	Error   string      `firestore:"error,omitempty" json:"error,omitempty"`
+	Created int64       `firestore:"created" json:"created"`
+	Updated int64       `firestore:"updated" json:"updated"`
+}
+
+// JobEntry is a job together with its ID.
+type JobEntry struct {
+	ID string `json:"id"`
+	Job
+}
+
+// JobFunc does the work of a job. It reports progress as the fraction of
+// the work that is done, and returns the result of the job.
+type JobFunc func(progress func(float64)) (interface{}, error)
+
+// jobStore persists jobs, which Storage does in Firestore.
+type jobStore interface {
+	createJob(j Job) (string, error)
+	updateJob(id string, j Job) error
+	// unfinishedJobs returns the jobs that are queued or running.
+	unfinishedJobs() ([]JobEntry, error)
+}
+
+// Limits of the job runner. Jobs that fail are retried with exponential
+// backoff until they failed maxJobAttempts times, unless retrying cannot
+// help.
+const (
+	maxJobAttempts  = 3
+	jobRetryBackoff = 10 * time.Second
+	jobQueueSize    = 100
+	jobWorkers      = 2
+)
+
+// staleJobAge is how long a job may stay queued or running without an
+// update before it counts as lost with the server that ran it. Jobs that
+// are alive are updated when they make progress or are retried.
+const staleJobAge = time.Hour
+
+type queuedJob struct {
+	id  string
+	job Job
+	f   JobFunc
+	// backoff is the delay before the next retry of the job.
+	backoff time.Duration
+}
+
+// jobRunner runs jobs in the background, one at a time per worker.
+type jobRunner struct {
+	store   jobStore
+	queue   chan queuedJob
+	backoff time.Duration
+}
+
+func newJobRunner(store jobStore, workers int) *jobRunner {
+	r := &jobRunner{store: store, queue: make(chan queuedJob, jobQueueSize), backoff: jobRetryBackoff}
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// enqueue records a job of the user and queues it. It returns the ID of
+// the job.
+func (r *jobRunner) enqueue(kind, userID string, f JobFunc) (string, error) {
+	now := time.Now().UnixNano() / 1000 / 1000
+	j := Job{Kind: kind, User: userID, State: JobQueued, Created: now, Updated: now}
+	id, err := r.store.createJob(j)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case r.queue <- queuedJob{id, j, f, r.backoff}:
+		return id, nil
+	default:
+		j.State = JobFailed
+		j.Error = "Too many jobs are queued"
+		if err := r.store.updateJob(id, j); err != nil {
+			logf(context.Background(), severityError, "Error updating job %s: %v", id, err)
+		}
+		return "", &UnavailableError{Operation: "enqueue " + kind, RetryAfter: r.backoff}
+	}
+}
+
+func (r *jobRunner) work() {
+	for q := range r.queue {
+		r.run(q)
+	}
+}
+
+// run runs an attempt of a job, and records its state along the way. A
+// job that fails is queued again once its backoff passed, so that the
+// worker runs other jobs in the meantime, until it failed too often.
+// Failures to record the state are logged, so that they do not fail the
+// job itself.
+func (r *jobRunner) run(q queuedJob) {
+	j := q.job
+	save := func() {
+		j.Updated = time.Now().UnixNano() / 1000 / 1000
+		if err := r.store.updateJob(q.id, j); err != nil {
+			logf(context.Background(), severityError, "Error updating job %s: %v", q.id, err)
+		}
+	}
+	progress := func(p float64) {
+		j.Progress = p
+		save()
+	}
+	j.State = JobRunning
+	j.Attempts++
+	j.Error = ""
+	save()
+	result, err := q.f(progress)
+	if err == nil {
+		j.State = JobSucceeded
+		j.Progress = 1
+		j.Result = result
+		save()
+		return
+	}
+	logf(context.Background(), severityWarning, "Job %s (%s) failed in attempt %d: %v", q.id, j.Kind, j.Attempts, err)
+	j.Error = err.Error()
+	if j.Attempts >= maxJobAttempts || permanentJobError(err) {
+		j.State = JobFailed
+		save()
+		return
+	}
+	j.State = JobQueued
+	save()
+	q.job = j
+	backoff := q.backoff
+	q.backoff *= 2
+	time.AfterFunc(backoff, func() {
+		r.queue <- q
+	})
+}
+
+// failStaleJobs fails the jobs that were queued or running on a server
+// that stopped, since their work was lost with it. Jobs of servers that
+// are still running were updated within staleJobAge, and are left alone.
+func (r *jobRunner) failStaleJobs(now time.Time) {
+	jobs, err := r.store.unfinishedJobs()
+	if err != nil {
+		logf(context.Background(), severityError, "Error listing unfinished jobs: %v", err)
+		return
+	}
+	cutoff := now.Add(-staleJobAge).UnixNano() / 1000 / 1000
+	for _, e := range jobs {
+		if e.Updated >= cutoff {
+			continue
+		}
+		e.State = JobFailed
+		e.Error = "Job was interrupted, start it again"
+		e.Updated = now.UnixNano() / 1000 / 1000
+		if err := r.store.updateJob(e.ID, e.Job); err != nil {
+			logf(context.Background(), severityError, "Error updating job %s: %v", e.ID, err)
+		}
+	}
+}
+
+// permanentJobError reports whether an error would recur if the job was
+// retried.
+func permanentJobError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidValue) || errors.Is(err, ErrForbidden)
+}
+
+func (s Storage) createJob(j Job) (string, error) {
+	var ref *firestore.DocumentRef
+	err := s.do("createJob", func(ctx context.Context) (err error) {
+		ref, _, err = s.collection("backgroundJobs").Add(ctx, j)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating job: %w", err)
+	}
+	return ref.ID, nil
+}
+
+func (s Storage) updateJob(id string, j Job) error {
+	err := s.do("updateJob", func(ctx context.Context) error {
+		_, err := s.collection("backgroundJobs").Doc(id).Set(ctx, j)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating job: %w", err)
+	}
+	return nil
+}
+
+func (s Storage) unfinishedJobs() ([]JobEntry, error) {
+	var jobs []JobEntry
+	for _, state := range []string{JobQueued, JobRunning} {
+		var docs []*firestore.DocumentSnapshot
+		err := s.do("unfinishedJobs", func(ctx context.Context) (err error) {
+			docs, err = s.collection("backgroundJobs").Where("state", "==", state).Documents(ctx).GetAll()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error listing jobs: %w", err)
+		}
+		for _, doc := range docs {
+			var j Job
+			if err := doc.DataTo(&j); err != nil {
+				return nil, fmt.Errorf("Error reading job %q: %w", doc.Ref.ID, err)
+			}
+			jobs = append(jobs, JobEntry{doc.Ref.ID, j})
+		}
+	}
+	return jobs, nil
+}
+
+// GetJob returns a job of a user. Jobs of other users are reported as
+// not found, so that their IDs are not disclosed.
+func (s Storage) GetJob(userID, id string) (JobEntry, error) {
+	var j Job
+	err := s.do("GetJob", func(ctx context.Context) error {
+		doc, err := s.collection("backgroundJobs").Doc(id).Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such job: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading job: %w", err)
+		}
+		return doc.DataTo(&j)
+	})
+	if err != nil {
+		return JobEntry{}, err
+	}
+	if j.User != userID {
+		return JobEntry{}, fmt.Errorf("No such job: %q: %w", id, ErrNotFound)
+	}
+	return JobEntry{id, j}, nil
+}
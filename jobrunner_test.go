@@ -0,0 +1,184 @@
+package pursuit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func (f *fakeJobStore) createJob(j Job) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.jobs == nil {
+		f.jobs = map[string]Job{}
+	}
+	id := fmt.Sprintf("job%d", len(f.jobs))
+	f.jobs[id] = j
+	return id, nil
+}
+
+func (f *fakeJobStore) updateJob(id string, j Job) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs[id] = j
+	return nil
+}
+
+func (f *fakeJobStore) unfinishedJobs() ([]JobEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var jobs []JobEntry
+	for id, j := range f.jobs {
+		if j.State == JobQueued || j.State == JobRunning {
+			jobs = append(jobs, JobEntry{id, j})
+		}
+	}
+	return jobs, nil
+}
+
+func runJob(t *testing.T, f JobFunc) Job {
+	store := &fakeJobStore{}
+	r := &jobRunner{store: store, queue: make(chan queuedJob, 1)}
+	id, err := r.enqueue("test", "alice", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		r.run(<-r.queue)
+		if j := store.jobs[id]; j.State == JobSucceeded || j.State == JobFailed {
+			return j
+		}
+	}
+}
+
+func TestJobRunnerSucceeds(t *testing.T) {
+	var saved float64
+	store := &fakeJobStore{}
+	r := &jobRunner{store: store, queue: make(chan queuedJob, 1)}
+	id, err := r.enqueue("test", "alice", func(report func(float64)) (interface{}, error) {
+		report(0.5)
+		saved = store.jobs["job0"].Progress
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.run(<-r.queue)
+	j := store.jobs[id]
+	if saved != 0.5 {
+		t.Errorf("got saved progress %v, want 0.5", saved)
+	}
+	if j.State != JobSucceeded || j.Result != "done" || j.Progress != 1 || j.Attempts != 1 {
+		t.Errorf("got job %+v, want succeeded job with result", j)
+	}
+	if j.User != "alice" || j.Kind != "test" {
+		t.Errorf("got job %+v, want job of alice", j)
+	}
+}
+
+func TestJobRunnerRetries(t *testing.T) {
+	calls := 0
+	j := runJob(t, func(func(float64)) (interface{}, error) {
+		calls++
+		if calls < 2 {
+			return nil, errors.New("flaky")
+		}
+		return nil, nil
+	})
+	if j.State != JobSucceeded || j.Attempts != 2 || j.Error != "" {
+		t.Errorf("got job %+v, want job that succeeded in second attempt", j)
+	}
+}
+
+func TestJobRunnerFails(t *testing.T) {
+	calls := 0
+	j := runJob(t, func(func(float64)) (interface{}, error) {
+		calls++
+		return nil, errors.New("broken")
+	})
+	if j.State != JobFailed || j.Error != "broken" || calls != maxJobAttempts {
+		t.Errorf("got job %+v after %d calls, want failed job after %d calls", j, calls, maxJobAttempts)
+	}
+}
+
+func TestJobRunnerDoesNotRetryPermanentErrors(t *testing.T) {
+	calls := 0
+	j := runJob(t, func(func(float64)) (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("No such import: %w", ErrNotFound)
+	})
+	if j.State != JobFailed || calls != 1 {
+		t.Errorf("got job %+v after %d calls, want failed job after 1 call", j, calls)
+	}
+}
+
+func TestJobRunnerQueueFull(t *testing.T) {
+	store := &fakeJobStore{}
+	r := &jobRunner{store: store, queue: make(chan queuedJob)}
+	_, err := r.enqueue("test", "alice", nil)
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Errorf("got error %v, want UnavailableError", err)
+	}
+	if j := store.jobs["job0"]; j.State != JobFailed {
+		t.Errorf("got job %+v, want failed job", j)
+	}
+}
+
+func TestJobRunnerRetriesWithoutBlocking(t *testing.T) {
+	store := &fakeJobStore{}
+	r := &jobRunner{store: store, queue: make(chan queuedJob, 1), backoff: time.Hour}
+	id, err := r.enqueue("test", "alice", func(func(float64)) (interface{}, error) {
+		return nil, errors.New("flaky")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		r.run(<-r.queue)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run blocked during backoff")
+	}
+	if j := store.jobs[id]; j.State != JobQueued || j.Attempts != 1 {
+		t.Errorf("got job %+v, want job queued for retry", j)
+	}
+}
+
+func TestFailStaleJobs(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	millis := func(t time.Time) int64 { return t.UnixNano() / 1000 / 1000 }
+	store := &fakeJobStore{jobs: map[string]Job{
+		"stale":    {State: JobRunning, Updated: millis(now.Add(-2 * time.Hour))},
+		"queued":   {State: JobQueued, Updated: millis(now.Add(-2 * time.Hour))},
+		"live":     {State: JobRunning, Updated: millis(now.Add(-time.Minute))},
+		"finished": {State: JobSucceeded, Updated: millis(now.Add(-2 * time.Hour))},
+	}}
+	r := &jobRunner{store: store}
+	r.failStaleJobs(now)
+	want := map[string]string{
+		"stale":    JobFailed,
+		"queued":   JobFailed,
+		"live":     JobRunning,
+		"finished": JobSucceeded,
+	}
+	for id, state := range want {
+		if j := store.jobs[id]; j.State != state {
+			t.Errorf("got job %s in state %q, want %q", id, j.State, state)
+		}
+	}
+	if j := store.jobs["stale"]; j.Error == "" {
+		t.Errorf("got job %+v, want error for interrupted job", j)
+	}
+}
@@ -0,0 +1,213 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/idtoken"
+)
+
+// jobLeaseTTL bounds how long a job holds its lease. If an instance dies
+// while running a job, the job can run again on another instance after
+// this time.
+const jobLeaseTTL = 15 * time.Minute
+
+// jobRunRetention is how long runs of jobs are kept.
+const jobRunRetention = 30 * 24 * time.Hour
+
+// JobLease for Firestore serialization/deserialization. An instance holds
+// the lease of a scheduled job in jobs/{job} while it runs the job, so
+// that the job does not run twice when Cloud Scheduler triggers it on
+// several instances, e.g. through retries.
+type JobLease struct {
+	Holder string `firestore:"holder" json:"holder"`
+	// Expires in milliseconds since the epoch.
+	Expires int64 `firestore:"expires" json:"expires"`
+}
+
+// heldByOther reports whether another instance holds the lease.
+func (l JobLease) heldByOther(holder string, now int64) bool {
+	return l.Holder != "" && l.Holder != holder && l.Expires > now
+}
+
+// JobRun for Firestore serialization/deserialization. Runs of a job are
+// recorded in jobs/{job}/runs. Dates are in milliseconds since the
+// epoch.
+type JobRun struct {
+	Holder   string    `firestore:"holder" json:"holder"`
+	Started  int64     `firestore:"started" json:"started"`
+	Finished int64     `firestore:"finished" json:"finished"`
+	Status   int       `firestore:"status" json:"status"`
+	ExpireAt time.Time `firestore:"expireAt" json:"-"`
+}
+
+// newInstanceID identifies the instance that runs the server in leases.
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// AcquireJobLease takes the lease of the job for the holder, unless
+// another holder has it. It reports whether the lease was taken.
+func (s Storage) AcquireJobLease(job, holder string, ttl time.Duration) (bool, error) {
+	ref := s.collection("jobs").Doc(job)
+	var acquired bool
+	err := s.transaction("AcquireJobLease", func(tx *firestore.Transaction) error {
+		acquired = false
+		now := time.Now().UnixNano() / 1000 / 1000
+		doc, err := tx.Get(ref)
+		if doc == nil || doc.Exists() {
+			if err != nil {
+				return err
+			}
+			var l JobLease
+			if err := doc.DataTo(&l); err != nil {
+				return err
+			}
+			if l.heldByOther(holder, now) {
+				return nil
+			}
+		}
+		acquired = true
+		return tx.Set(ref, JobLease{holder, now + ttl.Nanoseconds()/1000/1000})
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error acquiring lease of job %q: %w", job, err)
+	}
+	return acquired, nil
+}
+
+// ReleaseJobLease gives up the lease of the job, if the holder still has
+// it.
+func (s Storage) ReleaseJobLease(job, holder string) error {
+	ref := s.collection("jobs").Doc(job)
+	err := s.transaction("ReleaseJobLease", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var l JobLease
+		if err := doc.DataTo(&l); err != nil {
+			return err
+		}
+		if l.Holder != holder {
+			return nil
+		}
+		return tx.Set(ref, JobLease{})
+	})
+	if err != nil {
+		return fmt.Errorf("Error releasing lease of job %q: %w", job, err)
+	}
+	return nil
+}
+
+// recordJobRun persists a run of a job. Runs are a side channel, so
+// failures are logged.
+func (s Storage) recordJobRun(job string, run JobRun) {
+	run.ExpireAt = time.Unix(0, run.Started*int64(time.Millisecond)).Add(jobRunRetention)
+	ref := s.collection("jobs").Doc(job).Collection("runs")
+	err := s.do("recordJobRun", func(ctx context.Context) error {
+		_, _, err := ref.Add(ctx, run)
+		return err
+	})
+	if err != nil {
+		logf(s.ctx, severityError, "Error recording run %+v of job %q: %v", run, job, err)
+	}
+}
+
+// taskAuth authenticates the callers of scheduled jobs, see
+// UseTaskToken and UseSchedulerIdentity.
+type taskAuth struct {
+	token string
+	// audience and email are those of the OIDC tokens that Cloud
+	// Scheduler issues for its service account.
+	audience string
+	email    string
+	// validate verifies Google-signed ID tokens, and is idtoken.Validate
+	// outside of tests.
+	validate func(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+}
+
+// UseTaskToken lets callers of scheduled jobs under /tasks/ authenticate
+// with the token as bearer token.
+func (s *Server) UseTaskToken(token string) {
+	s.tasks.token = token
+}
+
+// UseSchedulerIdentity lets Cloud Scheduler call scheduled jobs under
+// /tasks/ with OIDC tokens for the service account with the email, issued
+// for the audience, such as the URL of the service.
+func (s *Server) UseSchedulerIdentity(audience, email string) {
+	s.tasks.audience = audience
+	s.tasks.email = email
+	s.tasks.validate = idtoken.Validate
+}
+
+// authorizeTask checks that a request to a scheduled job carries the task
+// token or an OIDC token of the scheduler. Without either configured, no
+// request is authorized.
+func (s *Server) authorizeTask(r *http.Request) error {
+	token := bearerToken(r)
+	if token == "" {
+		return fmt.Errorf("Missing task token: %w", ErrUnauthenticated)
+	}
+	a := s.tasks
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+		return nil
+	}
+	if a.email != "" && a.validate != nil {
+		p, err := a.validate(r.Context(), token, a.audience)
+		if err == nil && p.Claims["email"] == a.email && p.Claims["email_verified"] == true {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid task token: %w", ErrUnauthenticated)
+}
+
+// job wraps the handler of a scheduled job, so that only authorized
+// callers run it, only one instance runs the job at a time, and runs are
+// recorded. Triggers while the job runs elsewhere succeed without running
+// it, so that Cloud Scheduler does not retry them.
+func (s *Server) job(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.authorizeTask(r); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if r.Method != http.MethodPost {
+			h(w, r)
+			return
+		}
+		acquired, err := s.storageFor(r).AcquireJobLease(name, s.instance, jobLeaseTTL)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if !acquired {
+			writeJSON(w, http.StatusOK, map[string]bool{"skipped": true})
+			return
+		}
+		// The lease is released and the run recorded even if the request
+		// was canceled, so they use the context of the server.
+		defer func() {
+			if err := s.storage.ReleaseJobLease(name, s.instance); err != nil {
+				logf(r.Context(), severityError, "%v", err)
+			}
+		}()
+		run := JobRun{Holder: s.instance, Started: time.Now().UnixNano() / 1000 / 1000}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		run.Finished = time.Now().UnixNano() / 1000 / 1000
+		run.Status = rec.status
+		s.storage.recordJobRun(name, run)
+	}
+}
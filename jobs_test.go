@@ -0,0 +1,102 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/idtoken"
+)
+
+func TestJobLeaseHeldByOther(t *testing.T) {
+	tests := []struct {
+		lease JobLease
+		want  bool
+	}{
+		{JobLease{}, false},
+		{JobLease{Holder: "a", Expires: 2000}, false},
+		{JobLease{Holder: "b", Expires: 2000}, true},
+		{JobLease{Holder: "b", Expires: 1000}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.lease.heldByOther("a", 1000); got != tt.want {
+			t.Errorf("%+v.heldByOther(a) = %v; wanted %v", tt.lease, got, tt.want)
+		}
+	}
+}
+
+func TestJobOnlyLeasesPost(t *testing.T) {
+	s := &Server{instance: "a"}
+	s.UseTaskToken("secret")
+	called := false
+	h := s.job("test", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		allowMethod(w, r, http.MethodPost)
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/tasks/test", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+
+	h(w, r)
+
+	if !called || w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET was %d, handler called: %v", w.Code, called)
+	}
+}
+
+func TestPurgeSandboxRequiresTaskToken(t *testing.T) {
+	// Without storage, a purge that got past authentication would panic.
+	s := &Server{instance: "a"}
+	s.UseTaskToken("secret")
+	w := httptest.NewRecorder()
+
+	s.job("purgesandbox", s.purgeSandbox)(w, httptest.NewRequest(http.MethodPost, "/tasks/purgesandbox", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status was %d; wanted 401", w.Code)
+	}
+}
+
+func TestJobRejectsUnauthenticatedCallers(t *testing.T) {
+	s := &Server{instance: "a"}
+	s.UseTaskToken("secret")
+	s.tasks.email = "scheduler@example.iam.gserviceaccount.com"
+	s.tasks.validate = func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+		if token != "oidc" {
+			return nil, errors.New("invalid token")
+		}
+		return &idtoken.Payload{Claims: map[string]interface{}{"email": "scheduler@example.iam.gserviceaccount.com", "email_verified": true}}, nil
+	}
+	for _, tt := range []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer guess", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusNoContent},
+		{"Bearer oidc", http.StatusNoContent},
+	} {
+		purged := false
+		h := s.job("purgesandbox", func(w http.ResponseWriter, r *http.Request) {
+			purged = true
+			w.WriteHeader(http.StatusNoContent)
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/tasks/purgesandbox", nil)
+		r.Header.Set("Authorization", tt.header)
+
+		h(w, r)
+
+		if w.Code != tt.status || purged != (tt.status == http.StatusNoContent) {
+			t.Errorf("%q: status was %d, purged: %v; wanted %d", tt.header, w.Code, purged, tt.status)
+		}
+	}
+}
+
+func TestNewInstanceID(t *testing.T) {
+	if a, b := newInstanceID(), newInstanceID(); a == b {
+		t.Errorf("instance IDs were both %q", a)
+	}
+}
@@ -0,0 +1,128 @@
+package pursuit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LockedOutError is returned instead of checking a token while the
+// client is locked out after too many failed attempts.
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("Too many failed attempts, retry after %v", e.RetryAfter)
+}
+
+// EventSecurityLockout is emitted to the owner of a token when a client
+// is locked out after failed attempts with that token.
+const EventSecurityLockout = "security.lockout"
+
+// lockouts keep track of failed token attempts per source IP and per
+// token. After threshold failures, each further failure locks the key
+// out for a delay that doubles up to max. Keys are forgotten once they
+// have not failed for max.
+type lockouts struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*attempts
+	lastSweep time.Time
+}
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLockouts() *lockouts {
+	return &lockouts{
+		threshold: 5,
+		base:      time.Second,
+		max:       time.Hour,
+		now:       time.Now,
+		keys:      map[string]*attempts{},
+	}
+}
+
+// lockoutKeys returns the keys under which attempts with the secret from
+// the source IP are tracked. Tokens are tracked by their ID, so that
+// attempts with the same token are counted together no matter which
+// address they come from.
+func lockoutKeys(ip, secret string) (ipKey, tokenKey string) {
+	return "ip:" + ip, "token:" + tokenID(secret)
+}
+
+// wait returns how long any of the keys is still locked out.
+func (l *lockouts) wait(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys {
+		if a, ok := l.keys[k]; ok {
+			if w := a.lockedUntil.Sub(now); w > wait {
+				wait = w
+			}
+		}
+	}
+	return wait
+}
+
+// fail records a failed attempt and returns for how long the keys are
+// locked out as a result.
+func (l *lockouts) fail(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.forget(now)
+		l.lastSweep = now
+	}
+	var locked time.Duration
+	for _, k := range keys {
+		a, ok := l.keys[k]
+		if !ok {
+			a = &attempts{}
+			l.keys[k] = a
+		}
+		a.failures++
+		a.lastFailure = now
+		if a.failures <= l.threshold {
+			continue
+		}
+		delay := l.max
+		if n := a.failures - l.threshold - 1; n < 32 && l.base<<uint(n) < l.max {
+			delay = l.base << uint(n)
+		}
+		a.lockedUntil = now.Add(delay)
+		if delay > locked {
+			locked = delay
+		}
+	}
+	return locked
+}
+
+// succeed resets the key of a token after a successful attempt. Keys of
+// source IPs should not be reset, as a client could otherwise interleave
+// guesses with a valid token of its own.
+func (l *lockouts) succeed(tokenKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, tokenKey)
+}
+
+// forget removes keys that have not failed for max.
+func (l *lockouts) forget(now time.Time) {
+	for k, a := range l.keys {
+		if now.Sub(a.lastFailure) > l.max {
+			delete(l.keys, k)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pursuit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockoutsAfterThreshold(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newLockouts()
+	l.now = clock.now
+
+	for i := 0; i < l.threshold; i++ {
+		if locked := l.fail("ip:a"); locked != 0 {
+			t.Fatalf("failure %d locked out for %v", i+1, locked)
+		}
+	}
+	if locked := l.fail("ip:a"); locked != time.Second {
+		t.Errorf("locked out for %v; wanted 1s", locked)
+	}
+	if locked := l.fail("ip:a"); locked != 2*time.Second {
+		t.Errorf("locked out for %v; wanted 2s", locked)
+	}
+	if wait := l.wait("ip:a", "key:b"); wait != 2*time.Second {
+		t.Errorf("wait was %v; wanted 2s", wait)
+	}
+	if wait := l.wait("ip:c"); wait != 0 {
+		t.Errorf("wait of another IP was %v; wanted 0", wait)
+	}
+	clock.t = clock.t.Add(2 * time.Second)
+	if wait := l.wait("ip:a"); wait > 0 {
+		t.Errorf("still locked out for %v", wait)
+	}
+}
+
+func TestLockoutsAreCapped(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newLockouts()
+	l.now = clock.now
+
+	var locked time.Duration
+	for i := 0; i < 100; i++ {
+		locked = l.fail("key:k")
+	}
+
+	if locked != l.max {
+		t.Errorf("locked out for %v; wanted %v", locked, l.max)
+	}
+}
+
+func TestLockoutsSucceedResetsToken(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newLockouts()
+	l.now = clock.now
+	ipKey, tokenKey := lockoutKeys("1.2.3.4", "0123456789abcdef")
+
+	for i := 0; i <= l.threshold; i++ {
+		l.fail(ipKey, tokenKey)
+	}
+	l.succeed(tokenKey)
+
+	if wait := l.wait(tokenKey); wait != 0 {
+		t.Errorf("token still locked out for %v", wait)
+	}
+	if wait := l.wait(ipKey); wait == 0 {
+		t.Errorf("IP was reset by success")
+	}
+}
+
+func TestLockoutsForgetOldFailures(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newLockouts()
+	l.now = clock.now
+
+	l.fail("ip:a")
+	clock.t = clock.t.Add(2 * l.max)
+	l.fail("ip:b")
+
+	if _, ok := l.keys["ip:a"]; ok {
+		t.Errorf("old failures were kept")
+	}
+}
+
+func TestLockoutKeysTrackWholeTokens(t *testing.T) {
+	ipKey, a := lockoutKeys("1.2.3.4", "0123456789abcdef")
+	_, b := lockoutKeys("1.2.3.4", "01234567zzzzzzzz")
+
+	if ipKey != "ip:1.2.3.4" {
+		t.Errorf("IP key was %q", ipKey)
+	}
+	if a == b {
+		t.Errorf("guesses with the same prefix shared the key %q", a)
+	}
+	if a != "token:"+tokenID("0123456789abcdef") {
+		t.Errorf("key was %q; wanted the token ID", a)
+	}
+}
+
+func TestCheckTokenLocksOutDespiteForgedForwardedFor(t *testing.T) {
+	defer captureLog(t)()
+	s := &Server{storage: &Storage{}, lockouts: newLockouts()}
+	guess := func(i int) error {
+		r := httptest.NewRequest(http.MethodGet, "/setgoalvalue", nil)
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d, 198.51.100.9", i))
+		_, err := s.checkToken(r, fmt.Sprintf("guess-%d", i), "", "", func(*Storage) (string, error) {
+			return "", ErrForbidden
+		})
+		return err
+	}
+
+	for i := 0; i <= s.lockouts.threshold; i++ {
+		guess(i)
+	}
+
+	var locked *LockedOutError
+	if err := guess(100); !errors.As(err, &locked) {
+		t.Errorf("guess after the threshold got %v; wanted a lockout", err)
+	}
+	if len(s.lockouts.keys) != 1 {
+		t.Errorf("lockouts tracked %d keys; wanted only the address", len(s.lockouts.keys))
+	}
+}
@@ -0,0 +1,150 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Severities of log entries, as Cloud Logging names them.
+const (
+	severityInfo    = "INFO"
+	severityWarning = "WARNING"
+	severityError   = "ERROR"
+	// severityCritical is for errors that stop the server.
+	severityCritical = "CRITICAL"
+)
+
+// RequestIDHeader carries the ID of a request in its response, so that
+// clients can refer to the log entries of the request.
+const RequestIDHeader = "X-Request-Id"
+
+// logger writes log entries as lines of JSON to standard error, which
+// Cloud Run passes on to Cloud Logging as structured entries.
+var logger = log.New(os.Stderr, "", 0)
+
+// logEntry is a structured log entry. Cloud Logging takes severity,
+// message and httpRequest as the fields of the entry of the same name,
+// and keeps the others in its JSON payload, where queries can filter by
+// them, such as jsonPayload.requestId.
+type logEntry struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// RequestID and the fields after it describe the request that the
+	// entry was logged for, if any.
+	RequestID string `json:"requestId,omitempty"`
+	User      string `json:"user,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Objective string `json:"objective,omitempty"`
+	Goal      string `json:"goal,omitempty"`
+	// Error is the error that the request failed with.
+	Error       string            `json:"error,omitempty"`
+	HTTPRequest *httpRequestEntry `json:"httpRequest,omitempty"`
+}
+
+// httpRequestEntry is the HttpRequest of Cloud Logging.
+type httpRequestEntry struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	// Latency is a duration in seconds, such as "0.125s".
+	Latency   string `json:"latency"`
+	RemoteIP  string `json:"remoteIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// requestID returns the ID of a request, which is the trace ID that the
+// load balancer of Cloud Run assigns, so that log entries and traces of a
+// request match. Requests without one get a random ID.
+func requestID(r *http.Request) string {
+	// The header has the form TRACE_ID/SPAN_ID;o=OPTIONS, where the trace
+	// ID is 32 hex digits.
+	if t := r.Header.Get("X-Cloud-Trace-Context"); len(t) >= 32 {
+		if _, err := hex.DecodeString(t[:32]); err == nil && (len(t) == 32 || t[32] == '/') {
+			return t[:32]
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeLog writes the entry to the log. Entries that cannot be encoded
+// are written as plain text, which Cloud Logging keeps as the message.
+func writeLog(e logEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logger.Printf("%s: %s (error encoding log entry: %v)", e.Severity, e.Message, err)
+		return
+	}
+	logger.Print(string(b))
+}
+
+// logf logs a message of the severity. If the context belongs to a
+// request, the entry describes the request too, so that all entries of a
+// request can be found by its ID.
+func logf(ctx context.Context, severity, format string, args ...interface{}) {
+	e := logEntry{Severity: severity, Message: fmt.Sprintf(format, args...)}
+	if ctx != nil {
+		if l, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+			e.RequestID, e.User, e.Token, e.Objective, e.Goal = l.id, l.user, l.token, l.objective, l.goal
+		}
+	}
+	writeLog(e)
+}
+
+// LogInfof logs a message of the server that is not about a request,
+// such as that it started, in the format of the entries of requests.
+func LogInfof(format string, args ...interface{}) {
+	logf(context.Background(), severityInfo, format, args...)
+}
+
+// LogErrorf logs an error of the server that is not about a request.
+func LogErrorf(format string, args ...interface{}) {
+	logf(context.Background(), severityError, format, args...)
+}
+
+// LogFatalf logs an error that stops the server, and exits.
+func LogFatalf(format string, args ...interface{}) {
+	logf(context.Background(), severityCritical, format, args...)
+	os.Exit(1)
+}
+
+// logRequest logs a request once it has been served, together with the
+// error that it failed with, if any. Server errors are logged as errors,
+// and client errors as warnings.
+func logRequest(r *http.Request, l *requestLog, rec *statusRecorder, latency time.Duration) {
+	e := logEntry{
+		Severity:  severityInfo,
+		Message:   fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.status),
+		RequestID: l.id,
+		User:      l.user,
+		Token:     l.token,
+		Objective: l.objective,
+		Goal:      l.goal,
+		HTTPRequest: &httpRequestEntry{
+			RequestMethod: r.Method,
+			RequestURL:    r.URL.Path,
+			Status:        rec.status,
+			Latency:       fmt.Sprintf("%.3fs", latency.Seconds()),
+			RemoteIP:      sourceIP(r),
+			UserAgent:     r.UserAgent(),
+		},
+	}
+	switch {
+	case rec.status >= 500:
+		e.Severity = severityError
+	case rec.status >= 400:
+		e.Severity = severityWarning
+	}
+	if rec.err != nil {
+		e.Error = rec.err.Error()
+	}
+	writeLog(e)
+}
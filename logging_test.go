@@ -0,0 +1,106 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// captureLog collects log entries until the returned function is called,
+// which returns them.
+func captureLog(t *testing.T) func() []logEntry {
+	var buf bytes.Buffer
+	saved := logger
+	logger = log.New(&buf, "", 0)
+	return func() []logEntry {
+		logger = saved
+		var entries []logEntry
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var e logEntry
+			if err := dec.Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	if id := requestID(r); id != "105445aa7843bc8bf206b12000100000" {
+		t.Errorf("ID was %q; wanted the trace ID", id)
+	}
+	r.Header.Set("X-Cloud-Trace-Context", `"},"severity":"DEBUG`)
+	if id := requestID(r); len(id) != 32 || id == requestID(r) {
+		t.Errorf("ID of a request with an invalid trace was %q; wanted a random ID", id)
+	}
+}
+
+func TestLogfDescribesRequest(t *testing.T) {
+	entries := captureLog(t)
+	l := &requestLog{id: "r1", user: "alice", objective: "fitness", goal: "run"}
+	ctx := context.WithValue(context.Background(), requestLogKey{}, l)
+
+	logf(ctx, severityError, "Error recording event of user %q", "alice")
+	logf(context.Background(), severityInfo, "Started")
+
+	got := entries()
+	if len(got) != 2 {
+		t.Fatalf("entries were %+v", got)
+	}
+	want := logEntry{Severity: severityError, Message: `Error recording event of user "alice"`, RequestID: "r1", User: "alice", Objective: "fitness", Goal: "run"}
+	if got[0].Severity != want.Severity || got[0].Message != want.Message || got[0].RequestID != want.RequestID ||
+		got[0].User != want.User || got[0].Objective != want.Objective || got[0].Goal != want.Goal {
+		t.Errorf("entry was %+v; wanted %+v", got[0], want)
+	}
+	if got[1].RequestID != "" {
+		t.Errorf("entry without request had request ID %q", got[1].RequestID)
+	}
+}
+
+func TestServeHTTPLogsRequest(t *testing.T) {
+	entries := captureLog(t)
+	s := &Server{storage: &Storage{}, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		writeStorageError(w, errors.New("Error reading objective: deadline exceeded"))
+	})
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fail?token=secret", nil))
+
+	got := entries()
+	if len(got) != 1 {
+		t.Fatalf("entries were %+v", got)
+	}
+	e := got[0]
+	if e.RequestID == "" || e.RequestID != w.Header().Get(RequestIDHeader) {
+		t.Errorf("request ID was %q; the response had %q", e.RequestID, w.Header().Get(RequestIDHeader))
+	}
+	if e.Severity != severityError || e.Error != "Error reading objective: deadline exceeded" {
+		t.Errorf("entry was %+v", e)
+	}
+	if r := e.HTTPRequest; r == nil || r.Status != http.StatusInternalServerError || r.RequestURL != "/fail" {
+		t.Errorf("HTTP request was %+v", r)
+	}
+}
+
+func TestLogServerMessages(t *testing.T) {
+	entries := captureLog(t)
+
+	LogInfof("Listening on port %s", "8080")
+	LogErrorf("Error shutting down: %v", errors.New("timeout"))
+
+	got := entries()
+	if len(got) != 2 || got[0].Severity != severityInfo || got[0].Message != "Listening on port 8080" ||
+		got[1].Severity != severityError || got[1].Message != "Error shutting down: timeout" {
+		t.Errorf("entries were %+v", got)
+	}
+}
@@ -0,0 +1,375 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// MergeReport describes the changes made, or planned in a dry run, when
+// merging the account of one user into the account of another.
+type MergeReport struct {
+	From   string `json:"from"`
+	Into   string `json:"into"`
+	DryRun bool   `json:"dryRun"`
+	// Moved maps the IDs of moved objectives to their IDs in the target
+	// account. Objectives are renamed only if their ID is already in use.
+	Moved map[string]string `json:"moved"`
+	// Profile lists the profile fields copied into the target account.
+	Profile []string `json:"profile"`
+	// Slugs maps the IDs of moved objectives whose slug is already used
+	// in the target account to their new slug.
+	Slugs map[string]string `json:"slugs,omitempty"`
+	// Documents is the number of documents in subcollections of the moved
+	// objectives, such as values of trajectories and check-ins.
+	Documents int `json:"documents"`
+}
+
+// planMerge determines which objectives to move and which profile fields
+// to copy. Fields already present in the target profile take precedence.
+func planMerge(from, into string, fromObjectives, intoObjectives []string, fromProfile, intoProfile map[string]interface{}, newID func() string) MergeReport {
+	r := MergeReport{
+		From:  from,
+		Into:  into,
+		Moved: map[string]string{},
+	}
+	taken := map[string]bool{}
+	for _, id := range intoObjectives {
+		taken[id] = true
+	}
+	for _, id := range fromObjectives {
+		if taken[id] {
+			r.Moved[id] = newID()
+		} else {
+			r.Moved[id] = id
+		}
+	}
+	for k := range fromProfile {
+		if _, ok := intoProfile[k]; !ok && k != "mergedInto" {
+			r.Profile = append(r.Profile, k)
+		}
+	}
+	sort.Strings(r.Profile)
+	return r
+}
+
+// mergeSlugs picks the slugs of moved objectives, by their ID in the
+// target account. Objectives keep their slug unless it is taken in the
+// target account, either in its slug index or as the ID of an
+// objective, in which case they get a new one.
+func mergeSlugs(objectives map[string]Objective, taken map[string]bool) map[string]string {
+	ids := make([]string, 0, len(objectives))
+	for id := range objectives {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	used := func(id, slug string) bool {
+		_, ok := objectives[slug]
+		return taken[slug] || (ok && slug != id)
+	}
+	slugs := map[string]string{}
+	for _, id := range ids {
+		o := objectives[id]
+		if o.Slug == "" {
+			continue
+		}
+		slug := o.Slug
+		if used(id, slug) {
+			slug, _ = uniqueSlug(o.Name, "objective", id, func(slug string) (bool, error) {
+				return used(id, slug), nil
+			})
+		}
+		taken[slug] = true
+		slugs[id] = slug
+	}
+	return slugs
+}
+
+// mergeTarget returns the reference that a document below the account of
+// a merged user moves to: the same path below the target account, with
+// the objective renamed as in moved.
+func mergeTarget(from, into, ref *firestore.DocumentRef, moved map[string]string) *firestore.DocumentRef {
+	ids := strings.Split(strings.TrimPrefix(ref.Path, from.Path+"/"), "/")
+	if len(ids) > 1 && ids[0] == "objectives" {
+		ids[1] = moved[ids[1]]
+	}
+	target := into
+	for i := 0; i+1 < len(ids); i += 2 {
+		target = target.Collection(ids[i]).Doc(ids[i+1])
+	}
+	return target
+}
+
+// subdocuments returns the documents in the subcollections of the
+// document, at any depth. Documents that are missing but have
+// subcollections, like the goals of trajectories, are skipped.
+func (s Storage) subdocuments(ref *firestore.DocumentRef) ([]*firestore.DocumentSnapshot, error) {
+	var collections []*firestore.CollectionRef
+	err := s.do("MergeUsers", func(ctx context.Context) (err error) {
+		collections, err = ref.Collections(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing collections of %q: %w", ref.ID, err)
+	}
+	var docs []*firestore.DocumentSnapshot
+	for _, c := range collections {
+		var refs []*firestore.DocumentRef
+		var snapshots []*firestore.DocumentSnapshot
+		err := s.do("MergeUsers", func(ctx context.Context) (err error) {
+			refs, err = c.DocumentRefs(ctx).GetAll()
+			if err != nil || len(refs) == 0 {
+				return err
+			}
+			snapshots, err = s.client.GetAll(ctx, refs)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %q: %w", c.ID, err)
+		}
+		for i, r := range refs {
+			if snapshots[i].Exists() {
+				docs = append(docs, snapshots[i])
+			}
+			sub, err := s.subdocuments(r)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, sub...)
+		}
+	}
+	return docs, nil
+}
+
+// MergeUsers moves all objectives of one user to another user, along
+// with their subcollections, such as trajectories, check-ins and edits
+// of descriptions, and their entries in the slug index. It copies
+// missing profile fields, and replaces the profile of the merged user
+// with a tombstone that redirects to the target user. In a dry run,
+// nothing is written.
+//
+// Since a transaction fits at most maxTransactionWrites writes, the
+// documents in subcollections are copied in batches first. The
+// objectives, the slug index and the profiles are then moved in a single
+// transaction, after which the copied documents of the merged user are
+// deleted. Copies left behind by a merge that fails are overwritten when
+// it is retried, unless their objective is renamed again.
+func (s Storage) MergeUsers(fromID, intoID string, dryRun bool) (MergeReport, error) {
+	if fromID == intoID {
+		return MergeReport{}, fmt.Errorf("Cannot merge user %q into itself", fromID)
+	}
+	fromRef := s.collection("users").Doc(fromID)
+	intoRef := s.collection("users").Doc(intoID)
+	var report MergeReport
+	err := s.transaction("MergeUsers", func(tx *firestore.Transaction) error {
+		fromProfile, intoProfile, err := readMergeProfiles(tx, fromRef, intoRef)
+		if err != nil {
+			return err
+		}
+		fromDocs, err := tx.Documents(fromRef.Collection("objectives")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading objectives: %w", err)
+		}
+		intoDocs, err := tx.Documents(intoRef.Collection("objectives")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading objectives: %w", err)
+		}
+		report = planMerge(fromID, intoID, docIDs(fromDocs), docIDs(intoDocs), fromProfile, intoProfile, func() string {
+			return intoRef.Collection("objectives").NewDoc().ID
+		})
+		report.DryRun = dryRun
+		return nil
+	})
+	if err != nil {
+		return MergeReport{}, err
+	}
+
+	var copies []*firestore.DocumentSnapshot
+	for id := range report.Moved {
+		docs, err := s.subdocuments(fromRef.Collection("objectives").Doc(id))
+		if err != nil {
+			return MergeReport{}, err
+		}
+		copies = append(copies, docs...)
+	}
+	report.Documents = len(copies)
+	if dryRun {
+		return report, nil
+	}
+	for len(copies) > 0 {
+		n := len(copies)
+		if n > maxTransactionWrites {
+			n = maxTransactionWrites
+		}
+		batch := copies[:n]
+		copies = copies[n:]
+		err := s.transaction("MergeUsers", func(tx *firestore.Transaction) error {
+			for _, doc := range batch {
+				if err := tx.Set(mergeTarget(fromRef, intoRef, doc.Ref, report.Moved), doc.Data()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return MergeReport{}, fmt.Errorf("Error copying subcollections: %w", err)
+		}
+	}
+
+	err = s.transaction("MergeUsers", func(tx *firestore.Transaction) error {
+		fromProfile, _, err := readMergeProfiles(tx, fromRef, intoRef)
+		if err != nil {
+			return err
+		}
+		fromDocs, err := tx.Documents(fromRef.Collection("objectives")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading objectives: %w", err)
+		}
+		objectives := map[string]Objective{}
+		for _, doc := range fromDocs {
+			to, ok := report.Moved[doc.Ref.ID]
+			if !ok {
+				return fmt.Errorf("Objective %q was added during the merge; retry", doc.Ref.ID)
+			}
+			var o Objective
+			if err := doc.DataTo(&o); err != nil {
+				return fmt.Errorf("Error reading objective %q: %w", doc.Ref.ID, err)
+			}
+			objectives[to] = o
+		}
+		taken := map[string]bool{}
+		intoDocs, err := tx.Documents(intoRef.Collection("objectives")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading objectives: %w", err)
+		}
+		for _, id := range docIDs(intoDocs) {
+			taken[id] = true
+		}
+		intoSlugs, err := tx.Documents(intoRef.Collection("slugs")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading slug index: %w", err)
+		}
+		for _, id := range docIDs(intoSlugs) {
+			taken[id] = true
+		}
+		fromSlugs, err := tx.Documents(fromRef.Collection("slugs")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading slug index: %w", err)
+		}
+		slugs := mergeSlugs(objectives, taken)
+		if n := 2*len(fromDocs) + len(fromSlugs) + len(slugs) + 2; n > maxTransactionWrites {
+			return fmt.Errorf("Merging takes %d writes, at most %d fit into a transaction: %w", n, maxTransactionWrites, ErrInvalidValue)
+		}
+
+		report.Slugs = nil
+		for _, doc := range fromDocs {
+			to := report.Moved[doc.Ref.ID]
+			o := objectives[to]
+			if slug, ok := slugs[to]; ok && slug != o.Slug {
+				if report.Slugs == nil {
+					report.Slugs = map[string]string{}
+				}
+				report.Slugs[doc.Ref.ID] = slug
+				o.Slug = slug
+			}
+			if err := tx.Create(intoRef.Collection("objectives").Doc(to), o); err != nil {
+				return err
+			}
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		for _, doc := range fromSlugs {
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		for id, slug := range slugs {
+			if err := tx.Set(intoRef.Collection("slugs").Doc(slug), slugEntry{id}); err != nil {
+				return err
+			}
+		}
+		profile := map[string]interface{}{}
+		for _, k := range report.Profile {
+			profile[k] = fromProfile[k]
+		}
+		if err := tx.Set(intoRef, profile, firestore.MergeAll); err != nil {
+			return err
+		}
+		return tx.Set(fromRef, map[string]interface{}{"mergedInto": intoID})
+	})
+	if err != nil {
+		return MergeReport{}, err
+	}
+	for id := range report.Moved {
+		if _, err := s.purge(fromRef.Collection("objectives").Doc(id)); err != nil {
+			logf(s.ctx, severityError, "Error deleting objective %q of merged user %q: %v", id, fromID, err)
+		}
+	}
+	return report, nil
+}
+
+// readMergeProfiles reads the profiles of the users to merge within a
+// transaction, and fails if either of them has already been merged.
+func readMergeProfiles(tx *firestore.Transaction, fromRef, intoRef *firestore.DocumentRef) (map[string]interface{}, map[string]interface{}, error) {
+	fromProfile, err := readProfile(tx, fromRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := fromProfile["mergedInto"]; ok {
+		return nil, nil, fmt.Errorf("User %q has already been merged", fromRef.ID)
+	}
+	intoProfile, err := readProfile(tx, intoRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := intoProfile["mergedInto"]; ok {
+		return nil, nil, fmt.Errorf("User %q has already been merged", intoRef.ID)
+	}
+	return fromProfile, intoProfile, nil
+}
+
+// ResolveUser follows the tombstone left behind by MergeUsers and
+// returns the ID of the user that now owns the data of the given user.
+func (s Storage) ResolveUser(userID string) (string, error) {
+	var doc *firestore.DocumentSnapshot
+	err := s.do("ResolveUser", func(ctx context.Context) (err error) {
+		doc, err = s.collection("users").Doc(userID).Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading user: %w", err)
+	}
+	if !doc.Exists() {
+		return userID, nil
+	}
+	if into, ok := doc.Data()["mergedInto"].(string); ok && into != "" {
+		return into, nil
+	}
+	return userID, nil
+}
+
+func readProfile(tx *firestore.Transaction, ref *firestore.DocumentRef) (map[string]interface{}, error) {
+	doc, err := tx.Get(ref)
+	if err != nil {
+		if doc != nil && !doc.Exists() {
+			return map[string]interface{}{}, nil
+		}
+		return nil, fmt.Errorf("Error reading user: %w", err)
+	}
+	return doc.Data(), nil
+}
+
+func docIDs(docs []*firestore.DocumentSnapshot) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Ref.ID
+	}
+	return ids
+}
@@ -0,0 +1,109 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestPlanMerge(t *testing.T) {
+	r := planMerge("a", "b", []string{"x", "y"}, []string{"z"}, nil, nil, func() string {
+		return "new"
+	})
+
+	want := map[string]string{"x": "x", "y": "y"}
+	if !reflect.DeepEqual(r.Moved, want) {
+		t.Errorf("moved %v; wanted %v", r.Moved, want)
+	}
+}
+
+func TestPlanMergeRenamesConflicts(t *testing.T) {
+	r := planMerge("a", "b", []string{"x", "y"}, []string{"y"}, nil, nil, func() string {
+		return "new"
+	})
+
+	want := map[string]string{"x": "x", "y": "new"}
+	if !reflect.DeepEqual(r.Moved, want) {
+		t.Errorf("moved %v; wanted %v", r.Moved, want)
+	}
+}
+
+func TestPlanMergeProfile(t *testing.T) {
+	from := map[string]interface{}{"name": "Ada", "email": "ada@example.com"}
+	into := map[string]interface{}{"name": "Ada Lovelace"}
+
+	r := planMerge("a", "b", nil, nil, from, into, nil)
+
+	want := []string{"email"}
+	if !reflect.DeepEqual(r.Profile, want) {
+		t.Errorf("copied profile fields %v; wanted %v", r.Profile, want)
+	}
+}
+
+func TestMergeUserNeedsTargetIDToken(t *testing.T) {
+	s, _ := newMemoryServer()
+	for _, c := range []struct {
+		body string
+		want int
+	}{
+		{`{"into": "bob"}`, http.StatusBadRequest},
+		{`{"into": "bob", "intoToken": "a.mallory.c"}`, http.StatusForbidden},
+		{`{"into": "bob", "intoToken": "forged"}`, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/users/alice/merge", strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+
+		s.mergeUser(w, r, "alice")
+
+		if w.Code != c.want {
+			t.Errorf("status of merge with %s was %d; wanted %d", c.body, w.Code, c.want)
+		}
+	}
+}
+
+func TestMergeTargetMovesSubcollections(t *testing.T) {
+	users := (&firestore.Client{}).Collection("users")
+	from, into := users.Doc("a"), users.Doc("b")
+	moved := map[string]string{"fitness": "fitness", "work": "new"}
+	for _, c := range []struct {
+		ref  *firestore.DocumentRef
+		want *firestore.DocumentRef
+	}{
+		{
+			from.Collection("objectives").Doc("fitness").Collection("goals").Doc("run").Collection("trajectory").Doc("p1"),
+			into.Collection("objectives").Doc("fitness").Collection("goals").Doc("run").Collection("trajectory").Doc("p1"),
+		},
+		{
+			from.Collection("objectives").Doc("work").Collection("checkIns").Doc("2025-W01"),
+			into.Collection("objectives").Doc("new").Collection("checkIns").Doc("2025-W01"),
+		},
+		{
+			from.Collection("objectives").Doc("work").Collection("descriptionEdits").Doc("e1"),
+			into.Collection("objectives").Doc("new").Collection("descriptionEdits").Doc("e1"),
+		},
+	} {
+		if got := mergeTarget(from, into, c.ref, moved); got.Path != c.want.Path {
+			t.Errorf("%s moved to %s; wanted %s", c.ref.Path, got.Path, c.want.Path)
+		}
+	}
+}
+
+func TestMergeSlugsRenamesTakenSlugs(t *testing.T) {
+	objectives := map[string]Objective{
+		"fitness": {Name: "Fitness", Slug: "fitness"},
+		"new":     {Name: "Work", Slug: "work"},
+		"old":     {Name: "Old"},
+	}
+	taken := map[string]bool{"work": true, "reading": true}
+
+	got := mergeSlugs(objectives, taken)
+
+	want := map[string]string{"fitness": "fitness", "new": "work-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("slugs were %v; wanted %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergePatchType is the content type of JSON merge patches, see RFC 7386.
+const MergePatchType = "application/merge-patch+json"
+
+// mergePatchFields converts a JSON merge patch of a value of type t into
+// the field mask and values of an update: objects are merged recursively,
+// null clears a field, and any other value replaces it. Members are
+// matched to fields by their JSON names, and to map entries by their
+// keys. The paths in the mask start with prefix.
+func mergePatchFields(t reflect.Type, prefix string, patch interface{}, mask *FieldMask, values map[string]interface{}) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	p, ok := patch.(map[string]interface{})
+	if !ok || (t.Kind() != reflect.Struct && t.Kind() != reflect.Map) {
+		*mask = append(*mask, prefix)
+		if patch != nil {
+			values[prefix] = patch
+		}
+		return nil
+	}
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var name string
+		var elem reflect.Type
+		if t.Kind() == reflect.Struct {
+			f, ok := jsonField(t, k)
+			name = strings.Split(f.Tag.Get("firestore"), ",")[0]
+			if !ok || name == "" {
+				return fmt.Errorf("Unknown field %q: %w", k, ErrInvalidValue)
+			}
+			elem = f.Type
+		} else {
+			if k == "" || strings.Contains(k, ".") {
+				return fmt.Errorf("Invalid key %q: %w", k, ErrInvalidValue)
+			}
+			name = k
+			elem = t.Elem()
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if err := mergePatchFields(elem, name, p[k], mask, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonField returns the field of the struct type that encoding/json
+// decodes the member into, which matches its JSON name ignoring case.
+func jsonField(t reflect.Type, member string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		if name != "-" && strings.EqualFold(name, member) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// patchObjectiveFields applies a JSON merge patch of a value of type t at
+// prefix in an objective of a user.
+func (s Storage) patchObjectiveFields(op, userID, objectiveID string, t reflect.Type, prefix string, patch []byte, check func(o Objective) error) error {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return fmt.Errorf("Invalid merge patch: %v: %w", err, ErrInvalidValue)
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return fmt.Errorf("Merge patch is not an object: %w", ErrInvalidValue)
+	}
+	var mask FieldMask
+	values := map[string]interface{}{}
+	if err := mergePatchFields(t, prefix, p, &mask, values); err != nil {
+		return err
+	}
+	if len(mask) == 0 {
+		return nil
+	}
+	return s.updateObjectiveFields(op, userID, objectiveID, mask, values, check)
+}
+
+// PatchObjective applies a JSON merge patch to an objective of a user, so
+// that clients can change single fields without overwriting concurrent
+// changes to others. Only the patched fields are written. The schema
+// version cannot be patched.
+func (s Storage) PatchObjective(userID, objectiveID string, patch []byte) error {
+	return s.patchObjectiveFields("PatchObjective", userID, objectiveID, reflect.TypeOf(Objective{}), "", patch, nil)
+}
+
+// PatchGoal applies a JSON merge patch to a goal of a user.
+func (s Storage) PatchGoal(userID, objectiveID, goalID string, patch []byte) error {
+	if goalID == "" || strings.Contains(goalID, ".") {
+		return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	return s.patchObjectiveFields("PatchGoal", userID, objectiveID, reflect.TypeOf(Goal{}), "goals."+goalID, patch, func(o Objective) error {
+		if _, ok := o.Goals[goalID]; !ok {
+			return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,45 @@
+package pursuit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatchFields(t *testing.T) {
+	patch := map[string]interface{}{
+		"Description": nil,
+		"Goals": map[string]interface{}{
+			"run":  map[string]interface{}{"Target": 20.0, "Mute": nil},
+			"swim": nil,
+		},
+	}
+	var mask FieldMask
+	values := map[string]interface{}{}
+
+	if err := mergePatchFields(reflect.TypeOf(Objective{}), "", patch, &mask, values); err != nil {
+		t.Fatal(err)
+	}
+
+	wantMask := FieldMask{"description", "goals.run.mute", "goals.run.target", "goals.swim"}
+	if !reflect.DeepEqual(mask, wantMask) {
+		t.Errorf("mask was %v; wanted %v", mask, wantMask)
+	}
+	if !reflect.DeepEqual(values, map[string]interface{}{"goals.run.target": 20.0}) {
+		t.Errorf("values were %v", values)
+	}
+}
+
+func TestMergePatchFieldsInvalid(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"unknown field": {"Color": "red"},
+		"dotted key":    {"Goals": map[string]interface{}{"a.b": nil}},
+	}
+	for name, patch := range tests {
+		var mask FieldMask
+		err := mergePatchFields(reflect.TypeOf(Objective{}), "", patch, &mask, map[string]interface{}{})
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("%s: error was %v; wanted ErrInvalidValue", name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// MetricExport for Firestore serialization/deserialization. A metric
+// export continuously sends the values of all goals of a user to an
+// InfluxDB bucket, so that they can be charted e.g. in Grafana. Metric
+// exports are stored in users/{user}/metricExports.
+type MetricExport struct {
+	// URL of the InfluxDB v2 write endpoint, including the organization
+	// and bucket, e.g.
+	// https://influx.example.com/api/v2/write?org=me&bucket=pursuit.
+	URL   string `firestore:"url" json:"url"`
+	Token string `firestore:"token" json:"-"`
+	// Exported is the date of the latest event that was exported, in
+	// milliseconds since the epoch.
+	Exported int64 `firestore:"exported" json:"exported"`
+}
+
+// MetricExportEntry is a metric export together with the user that
+// configured it.
+type MetricExportEntry struct {
+	User string `json:"user"`
+	ID   string `json:"id"`
+	MetricExport
+}
+
+// escapeTag escapes a tag value for the InfluxDB line protocol.
+var escapeTag = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace
+
+// lineProtocol formats events as points of the measurement pursuit in the
+// InfluxDB line protocol, tagged by environment, user, objective and goal,
+// with timestamps in milliseconds.
+func lineProtocol(env, userID string, events []EventEntry) []byte {
+	var b bytes.Buffer
+	for _, e := range events {
+		fmt.Fprintf(&b, "pursuit,env=%s,user=%s,objective=%s,goal=%s value=%s %d\n",
+			escapeTag(env), escapeTag(userID), escapeTag(e.Objective), escapeTag(e.Goal),
+			strconv.FormatFloat(float64(e.Value), 'g', -1, 32), e.Date)
+	}
+	return b.Bytes()
+}
+
+// exportMetrics writes the events to the InfluxDB endpoint of the export.
+func exportMetrics(client *http.Client, env, userID string, m MetricExport, events []EventEntry) error {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("precision", "ms")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(lineProtocol(env, userID, events)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+m.Token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Error exporting metrics to %s: %s", u.Host, resp.Status)
+	}
+	return nil
+}
+
+// ListMetricExports returns the metric exports configured by all users.
+func (s Storage) ListMetricExports() ([]MetricExportEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListMetricExports", func(ctx context.Context) (err error) {
+		docs, err = s.client.CollectionGroup("metricExports").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing metric exports: %w", err)
+	}
+	exports := make([]MetricExportEntry, 0, len(docs))
+	for _, doc := range docs {
+		if !s.inNamespace(doc.Ref) {
+			continue
+		}
+		var m MetricExport
+		if err := doc.DataTo(&m); err != nil {
+			return nil, fmt.Errorf("Error reading metric export %q: %w", doc.Ref.ID, err)
+		}
+		exports = append(exports, MetricExportEntry{doc.Ref.Parent.Parent.ID, doc.Ref.ID, m})
+	}
+	return exports, nil
+}
+
+// ExportMetrics sends the events of the user since the previous run to the
+// export, and remembers the latest exported event. It returns the number
+// of exported events.
+func (s Storage) ExportMetrics(client *http.Client, m MetricExportEntry) (int, error) {
+	events, err := s.ListEvents(m.User, EventFilter{Since: m.Exported + 1})
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	if err := exportMetrics(client, s.Environment(), m.User, m.MetricExport, events); err != nil {
+		return 0, err
+	}
+	ref := s.collection("users").Doc(m.User).Collection("metricExports").Doc(m.ID)
+	err = s.do("ExportMetrics", func(ctx context.Context) error {
+		_, err := ref.Update(ctx, []firestore.Update{
+			{Path: "exported", Value: events[len(events)-1].Date},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error updating metric export: %w", err)
+	}
+	return len(events), nil
+}
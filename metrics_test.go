@@ -0,0 +1,63 @@
+package pursuit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLineProtocol(t *testing.T) {
+	events := []EventEntry{
+		{"a", Event{Objective: "o", Goal: "g", Value: 1.5, Date: 1000}},
+		{"b", Event{Objective: "my objective", Goal: "a=b,c", Value: 2, Date: 2000}},
+	}
+
+	got := string(lineProtocol("prod", "u", events))
+
+	want := "pursuit,env=prod,user=u,objective=o,goal=g value=1.5 1000\n" +
+		`pursuit,env=prod,user=u,objective=my\ objective,goal=a\=b\,c value=2 2000` + "\n"
+	if got != want {
+		t.Errorf("line protocol was\n%s\nwanted\n%s", got, want)
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	var body, precision, authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		precision = r.URL.Query().Get("precision")
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	m := MetricExport{URL: srv.URL + "/api/v2/write?org=me&bucket=pursuit", Token: "secret"}
+	events := []EventEntry{{"a", Event{Objective: "o", Goal: "g", Value: 3, Date: 1000}}}
+
+	if err := exportMetrics(srv.Client(), "prod", "u", m, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if body != "pursuit,env=prod,user=u,objective=o,goal=g value=3 1000\n" {
+		t.Errorf("body was %q", body)
+	}
+	if precision != "ms" {
+		t.Errorf("precision was %q; wanted ms", precision)
+	}
+	if authorization != "Token secret" {
+		t.Errorf("authorization was %q", authorization)
+	}
+}
+
+func TestExportMetricsFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	events := []EventEntry{{"a", Event{Objective: "o", Goal: "g", Value: 3, Date: 1000}}}
+
+	if err := exportMetrics(srv.Client(), "prod", "u", MetricExport{URL: srv.URL}, events); err == nil {
+		t.Errorf("export succeeded despite an error response")
+	}
+}
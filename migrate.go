@@ -0,0 +1,253 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Migration upgrades the raw data of an objective document from the
+// previous schema version to Version. Migrations work on raw data rather
+// than Objective so that they can change the types of fields.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(data map[string]interface{}) error
+}
+
+// migrations lists all migrations in order of their versions.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Record the schema version of objectives",
+		Apply:       func(data map[string]interface{}) error { return nil },
+	},
+}
+
+// LatestSchemaVersion is the schema version that new objectives have.
+var LatestSchemaVersion = migrations[len(migrations)-1].Version
+
+// ParseSchemaVersion parses versions like "v2" or "2".
+func ParseSchemaVersion(s string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || v < 1 || v > LatestSchemaVersion {
+		return 0, fmt.Errorf("Invalid schema version %q, must be between v1 and v%d", s, LatestSchemaVersion)
+	}
+	return v, nil
+}
+
+// migrateData applies all migrations that are needed to upgrade the data
+// to the given version. It reports whether the data was changed.
+func migrateData(data map[string]interface{}, to int) (bool, error) {
+	from := schemaVersion(data)
+	if from >= to {
+		return false, nil
+	}
+	for _, m := range migrations {
+		if m.Version <= from || m.Version > to {
+			continue
+		}
+		if err := m.Apply(data); err != nil {
+			return false, fmt.Errorf("Error migrating to v%d: %w", m.Version, err)
+		}
+		data["schemaVersion"] = int64(m.Version)
+	}
+	return true, nil
+}
+
+func schemaVersion(data map[string]interface{}) int {
+	v, _ := data["schemaVersion"].(int64)
+	return int(v)
+}
+
+// MigrateOptions configure a bulk migration.
+type MigrateOptions struct {
+	To int
+	// Workers is the number of users migrated in parallel.
+	Workers int
+	// Rate limits the number of objectives written per second. Zero means
+	// no limit.
+	Rate float64
+	// PageSize is the number of users migrated between checkpoints.
+	PageSize int
+}
+
+// MigrationCheckpoint for Firestore serialization/deserialization. It
+// records the progress of a bulk migration, so that an interrupted
+// migration resumes after the last completed page of users.
+type MigrationCheckpoint struct {
+	Cursor   string `firestore:"cursor" json:"cursor"`
+	Users    int64  `firestore:"users" json:"users"`
+	Migrated int64  `firestore:"migrated" json:"migrated"`
+	Done     bool   `firestore:"done" json:"done"`
+	Updated  int64  `firestore:"updated" json:"updated"`
+}
+
+// Migrate upgrades the objectives of all users to the schema version in
+// the options. Progress is checkpointed in the migrations collection after
+// every page of users and reported to the progress function.
+func (s Storage) Migrate(opts MigrateOptions, progress func(MigrationCheckpoint)) error {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.PageSize < 1 {
+		opts.PageSize = 100
+	}
+	checkpointRef := s.collection("migrations").Doc(fmt.Sprintf("v%d", opts.To))
+	var checkpoint MigrationCheckpoint
+	err := s.do("Migrate", func(ctx context.Context) error {
+		doc, err := checkpointRef.Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return doc.DataTo(&checkpoint)
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading checkpoint: %w", err)
+	}
+	if checkpoint.Done {
+		progress(checkpoint)
+		return nil
+	}
+
+	limiter := newRateLimiter(opts.Rate)
+	defer limiter.stop()
+	for {
+		q := s.collection("users").OrderBy(firestore.DocumentID, firestore.Asc).Limit(opts.PageSize)
+		if checkpoint.Cursor != "" {
+			q = q.StartAfter(checkpoint.Cursor)
+		}
+		var users []*firestore.DocumentSnapshot
+		err := s.do("Migrate", func(ctx context.Context) (err error) {
+			users, err = q.Documents(ctx).GetAll()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error listing users: %w", err)
+		}
+
+		migrated, err := s.migrateUsers(users, opts, limiter)
+		if err != nil {
+			return err
+		}
+		checkpoint.Users += int64(len(users))
+		checkpoint.Migrated += migrated
+		checkpoint.Done = len(users) < opts.PageSize
+		if len(users) > 0 {
+			checkpoint.Cursor = users[len(users)-1].Ref.ID
+		}
+		checkpoint.Updated = time.Now().UnixNano() / 1000 / 1000
+		err = s.do("Migrate", func(ctx context.Context) error {
+			_, err := checkpointRef.Set(ctx, checkpoint)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error writing checkpoint: %w", err)
+		}
+		progress(checkpoint)
+		if checkpoint.Done {
+			return nil
+		}
+	}
+}
+
+// migrateUsers migrates the objectives of the users in parallel and
+// returns the number of migrated objectives.
+func (s Storage) migrateUsers(users []*firestore.DocumentSnapshot, opts MigrateOptions, limiter *rateLimiter) (int64, error) {
+	var migrated int64
+	var firstErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	userIDs := make(chan string)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range userIDs {
+				n, err := s.migrateUser(userID, opts.To, limiter)
+				atomic.AddInt64(&migrated, n)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, u := range users {
+		userIDs <- u.Ref.ID
+	}
+	close(userIDs)
+	wg.Wait()
+	return migrated, firstErr
+}
+
+func (s Storage) migrateUser(userID string, to int, limiter *rateLimiter) (int64, error) {
+	var refs []*firestore.DocumentRef
+	err := s.do("Migrate", func(ctx context.Context) (err error) {
+		refs, err = s.collection("users").Doc(userID).Collection("objectives").DocumentRefs(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error listing objectives of user %q: %w", userID, err)
+	}
+	var migrated int64
+	for _, ref := range refs {
+		limiter.wait()
+		changed := false
+		err := s.transaction("Migrate", func(tx *firestore.Transaction) error {
+			doc, err := tx.Get(ref)
+			if err != nil {
+				return err
+			}
+			data := doc.Data()
+			changed, err = migrateData(data, to)
+			if err != nil || !changed {
+				return err
+			}
+			return tx.Set(ref, data)
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("Error migrating objective %q of user %q: %w", ref.ID, userID, err)
+		}
+		if changed {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// rateLimiter spaces out operations evenly at a given rate per second.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{time.NewTicker(time.Duration(float64(time.Second) / rate))}
+}
+
+func (l *rateLimiter) wait() {
+	if l.ticker != nil {
+		<-l.ticker.C
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,82 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+// withMigrations replaces the migrations until the returned function is
+// called.
+func withMigrations(ms []Migration) func() {
+	saved, savedLatest := migrations, LatestSchemaVersion
+	migrations, LatestSchemaVersion = ms, ms[len(ms)-1].Version
+	return func() {
+		migrations, LatestSchemaVersion = saved, savedLatest
+	}
+}
+
+func TestMigrateData(t *testing.T) {
+	var applied []int
+	record := func(v int) func(map[string]interface{}) error {
+		return func(map[string]interface{}) error {
+			applied = append(applied, v)
+			return nil
+		}
+	}
+	defer withMigrations([]Migration{
+		{Version: 1, Apply: record(1)},
+		{Version: 2, Apply: record(2)},
+		{Version: 3, Apply: record(3)},
+	})()
+	data := map[string]interface{}{"schemaVersion": int64(1)}
+
+	changed, err := migrateData(data, 2)
+
+	if err != nil || !changed {
+		t.Fatalf("wanted change without error, got %v, %v", changed, err)
+	}
+	if len(applied) != 1 || applied[0] != 2 {
+		t.Errorf("applied migrations %v; wanted [2]", applied)
+	}
+	if data["schemaVersion"] != int64(2) {
+		t.Errorf("schema version was %v; wanted 2", data["schemaVersion"])
+	}
+}
+
+func TestMigrateDataUpToDate(t *testing.T) {
+	data := map[string]interface{}{"schemaVersion": int64(LatestSchemaVersion)}
+
+	changed, err := migrateData(data, LatestSchemaVersion)
+
+	if err != nil || changed {
+		t.Errorf("wanted no change, got %v, %v", changed, err)
+	}
+}
+
+func TestMigrateDataError(t *testing.T) {
+	defer withMigrations([]Migration{
+		{Version: 1, Apply: func(map[string]interface{}) error { return errors.New("broken") }},
+	})()
+	data := map[string]interface{}{}
+
+	_, err := migrateData(data, 1)
+
+	if err == nil {
+		t.Errorf("wanted error, got none")
+	}
+	if _, ok := data["schemaVersion"]; ok {
+		t.Errorf("schema version was recorded despite the error")
+	}
+}
+
+func TestParseSchemaVersion(t *testing.T) {
+	if v, err := ParseSchemaVersion("v1"); v != 1 || err != nil {
+		t.Errorf("parsed v1 as %d, %v", v, err)
+	}
+	if _, err := ParseSchemaVersion("v0"); err == nil {
+		t.Errorf("wanted error for v0, got none")
+	}
+	if _, err := ParseSchemaVersion("latest"); err == nil {
+		t.Errorf("wanted error for latest, got none")
+	}
+}
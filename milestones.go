@@ -0,0 +1,248 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// EventGoalMilestone is emitted when the progress of a goal reaches a
+// milestone for the first time.
+const EventGoalMilestone = "goal.milestone"
+
+// Milestones are the percentages of the target that are notified when the
+// progress of a goal reaches them, in ascending order.
+var Milestones = []int{25, 50, 75, 100}
+
+// reachedMilestone returns the highest milestone that the progress of the
+// goal reached, or 0 if none.
+func (g Goal) reachedMilestone() int {
+	p := g.Progress()
+	if math.IsNaN(float64(p)) || math.IsInf(float64(p), 0) {
+		return 0
+	}
+	reached := 0
+	for _, m := range Milestones {
+		// Rounding keeps 0.75 from falling short of 75%.
+		if math.Round(float64(p)*1000) >= float64(m*10) {
+			reached = m
+		}
+	}
+	return reached
+}
+
+// updateMilestone records the highest milestone that the goal reached,
+// and returns it if it is higher than the one recorded before, or 0.
+// Milestones are not notified twice, even if the progress drops and
+// rises again.
+func (g *Goal) updateMilestone() int {
+	m := g.reachedMilestone()
+	if m <= g.Milestone {
+		return 0
+	}
+	g.Milestone = m
+	return m
+}
+
+// newMilestoneEvent describes that a goal reached a milestone with its
+// latest value.
+func newMilestoneEvent(objectiveID, goalID string, g Goal, milestone int) Event {
+	e := newGoalEvent(EventGoalMilestone, objectiveID, goalID, g, 0)
+	e.Milestone = milestone
+	return e
+}
+
+// NotifyMilestones calls f with the events of goals that reach a
+// milestone or break a record, after the change was written. The server
+// uses it to send the events to the notification hooks of the objective.
+// It must be called before the storage is used.
+func (s *Storage) NotifyMilestones(f func(userID string, e Event)) {
+	s.milestones = f
+}
+
+// goalEvent is an event of a goal together with the goal, whose mute
+// decides whether the event is notified.
+type goalEvent struct {
+	goal  Goal
+	event Event
+}
+
+// notifyGoalEvent records and notifies the event of a goal that reached a
+// milestone or broke a record. It is not notified if the goal mutes
+// milestone notifications.
+func (s Storage) notifyGoalEvent(userID string, g Goal, e Event) {
+	s.recordEvent(userID, e)
+	if s.milestones != nil && !g.IsMuted(NotificationMilestone, e.Date) {
+		s.milestones(userID, e)
+	}
+}
+
+// NotificationHook for Firestore serialization/deserialization. A
+// notification hook is a webhook URL that a user registered for an
+// objective, to which milestone and record events of its goals are sent.
+// Hooks are stored in users/{user}/notificationHooks. The secret signs the
+// deliveries, see SignatureHeader.
+type NotificationHook struct {
+	Objective string `firestore:"objective" json:"objective"`
+	URL       string `firestore:"url" json:"url"`
+	Secret    string `firestore:"secret" json:"-"`
+	// Created in milliseconds since the epoch.
+	Created int64 `firestore:"created" json:"created"`
+}
+
+// NotificationHookEntry is a notification hook together with its ID.
+type NotificationHookEntry struct {
+	ID string `json:"id"`
+	NotificationHook
+}
+
+// CreateNotificationHook registers a webhook URL for an existing objective
+// of a user. It returns the secret that signs the deliveries, which
+// cannot be recovered later.
+func (s Storage) CreateNotificationHook(userID, objectiveID, url string) (string, NotificationHookEntry, error) {
+	if err := validateTarget(url); err != nil {
+		return "", NotificationHookEntry{}, err
+	}
+	if _, err := s.getObjective(userID, objectiveID); err != nil {
+		return "", NotificationHookEntry{}, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", NotificationHookEntry{}, err
+	}
+	h := NotificationHook{
+		Objective: objectiveID,
+		URL:       url,
+		Secret:    hex.EncodeToString(b),
+		Created:   time.Now().UnixNano() / 1000 / 1000,
+	}
+	ref := s.collection("users").Doc(userID).Collection("notificationHooks").NewDoc()
+	err := s.do("CreateNotificationHook", func(ctx context.Context) error {
+		_, err := ref.Create(ctx, h)
+		return err
+	})
+	if err != nil {
+		return "", NotificationHookEntry{}, fmt.Errorf("Error creating notification hook: %w", err)
+	}
+	return h.Secret, NotificationHookEntry{ref.ID, h}, nil
+}
+
+// ListNotificationHooks returns the notification hooks of an objective of
+// a user, without their secrets.
+func (s Storage) ListNotificationHooks(userID, objectiveID string) ([]NotificationHookEntry, error) {
+	q := s.collection("users").Doc(userID).Collection("notificationHooks").Where("objective", "==", objectiveID)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListNotificationHooks", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing notification hooks: %w", err)
+	}
+	hooks := make([]NotificationHookEntry, 0, len(docs))
+	for _, doc := range docs {
+		var h NotificationHook
+		if err := doc.DataTo(&h); err != nil {
+			return nil, fmt.Errorf("Error reading notification hook %q: %w", doc.Ref.ID, err)
+		}
+		hooks = append(hooks, NotificationHookEntry{doc.Ref.ID, h})
+	}
+	return hooks, nil
+}
+
+// DeleteNotificationHook deletes a notification hook of an objective of
+// a user.
+func (s Storage) DeleteNotificationHook(userID, objectiveID, id string) error {
+	ref := s.collection("users").Doc(userID).Collection("notificationHooks").Doc(id)
+	return s.transaction("DeleteNotificationHook", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such notification hook: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading notification hook: %w", err)
+		}
+		var h NotificationHook
+		if err := doc.DataTo(&h); err != nil {
+			return fmt.Errorf("Error reading notification hook: %w", err)
+		}
+		if h.Objective != objectiveID {
+			return fmt.Errorf("No such notification hook: %q: %w", id, ErrNotFound)
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// SignatureHeader carries the signature of a delivery to a notification
+// hook, in the form t=<timestamp>,v1=<signature>. The timestamp is in
+// seconds since the epoch, and the signature is the hex-encoded
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret of the hook.
+// Receivers should reject deliveries with old timestamps.
+const SignatureHeader = "X-Pursuit-Signature"
+
+// signDelivery returns the value of SignatureHeader for a body sent at
+// the given time.
+func signDelivery(secret string, body []byte, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverNotification sends an event to a notification hook, signed with
+// its secret.
+func deliverNotification(client *http.Client, h NotificationHook, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Pursuit-Event", e.Type)
+	req.Header.Set(SignatureHeader, signDelivery(h.Secret, b, time.Now()))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyMilestone sends the event of a goal that reached a milestone or
+// broke a record to the notification hooks of its objective. Each delivery runs as a
+// background job, which is retried if it fails. Failures are logged,
+// since notifications must not fail the change that triggered them.
+func (s *Server) notifyMilestone(userID string, e Event) {
+	hooks, err := s.storage.ListNotificationHooks(userID, e.Objective)
+	if err != nil {
+		logf(context.Background(), severityError, "Error listing notification hooks of user %q: %v", userID, err)
+		return
+	}
+	for _, h := range hooks {
+		h := h
+		_, err := s.jobs.enqueue("notification", userID, func(progress func(float64)) (interface{}, error) {
+			return nil, deliverNotification(s.webhooks, h.NotificationHook, e)
+		})
+		if err != nil {
+			logf(context.Background(), severityError, "Error queueing notification %q of user %q: %v", h.ID, userID, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package pursuit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateMilestone(t *testing.T) {
+	g := Goal{Target: 100, Trajectory: Trajectory{{Date: 1, Value: 0}, {Date: 2, Value: 30}}}
+	if m := g.updateMilestone(); m != 25 {
+		t.Errorf("milestone was %d; wanted 25", m)
+	}
+	g.Trajectory = append(g.Trajectory, DateValue{Date: 3, Value: 80})
+	if m := g.updateMilestone(); m != 75 {
+		t.Errorf("milestone was %d; wanted 75, skipping 50", m)
+	}
+	g.Trajectory = append(g.Trajectory, DateValue{Date: 4, Value: 40}, DateValue{Date: 5, Value: 76})
+	if m := g.updateMilestone(); m != 0 {
+		t.Errorf("milestone %d was notified again", m)
+	}
+	g.Trajectory = append(g.Trajectory, DateValue{Date: 6, Value: 100})
+	if m := g.updateMilestone(); m != 100 || g.Milestone != 100 {
+		t.Errorf("milestone was %d, recorded %d; wanted 100", m, g.Milestone)
+	}
+	if m := (&Goal{Target: 100}).updateMilestone(); m != 0 {
+		t.Errorf("goal without values reached milestone %d", m)
+	}
+}
+
+func TestDeliverNotification(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	h := NotificationHook{Objective: "fitness", URL: srv.URL, Secret: "s3cret"}
+	e := Event{Type: EventGoalMilestone, Objective: "fitness", Goal: "run", Value: 50, Date: 1, Milestone: 50}
+	if err := deliverNotification(srv.Client(), h, e); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"milestone":50`) {
+		t.Errorf("body was %s; wanted the milestone", body)
+	}
+	parts := strings.SplitN(signature, ",v1=", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") {
+		t.Fatalf("signature was %q", signature)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(strings.TrimPrefix(parts[0], "t=") + "."))
+	mac.Write(body)
+	if parts[1] != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature %q does not match the body", signature)
+	}
+}
+
+func TestSignDelivery(t *testing.T) {
+	got := signDelivery("key", []byte("{}"), time.Unix(1600000000, 0))
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("1600000000.{}"))
+	if want := "t=1600000000,v1=" + hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature was %q; wanted %q", got, want)
+	}
+}
+
+func TestMuteGoalHandlerAcceptsHookKinds(t *testing.T) {
+	s, goals := newMemoryServer()
+	put := func(body string) int {
+		r := httptest.NewRequest(http.MethodPut, "/users/alice/objectives/fitness/goals/run/mute", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.muteGoal(w, r, "alice", "fitness", "run")
+		return w.Code
+	}
+
+	if code := put(`{"kinds": ["milestone", "goal-hook"]}`); code != http.StatusNoContent {
+		t.Fatalf("status was %d", code)
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if g := o.Goals["run"]; !g.IsMuted(NotificationMilestone, 0) || !g.IsMuted(NotificationGoalHook, 0) || g.IsMuted(NotificationDigest, 0) {
+		t.Errorf("mute was %+v; wanted milestones and goal hooks muted", g.Mute)
+	}
+	if code := put(`{"kinds": ["hook"]}`); code != http.StatusBadRequest {
+		t.Errorf("unknown kind got status %d; wanted 400", code)
+	}
+}
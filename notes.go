@@ -0,0 +1,45 @@
+package pursuit
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// maxNoteCiphertext limits the size of the ciphertext of a private note,
+// in bytes.
+const maxNoteCiphertext = 16 << 10
+
+// EncryptedNote is a private note of a goal or a check-in, for details
+// that users would rather not reveal, such as about their health. Clients
+// encrypt notes with a key that never leaves them, see PrivateNotes in
+// pursuit.js, so that only the ciphertext is sent to and stored by the
+// server, which cannot read it.
+type EncryptedNote struct {
+	// KeyID identifies the key that the note is encrypted with, so that
+	// clients can tell notes apart that they cannot decrypt.
+	KeyID string `firestore:"keyId" json:"keyId"`
+	// Nonce and Ciphertext are base64, as produced by AES-GCM with a
+	// 96-bit nonce. The ciphertext includes the authentication tag.
+	Nonce      string `firestore:"nonce" json:"nonce"`
+	Ciphertext string `firestore:"ciphertext" json:"ciphertext"`
+}
+
+// validate checks that the note looks like the output of AES-GCM. The
+// server cannot check more than that.
+func (n EncryptedNote) validate() error {
+	if n.KeyID == "" {
+		return fmt.Errorf("Private note without key ID: %w", ErrInvalidValue)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(n.Nonce)
+	if err != nil || len(nonce) != 12 {
+		return fmt.Errorf("Private note with invalid nonce: %w", ErrInvalidValue)
+	}
+	if base64.StdEncoding.DecodedLen(len(n.Ciphertext)) > maxNoteCiphertext {
+		return fmt.Errorf("Private note larger than %d bytes: %w", maxNoteCiphertext, ErrInvalidValue)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(n.Ciphertext)
+	if err != nil || len(ciphertext) < 16 {
+		return fmt.Errorf("Private note with invalid ciphertext: %w", ErrInvalidValue)
+	}
+	return nil
+}
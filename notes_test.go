@@ -0,0 +1,36 @@
+package pursuit
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedNoteValidate(t *testing.T) {
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, 12))
+	ciphertext := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := (EncryptedNote{KeyID: "k1", Nonce: nonce, Ciphertext: ciphertext}).validate(); err != nil {
+		t.Errorf("valid note was rejected: %v", err)
+	}
+	for name, n := range map[string]EncryptedNote{
+		"missing key ID":         {Nonce: nonce, Ciphertext: ciphertext},
+		"short nonce":            {KeyID: "k1", Nonce: base64.StdEncoding.EncodeToString(make([]byte, 8)), Ciphertext: ciphertext},
+		"plaintext":              {KeyID: "k1", Nonce: nonce, Ciphertext: "my blood pressure is 120/80"},
+		"ciphertext without tag": {KeyID: "k1", Nonce: nonce, Ciphertext: base64.StdEncoding.EncodeToString(make([]byte, 8))},
+		"large ciphertext":       {KeyID: "k1", Nonce: nonce, Ciphertext: strings.Repeat("A", 4*maxNoteCiphertext/3+4)},
+	} {
+		if err := n.validate(); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("note with %s was accepted: %v", name, err)
+		}
+	}
+}
+
+func TestValidateObjectiveChecksPrivateNotes(t *testing.T) {
+	o := Objective{Name: "Health", Goals: map[string]Goal{
+		"weight": {Name: "Weight", Target: 70, PrivateNote: &EncryptedNote{KeyID: "k1", Ciphertext: "secret"}},
+	}}
+	if err := validateObjective(o); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("objective with an invalid private note was accepted: %v", err)
+	}
+}
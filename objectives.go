@@ -0,0 +1,164 @@
+package pursuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ErrAlreadyExists is wrapped by errors about documents that cannot be
+// created because they exist.
+var ErrAlreadyExists = errors.New("Already exists")
+
+// validateObjective rejects objectives that violate the invariants that
+// fsck checks. Zero targets are allowed, as fsck only reports them.
+func validateObjective(o Objective) error {
+	if o.Name == "" {
+		return fmt.Errorf("Missing name: %w", ErrInvalidValue)
+	}
+	if err := validateCheckInQuestions(o.CheckInQuestions); err != nil {
+		return err
+	}
+	goals := make(map[string]Goal, len(o.Goals))
+	for id, g := range o.Goals {
+		if id == "" {
+			return fmt.Errorf("Missing goal ID: %w", ErrInvalidValue)
+		}
+		if !validStage(g.Stage) {
+			return fmt.Errorf("Goal %q: unknown stage %q: %w", id, g.Stage, ErrInvalidValue)
+		}
+		if !validSourcePolicy(g.SourcePolicy) {
+			return fmt.Errorf("Goal %q: unknown source policy %q: %w", id, g.SourcePolicy, ErrInvalidValue)
+		}
+		if g.PrivateNote != nil {
+			if err := g.PrivateNote.validate(); err != nil {
+				return fmt.Errorf("Goal %q: %w", id, err)
+			}
+		}
+		goals[id] = g
+	}
+	// CheckObjective writes to the goals, which belong to the caller.
+	o.Goals = goals
+	for _, p := range CheckObjective(&o, false) {
+		if p.Kind != ProblemZeroTarget {
+			return fmt.Errorf("Goal %q: %s: %w", p.Goal, p.Detail, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// GetObjective returns an objective of a user.
+func (s Storage) GetObjective(userID, objectiveID string) (Objective, error) {
+	o, err := s.getObjective(userID, objectiveID)
+	if err != nil {
+		return Objective{}, err
+	}
+	if _, err := s.loadTrajectories(userID, objectiveID, &o); err != nil {
+		return Objective{}, err
+	}
+	return o, nil
+}
+
+// getObjective returns an objective of a user as stored, without the
+// values in trajectory subcollections.
+func (s Storage) getObjective(userID, objectiveID string) (Objective, error) {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	var o Objective
+	err := s.do("GetObjective", func(ctx context.Context) error {
+		doc, err := ref.Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		return doc.DataTo(&o)
+	})
+	return o, err
+}
+
+// CreateObjective stores a new objective of a user, at the latest schema
+// version.
+func (s Storage) CreateObjective(userID, objectiveID string, o Objective) error {
+	if err := validateObjective(o); err != nil {
+		return err
+	}
+	o.SchemaVersion = LatestSchemaVersion
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	return s.transaction("CreateObjective", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return tx.Create(ref, o)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		return fmt.Errorf("Objective %q: %w", objectiveID, ErrAlreadyExists)
+	})
+}
+
+// UpdateObjective replaces an existing objective of a user, at the latest
+// schema version. The slugs of the objective and its goals are kept.
+func (s Storage) UpdateObjective(userID, objectiveID string, o Objective) error {
+	if err := validateObjective(o); err != nil {
+		return err
+	}
+	o.SchemaVersion = LatestSchemaVersion
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	return s.transaction("UpdateObjective", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var stored Objective
+		if err := doc.DataTo(&stored); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		keepSlugs(&o, stored)
+		if s.trajectories {
+			keepInlineTrajectories(&o, stored)
+		}
+		return tx.Set(ref, o)
+	})
+}
+
+// DeleteObjective deletes an objective of a user, together with the
+// trajectories of its goals, its check-ins, the edits of its description
+// and its entry in the slug index.
+func (s Storage) DeleteObjective(userID, objectiveID string) error {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	err := s.transaction("DeleteObjective", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if o.Slug != "" {
+			if err := tx.Delete(s.slugRef(userID, o.Slug)); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.deleteCheckIns(userID, objectiveID); err != nil {
+		return err
+	}
+	if err := s.deleteDescriptionEdits(userID, objectiveID); err != nil {
+		return err
+	}
+	return s.deleteTrajectories(userID, objectiveID, "")
+}
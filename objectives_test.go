@@ -0,0 +1,35 @@
+package pursuit
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestValidateObjective(t *testing.T) {
+	valid := Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run":  {Name: "Run", Target: 1000, Trajectory: Trajectory{{Date: 1, Value: 1}, {Date: 2, Value: 3}}},
+		"swim": {Name: "Swim"},
+	}}
+	if err := validateObjective(valid); err != nil {
+		t.Errorf("valid objective was rejected: %v", err)
+	}
+
+	tests := map[string]Objective{
+		"missing name": {Goals: map[string]Goal{}},
+		"empty goal ID": {Name: "Fitness", Goals: map[string]Goal{
+			"": {Name: "Run"},
+		}},
+		"unsorted trajectory": {Name: "Fitness", Goals: map[string]Goal{
+			"run": {Target: 1, Trajectory: Trajectory{{Date: 2, Value: 1}, {Date: 1, Value: 3}}},
+		}},
+		"non-finite value": {Name: "Fitness", Goals: map[string]Goal{
+			"run": {Target: 1, Trajectory: Trajectory{{Date: 1, Value: float32(math.NaN())}}},
+		}},
+	}
+	for name, o := range tests {
+		if err := validateObjective(o); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("%s: error was %v; wanted ErrInvalidValue", name, err)
+		}
+	}
+}
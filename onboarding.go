@@ -0,0 +1,207 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// onboardingWindow is how long after its day a step may still be sent,
+// so that a daily run does not miss it, but users who signed up long ago
+// do not receive a backlog of steps.
+const onboardingWindow = 2 * day
+
+// OnboardingStep is a push notification that is sent to new users a
+// number of days after they signed up, unless it no longer applies.
+type OnboardingStep struct {
+	ID    string
+	Day   int
+	Title string
+	Body  string
+	// Applies reports whether the step is still useful to the user.
+	Applies func(u OnboardingUser) bool
+}
+
+// OnboardingUser is what steps know about a user.
+type OnboardingUser struct {
+	ID string
+	// Created in milliseconds since the epoch.
+	Created    int64
+	Objectives []ObjectiveEntry
+	Devices    []DeviceEntry
+}
+
+// OnboardingState is stored in the profile of a user, in the field
+// onboarding, to remember which steps were sent and whether the user
+// opted out.
+type OnboardingState struct {
+	Sent   []string `firestore:"sent,omitempty" json:"sent,omitempty"`
+	OptOut bool     `firestore:"optOut,omitempty" json:"optOut,omitempty"`
+}
+
+var onboardingSteps = []OnboardingStep{
+	{
+		ID:    "first-value",
+		Day:   1,
+		Title: "Log your first value",
+		Body:  "Record where you stand today to start your trajectory.",
+		Applies: func(u OnboardingUser) bool {
+			for _, o := range u.Objectives {
+				for _, g := range o.Goals {
+					if len(g.Trajectory) > 1 {
+						return false
+					}
+				}
+			}
+			return true
+		},
+	},
+	{
+		ID:    "plan",
+		Day:   3,
+		Title: "Plan your weeks",
+		Body:  "Add a weekly plan to your goals to know whether you are on track.",
+		Applies: func(u OnboardingUser) bool {
+			for _, o := range u.Objectives {
+				for _, g := range o.Goals {
+					if len(g.Plan) > 0 {
+						return false
+					}
+				}
+			}
+			return true
+		},
+	},
+}
+
+// dueOnboardingSteps returns the steps that should be sent to the user
+// at the given date.
+func dueOnboardingSteps(steps []OnboardingStep, u OnboardingUser, state OnboardingState, now int64) []OnboardingStep {
+	if state.OptOut {
+		return nil
+	}
+	sent := map[string]bool{}
+	for _, id := range state.Sent {
+		sent[id] = true
+	}
+	var due []OnboardingStep
+	for _, step := range steps {
+		at := u.Created + int64(step.Day)*day
+		if sent[step.ID] || now < at || now >= at+onboardingWindow {
+			continue
+		}
+		if step.Applies(u) {
+			due = append(due, step)
+		}
+	}
+	return due
+}
+
+// onboardingAge is the age of accounts after which no steps are sent.
+func onboardingAge(steps []OnboardingStep) int64 {
+	var max int64
+	for _, step := range steps {
+		if d := int64(step.Day)*day + onboardingWindow; d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// RunOnboarding sends the due onboarding steps to all new users who did
+// not opt out, and reports how many notifications were sent.
+func (s Storage) RunOnboarding(push PushSender) (int, error) {
+	now := time.Now().UnixNano() / 1000 / 1000
+	since := time.Unix(0, (now-onboardingAge(onboardingSteps))*int64(time.Millisecond))
+	var users []*firestore.DocumentSnapshot
+	err := s.do("RunOnboarding", func(ctx context.Context) (err error) {
+		users, err = s.collection("users").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error listing users: %w", err)
+	}
+	sent := 0
+	for _, doc := range users {
+		if doc.CreateTime.Before(since) {
+			continue
+		}
+		var profile struct {
+			MergedInto string          `firestore:"mergedInto" json:"mergedInto"`
+			Onboarding OnboardingState `firestore:"onboarding" json:"onboarding"`
+		}
+		if err := doc.DataTo(&profile); err != nil {
+			logf(s.ctx, severityError, "Error reading profile of user %q: %v", doc.Ref.ID, err)
+			continue
+		}
+		if profile.MergedInto != "" || profile.Onboarding.OptOut {
+			continue
+		}
+		n, err := s.onboardUser(doc.Ref.ID, doc.CreateTime, profile.Onboarding, push, now)
+		if err != nil {
+			logf(s.ctx, severityError, "Error onboarding user %q: %v", doc.Ref.ID, err)
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+func (s Storage) onboardUser(userID string, created time.Time, state OnboardingState, push PushSender, now int64) (int, error) {
+	objectives, err := s.ListObjectives(userID)
+	if err != nil {
+		return 0, err
+	}
+	devices, err := s.ListDevices(userID)
+	if err != nil {
+		return 0, err
+	}
+	if len(devices) == 0 {
+		return 0, nil
+	}
+	tokens := make([]string, len(devices))
+	for i, d := range devices {
+		tokens[i] = d.Token
+	}
+	u := OnboardingUser{
+		ID:         userID,
+		Created:    created.UnixNano() / 1000 / 1000,
+		Objectives: objectives,
+		Devices:    devices,
+	}
+	sent := 0
+	for _, step := range dueOnboardingSteps(onboardingSteps, u, state, now) {
+		if err := push.Push(tokens, step.Title, step.Body); err != nil {
+			return sent, err
+		}
+		sent++
+		ref := s.collection("users").Doc(userID)
+		err := s.do("onboardUser", func(ctx context.Context) error {
+			_, err := ref.Update(ctx, []firestore.Update{
+				{Path: "onboarding.sent", Value: firestore.ArrayUnion(step.ID)},
+			})
+			return err
+		})
+		if err != nil {
+			return sent, fmt.Errorf("Error recording onboarding step %q: %w", step.ID, err)
+		}
+	}
+	return sent, nil
+}
+
+// SetOnboardingOptOut stops or resumes onboarding notifications for a
+// user.
+func (s Storage) SetOnboardingOptOut(userID string, optOut bool) error {
+	ref := s.collection("users").Doc(userID)
+	err := s.do("SetOnboardingOptOut", func(ctx context.Context) error {
+		_, err := ref.Set(ctx, map[string]interface{}{
+			"onboarding": map[string]interface{}{"optOut": optOut},
+		}, firestore.MergeAll)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating profile: %w", err)
+	}
+	return nil
+}
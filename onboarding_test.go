@@ -0,0 +1,80 @@
+package pursuit
+
+import "testing"
+
+func onboardingTestSteps() []OnboardingStep {
+	always := func(OnboardingUser) bool { return true }
+	return []OnboardingStep{
+		{ID: "a", Day: 1, Applies: always},
+		{ID: "b", Day: 3, Applies: func(u OnboardingUser) bool { return len(u.Objectives) == 0 }},
+	}
+}
+
+func stepIDs(steps []OnboardingStep) []string {
+	ids := []string{}
+	for _, s := range steps {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestDueOnboardingSteps(t *testing.T) {
+	u := OnboardingUser{Created: 0}
+
+	for _, c := range []struct {
+		now  int64
+		want []string
+	}{
+		{0, []string{}},
+		{day, []string{"a"}},
+		{3 * day, []string{"b"}},
+		{6 * day, []string{}},
+	} {
+		got := stepIDs(dueOnboardingSteps(onboardingTestSteps(), u, OnboardingState{}, c.now))
+		if len(got) != len(c.want) || (len(got) > 0 && got[0] != c.want[0]) {
+			t.Errorf("due steps at day %d were %v; wanted %v", c.now/day, got, c.want)
+		}
+	}
+}
+
+func TestDueOnboardingStepsSkipsSentAndInapplicable(t *testing.T) {
+	u := OnboardingUser{Created: 0, Objectives: []ObjectiveEntry{{ID: "o"}}}
+
+	if due := dueOnboardingSteps(onboardingTestSteps(), u, OnboardingState{Sent: []string{"a"}}, day); len(due) != 0 {
+		t.Errorf("sent step was due again: %v", stepIDs(due))
+	}
+	if due := dueOnboardingSteps(onboardingTestSteps(), u, OnboardingState{}, 3*day); len(due) != 0 {
+		t.Errorf("inapplicable step was due: %v", stepIDs(due))
+	}
+}
+
+func TestDueOnboardingStepsOptOut(t *testing.T) {
+	due := dueOnboardingSteps(onboardingTestSteps(), OnboardingUser{}, OnboardingState{OptOut: true}, day)
+
+	if len(due) != 0 {
+		t.Errorf("steps were due after opting out: %v", stepIDs(due))
+	}
+}
+
+func TestFirstValueStep(t *testing.T) {
+	step := onboardingSteps[0]
+	fresh := OnboardingUser{Objectives: []ObjectiveEntry{{"o", Objective{Goals: map[string]Goal{
+		"g": {Trajectory: Trajectory{{Date: 0, Value: 0}}},
+	}}}}}
+	logged := OnboardingUser{Objectives: []ObjectiveEntry{{"o", Objective{Goals: map[string]Goal{
+		"g": {Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 1, Value: 5}}},
+	}}}}}
+
+	if !step.Applies(fresh) {
+		t.Errorf("first value step did not apply to a fresh user")
+	}
+	if step.Applies(logged) {
+		t.Errorf("first value step applied after a value was logged")
+	}
+}
+
+func TestOnboardingAge(t *testing.T) {
+	if got := onboardingAge(onboardingTestSteps()); got != 5*day {
+		t.Errorf("onboarding age was %d days; wanted 5", got/day)
+	}
+}
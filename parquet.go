@@ -0,0 +1,251 @@
+package pursuit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+// This file writes trajectories as Parquet files, see
+// https://github.com/apache/parquet-format. Only what is needed for a
+// flat table of required columns is implemented: a single row group with
+// one uncompressed, plain-encoded data page per column.
+
+// Parquet physical and converted types, and other enum values of the
+// Parquet format.
+const (
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetRequired = 0
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetDataPage = 0
+)
+
+// TrajectoryRow is a point on the trajectory of a goal, as exported.
+type TrajectoryRow struct {
+	Objective string
+	Goal      string
+	Name      string
+	Unit      string
+	// Date in milliseconds since the epoch.
+	Date  int64
+	Value float32
+}
+
+// parquetColumn is a column of the exported table. Its values are plain
+// encoded.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	values    bytes.Buffer
+}
+
+// trajectoryRows flattens the trajectories of all goals, ordered by
+// objective, goal and date.
+func trajectoryRows(objectives []ObjectiveEntry) []TrajectoryRow {
+	var rows []TrajectoryRow
+	for _, o := range objectives {
+		for id, g := range o.Goals {
+			for _, p := range g.Trajectory {
+				rows = append(rows, TrajectoryRow{o.ID, id, g.Name, g.Unit, p.Date, p.Value})
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Objective != rows[j].Objective {
+			return rows[i].Objective < rows[j].Objective
+		}
+		if rows[i].Goal != rows[j].Goal {
+			return rows[i].Goal < rows[j].Goal
+		}
+		return rows[i].Date < rows[j].Date
+	})
+	return rows
+}
+
+// WriteParquet writes the rows as a Parquet file with the columns
+// objective, goal, name, unit, date and value. The schema is stable, so
+// that queries over exports keep working.
+func WriteParquet(w io.Writer, rows []TrajectoryRow) error {
+	columns := []*parquetColumn{
+		{name: "objective", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "goal", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "name", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "unit", typ: parquetByteArray, converted: parquetUTF8},
+		{name: "date", typ: parquetInt64, converted: parquetTimestampMillis},
+		{name: "value", typ: parquetFloat, converted: -1},
+	}
+	for _, r := range rows {
+		for i, s := range []string{r.Objective, r.Goal, r.Name, r.Unit} {
+			binary.Write(&columns[i].values, binary.LittleEndian, uint32(len(s)))
+			columns[i].values.WriteString(s)
+		}
+		binary.Write(&columns[4].values, binary.LittleEndian, r.Date)
+		binary.Write(&columns[5].values, binary.LittleEndian, math.Float32bits(r.Value))
+	}
+
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	meta := &thriftWriter{}
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		meta.begin()
+		meta.i32(1, c.typ)
+		meta.i32(3, parquetRequired)
+		meta.binary(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(len(rows)))
+	if len(rows) == 0 {
+		meta.list(4, thriftStruct, 0)
+	} else {
+		meta.list(4, thriftStruct, 1)
+		meta.begin()
+		meta.list(1, thriftStruct, len(columns))
+		var total int64
+		for _, c := range columns {
+			page := &thriftWriter{}
+			page.begin()
+			page.i32(1, parquetDataPage)
+			page.i32(2, int32(c.values.Len()))
+			page.i32(3, int32(c.values.Len()))
+			page.field(5, thriftStruct)
+			page.begin()
+			page.i32(1, int32(len(rows)))
+			page.i32(2, parquetPlain)
+			page.i32(3, parquetRLE)
+			page.i32(4, parquetRLE)
+			page.end()
+			page.end()
+
+			offset := int64(file.Len())
+			size := int64(page.buf.Len() + c.values.Len())
+			total += size
+			file.Write(page.buf.Bytes())
+			file.Write(c.values.Bytes())
+
+			meta.begin()
+			meta.i64(2, offset)
+			meta.field(3, thriftStruct)
+			meta.begin()
+			meta.i32(1, c.typ)
+			meta.list(2, thriftI32, 2)
+			meta.varint(zigzag(parquetPlain))
+			meta.varint(zigzag(parquetRLE))
+			meta.list(3, thriftBinary, 1)
+			meta.varint(uint64(len(c.name)))
+			meta.buf.WriteString(c.name)
+			meta.i32(4, 0)
+			meta.i64(5, int64(len(rows)))
+			meta.i64(6, size)
+			meta.i64(7, size)
+			meta.i64(9, offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(len(rows)))
+		meta.end()
+	}
+	meta.binary(6, "pursuit")
+	meta.end()
+
+	file.Write(meta.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which
+// Parquet uses for its metadata. Structs are written by calling begin,
+// then writing the fields in increasing order, then calling end.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// last holds the ID of the last field written in each open struct.
+	last []int16
+}
+
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// list writes the header of a list field. The elements follow without
+// field headers.
+func (w *thriftWriter) list(id int16, elem byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elem)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elem)
+	w.varint(uint64(size))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,70 @@
+package pursuit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestTrajectoryRows(t *testing.T) {
+	objectives := []ObjectiveEntry{{"o", Objective{Goals: map[string]Goal{
+		"b": {Name: "Swim", Trajectory: Trajectory{{Date: 1000, Value: 0}, {Date: 2000, Value: 1}}},
+		"a": {Name: "Run", Unit: "km", Trajectory: Trajectory{{Date: 3000, Value: 5}}},
+	}}}}
+
+	rows := trajectoryRows(objectives)
+
+	want := []TrajectoryRow{
+		{"o", "a", "Run", "km", 3000, 5},
+		{"o", "b", "Swim", "", 1000, 0},
+		{"o", "b", "Swim", "", 2000, 1},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows were %v; wanted %v", rows, want)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d was %v; wanted %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	var b bytes.Buffer
+	rows := []TrajectoryRow{{"o", "g", "Run", "km", 1000, 1.5}}
+
+	if err := WriteParquet(&b, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	data := b.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatalf("file does not start and end with the Parquet magic number")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if n <= 0 || n > len(data)-12 {
+		t.Fatalf("footer length %d does not fit into a file of %d bytes", n, len(data))
+	}
+	footer := data[len(data)-8-n : len(data)-8]
+	for _, column := range []string{"objective", "goal", "name", "unit", "date", "value"} {
+		if !bytes.Contains(footer, []byte(column)) {
+			t.Errorf("footer does not describe column %q", column)
+		}
+	}
+	// The name column holds a single plain-encoded string.
+	if !bytes.Contains(data[:len(data)-8-n], []byte{3, 0, 0, 0, 'R', 'u', 'n'}) {
+		t.Errorf("file does not contain the plain-encoded name")
+	}
+}
+
+func TestWriteParquetEmpty(t *testing.T) {
+	var b bytes.Buffer
+
+	if err := WriteParquet(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b.Bytes(), []byte("PAR1")) || !bytes.HasSuffix(b.Bytes(), []byte("PAR1")) {
+		t.Errorf("empty file is not a Parquet file")
+	}
+}
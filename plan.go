@@ -0,0 +1,61 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Plan lists the changes that a command would make, in the style of
+// terraform plan, so that they can be reviewed before they are applied.
+type Plan struct {
+	Add     []string
+	Change  []string
+	Destroy []string
+}
+
+// Empty reports whether the plan has no changes.
+func (p Plan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Change) == 0 && len(p.Destroy) == 0
+}
+
+func (p Plan) String() string {
+	if p.Empty() {
+		return "No changes.\n"
+	}
+	var b strings.Builder
+	for _, lines := range [][]string{p.Add, p.Change, p.Destroy} {
+		for _, l := range lines {
+			b.WriteString("  " + l + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "\nPlan: %d to add, %d to change, %d to destroy.\n", len(p.Add), len(p.Change), len(p.Destroy))
+	return b.String()
+}
+
+// PlanMigration lists the objectives that a migration to the given schema
+// version would change.
+func (s Storage) PlanMigration(to int) (Plan, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("PlanMigration", func(ctx context.Context) (err error) {
+		docs, err = s.client.CollectionGroup("objectives").Select("schemaVersion").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return Plan{}, fmt.Errorf("Error listing objectives: %w", err)
+	}
+	var plan Plan
+	for _, doc := range docs {
+		if !s.inNamespace(doc.Ref) {
+			continue
+		}
+		if from := schemaVersion(doc.Data()); from < to {
+			plan.Change = append(plan.Change, fmt.Sprintf("~ %s/%s: v%d -> v%d", doc.Ref.Parent.Parent.ID, doc.Ref.ID, from, to))
+		}
+	}
+	sort.Strings(plan.Change)
+	return plan, nil
+}
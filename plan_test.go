@@ -0,0 +1,46 @@
+package pursuit
+
+import "testing"
+
+func TestPlanString(t *testing.T) {
+	p := Plan{
+		Add:     []string{"+ fitness/swim"},
+		Change:  []string{"~ fitness/run: target: 900 -> 1000"},
+		Destroy: []string{"- fitness/walk (archive)"},
+	}
+	want := "  + fitness/swim\n" +
+		"  ~ fitness/run: target: 900 -> 1000\n" +
+		"  - fitness/walk (archive)\n" +
+		"\nPlan: 1 to add, 1 to change, 1 to destroy.\n"
+	if got := p.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestPlanEmpty(t *testing.T) {
+	var p Plan
+	if !p.Empty() {
+		t.Errorf("Empty() = false for %+v", p)
+	}
+	if got, want := p.String(), "No changes.\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestSpecPlanPlan(t *testing.T) {
+	p := SpecPlan{Changes: []SpecChange{
+		{Action: SpecArchive, Objective: "fitness", Goal: "walk"},
+		{Action: SpecCreate, Objective: "fitness", Goal: "swim"},
+		{Action: SpecUpdate, Objective: "fitness", Goal: "run", Fields: []string{"target: 900 -> 1000"}},
+	}}
+	plan := p.Plan()
+	if len(plan.Add) != 1 || plan.Add[0] != "+ fitness/swim" {
+		t.Errorf("Add = %q", plan.Add)
+	}
+	if len(plan.Change) != 1 || plan.Change[0] != "~ fitness/run: target: 900 -> 1000" {
+		t.Errorf("Change = %q", plan.Change)
+	}
+	if len(plan.Destroy) != 1 || plan.Destroy[0] != "- fitness/walk (archive)" {
+		t.Errorf("Destroy = %q", plan.Destroy)
+	}
+}
@@ -0,0 +1,150 @@
+package pursuit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StreamPresence is the type of the Server-Sent Events that carry the
+// Presence of an objective whenever its viewers change.
+const StreamPresence = "presence"
+
+// maxViewerName limits the length of the names of viewers.
+const maxViewerName = 64
+
+// Viewer is a client that has the stream of an objective open.
+type Viewer struct {
+	// ID is the ID of the user for the owner of the objective, and the ID
+	// of the share token for collaborators.
+	ID string `json:"id"`
+	// Name is how the viewer calls themselves, if they gave a name.
+	Name string `json:"name,omitempty"`
+	// Since in milliseconds since the epoch.
+	Since int64 `json:"since"`
+}
+
+// Presence lists the viewers of an objective, sorted by when they came.
+type Presence struct {
+	Objective string   `json:"objective"`
+	Viewers   []Viewer `json:"viewers"`
+}
+
+// presenceHub tracks the viewers of the objectives of users and notifies
+// the streams of a user of changes. It only knows the streams served by
+// this instance of the server, so presence is best effort when several
+// instances run.
+type presenceHub struct {
+	mu sync.Mutex
+	// viewers of each objective of each user.
+	viewers map[string]map[string][]*Viewer
+	// watchers of the presence of each user.
+	watchers map[string]map[*presenceWatcher]bool
+}
+
+// presenceWatcher collects the objectives whose presence changed until
+// its stream gets to send them. Notify signals that there are some.
+type presenceWatcher struct {
+	notify  chan struct{}
+	pending map[string]bool
+}
+
+func newPresenceHub() *presenceHub {
+	return &presenceHub{
+		viewers:  map[string]map[string][]*Viewer{},
+		watchers: map[string]map[*presenceWatcher]bool{},
+	}
+}
+
+// join adds a viewer to an objective of a user, and returns a function
+// that removes it again.
+func (h *presenceHub) join(userID, objectiveID string, v Viewer) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(v.Name) > maxViewerName {
+		v.Name = v.Name[:maxViewerName]
+	}
+	v.Since = time.Now().UnixNano() / 1000 / 1000
+	if h.viewers[userID] == nil {
+		h.viewers[userID] = map[string][]*Viewer{}
+	}
+	h.viewers[userID][objectiveID] = append(h.viewers[userID][objectiveID], &v)
+	h.changed(userID, objectiveID)
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		viewers := h.viewers[userID][objectiveID]
+		for i, w := range viewers {
+			if w == &v {
+				viewers = append(viewers[:i:i], viewers[i+1:]...)
+				break
+			}
+		}
+		if len(viewers) == 0 {
+			delete(h.viewers[userID], objectiveID)
+		} else {
+			h.viewers[userID][objectiveID] = viewers
+		}
+		if len(h.viewers[userID]) == 0 {
+			delete(h.viewers, userID)
+		}
+		h.changed(userID, objectiveID)
+	}
+}
+
+// changed notifies the watchers of a user that the viewers of an
+// objective changed. The caller holds the lock.
+func (h *presenceHub) changed(userID, objectiveID string) {
+	for w := range h.watchers[userID] {
+		w.pending[objectiveID] = true
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch starts to collect changes of the presence of the objectives of a
+// user, starting with the objectives that have viewers already, and
+// returns the watcher together with a function that stops it.
+func (h *presenceHub) watch(userID string) (*presenceWatcher, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w := &presenceWatcher{notify: make(chan struct{}, 1), pending: map[string]bool{}}
+	for objectiveID := range h.viewers[userID] {
+		w.pending[objectiveID] = true
+	}
+	if len(w.pending) > 0 {
+		w.notify <- struct{}{}
+	}
+	if h.watchers[userID] == nil {
+		h.watchers[userID] = map[*presenceWatcher]bool{}
+	}
+	h.watchers[userID][w] = true
+	return w, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.watchers[userID], w)
+		if len(h.watchers[userID]) == 0 {
+			delete(h.watchers, userID)
+		}
+	}
+}
+
+// take returns the current presence of the objectives that changed since
+// the last call, sorted by objective, and clears them.
+func (h *presenceHub) take(userID string, w *presenceWatcher) []Presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var presences []Presence
+	for objectiveID := range w.pending {
+		p := Presence{Objective: objectiveID, Viewers: []Viewer{}}
+		for _, v := range h.viewers[userID][objectiveID] {
+			p.Viewers = append(p.Viewers, *v)
+		}
+		presences = append(presences, p)
+	}
+	w.pending = map[string]bool{}
+	sort.Slice(presences, func(i, j int) bool { return presences[i].Objective < presences[j].Objective })
+	return presences
+}
@@ -0,0 +1,41 @@
+package pursuit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPresenceHub(t *testing.T) {
+	h := newPresenceHub()
+	leaveAlice := h.join("alice", "fitness", Viewer{ID: "alice"})
+	w, stop := h.watch("alice")
+	defer stop()
+
+	select {
+	case <-w.notify:
+	default:
+		t.Fatalf("watcher was not told about existing viewers")
+	}
+	if p := h.take("alice", w); len(p) != 1 || len(p[0].Viewers) != 1 || p[0].Viewers[0].ID != "alice" {
+		t.Errorf("presence was %+v; wanted alice viewing fitness", p)
+	}
+
+	leaveBob := h.join("alice", "fitness", Viewer{ID: "t1", Name: "Bob"})
+	h.join("carol", "reading", Viewer{ID: "carol"})
+	<-w.notify
+	p := h.take("alice", w)
+	if len(p) != 1 || p[0].Objective != "fitness" || len(p[0].Viewers) != 2 || p[0].Viewers[1].Name != "Bob" {
+		t.Errorf("presence was %+v; wanted alice and Bob viewing fitness", p)
+	}
+
+	leaveAlice()
+	leaveBob()
+	<-w.notify
+	want := []Presence{{Objective: "fitness", Viewers: []Viewer{}}}
+	if p := h.take("alice", w); !reflect.DeepEqual(p, want) {
+		t.Errorf("presence was %+v; wanted %+v", p, want)
+	}
+	if _, ok := h.viewers["alice"]; ok {
+		t.Errorf("viewers of alice were kept after everyone left")
+	}
+}
@@ -18,6 +18,7 @@
 
 let HOUR = 60 * 60 * 1000;
 let DAY = 24 * HOUR;
+let WEEK = 7 * DAY;
 
 class Objective {
   constructor({id, name, description, goals}) {
@@ -47,9 +48,23 @@ class Objective {
 const Stage = {
   DRAFT: 'draft',
   PLEDGED: 'pledged',
+  PAUSED: 'paused',
+  COMPLETED: 'completed',
   ARCHIVED: 'archived',
 };
 
+/**
+ * Determines how the values on the trajectory of a goal are combined into
+ * its current value. Cumulative goals use the latest value, personal
+ * records use the best value, and goals like a resting heart rate use the
+ * average of the values since the start of the goal.
+ */
+const Aggregation = {
+  LATEST: 'latest',
+  MAXIMUM: 'max',
+  AVERAGE: 'average',
+};
+
 class Goal {
 
   constructor({id = '',
@@ -59,7 +74,10 @@ class Goal {
                start = 0,
                end = 0,
                stage = Stage.PLEDGED,
-               trajectory = new Trajectory()}) {
+               aggregation = Aggregation.LATEST,
+               plan = [],
+               trajectory = new Trajectory(),
+               privateNote = null}) {
     this._id = id;
     this._name = name;
     this._unit = unit;
@@ -67,7 +85,10 @@ class Goal {
     this._start = start;
     this._end = end;
     this._stage = stage;
+    this._aggregation = aggregation;
+    this._plan = plan;
     this._trajectory = trajectory;
+    this._privateNote = privateNote;
   }
 
   get id() {
@@ -98,10 +119,58 @@ class Goal {
     return this._stage;
   }
 
+  get aggregation() {
+    return this._aggregation;
+  }
+
+  /**
+   * The amounts planned for each week since the start of the goal, e.g.
+   * [30, 35, 40, 25] for a training plan. If empty, linear pacing is
+   * assumed.
+   */
+  get plan() {
+    return this._plan;
+  }
+
   get trajectory() {
     return this._trajectory;
   }
 
+  /**
+   * The encrypted private note of the goal, if any, see PrivateNotes.
+   */
+  get privateNote() {
+    return this._privateNote;
+  }
+
+  get current() {
+    if (!this.trajectory.length) {
+      return undefined;
+    }
+
+    if (this.aggregation == Aggregation.MAXIMUM) {
+      let values = Array.from(this.trajectory)
+        .filter((p) => p.date > this.start)
+        .map((p) => p.value);
+      if (!values.length) {
+        return this.baseline;
+      }
+      return Math.max(...values);
+    }
+
+    if (this.aggregation == Aggregation.AVERAGE) {
+      let values = Array.from(this.trajectory)
+        .filter((p) => p.date > this.start)
+        .map((p) => p.value);
+      if (!values.length) {
+        return this.baseline;
+      }
+      return values.reduce((a, b) => a + b) / values.length;
+    }
+
+    return this.trajectory.latest.value;
+  }
+
   get baseline() {
     return this.trajectory.at(this.start);
   }
@@ -112,7 +181,7 @@ class Goal {
     }
     
     return (
-      (this.trajectory.latest.value - this.baseline) /
+      (this.current - this.baseline) /
       (this.target - this.baseline));
   }
 
@@ -130,15 +199,35 @@ class Goal {
     return (this.end - by_date) / DAY;
   }
 
+  planned_progress(by_date) {
+    // Without a distance from the baseline to the target, such as while
+    // the trajectory is empty, the plan cannot be turned into progress.
+    let distance = Math.abs(this.target - this.baseline);
+    if (!this.plan.length || !(distance > 0)) {
+      return this.time_spent(Math.min(this.end, by_date));
+    }
+    let weeks = Math.max(0, Math.min(this.end, by_date) - this.start) / WEEK;
+    let amount = 0;
+    for (let i = 0; i < this.plan.length && i < weeks; i++) {
+      amount += Math.min(1, weeks - i) * this.plan[i];
+    }
+    return amount / distance;
+  }
+
   relative_progress(by_date) {
     if (by_date <= this.start) { return 1.0; }
-    let p = this.trajectory.at(by_date) / (this.target - this.baseline);
-    let t = this.time_spent(Math.min(this.end, by_date));
+    // Only cumulative goals can be compared against the trajectory at a
+    // given date, for aggregated goals the current progress is used.
+    let p = this.aggregation == Aggregation.LATEST
+      ? this.trajectory.at(by_date) / (this.target - this.baseline)
+      : this.progress;
+    let t = this.planned_progress(by_date);
+    if (t <= 0) { return 1.0; }
     return p / t;
   }
 
   is_on_track(by_date) {
-    return this.progress >= this.time_spent(Math.min(this.end, by_date));
+    return this.progress >= this.planned_progress(by_date);
   }
 
   velocity(by_date) {
@@ -266,8 +355,13 @@ class ObjectiveConverter {
         end: g.end,
         target: g.target,
         stage: g.stage,
+        aggregation: g.aggregation,
+        plan: g.plan,
         trajectory: Array.from(g.trajectory),
       };
+      if (g.privateNote) {
+        goals[g.id].privateNote = g.privateNote;
+      }
     }
     return {
       name: objective.name,
@@ -285,6 +379,11 @@ class ObjectiveConverter {
       let t = new Trajectory();
       if (g.trajectory) {
         for (let {date, value} of g.trajectory) {
+          // Skip values that would poison the chart and all computations;
+          // `pursuit fsck -repair` removes them from the document.
+          if (!Number.isFinite(value)) {
+            continue;
+          }
           t.insert(date, value);
         }
       }
@@ -296,7 +395,10 @@ class ObjectiveConverter {
         end: g.end,
         target: g.target,
         stage: g.stage,
+        aggregation: g.aggregation,
+        plan: g.plan,
         trajectory: t,
+        privateNote: g.privateNote ?? null,
       }));
     }
 
@@ -335,6 +437,79 @@ class SafeMarkdownRenderer {
 }
 
 
+/**
+ * Encrypts private notes in the browser with AES-GCM, so that the server
+ * only ever stores their ciphertexts. The key is derived from a passphrase
+ * of the user, salted with the user ID, and is neither stored nor sent
+ * anywhere; notes cannot be recovered without the passphrase.
+ */
+class PrivateNotes {
+  constructor(key, keyId) {
+    this._key = key;
+    this._keyId = keyId;
+  }
+
+  /**
+   * Derives the key of a user from a passphrase. Half of the derived bits
+   * become the key, and the hash of the other half identifies it, so that
+   * the ID does not reveal anything about the key.
+   */
+  static async unlock(passphrase, userId) {
+    let encoder = new TextEncoder();
+    let material = await crypto.subtle.importKey(
+      'raw', encoder.encode(passphrase), 'PBKDF2', false, ['deriveBits']);
+    let bits = new Uint8Array(await crypto.subtle.deriveBits({
+      name: 'PBKDF2',
+      salt: encoder.encode(`pursuit/${userId}`),
+      iterations: 310000,
+      hash: 'SHA-256',
+    }, material, 512));
+    let key = await crypto.subtle.importKey(
+      'raw', bits.slice(0, 32), 'AES-GCM', false, ['encrypt', 'decrypt']);
+    let hash = new Uint8Array(await crypto.subtle.digest('SHA-256', bits.slice(32)));
+    let keyId = Array.from(hash.slice(0, 8), (b) => b.toString(16).padStart(2, '0')).join('');
+    return new PrivateNotes(key, keyId);
+  }
+
+  get keyId() {
+    return this._keyId;
+  }
+
+  async encrypt(text) {
+    let nonce = crypto.getRandomValues(new Uint8Array(12));
+    let ciphertext = await crypto.subtle.encrypt(
+      {name: 'AES-GCM', iv: nonce}, this._key, new TextEncoder().encode(text));
+    return {
+      keyId: this._keyId,
+      nonce: PrivateNotes._toBase64(nonce),
+      ciphertext: PrivateNotes._toBase64(new Uint8Array(ciphertext)),
+    };
+  }
+
+  /**
+   * Decrypts a note, or returns null if it was encrypted with another key.
+   */
+  async decrypt(note) {
+    if (!note || note.keyId != this._keyId) {
+      return null;
+    }
+    let plaintext = await crypto.subtle.decrypt(
+      {name: 'AES-GCM', iv: PrivateNotes._fromBase64(note.nonce)},
+      this._key,
+      PrivateNotes._fromBase64(note.ciphertext));
+    return new TextDecoder().decode(plaintext);
+  }
+
+  static _toBase64(bytes) {
+    return btoa(String.fromCharCode(...bytes));
+  }
+
+  static _fromBase64(text) {
+    return Uint8Array.from(atob(text), (c) => c.charCodeAt(0));
+  }
+}
+
+
 class Model {
   constructor() {
     this._objectives = [];
@@ -342,6 +517,7 @@ class Model {
     this._mode = 'view';
     this._show_archived = false;
     this._show_drafts = false;
+    this._private_notes = null;
   }
 
   get objectives() {
@@ -383,6 +559,14 @@ class Model {
   set show_drafts(value) {
     this._show_drafts = value;
   }
+
+  get private_notes() {
+    return this._private_notes;
+  }
+
+  set private_notes(value) {
+    this._private_notes = value;
+  }
 }
 
 
@@ -486,7 +670,10 @@ class Controller {
             return;
           }
           g.trajectory.insert(new Date().getTime(), value);
-          g.trajectory.compact_head(HOUR);
+          // Each value of an aggregated goal is a reading of its own.
+          if (g.aggregation == Aggregation.LATEST) {
+            g.trajectory.compact_head(HOUR);
+          }
           trajectory = Array.from(g.trajectory);
           break;
         }
@@ -579,6 +766,34 @@ class Controller {
       });
   }
 
+  async unlockPrivateNotes(passphrase) {
+    this._model.private_notes = await PrivateNotes.unlock(passphrase, this._model.user_id);
+    this._view.render();
+  }
+
+  async updatePrivateNote(goalId, text) {
+    let objectiveId = null;
+    for (let o of this._model.objectives) {
+      for (let g of o.goals) {
+        if (g.id == goalId) {
+          objectiveId = o.id;
+        }
+      }
+    }
+
+    let note = text
+      ? await this._model.private_notes.encrypt(text)
+      : firebase.firestore.FieldValue.delete();
+    firebase.firestore()
+      .collection('users')
+      .doc(this._model.user_id)
+      .collection('objectives')
+      .doc(objectiveId)
+      .update({
+        [`goals.${goalId}.privateNote`]: note,
+      });
+  }
+
   deleteGoal(goalId) {
     let objectiveId = null;
     for (let o of this._model.objectives) {
@@ -714,6 +929,17 @@ class View {
           .on('click', () => {
             this._controller.addObjective();
           });
+        if (!this._model.private_notes) {
+          toolbarSecondary
+            .append('a')
+            .text('Unlock notes')
+            .on('click', () => {
+              let passphrase = prompt('Passphrase of your private notes:');
+              if (passphrase) {
+                this._controller.unlockPrivateNotes(passphrase);
+              }
+            });
+        }
       }
 
       toolbarSecondary
@@ -800,6 +1026,8 @@ class View {
     );
     let byStatus = (g) => (
       g.stage == Stage.PLEDGED
+        || g.stage == Stage.PAUSED
+        || g.stage == Stage.COMPLETED
         || (g.stage == Stage.DRAFT && this._model.show_drafts)
         || (g.stage == Stage.ARCHIVED && this._model.show_archived));
     node.selectAll('div.goal')
@@ -888,6 +1116,15 @@ class View {
      .attr('x', (g) => `${100 * g.time_spent(now) - 0.25}%`)
      .attr('y', 22);
 
+   // Draw planned progress, if it differs from the current date
+   svg.filter((g) => g.plan.length > 0)
+     .append('rect')
+     .attr('class', 'planned')
+     .attr('width', '0.5%')
+     .attr('height', 26)
+     .attr('x', (g) => `${100 * this._bound(g.planned_progress(now), 0, 1) - 0.25}%`)
+     .attr('y', 22);
+
    // Draw start as text
    svg.append('text')
      .attr('class', 'start')
@@ -968,6 +1205,10 @@ class View {
           (g) => g.trajectory.latest.value,
           (g, v) => {
             let value = parseFloat(v);
+            if (!isFinite(value)) {
+              this._controller._view.render();
+              return;
+            }
             this._controller.updateTrajectory(g.id, value);
           },
           (g) => `last updated ${format_date(g.trajectory.latest.date)}`);
@@ -1007,7 +1248,14 @@ class View {
           'Baseline',
           'number',
           (g) => g.baseline,
-          (g, v) => this._controller.updateGoal(g.id, 'baseline', parseFloat(v)));
+          (g, v) => {
+            let value = parseFloat(v);
+            if (!isFinite(value)) {
+              this._controller._view.render();
+              return;
+            }
+            this._controller.updateGoal(g.id, 'baseline', value);
+          });
         add_field(
           'End',
           'date',
@@ -1035,12 +1283,67 @@ class View {
           'Target',
           'number',
           (g) => g.target,
-          (g, v) => this._controller.updateGoal(g.id, 'target', parseFloat(v)));
+          (g, v) => {
+            let value = parseFloat(v);
+            if (!isFinite(value)) {
+              this._controller._view.render();
+              return;
+            }
+            this._controller.updateGoal(g.id, 'target', value);
+          });
         add_field(
           'Stage',
           'text',
           (g) => g.stage,
           (g, v) => this._controller.updateGoal(g.id, 'stage', v));
+        add_field(
+          'Plan',
+          'text',
+          (g) => g.plan.join(', '),
+          (g, v) => {
+            let plan = v.split(',')
+              .map((a) => a.trim())
+              .filter((a) => a)
+              .map(parseFloat);
+            if (!plan.every(isFinite)) {
+              this._controller._view.render();
+              return;
+            }
+            this._controller.updateGoal(g.id, 'plan', plan);
+          },
+          'weekly amounts, comma-separated');
+        add_field(
+          'Aggregation',
+          'text',
+          (g) => g.aggregation,
+          (g, v) => this._controller.updateGoal(g.id, 'aggregation', v),
+          `one of ${Object.values(Aggregation).join(', ')}`);
+
+        let notes = this._model.private_notes;
+        if (notes) {
+          let field = form.append('div');
+          field.append('div')
+            .text('Private note');
+          field.append('textarea')
+            .attr('placeholder', 'Only you can read this...')
+            .each(function(g) {
+              // Notes encrypted with another key stay read-only, so that
+              // they are not overwritten by mistake.
+              notes.decrypt(g.privateNote).then((text) => {
+                if (text === null && g.privateNote) {
+                  this.disabled = true;
+                  this.placeholder = 'Encrypted with another passphrase';
+                } else {
+                  this.value = text ?? '';
+                }
+              });
+            })
+            .on('change', (g) => {
+              this._controller.updatePrivateNote(g.id, d3.event.target.value);
+            });
+          field.append('div')
+            .text('encrypted on this device');
+        }
 
         let toolbar = form.append('div')
           .attr('class', 'toolbar');
@@ -1056,6 +1359,18 @@ class View {
           .on('click', (g) => {
             this._controller.updateGoalStage(g.id, Stage.PLEDGED);
           });
+        toolbar
+          .append('a')
+          .text('Pause')
+          .on('click', (g) => {
+            this._controller.updateGoalStage(g.id, Stage.PAUSED);
+          });
+        toolbar
+          .append('a')
+          .text('Complete')
+          .on('click', (g) => {
+            this._controller.updateGoalStage(g.id, Stage.COMPLETED);
+          });
         toolbar
           .append('a')
           .text('Archive')
@@ -1091,6 +1406,10 @@ class View {
 
 class VelocityReport {
   report(goal, by_date) {
+    // Velocities are only meaningful for cumulative goals.
+    if (goal.aggregation != Aggregation.LATEST) {
+      return '';
+    }
     let v = goal.velocity_30d(by_date) * DAY;
     let rv = goal.velocity_required(by_date) * DAY;
     let round = (f) => f.toFixed(1);
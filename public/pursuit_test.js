@@ -138,6 +138,73 @@ describe('goal', () => {
     expect(goal.progress).to.equal(0.4);
   });
 
+  it('aggregates the latest value by default', () => {
+    let goal = new Goal({});
+    expect(goal.aggregation).to.equal(Aggregation.LATEST);
+  });
+
+  it('has progress towards a personal record', () => {
+    let goal = new Goal({
+      start: 0,
+      end: 10,
+      target: 20,
+      aggregation: Aggregation.MAXIMUM,
+      trajectory: (
+        new Trajectory()
+          .insert(0, 10)
+          .insert(5, 15)
+          .insert(6, 12)),
+    });
+    expect(goal.current).to.equal(15);
+    expect(goal.progress).to.equal(0.5);
+  });
+
+  it('ignores values before the start for a personal record', () => {
+    let goal = new Goal({
+      start: 10,
+      end: 20,
+      target: 20,
+      aggregation: Aggregation.MAXIMUM,
+      trajectory: (
+        new Trajectory()
+          .insert(0, 25)
+          .insert(10, 10)
+          .insert(15, 15)),
+    });
+    expect(goal.current).to.equal(15);
+    expect(goal.progress).to.equal(0.5);
+  });
+
+  it('has the baseline as personal record without values after the start', () => {
+    let goal = new Goal({
+      start: 10,
+      end: 20,
+      target: 20,
+      aggregation: Aggregation.MAXIMUM,
+      trajectory: (
+        new Trajectory()
+          .insert(0, 25)
+          .insert(10, 10)),
+    });
+    expect(goal.current).to.equal(10);
+  });
+
+  it('has progress towards an average', () => {
+    let goal = new Goal({
+      start: 0,
+      end: 10,
+      target: 50,
+      aggregation: Aggregation.AVERAGE,
+      trajectory: (
+        new Trajectory()
+          .insert(0, 70)
+          .insert(5, 62)
+          .insert(6, 58)),
+    });
+    expect(goal.current).to.equal(60);
+    expect(goal.progress).to.equal(0.5);
+  });
+
   it('supports descending towards a target', () => {
     let goal = new Goal({
       start: 0,
@@ -309,6 +376,36 @@ describe('goal', () => {
     expect(goal.is_on_track(6001)).to.be.false;
   });
 
+  it('follows a weekly plan', () => {
+    let goal = new Goal({
+      start: 0,
+      end: 4 * WEEK,
+      target: 130,
+      plan: [30, 35, 40, 25],
+      trajectory: (
+        new Trajectory()
+          .insert(0, 0)
+          .insert(2 * WEEK, 60)),
+    });
+    expect(goal.planned_progress(2 * WEEK)).to.equal(0.5);
+    expect(goal.planned_progress(2.5 * WEEK)).to.equal(85 / 130);
+    expect(goal.is_on_track(2 * WEEK)).to.be.false;
+    expect(goal.is_on_track(WEEK)).to.be.true;
+  });
+
+  it('paces a plan linearly without distance to the target', () => {
+    let goal = new Goal({
+      start: 0,
+      end: 4 * WEEK,
+      target: 130,
+      plan: [30, 35, 40, 25],
+      trajectory: new Trajectory(),
+    });
+    expect(goal.planned_progress(WEEK)).to.equal(0.25);
+    goal.trajectory.insert(0, 130);
+    expect(goal.planned_progress(WEEK)).to.equal(0.25);
+  });
+
   it('compares progress against end date in the past', () => {
     let goal = new Goal({
       start: 5000,
@@ -570,6 +667,31 @@ describe('objective converter', () => {
 
     expect(converter.fromFirestore(doc)).to.eql(expected);
   });
+
+  it('skips non-finite values when converting from Firestore', () => {
+    let converter = new ObjectiveConverter();
+    let doc = {
+      id: 'id',
+      data: () => ({
+        name: 'name',
+        goals: {
+          g: {
+            name: 'goal',
+            trajectory: [
+              {date: 1, value: 5},
+              {date: 2, value: NaN},
+              {date: 3, value: Infinity},
+            ],
+          },
+        },
+      })
+    };
+
+    let goal = converter.fromFirestore(doc).goals[0];
+
+    expect(goal.trajectory.length).to.equal(1);
+    expect(goal.trajectory.latest.value).to.equal(5);
+  });
   
   it('can convert to Firestore', () => {
     let converter = new ObjectiveConverter();
@@ -606,6 +728,8 @@ describe('objective converter', () => {
             start: 2490,
             end: 5439,
             stage: Stage.ARCHIVED,
+            aggregation: Aggregation.LATEST,
+            plan: [],
             trajectory: [
               {date: 2490, value: 0},
               {date: 3622, value: 110},
@@ -844,6 +968,21 @@ describe('velocity report', () => {
     let velocity = new VelocityReport();
     expect(velocity.report(goal, 90 * DAY)).to.be.equal('30d: 3.0 sessions per month; now need 1.0 sessions per month');
   });
+
+  it('is empty for aggregated goals', () => {
+    let goal = new Goal({
+      start: 0,
+      end: 60 * DAY,
+      target: 180,
+      aggregation: Aggregation.MAXIMUM,
+      trajectory: (
+        new Trajectory()
+          .insert(0, 0)
+          .insert(30 * DAY, 135))
+    });
+    let velocity = new VelocityReport();
+    expect(velocity.report(goal, 30 * DAY)).to.be.equal('');
+  });
 });
 
 describe('progress report', () => {
@@ -929,3 +1068,27 @@ describe('safe markdown renderer', () => {
     expect(html).to.equal('<p>this <a>link</a> abc.</p>\n');
   });
 });
+
+
+describe('private notes', () => {
+  it('round-trip through encryption', async () => {
+    let notes = await PrivateNotes.unlock('correct horse', 'alice');
+    let note = await notes.encrypt('resting heart rate 52');
+    expect(note.keyId).to.equal(notes.keyId);
+    expect(note.ciphertext).to.not.contain('52');
+    expect(await notes.decrypt(note)).to.equal('resting heart rate 52');
+  });
+
+  it('derive the same key from the same passphrase', async () => {
+    let a = await PrivateNotes.unlock('correct horse', 'alice');
+    let b = await PrivateNotes.unlock('correct horse', 'alice');
+    expect(await b.decrypt(await a.encrypt('note'))).to.equal('note');
+  });
+
+  it('are not decrypted with another key', async () => {
+    let alice = await PrivateNotes.unlock('correct horse', 'alice');
+    let bob = await PrivateNotes.unlock('correct horse', 'bob');
+    expect(alice.keyId).to.not.equal(bob.keyId);
+    expect(await bob.decrypt(await alice.encrypt('note'))).to.equal(null);
+  });
+});
@@ -0,0 +1,60 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+)
+
+// PushSender sends push notifications to devices.
+type PushSender interface {
+	// Push sends a notification to each of the registration tokens. It
+	// returns an error if it could not be sent to any of them.
+	Push(tokens []string, title, body string) error
+}
+
+// fcmSender sends push notifications through Firebase Cloud Messaging.
+type fcmSender struct {
+	client *messaging.Client
+}
+
+// NewFCMSender creates a push sender for a particular project.
+func NewFCMSender(projectID string) PushSender {
+	ctx := context.Background()
+	conf := &firebase.Config{ProjectID: projectID}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return fcmSender{client}
+}
+
+func (s fcmSender) Push(tokens []string, title, body string) error {
+	sent := 0
+	var last error
+	for _, token := range tokens {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := s.client.Send(ctx, &messaging.Message{
+			Token:        token,
+			Notification: &messaging.Notification{Title: title, Body: body},
+		})
+		cancel()
+		if err != nil {
+			last = err
+			continue
+		}
+		sent++
+	}
+	if sent == 0 && last != nil {
+		return fmt.Errorf("Error sending push notification: %w", last)
+	}
+	return nil
+}
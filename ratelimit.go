@@ -0,0 +1,113 @@
+package pursuit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitedError is returned instead of serving a request that changes
+// data while its user has used up the rate limit.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("Too many requests, retry after %v", e.RetryAfter)
+}
+
+// tokenBuckets limits the rate of requests per key with token buckets.
+// Each key may make burst requests at once, and its bucket refills at
+// rate requests per second. Keys are forgotten once their buckets are
+// full again.
+type tokenBuckets struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBuckets(perMinute, burst int) *tokenBuckets {
+	return &tokenBuckets{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// take takes a token from the bucket of the key, and returns 0 if there
+// was one. Otherwise it returns how long until there is one.
+func (l *tokenBuckets) take(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.forget(now)
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// refill returns the tokens in the bucket at the time.
+func (l *tokenBuckets) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// forget removes the buckets that are full, which behave like new ones.
+func (l *tokenBuckets) forget(now time.Time) {
+	for k, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// LimitRate limits the requests that change data, such as setting goal
+// values or editing objectives, to perMinute per user, with bursts of up
+// to burst requests. Requests beyond the limit are rejected with status
+// 429 Too Many Requests, so that a runaway client cannot use up the
+// Firestore quota. Reading is not limited.
+func (s *Server) LimitRate(perMinute, burst int) {
+	s.limits = newTokenBuckets(perMinute, burst)
+}
+
+// limitRate returns a RateLimitedError if the request changes data and
+// the user has used up the rate limit, if any.
+func (s *Server) limitRate(r *http.Request, userID string) error {
+	if s.limits == nil {
+		return nil
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if wait := s.limits.take(userID); wait > 0 {
+		return &RateLimitedError{wait}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketsRefill(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newTokenBuckets(60, 3)
+	l.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		if wait := l.take("alice"); wait != 0 {
+			t.Fatalf("request %d of the burst had to wait %v", i+1, wait)
+		}
+	}
+	if wait := l.take("alice"); wait != time.Second {
+		t.Errorf("wait after the burst was %v; wanted 1s", wait)
+	}
+	if wait := l.take("bob"); wait != 0 {
+		t.Errorf("another user had to wait %v", wait)
+	}
+	clock.t = clock.t.Add(time.Second)
+	if wait := l.take("alice"); wait != 0 {
+		t.Errorf("request after refill had to wait %v", wait)
+	}
+}
+
+func TestTokenBucketsForgetFullBuckets(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	l := newTokenBuckets(60, 3)
+	l.now = clock.now
+
+	l.take("alice")
+	clock.t = clock.t.Add(2 * time.Minute)
+	l.take("bob")
+
+	if _, ok := l.buckets["alice"]; ok {
+		t.Errorf("full bucket was kept")
+	}
+}
+
+func TestSetGoalValueHandlerRateLimited(t *testing.T) {
+	s, _ := newMemoryServer()
+	s.LimitRate(1, 1)
+	body := `{"objective": "fitness", "goal": "run", "value": 5000, "unit": "m"}`
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer a.alice.c")
+		w = httptest.NewRecorder()
+		s.setGoalValue(w, r)
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After was %q; wanted 60", got)
+	}
+}
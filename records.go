@@ -0,0 +1,124 @@
+package pursuit
+
+import "time"
+
+// EventGoalRecordBroken is emitted when a goal breaks one of its
+// personal records, see Records.
+const EventGoalRecordBroken = "goal.record_broken"
+
+// Kinds of personal records of a goal.
+const (
+	RecordBestDay       = "bestDay"
+	RecordBestWeek      = "bestWeek"
+	RecordLongestStreak = "longestStreak"
+)
+
+// Records are the personal records of a goal. They are maintained as
+// values are added, so values that are added with an earlier date than
+// the latest value do not count.
+type Records struct {
+	// BestDay and BestWeek are the largest increase of the value of a
+	// goal within a day and an ISO week in UTC. They are only kept for
+	// goals with the latest aggregation, whose values accumulate.
+	BestDay  *Record `firestore:"bestDay,omitempty" json:"bestDay,omitempty"`
+	BestWeek *Record `firestore:"bestWeek,omitempty" json:"bestWeek,omitempty"`
+	// LongestStreak is the most consecutive days in UTC with values, and
+	// Streak the current streak, which ends on the day of the latest
+	// value.
+	LongestStreak *Record `firestore:"longestStreak,omitempty" json:"longestStreak,omitempty"`
+	Streak        *Record `firestore:"streak,omitempty" json:"streak,omitempty"`
+}
+
+// Record is the value of a personal record, or of a streak in days.
+type Record struct {
+	// Date in milliseconds since the epoch at which the day, week or
+	// streak of the record starts.
+	Date  int64   `firestore:"date" json:"date"`
+	Value float32 `firestore:"value" json:"value"`
+}
+
+// recordPeriods returns the start of the day and of the ISO week in UTC
+// of a date, in milliseconds since the epoch.
+func recordPeriods(date int64) (int64, int64) {
+	d := startOfDay(toTime(date, time.UTC))
+	w := d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+	return d.UnixNano() / 1000 / 1000, w.UnixNano() / 1000 / 1000
+}
+
+// increase returns how much the value of the goal changed within a period,
+// from the value before its first value in the period.
+func (g Goal) increase(start, end int64) float32 {
+	var sum float32
+	for i := 1; i < len(g.Trajectory); i++ {
+		if d := g.Trajectory[i].Date; d >= start && d < end {
+			sum += g.Trajectory[i].Value - g.Trajectory[i-1].Value
+		}
+	}
+	return sum
+}
+
+// updateRecords updates the records of the goal with its latest value, and
+// returns the kinds of records that it broke. A record is broken if a
+// different day, week or streak beats it; the first record of a kind is
+// not broken, nor is a record that grows, such as the best day getting
+// another value.
+func (g *Goal) updateRecords() []string {
+	if len(g.Trajectory) == 0 {
+		return nil
+	}
+	// Records are copied, since copies of the goal share them.
+	r := &Records{}
+	if g.Records != nil {
+		*r = *g.Records
+	}
+	latest := g.Trajectory[len(g.Trajectory)-1]
+	today, week := recordPeriods(latest.Date)
+	if r.Streak != nil && today < r.Streak.Date+int64(r.Streak.Value-1)*day {
+		return nil
+	}
+	g.Records = r
+
+	var broken []string
+	update := func(kind string, record **Record, c Record) {
+		switch {
+		case *record == nil:
+			if c.Value <= 0 {
+				return
+			}
+		case c.Value <= (*record).Value:
+			return
+		case c.Date != (*record).Date:
+			broken = append(broken, kind)
+		}
+		*record = &c
+	}
+	if g.Aggregation == "" || g.Aggregation == AggregationLatest {
+		update(RecordBestDay, &r.BestDay, Record{today, g.increase(today, today+day)})
+		update(RecordBestWeek, &r.BestWeek, Record{week, g.increase(week, week+7*day)})
+	}
+
+	switch {
+	case r.Streak == nil || today > r.Streak.Date+int64(r.Streak.Value)*day:
+		r.Streak = &Record{today, 1}
+	case today == r.Streak.Date+int64(r.Streak.Value)*day:
+		r.Streak = &Record{r.Streak.Date, r.Streak.Value + 1}
+	}
+	update(RecordLongestStreak, &r.LongestStreak, *r.Streak)
+	return broken
+}
+
+// newRecordEvent describes that a goal broke a record with its latest
+// value.
+func newRecordEvent(objectiveID, goalID string, g Goal, kind string) Event {
+	e := newGoalEvent(EventGoalRecordBroken, objectiveID, goalID, g, 0)
+	e.Record = kind
+	switch kind {
+	case RecordBestDay:
+		e.RecordValue = g.Records.BestDay.Value
+	case RecordBestWeek:
+		e.RecordValue = g.Records.BestWeek.Value
+	case RecordLongestStreak:
+		e.RecordValue = g.Records.LongestStreak.Value
+	}
+	return e
+}
@@ -0,0 +1,57 @@
+package pursuit
+
+import "testing"
+
+func TestUpdateRecords(t *testing.T) {
+	// Day 4 is a Monday.
+	g := Goal{Target: 100}
+	add := func(date int64, value float32) []string {
+		g.Trajectory = append(g.Trajectory, DateValue{Date: date, Value: value})
+		return g.updateRecords()
+	}
+
+	if broken := add(4*day, 0); len(broken) != 0 || g.Records.BestDay != nil {
+		t.Errorf("first value broke %v; records were %+v", broken, g.Records)
+	}
+	add(4*day+1, 5)
+	if broken := add(4*day+2, 8); len(broken) != 0 || *g.Records.BestDay != (Record{4 * day, 8}) {
+		t.Errorf("growing best day broke %v; best day was %+v", broken, g.Records.BestDay)
+	}
+	if broken := add(5*day, 10); len(broken) != 0 || *g.Records.LongestStreak != (Record{4 * day, 2}) {
+		t.Errorf("growing streak broke %v; longest streak was %+v", broken, g.Records.LongestStreak)
+	}
+	if broken := add(8*day, 20); len(broken) != 1 || broken[0] != RecordBestDay {
+		t.Errorf("broke %v; wanted the best day", broken)
+	}
+	if *g.Records.Streak != (Record{8 * day, 1}) || *g.Records.BestWeek != (Record{4 * day, 20}) {
+		t.Errorf("records were %+v", g.Records)
+	}
+	if broken := add(12*day, 45); len(broken) != 2 || broken[0] != RecordBestDay || broken[1] != RecordBestWeek {
+		t.Errorf("broke %v; wanted the best day and week", broken)
+	}
+	add(13*day, 46)
+	if broken := add(14*day, 47); len(broken) != 1 || broken[0] != RecordLongestStreak || g.Records.LongestStreak.Value != 3 {
+		t.Errorf("broke %v; wanted the longest streak", broken)
+	}
+	before := *g.Records
+	if broken := add(2*day, 100); broken != nil || *g.Records != before {
+		t.Errorf("backdated value broke %v", broken)
+	}
+}
+
+func TestUpdateRecordsOfMeasurements(t *testing.T) {
+	g := Goal{Aggregation: AggregationAverage, Trajectory: Trajectory{{Date: 0, Value: 60}, {Date: day, Value: 80}}}
+	g.updateRecords()
+	if g.Records.BestDay != nil || g.Records.BestWeek != nil || g.Records.Streak.Value != 1 {
+		t.Errorf("records were %+v", g.Records)
+	}
+}
+
+func TestChangeUnitOfRecords(t *testing.T) {
+	records := &Records{BestDay: &Record{0, 2}, LongestStreak: &Record{0, 3}}
+	g := Goal{Unit: "km", Records: records}
+	g.changeUnit("m")
+	if g.Records.BestDay.Value != 2000 || g.Records.LongestStreak.Value != 3 || records.BestDay.Value != 2 {
+		t.Errorf("records were %+v", g.Records)
+	}
+}
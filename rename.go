@@ -0,0 +1,351 @@
+package pursuit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// GoalRename changes the ID or the slug of a goal. An empty To keeps the
+// ID, and an empty Slug keeps the slug.
+type GoalRename struct {
+	Objective string
+	From      string
+	To        string
+	Slug      string
+}
+
+// RenameReport counts what renaming goals changes: the renamed goals, and
+// the documents that refer to them by ID.
+type RenameReport struct {
+	Goals         int
+	Tokens        int
+	Imports       int
+	StatusUpdates int
+	Conflicts     int
+	GoalHooks     int
+	Reviews       int
+	// Points is the number of values moved between trajectory
+	// subcollections.
+	Points int
+}
+
+func (r RenameReport) String() string {
+	return fmt.Sprintf("%d goals renamed, references rewritten in %d share tokens, %d imports, %d status updates, %d conflicts, %d goal hooks and %d reviews, %d values moved",
+		r.Goals, r.Tokens, r.Imports, r.StatusUpdates, r.Conflicts, r.GoalHooks, r.Reviews, r.Points)
+}
+
+// ParseGoalRename parses a rename of the form objective/goal=id, which
+// changes the ID, objective/goal=@slug, which changes the slug, or
+// objective/goal=id@slug, which changes both.
+func ParseGoalRename(s string) (GoalRename, error) {
+	eq := strings.Index(s, "=")
+	slash := strings.Index(s, "/")
+	if eq < 0 || slash < 0 || slash > eq {
+		return GoalRename{}, fmt.Errorf("Invalid rename %q, wanted objective/goal=id@slug: %w", s, ErrInvalidValue)
+	}
+	r := GoalRename{Objective: s[:slash], From: s[slash+1 : eq], To: s[eq+1:]}
+	if at := strings.Index(r.To, "@"); at >= 0 {
+		r.To, r.Slug = r.To[:at], r.To[at+1:]
+	}
+	if r.Objective == "" || r.From == "" || (r.To == "" && r.Slug == "") {
+		return GoalRename{}, fmt.Errorf("Invalid rename %q, wanted objective/goal=id@slug: %w", s, ErrInvalidValue)
+	}
+	return r, nil
+}
+
+// maxTransactionWrites is the limit of writes in a Firestore transaction.
+const maxTransactionWrites = 500
+
+// validGoalID reports whether the ID can name a goal, which is a key in
+// the field path of its objective.
+func validGoalID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "./")
+}
+
+// renameGoals applies the renames to the objectives in order, so that a
+// goal can be renamed more than once. It returns the final ID of each
+// renamed goal by objective and original ID.
+func renameGoals(objectives map[string]*Objective, renames []GoalRename) (map[string]map[string]string, error) {
+	moved := map[string]map[string]string{}
+	for _, r := range renames {
+		o := objectives[r.Objective]
+		g, ok := o.Goals[r.From]
+		if !ok {
+			return nil, fmt.Errorf("No such goal: %q: %w", r.Objective+"/"+r.From, ErrNotFound)
+		}
+		id := r.From
+		delete(o.Goals, r.From)
+		if r.To != "" && r.To != r.From {
+			if !validGoalID(r.To) {
+				return nil, fmt.Errorf("Invalid goal ID: %q: %w", r.To, ErrInvalidValue)
+			}
+			if goalSlugTaken(*o, r.To) {
+				return nil, fmt.Errorf("Goal %q: %w", r.Objective+"/"+r.To, ErrAlreadyExists)
+			}
+			id = r.To
+		}
+		if r.Slug != "" && r.Slug != g.Slug {
+			if Slugify(r.Slug) != r.Slug {
+				return nil, fmt.Errorf("Invalid slug: %q: %w", r.Slug, ErrInvalidValue)
+			}
+			if goalSlugTaken(*o, r.Slug) {
+				return nil, fmt.Errorf("Slug %q: %w", r.Objective+"/"+r.Slug, ErrAlreadyExists)
+			}
+			g.Slug = r.Slug
+		}
+		o.Goals[id] = g
+		if id == r.From {
+			continue
+		}
+		for _, other := range o.Goals {
+			for i, c := range other.Components {
+				if c.Goal == r.From {
+					other.Components[i].Goal = id
+				}
+			}
+		}
+		if moved[r.Objective] == nil {
+			moved[r.Objective] = map[string]string{}
+		}
+		original := r.From
+		for from, to := range moved[r.Objective] {
+			if to == r.From {
+				original = from
+			}
+		}
+		moved[r.Objective][original] = id
+	}
+	return moved, nil
+}
+
+// renameReview rewrites the IDs of renamed goals in the goals that carry
+// over and in the progress of a review. It reports whether the review
+// changed.
+func renameReview(r *Review, rename func(objectiveID, goalID string) (string, bool)) bool {
+	changed := false
+	for i, id := range r.CarryOver {
+		if to, ok := rename(r.Objective, id); ok {
+			r.CarryOver[i] = to
+			changed = true
+		}
+	}
+	sort.Strings(r.CarryOver)
+	if r.Progress != nil {
+		progress := map[string]float32{}
+		for id, p := range r.Progress {
+			if to, ok := rename(r.Objective, id); ok {
+				id = to
+				changed = true
+			}
+			progress[id] = p
+		}
+		r.Progress = progress
+	}
+	return changed
+}
+
+// RenameGoals changes the IDs and slugs of goals of a user in a single
+// transaction, and rewrites the references to renamed goals in the
+// components of composite goals, share tokens, imports, status updates,
+// unresolved conflicts, goal hooks and reviews. Values in
+// trajectory subcollections move along with their goal. Events keep the
+// IDs that goals had when they happened. Unless dryRun, the changes are
+// written; the report is the same either way.
+func (s Storage) RenameGoals(userID string, renames []GoalRename, dryRun bool) (RenameReport, error) {
+	user := s.collection("users").Doc(userID)
+	var report RenameReport
+	err := s.transaction("RenameGoals", func(tx *firestore.Transaction) error {
+		report = RenameReport{}
+		var writes []func() error
+		objectives := map[string]*Objective{}
+		refs := map[string]*firestore.DocumentRef{}
+		for _, r := range renames {
+			if _, ok := objectives[r.Objective]; ok {
+				continue
+			}
+			ref := user.Collection("objectives").Doc(r.Objective)
+			doc, err := tx.Get(ref)
+			if doc != nil && !doc.Exists() {
+				return fmt.Errorf("No such objective: %q: %w", r.Objective, ErrNotFound)
+			}
+			if err != nil {
+				return fmt.Errorf("Error reading objective: %w", err)
+			}
+			var o Objective
+			if err := doc.DataTo(&o); err != nil {
+				return fmt.Errorf("Error reading objective: %w", err)
+			}
+			objectives[r.Objective] = &o
+			refs[r.Objective] = ref
+		}
+		moved, err := renameGoals(objectives, renames)
+		if err != nil {
+			return err
+		}
+		report.Goals = len(renames)
+		rename := func(objectiveID, goalID string) (string, bool) {
+			to, ok := moved[objectiveID][goalID]
+			return to, ok
+		}
+
+		tokens, err := tx.Documents(s.collection("tokens").Where("user", "==", userID)).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading tokens: %w", err)
+		}
+		for _, doc := range tokens {
+			var t ShareToken
+			if err := doc.DataTo(&t); err != nil {
+				return fmt.Errorf("Error reading token %q: %w", doc.Ref.ID, err)
+			}
+			changed := false
+			for i, scope := range t.Scopes {
+				if to, ok := rename(scope.Objective, scope.Goal); ok {
+					t.Scopes[i].Goal = to
+					changed = true
+				}
+			}
+			if changed {
+				report.Tokens++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, t) })
+			}
+		}
+
+		imports, err := tx.Documents(user.Collection("imports")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading imports: %w", err)
+		}
+		for _, doc := range imports {
+			var i Import
+			if err := doc.DataTo(&i); err != nil {
+				return fmt.Errorf("Error reading import %q: %w", doc.Ref.ID, err)
+			}
+			changed := false
+			for metric, target := range i.Goals {
+				if to, ok := rename(target.Objective, target.Goal); ok {
+					target.Goal = to
+					i.Goals[metric] = target
+					changed = true
+				}
+			}
+			if changed {
+				report.Imports++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, i) })
+			}
+		}
+
+		updates, err := tx.Documents(user.Collection("statusUpdates")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading status updates: %w", err)
+		}
+		for _, doc := range updates {
+			var u StatusUpdate
+			if err := doc.DataTo(&u); err != nil {
+				return fmt.Errorf("Error reading status update %q: %w", doc.Ref.ID, err)
+			}
+			if to, ok := rename(u.Objective, u.Goal); ok {
+				u.Goal = to
+				report.StatusUpdates++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, u) })
+			}
+		}
+
+		conflicts, err := tx.Documents(user.Collection("conflicts")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading conflicts: %w", err)
+		}
+		for _, doc := range conflicts {
+			var c Conflict
+			if err := doc.DataTo(&c); err != nil {
+				return fmt.Errorf("Error reading conflict %q: %w", doc.Ref.ID, err)
+			}
+			if to, ok := rename(c.Objective, c.Goal); ok {
+				c.Goal = to
+				report.Conflicts++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, c) })
+			}
+		}
+
+		hooks, err := tx.Documents(user.Collection("goalHooks")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading goal hooks: %w", err)
+		}
+		for _, doc := range hooks {
+			var h GoalHook
+			if err := doc.DataTo(&h); err != nil {
+				return fmt.Errorf("Error reading goal hook %q: %w", doc.Ref.ID, err)
+			}
+			if to, ok := rename(h.Objective, h.Goal); ok {
+				h.Goal = to
+				report.GoalHooks++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, h) })
+			}
+		}
+
+		reviews, err := tx.Documents(user.Collection("reviews")).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading reviews: %w", err)
+		}
+		for _, doc := range reviews {
+			var r Review
+			if err := doc.DataTo(&r); err != nil {
+				return fmt.Errorf("Error reading review %q: %w", doc.Ref.ID, err)
+			}
+			if renameReview(&r, rename) {
+				report.Reviews++
+				ref := doc.Ref
+				writes = append(writes, func() error { return tx.Set(ref, r) })
+			}
+		}
+
+		if s.trajectories {
+			for objectiveID, goals := range moved {
+				for from, to := range goals {
+					points, err := tx.Documents(s.trajectoryRef(userID, objectiveID, from)).GetAll()
+					if err != nil {
+						return fmt.Errorf("Error reading trajectory: %w", err)
+					}
+					target := s.trajectoryRef(userID, objectiveID, to)
+					for _, doc := range points {
+						report.Points++
+						old, ref, data := doc.Ref, target.Doc(doc.Ref.ID), doc.Data()
+						writes = append(writes, func() error {
+							if err := tx.Create(ref, data); err != nil {
+								return err
+							}
+							return tx.Delete(old)
+						})
+					}
+				}
+			}
+		}
+
+		for id, o := range objectives {
+			if err := validateObjective(*o); err != nil {
+				return err
+			}
+			ref, o := refs[id], o
+			writes = append(writes, func() error { return tx.Set(ref, *o) })
+		}
+		if n := len(writes) + report.Points; n > maxTransactionWrites {
+			return fmt.Errorf("Renaming takes %d writes, at most %d fit into a transaction; rename fewer goals at once: %w", n, maxTransactionWrites, ErrInvalidValue)
+		}
+		if dryRun {
+			return nil
+		}
+		for _, w := range writes {
+			if err := w(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return report, err
+}
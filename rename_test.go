@@ -0,0 +1,108 @@
+package pursuit
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGoalRename(t *testing.T) {
+	tests := map[string]GoalRename{
+		"fitness/run=jog":         {Objective: "fitness", From: "run", To: "jog"},
+		"fitness/run=@jogging":    {Objective: "fitness", From: "run", Slug: "jogging"},
+		"fitness/run=jog@jogging": {Objective: "fitness", From: "run", To: "jog", Slug: "jogging"},
+	}
+	for s, want := range tests {
+		if got, err := ParseGoalRename(s); err != nil || got != want {
+			t.Errorf("ParseGoalRename(%q) = %+v, %v; wanted %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"run=jog", "fitness/run", "fitness/run=", "/run=jog", "fitness/=jog"} {
+		if _, err := ParseGoalRename(s); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("ParseGoalRename(%q) was accepted", s)
+		}
+	}
+}
+
+func TestRenameGoals(t *testing.T) {
+	o := &Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run":  {Name: "Run"},
+		"swim": {Name: "Swim"},
+	}}
+	moved, err := renameGoals(map[string]*Objective{"fitness": o}, []GoalRename{
+		{Objective: "fitness", From: "run", To: "tmp"},
+		{Objective: "fitness", From: "swim", To: "run", Slug: "laps"},
+		{Objective: "fitness", From: "tmp", To: "swim"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := moved["fitness"]; len(got) != 2 || got["run"] != "swim" || got["swim"] != "run" {
+		t.Errorf("moved was %v; wanted run and swim swapped", got)
+	}
+	if g := o.Goals["run"]; g.Name != "Swim" || g.Slug != "laps" {
+		t.Errorf("goal run was %+v; wanted Swim with slug laps", g)
+	}
+	if g := o.Goals["swim"]; g.Name != "Run" {
+		t.Errorf("goal swim was %+v; wanted Run", g)
+	}
+}
+
+func TestRenameGoalsConflicts(t *testing.T) {
+	tests := []struct {
+		r    GoalRename
+		want error
+	}{
+		{GoalRename{Objective: "fitness", From: "walk", To: "hike"}, ErrNotFound},
+		{GoalRename{Objective: "fitness", From: "run", To: "swim"}, ErrAlreadyExists},
+		{GoalRename{Objective: "fitness", From: "run", To: "laps"}, ErrAlreadyExists},
+		{GoalRename{Objective: "fitness", From: "run", Slug: "laps"}, ErrAlreadyExists},
+		{GoalRename{Objective: "fitness", From: "run", To: "a.b"}, ErrInvalidValue},
+		{GoalRename{Objective: "fitness", From: "run", Slug: "Not a slug"}, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		o := &Objective{Name: "Fitness", Goals: map[string]Goal{
+			"run":  {Name: "Run", Slug: "run"},
+			"swim": {Name: "Swim", Slug: "laps"},
+		}}
+		if _, err := renameGoals(map[string]*Objective{"fitness": o}, []GoalRename{tt.r}); !errors.Is(err, tt.want) {
+			t.Errorf("rename %+v failed with %v; wanted %v", tt.r, err, tt.want)
+		}
+	}
+}
+
+func TestRenameReview(t *testing.T) {
+	moved := map[string]map[string]string{"fitness": {"run": "swim", "swim": "run"}}
+	rename := func(objectiveID, goalID string) (string, bool) {
+		to, ok := moved[objectiveID][goalID]
+		return to, ok
+	}
+	r := Review{
+		Objective: "fitness",
+		CarryOver: []string{"bike", "run"},
+		Progress:  map[string]float32{"run": 0.5, "swim": 1, "bike": 0},
+	}
+
+	if !renameReview(&r, rename) {
+		t.Errorf("review was not changed")
+	}
+	if want := []string{"bike", "swim"}; !reflect.DeepEqual(r.CarryOver, want) {
+		t.Errorf("carry-over was %v; wanted %v", r.CarryOver, want)
+	}
+	if want := map[string]float32{"swim": 0.5, "run": 1, "bike": 0}; !reflect.DeepEqual(r.Progress, want) {
+		t.Errorf("progress was %v; wanted %v", r.Progress, want)
+	}
+
+	other := Review{Objective: "work", CarryOver: []string{"run"}}
+	if renameReview(&other, rename) || other.CarryOver[0] != "run" {
+		t.Errorf("review of another objective was changed to %+v", other)
+	}
+}
+
+func TestRenameReportString(t *testing.T) {
+	r := RenameReport{Goals: 2, GoalHooks: 1, Reviews: 3}
+	if s := r.String(); !strings.Contains(s, "1 goal hooks") || !strings.Contains(s, "3 reviews") {
+		t.Errorf("report was %q; wanted goal hooks and reviews", s)
+	}
+}
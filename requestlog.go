@@ -0,0 +1,166 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// requestLogRetention is how long API requests are kept. Like events,
+// they are deleted through a TTL policy on expireAt.
+const requestLogRetention = 30 * 24 * time.Hour
+
+// maxAPIRequests limits the number of API requests that are listed.
+const maxAPIRequests = 100
+
+// APIRequest records a request made with a share token of a user, so that
+// users can audit their automations and spot leaked tokens. Requests are
+// stored in users/{user}/requests.
+type APIRequest struct {
+	// Date in milliseconds since the epoch.
+	Date   int64  `firestore:"date" json:"date"`
+	Method string `firestore:"method" json:"method"`
+	Path   string `firestore:"path" json:"path"`
+	// Token is the ID of the share token used for the request.
+	Token    string    `firestore:"token" json:"token"`
+	SourceIP string    `firestore:"sourceIP" json:"sourceIP"`
+	Status   int       `firestore:"status" json:"status"`
+	ExpireAt time.Time `firestore:"expireAt" json:"-"`
+}
+
+// requestLog collects who a request was made for, and what about, while
+// it is served, for its log entries, see logf.
+type requestLog struct {
+	id        string
+	user      string
+	token     string
+	objective string
+	goal      string
+	// ip is the address of the client, see sourceIP.
+	ip string
+}
+
+type requestLogKey struct{}
+
+// statusRecorder remembers the status code of a response, and the error
+// that writeError replied with, if any.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	err    error
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// noteTarget notes in the log of a request which objective and goal it
+// is about.
+func noteTarget(r *http.Request, objectiveID, goalID string) {
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		l.objective, l.goal = objectiveID, goalID
+	}
+}
+
+func newAPIRequest(r *http.Request, token string, status int) APIRequest {
+	return APIRequest{
+		Date:     time.Now().UnixNano() / 1000 / 1000,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Token:    token,
+		SourceIP: sourceIP(r),
+		Status:   status,
+	}
+}
+
+// defaultTrustedProxies is the number of proxies in front of the server
+// that append to X-Forwarded-For. On Cloud Run, that is its front end.
+const defaultTrustedProxies = 1
+
+// clientIP returns the address of the client of a request that passed
+// through the given number of trusted proxies. Each proxy appends the
+// address of its client to X-Forwarded-For, so the client is the entry
+// that the outermost trusted proxy appended, counting from the right.
+// Entries further left come from the client and may be forged. Without
+// trusted proxies, X-Forwarded-For is ignored.
+func clientIP(r *http.Request, proxies int) string {
+	if f := r.Header.Get("X-Forwarded-For"); f != "" && proxies > 0 {
+		hops := strings.Split(f, ",")
+		i := len(hops) - proxies
+		if i < 0 {
+			i = 0
+		}
+		if ip := strings.TrimSpace(hops[i]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sourceIP returns the address of the client, as ServeHTTP determined it
+// from the proxies that the server trusts, see Server.TrustProxies.
+// Requests that did not pass through ServeHTTP trust the default proxies.
+func sourceIP(r *http.Request) string {
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok && l.ip != "" {
+		return l.ip
+	}
+	return clientIP(r, defaultTrustedProxies)
+}
+
+// recordAPIRequest persists an API request. Failures are logged rather
+// than failing the request, which has already been served.
+func (s Storage) recordAPIRequest(userID string, req APIRequest) {
+	req.ExpireAt = time.Unix(0, req.Date*int64(time.Millisecond)).Add(requestLogRetention)
+	ref := s.collection("users").Doc(userID).Collection("requests")
+	err := s.do("recordAPIRequest", func(ctx context.Context) error {
+		_, _, err := ref.Add(ctx, req)
+		return err
+	})
+	if err != nil {
+		logf(s.ctx, severityError, "Error recording API request %+v of user %q: %v", req, userID, err)
+	}
+}
+
+// ListAPIRequests returns the most recent API requests of a user, newest
+// first.
+func (s Storage) ListAPIRequests(userID string) ([]APIRequest, error) {
+	since := time.Now().Add(-requestLogRetention).UnixNano() / 1000 / 1000
+	q := s.collection("users").Doc(userID).Collection("requests").
+		Where("date", ">=", since).
+		OrderBy("date", firestore.Desc).
+		Limit(maxAPIRequests)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListAPIRequests", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing API requests: %w", err)
+	}
+	requests := make([]APIRequest, 0, len(docs))
+	for _, doc := range docs {
+		var req APIRequest
+		if err := doc.DataTo(&req); err != nil {
+			return nil, fmt.Errorf("Error reading API request %q: %w", doc.Ref.ID, err)
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
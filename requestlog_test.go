@@ -0,0 +1,72 @@
+package pursuit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, c := range []struct {
+		forwarded string
+		proxies   int
+		want      string
+	}{
+		{"", 1, "10.0.0.1"},
+		{"198.51.100.9", 1, "198.51.100.9"},
+		// The client sent the first entry itself.
+		{"203.0.113.7, 198.51.100.9", 1, "198.51.100.9"},
+		{"203.0.113.7, 198.51.100.9, 10.1.1.1", 2, "198.51.100.9"},
+		{"198.51.100.9, 10.1.1.1", 3, "198.51.100.9"},
+		{"203.0.113.7", 0, "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("POST", "/setgoalvalue", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := clientIP(r, c.proxies); got != c.want {
+			t.Errorf("client IP with X-Forwarded-For %q and %d proxies was %q; wanted %q", c.forwarded, c.proxies, got, c.want)
+		}
+	}
+}
+
+func TestSpoofedForwardedForIsNotAllowed(t *testing.T) {
+	defer captureLog(t)()
+	s := &Server{storage: &Storage{}, mux: http.NewServeMux(), proxies: defaultTrustedProxies}
+	token := ShareToken{AllowedNetworks: []string{"203.0.113.0/24"}}
+	var ip string
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ip = sourceIP(r)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.9")
+
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if ip != "198.51.100.9" || token.AllowsFrom(ip) {
+		t.Errorf("source IP was %q, allowed: %v; wanted the address that Cloud Run appended to be rejected", ip, token.AllowsFrom(ip))
+	}
+}
+
+func TestNewAPIRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/setgoalvalue", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+
+	req := newAPIRequest(r, "id", http.StatusForbidden)
+
+	if req.Method != "POST" || req.Path != "/setgoalvalue" || req.Token != "id" || req.SourceIP != "10.0.0.1" || req.Status != http.StatusForbidden {
+		t.Errorf("request was %+v", req)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	writeError(rec, http.StatusNotFound, ErrNotFound)
+
+	if rec.status != http.StatusNotFound || w.Code != http.StatusNotFound {
+		t.Errorf("status was %d, recorded %d; wanted %d", w.Code, rec.status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,311 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// States of reviews.
+const (
+	ReviewOpen   = "open"
+	ReviewClosed = "closed"
+)
+
+// Review for Firestore serialization/deserialization. A review looks
+// back on the period of an objective: it scores the objective, records
+// lessons learned and selects the goals that carry over into the next
+// period. Reviews are opened, filled in while open, and closed, after
+// which they cannot be changed. They are stored in users/{user}/reviews,
+// so that they outlive their objective.
+type Review struct {
+	Objective string `firestore:"objective" json:"objective"`
+	State     string `firestore:"state" json:"state"`
+	// Start and End of the reviewed period in milliseconds since the
+	// epoch, which span the goals of the objective.
+	Start int64 `firestore:"start" json:"start"`
+	End   int64 `firestore:"end" json:"end"`
+	// Score from 0 to 1, which starts as the mean progress of the goals.
+	Score   float32 `firestore:"score" json:"score"`
+	Lessons string  `firestore:"lessons" json:"lessons"`
+	// CarryOver are the IDs of the goals that carry over into the next
+	// period.
+	CarryOver []string `firestore:"carryOver" json:"carryOver"`
+	// Progress of each goal when the review was closed.
+	Progress map[string]float32 `firestore:"progress,omitempty" json:"progress,omitempty"`
+	// Opened and Closed in milliseconds since the epoch.
+	Opened int64 `firestore:"opened" json:"opened"`
+	Closed int64 `firestore:"closed,omitempty" json:"closed,omitempty"`
+}
+
+// ReviewEntry is a review together with its ID.
+type ReviewEntry struct {
+	ID string `json:"id"`
+	Review
+}
+
+// ReviewChange fills in an open review. Fields that are nil are left
+// unchanged.
+type ReviewChange struct {
+	Score     *float32  `json:"score,omitempty"`
+	Lessons   *string   `json:"lessons,omitempty"`
+	CarryOver *[]string `json:"carryOver,omitempty"`
+}
+
+// maxLessonsLength bounds the length of the lessons of a review, in bytes.
+const maxLessonsLength = 10000
+
+// progressOf returns the progress of each goal of the objective, capped at
+// 1, leaving out goals whose progress is not defined.
+func progressOf(o Objective) map[string]float32 {
+	progress := map[string]float32{}
+	for id, g := range o.Goals {
+		p := g.Progress()
+		if math.IsNaN(float64(p)) || math.IsInf(float64(p), 0) {
+			continue
+		}
+		progress[id] = float32(math.Min(float64(p), 1))
+	}
+	return progress
+}
+
+// newReview opens a review of the objective at the given date.
+func newReview(objectiveID string, o Objective, now int64) Review {
+	r := Review{Objective: objectiveID, State: ReviewOpen, CarryOver: []string{}, Opened: now}
+	for _, g := range o.Goals {
+		if g.Start != 0 && (r.Start == 0 || g.Start < r.Start) {
+			r.Start = g.Start
+		}
+		if g.End > r.End {
+			r.End = g.End
+		}
+	}
+	var sum float32
+	progress := progressOf(o)
+	for _, p := range progress {
+		sum += p
+	}
+	if len(progress) > 0 {
+		r.Score = float32(roundTo(sum/float32(len(progress)), 2))
+	}
+	return r
+}
+
+// apply fills in the review with the change. Goals to carry over must be
+// goals of the objective.
+func (r *Review) apply(c ReviewChange, o Objective) error {
+	if r.State != ReviewOpen {
+		return fmt.Errorf("Review is %s: %w", r.State, ErrInvalidValue)
+	}
+	if c.Score != nil {
+		if !(*c.Score >= 0 && *c.Score <= 1) {
+			return fmt.Errorf("Invalid score %v, wanted 0 to 1: %w", *c.Score, ErrInvalidValue)
+		}
+		r.Score = *c.Score
+	}
+	if c.Lessons != nil {
+		if len(*c.Lessons) > maxLessonsLength {
+			return fmt.Errorf("Lessons longer than %d bytes: %w", maxLessonsLength, ErrInvalidValue)
+		}
+		r.Lessons = *c.Lessons
+	}
+	if c.CarryOver != nil {
+		carryOver := []string{}
+		seen := map[string]bool{}
+		for _, id := range *c.CarryOver {
+			if _, ok := o.Goals[id]; !ok {
+				return fmt.Errorf("No such goal: %q: %w", id, ErrNotFound)
+			}
+			if !seen[id] {
+				seen[id] = true
+				carryOver = append(carryOver, id)
+			}
+		}
+		sort.Strings(carryOver)
+		r.CarryOver = carryOver
+	}
+	return nil
+}
+
+// Rollover drafts the objective of the next period from the goals that
+// carry over. They keep their definitions, but start without values in a
+// period of the same length that begins where the reviewed one ended.
+// Components of composite goals that do not carry over are dropped. The
+// draft is not stored; clients create it as a new objective.
+func (r Review) Rollover(o Objective) Objective {
+	next := Objective{Name: o.Name, Description: o.Description, Goals: map[string]Goal{}}
+	carried := map[string]bool{}
+	for _, id := range r.CarryOver {
+		carried[id] = true
+	}
+	for _, id := range r.CarryOver {
+		g, ok := o.Goals[id]
+		if !ok {
+			continue
+		}
+		length := g.End - g.Start
+		g.Start, g.End = r.End, r.End+length
+		if g.Start == 0 || length <= 0 {
+			g.Start, g.End = 0, 0
+		}
+		g.Trajectory = nil
+		g.Plan = nil
+		g.Mute = nil
+		g.SourceReadings = nil
+		g.Milestone = 0
+		g.Records = nil
+		g.Slug = ""
+		var components []Component
+		for _, c := range g.Components {
+			if carried[c.Goal] {
+				components = append(components, c)
+			}
+		}
+		g.Components = components
+		next.Goals[id] = g
+	}
+	return next
+}
+
+// reviewsRef returns the reviews of a user.
+func (s Storage) reviewsRef(userID string) *firestore.CollectionRef {
+	return s.collection("users").Doc(userID).Collection("reviews")
+}
+
+// OpenReview opens a review of an objective of a user. An objective has
+// at most one open review.
+func (s Storage) OpenReview(userID, objectiveID string) (ReviewEntry, error) {
+	o, err := s.GetObjective(userID, objectiveID)
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	r := newReview(objectiveID, o, time.Now().UnixNano()/1000/1000)
+	ref := s.reviewsRef(userID).NewDoc()
+	open := s.reviewsRef(userID).Where("objective", "==", objectiveID).Where("state", "==", ReviewOpen)
+	err = s.transaction("OpenReview", func(tx *firestore.Transaction) error {
+		docs, err := tx.Documents(open).GetAll()
+		if err != nil {
+			return fmt.Errorf("Error reading reviews: %w", err)
+		}
+		if len(docs) > 0 {
+			return fmt.Errorf("Open review %q of objective %q: %w", docs[0].Ref.ID, objectiveID, ErrAlreadyExists)
+		}
+		return tx.Create(ref, r)
+	})
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	return ReviewEntry{ref.ID, r}, nil
+}
+
+// GetReview returns a review of an objective of a user.
+func (s Storage) GetReview(userID, objectiveID, reviewID string) (ReviewEntry, error) {
+	var r Review
+	err := s.do("GetReview", func(ctx context.Context) error {
+		doc, err := s.reviewsRef(userID).Doc(reviewID).Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such review: %q: %w", reviewID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading review: %w", err)
+		}
+		return doc.DataTo(&r)
+	})
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	if r.Objective != objectiveID {
+		return ReviewEntry{}, fmt.Errorf("No such review: %q: %w", reviewID, ErrNotFound)
+	}
+	return ReviewEntry{reviewID, r}, nil
+}
+
+// ListReviews returns the reviews of an objective of a user, latest first.
+func (s Storage) ListReviews(userID, objectiveID string) ([]ReviewEntry, error) {
+	q := s.reviewsRef(userID).Where("objective", "==", objectiveID)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListReviews", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing reviews: %w", err)
+	}
+	reviews := make([]ReviewEntry, 0, len(docs))
+	for _, doc := range docs {
+		var r Review
+		if err := doc.DataTo(&r); err != nil {
+			return nil, fmt.Errorf("Error reading review %q: %w", doc.Ref.ID, err)
+		}
+		reviews = append(reviews, ReviewEntry{doc.Ref.ID, r})
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return reviews[i].Opened > reviews[j].Opened
+	})
+	return reviews, nil
+}
+
+// modifyReview applies f to an open review of an objective of a user,
+// together with the objective.
+func (s Storage) modifyReview(op, userID, objectiveID, reviewID string, f func(r *Review, o Objective) error) (ReviewEntry, error) {
+	o, err := s.GetObjective(userID, objectiveID)
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	ref := s.reviewsRef(userID).Doc(reviewID)
+	var r Review
+	err = s.transaction(op, func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such review: %q: %w", reviewID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading review: %w", err)
+		}
+		r = Review{}
+		if err := doc.DataTo(&r); err != nil {
+			return fmt.Errorf("Error reading review: %w", err)
+		}
+		if r.Objective != objectiveID {
+			return fmt.Errorf("No such review: %q: %w", reviewID, ErrNotFound)
+		}
+		if err := f(&r, o); err != nil {
+			return err
+		}
+		return tx.Set(ref, r)
+	})
+	if err != nil {
+		return ReviewEntry{}, err
+	}
+	return ReviewEntry{reviewID, r}, nil
+}
+
+// UpdateReview fills in an open review of an objective of a user.
+func (s Storage) UpdateReview(userID, objectiveID, reviewID string, c ReviewChange) (ReviewEntry, error) {
+	return s.modifyReview("UpdateReview", userID, objectiveID, reviewID, func(r *Review, o Objective) error {
+		return r.apply(c, o)
+	})
+}
+
+// CloseReview closes an open review of an objective of a user, recording
+// the progress of its goals. It returns the closed review and the draft
+// of the objective of the next period, see Review.Rollover.
+func (s Storage) CloseReview(userID, objectiveID, reviewID string) (ReviewEntry, Objective, error) {
+	var next Objective
+	e, err := s.modifyReview("CloseReview", userID, objectiveID, reviewID, func(r *Review, o Objective) error {
+		// Goals may have been deleted since they were selected.
+		if err := r.apply(ReviewChange{CarryOver: &r.CarryOver}, o); err != nil {
+			return err
+		}
+		r.State = ReviewClosed
+		r.Closed = time.Now().UnixNano() / 1000 / 1000
+		r.Progress = progressOf(o)
+		next = r.Rollover(o)
+		return nil
+	})
+	return e, next, err
+}
@@ -0,0 +1,89 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func reviewObjective() Objective {
+	return Objective{Name: "Fitness", Description: "Q1", Goals: map[string]Goal{
+		"run": {
+			Name: "Run", Start: 10 * day, End: 100 * day, Target: 100, Unit: "km", Slug: "run",
+			Trajectory: Trajectory{{Date: 10 * day, Value: 0}, {Date: 50 * day, Value: 150}},
+			Milestone:  100,
+		},
+		"swim": {
+			Name: "Swim", Start: 20 * day, End: 90 * day, Target: 10,
+			Trajectory: Trajectory{{Date: 20 * day, Value: 0}, {Date: 50 * day, Value: 5}},
+		},
+		"total": {
+			Name: "Total", Target: 100,
+			Components: []Component{{Goal: "run", Weight: 0.5}, {Goal: "swim", Weight: 0.5}},
+		},
+	}}
+}
+
+func TestNewReview(t *testing.T) {
+	r := newReview("fitness", reviewObjective(), 100*day)
+
+	if r.State != ReviewOpen || r.Start != 10*day || r.End != 100*day || r.Opened != 100*day {
+		t.Errorf("review was %+v", r)
+	}
+	// Run is capped at 100% and total has no values.
+	if r.Score != 0.75 {
+		t.Errorf("score was %v; wanted 0.75", r.Score)
+	}
+}
+
+func TestReviewApply(t *testing.T) {
+	o := reviewObjective()
+	r := newReview("fitness", o, 100*day)
+	score := float32(0.6)
+	lessons := "Swim earlier."
+	carryOver := []string{"swim", "total", "swim"}
+
+	if err := r.apply(ReviewChange{Score: &score, Lessons: &lessons, CarryOver: &carryOver}, o); err != nil {
+		t.Fatal(err)
+	}
+	if r.Score != 0.6 || r.Lessons != lessons || len(r.CarryOver) != 2 || r.CarryOver[0] != "swim" {
+		t.Errorf("review was %+v", r)
+	}
+
+	invalid := float32(1.5)
+	if err := r.apply(ReviewChange{Score: &invalid}, o); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("score 1.5 was accepted: %v", err)
+	}
+	missing := []string{"bike"}
+	if err := r.apply(ReviewChange{CarryOver: &missing}, o); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing goal was carried over: %v", err)
+	}
+	r.State = ReviewClosed
+	if err := r.apply(ReviewChange{Lessons: &lessons}, o); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("closed review was changed: %v", err)
+	}
+}
+
+func TestReviewRollover(t *testing.T) {
+	o := reviewObjective()
+	r := newReview("fitness", o, 100*day)
+	r.CarryOver = []string{"run", "total"}
+
+	next := r.Rollover(o)
+
+	if next.Name != "Fitness" || len(next.Goals) != 2 {
+		t.Fatalf("rollover was %+v", next)
+	}
+	run := next.Goals["run"]
+	if run.Start != 100*day || run.End != 190*day || len(run.Trajectory) != 0 || run.Milestone != 0 || run.Slug != "" || run.Target != 100 {
+		t.Errorf("run was %+v", run)
+	}
+	if total := next.Goals["total"]; len(total.Components) != 1 || total.Components[0].Goal != "run" {
+		t.Errorf("components of total were %+v; wanted only run", total.Components)
+	}
+	if err := validateObjective(next); err != nil {
+		t.Errorf("rollover was invalid: %v", err)
+	}
+	if o.Goals["run"].Slug != "run" || len(o.Goals["run"].Trajectory) != 2 {
+		t.Errorf("rollover changed the reviewed objective")
+	}
+}
@@ -0,0 +1,87 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// SandboxNamespace is the namespace of the sandbox, in which integrators
+// develop against the API without touching real data.
+const SandboxNamespace = "sandbox"
+
+// testTokenPrefix starts the secrets of share tokens that are created in
+// the sandbox, so that test keys are recognized as such.
+const testTokenPrefix = "test_"
+
+// UseNamespace stores all data under namespaces/{namespace} instead of at
+// the top level, e.g. SandboxNamespace. It must be called before the
+// storage is used.
+func (s *Storage) UseNamespace(namespace string) {
+	s.namespace = namespace
+}
+
+// Sandbox reports whether the storage keeps the data of the sandbox.
+func (s Storage) Sandbox() bool {
+	return s.namespace == SandboxNamespace
+}
+
+// checkTokenEnvironment rejects test keys outside of the sandbox, where
+// they would otherwise silently not be found.
+func (s Storage) checkTokenEnvironment(secret string) error {
+	if !s.Sandbox() && strings.HasPrefix(secret, testTokenPrefix) {
+		return fmt.Errorf("Test tokens only work in the sandbox: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// PurgeNamespace deletes all data of the namespace of the storage, and
+// returns the number of deleted documents. It refuses to delete real data.
+func (s Storage) PurgeNamespace() (int, error) {
+	if s.namespace == "" {
+		return 0, fmt.Errorf("Only sandbox data can be purged: %w", ErrForbidden)
+	}
+	return s.purge(s.client.Collection("namespaces").Doc(s.namespace))
+}
+
+// purge deletes the document and all documents in its subcollections.
+func (s Storage) purge(ref *firestore.DocumentRef) (int, error) {
+	var collections []*firestore.CollectionRef
+	err := s.do("PurgeNamespace", func(ctx context.Context) (err error) {
+		collections, err = ref.Collections(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error listing collections of %q: %w", ref.ID, err)
+	}
+	deleted := 0
+	for _, c := range collections {
+		var refs []*firestore.DocumentRef
+		err := s.do("PurgeNamespace", func(ctx context.Context) (err error) {
+			// DocumentRefs also lists missing documents that only have
+			// subcollections.
+			refs, err = c.DocumentRefs(ctx).GetAll()
+			return err
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("Error listing %q: %w", c.ID, err)
+		}
+		for _, r := range refs {
+			n, err := s.purge(r)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+		}
+	}
+	err = s.do("PurgeNamespace", func(ctx context.Context) error {
+		_, err := ref.Delete(ctx)
+		return err
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("Error deleting %q: %w", ref.ID, err)
+	}
+	return deleted + 1, nil
+}
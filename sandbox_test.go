@@ -0,0 +1,44 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestInNamespace(t *testing.T) {
+	client := &firestore.Client{}
+	live := Storage{client: client}
+	sandbox := Storage{client: client, namespace: SandboxNamespace}
+	liveEvent := live.collection("users").Doc("u").Collection("events").Doc("e")
+	sandboxEvent := sandbox.collection("users").Doc("u").Collection("events").Doc("e")
+
+	if !live.inNamespace(liveEvent) || live.inNamespace(sandboxEvent) {
+		t.Errorf("live storage does not only see live data")
+	}
+	if !sandbox.inNamespace(sandboxEvent) || sandbox.inNamespace(liveEvent) {
+		t.Errorf("sandbox storage does not only see sandbox data")
+	}
+}
+
+func TestTestTokensOnlyWorkInSandbox(t *testing.T) {
+	live := Storage{}
+	sandbox := Storage{namespace: SandboxNamespace}
+
+	if err := live.checkTokenEnvironment("test_abc"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("test token outside of the sandbox returned %v; wanted ErrForbidden", err)
+	}
+	if err := live.checkTokenEnvironment("abc"); err != nil {
+		t.Errorf("live token returned %v", err)
+	}
+	if err := sandbox.checkTokenEnvironment("test_abc"); err != nil {
+		t.Errorf("test token in the sandbox returned %v", err)
+	}
+}
+
+func TestPurgeNamespaceRefusesLiveData(t *testing.T) {
+	if _, err := (Storage{}).PurgeNamespace(); !errors.Is(err, ErrForbidden) {
+		t.Errorf("purging live data returned %v; wanted ErrForbidden", err)
+	}
+}
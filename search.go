@@ -0,0 +1,235 @@
+package pursuit
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of search results.
+const (
+	SearchObjective = "objective"
+	SearchGoal      = "goal"
+	SearchAction    = "action"
+	SearchValue     = "value"
+)
+
+// searchTypeOrder breaks ties between results of the same score.
+var searchTypeOrder = map[string]int{SearchObjective: 0, SearchGoal: 1, SearchAction: 2, SearchValue: 3}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// searchValues is how many of the latest values of a goal that
+	// matches are results too.
+	searchValues = 3
+)
+
+// SearchResult is a result of a search over the objectives of a user,
+// for command palettes. Results of all types are ranked together by
+// their score.
+type SearchResult struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	// Detail tells where the result belongs, such as the objective of a
+	// goal.
+	Detail    string `json:"detail,omitempty"`
+	Objective string `json:"objective,omitempty"`
+	Goal      string `json:"goal,omitempty"`
+	// Value is set for values, and Action for actions.
+	Value  *DateValue     `json:"value,omitempty"`
+	Action *ActionRequest `json:"action,omitempty"`
+	// Score ranks the result, from 0 to 1.
+	Score float64 `json:"score"`
+}
+
+// ActionRequest is the request that carries out an action.
+type ActionRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Body holds the fields of the JSON body that name the goal. Clients
+	// add the rest, such as the value, before they send it.
+	Body map[string]string `json:"body,omitempty"`
+}
+
+// matchScore scores how well the text matches the query, from 0 for no
+// match to 1 for matching words. Each word of the query has to match a
+// word of the text, its start, any part of the text, or, failing that,
+// some of its characters in order.
+func matchScore(query, text string) float64 {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return 0
+	}
+	text = strings.ToLower(text)
+	textWords := strings.Fields(text)
+	var sum float64
+	for _, w := range words {
+		best := 0.0
+		for _, t := range textWords {
+			switch {
+			case t == w:
+				best = 1
+			case strings.HasPrefix(t, w) && best < 0.9:
+				best = 0.9
+			}
+		}
+		switch {
+		case best == 0 && strings.Contains(text, w):
+			best = 0.6
+		case best == 0 && isSubsequence(w, text):
+			best = 0.3
+		case best == 0:
+			return 0
+		}
+		sum += best
+	}
+	return sum / float64(len(words))
+}
+
+// isSubsequence reports whether the characters of s appear in t in order.
+func isSubsequence(s, t string) bool {
+	rs := []rune(s)
+	i := 0
+	for _, c := range t {
+		if i < len(rs) && rs[i] == c {
+			i++
+		}
+	}
+	return i == len(rs)
+}
+
+// maxScore returns the highest of the scores.
+func maxScore(scores ...float64) float64 {
+	var max float64
+	for _, s := range scores {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// Search returns the objectives, goals, latest values and actions that
+// match the query, best first. Goals also match by the name of their
+// objective, but score lower that way. Archived goals score lower and
+// have no actions.
+func Search(objectives []ObjectiveEntry, query string) []SearchResult {
+	var results []SearchResult
+	for _, o := range objectives {
+		if score := maxScore(matchScore(query, o.Name), matchScore(query, o.Slug)); score > 0 {
+			results = append(results, SearchResult{
+				Type:      SearchObjective,
+				Title:     o.Name,
+				Objective: o.ID,
+				Score:     score,
+			})
+		}
+		for id, g := range o.Goals {
+			score := maxScore(
+				matchScore(query, g.Name),
+				matchScore(query, g.Slug),
+				0.8*matchScore(query, g.Name+" "+o.Name),
+			)
+			if g.Stage == StageArchived {
+				score /= 2
+			}
+			if score > 0 {
+				results = append(results, SearchResult{
+					Type:      SearchGoal,
+					Title:     g.Name,
+					Detail:    o.Name,
+					Objective: o.ID,
+					Goal:      id,
+					Score:     score,
+				})
+				for i := len(g.Trajectory) - 1; i >= 0 && i >= len(g.Trajectory)-searchValues; i-- {
+					v := g.Trajectory[i]
+					results = append(results, SearchResult{
+						Type:      SearchValue,
+						Title:     strings.TrimSpace(strconv.FormatFloat(float64(v.Value), 'g', -1, 32) + " " + g.Unit),
+						Detail:    g.Name + " in " + o.Name,
+						Objective: o.ID,
+						Goal:      id,
+						Value:     &v,
+						Score:     0.8 * score,
+					})
+				}
+			}
+			if g.Stage == StageArchived {
+				continue
+			}
+			body := map[string]string{"objective": o.ID, "goal": id}
+			for _, a := range []struct{ title, path string }{
+				{"Log value to " + g.Name, "/setgoalvalue"},
+				{"Add to " + g.Name, "/incrementgoalvalue"},
+			} {
+				score := 0.9 * maxScore(matchScore(query, a.title), 0.8*matchScore(query, a.title+" "+o.Name))
+				if score == 0 {
+					continue
+				}
+				results = append(results, SearchResult{
+					Type:      SearchAction,
+					Title:     a.title,
+					Detail:    o.Name,
+					Objective: o.ID,
+					Goal:      id,
+					Action:    &ActionRequest{Method: http.MethodPost, Path: a.path, Body: body},
+					Score:     score,
+				})
+			}
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Type != b.Type:
+			return searchTypeOrder[a.Type] < searchTypeOrder[b.Type]
+		case a.Value != nil && b.Value != nil && a.Value.Date != b.Value.Date:
+			return a.Value.Date > b.Value.Date
+		case a.Title != b.Title:
+			return a.Title < b.Title
+		case a.Objective != b.Objective:
+			return a.Objective < b.Objective
+		}
+		return a.Goal < b.Goal
+	})
+	return results
+}
+
+// search serves GET /users/{user}/search?q=...&limit=..., which returns
+// the results of Search for the query, see SearchResult.
+func (s *Server) search(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	var v validation
+	v.require("q", strings.TrimSpace(q.Get("q")))
+	limit := defaultSearchLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		v.check(err == nil && n >= 1 && n <= maxSearchLimit, "limit", "wanted 1 to %d, got %q", maxSearchLimit, l)
+		limit = n
+	}
+	if err := v.err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	results := Search(objectives, q.Get("q"))
+	if results == nil {
+		results = []SearchResult{}
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
@@ -0,0 +1,110 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchScore(t *testing.T) {
+	for _, c := range []struct {
+		query, text string
+		want        float64
+	}{
+		{"run", "Run", 1},
+		{"ru", "Run", 0.9},
+		{"un", "Run", 0.6},
+		{"rn", "Run", 0.3},
+		{"log run", "Log value to Run", 1},
+		{"swim", "Run", 0},
+		{"run swim", "Run", 0},
+		{"", "Run", 0},
+	} {
+		if got := matchScore(c.query, c.text); got != c.want {
+			t.Errorf("matchScore(%q, %q) was %v; wanted %v", c.query, c.text, got, c.want)
+		}
+	}
+}
+
+func TestSearchRanksTypes(t *testing.T) {
+	objectives := []ObjectiveEntry{
+		{"fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+			"run":  {Name: "Run", Unit: "km", Trajectory: Trajectory{{Date: 1, Value: 3}, {Date: 2, Value: 5}}},
+			"swim": {Name: "Swim", Stage: StageArchived},
+		}}},
+		{"running", Objective{Name: "Running club"}},
+	}
+
+	results := Search(objectives, "run")
+
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Type+":"+r.Title)
+	}
+	want := []string{
+		"goal:Run",
+		"objective:Running club",
+		"action:Add to Run",
+		"action:Log value to Run",
+		"value:5 km",
+		"value:3 km",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("results were %v; wanted %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("results were %v; wanted %v", got, want)
+			break
+		}
+	}
+	if a := results[3].Action; a == nil || a.Path != "/setgoalvalue" || a.Body["goal"] != "run" {
+		t.Errorf("action was %+v", a)
+	}
+}
+
+func TestSearchMatchesGoalsByObjective(t *testing.T) {
+	objectives := []ObjectiveEntry{
+		{"fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+			"run":  {Name: "Run"},
+			"swim": {Name: "Swim", Stage: StageArchived},
+		}}},
+	}
+
+	results := Search(objectives, "fitness swim")
+
+	if len(results) == 0 || results[0].Type != SearchGoal || results[0].Goal != "swim" {
+		t.Fatalf("results were %+v; wanted the goal swim first", results)
+	}
+	for _, r := range results {
+		if r.Type == SearchAction {
+			t.Errorf("archived goal had action %q", r.Title)
+		}
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	s, _ := newMemoryServer()
+	r := httptest.NewRequest(http.MethodGet, "/users/alice/search?q=run&limit=2", nil)
+	w := httptest.NewRecorder()
+
+	s.search(w, r, "alice")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var resp struct{ Results []SearchResult }
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 || resp.Results[0].Type != SearchGoal || resp.Results[0].Goal != "run" {
+		t.Errorf("results were %+v", resp.Results)
+	}
+
+	w = httptest.NewRecorder()
+	s.search(w, httptest.NewRequest(http.MethodGet, "/users/alice/search?q=", nil), "alice")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status without query was %d; wanted 400", w.Code)
+	}
+}
@@ -0,0 +1,2142 @@
+package pursuit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server exposes objectives and goals stored in Firestore over HTTP.
+type Server struct {
+	storage *Storage
+	// goals is the storage for the goal endpoints, see goalStore.
+	goals     goalStore
+	mux       *http.ServeMux
+	publisher statusPublisher
+	coalescer *incrementCoalescer
+	webhooks  *http.Client
+	lockouts  *lockouts
+	digests   *DigestTemplates
+	push      PushSender
+	bigquery  *BigQuerySync
+	exports   *ExportBucket
+	snapshots *ExportBucket
+	reads     *readCache
+	// instance identifies the server in leases of scheduled jobs.
+	instance string
+	auth     IDTokenVerifier
+	// tasks authenticates the callers of scheduled jobs.
+	tasks  taskAuth
+	jobs   *jobRunner
+	strava *Strava
+	// presence tracks the viewers of streams of objectives.
+	presence *presenceHub
+	// limits limits the rate of changes per user, see LimitRate.
+	limits *tokenBuckets
+	// proxies is the number of trusted proxies, see TrustProxies.
+	proxies int
+	// version identifies the deployed revision, see SetVersion.
+	version string
+}
+
+// NewServer creates a server backed by the given storage.
+func NewServer(storage *Storage) *Server {
+	s := &Server{
+		storage:   storage,
+		goals:     storage,
+		mux:       http.NewServeMux(),
+		publisher: newStatusPublisher(),
+		webhooks:  newWebhookClient(10 * time.Second),
+		lockouts:  newLockouts(),
+		digests:   DefaultDigestTemplates,
+		reads:     newReadCache(publicReadTTL),
+		instance:  newInstanceID(),
+		presence:  newPresenceHub(),
+		proxies:   defaultTrustedProxies,
+	}
+	s.jobs = newJobRunner(storage, jobWorkers)
+	go s.jobs.failStaleJobs(time.Now())
+	storage.HandleEvents(s.runGoalHooks)
+	storage.NotifyMilestones(s.notifyMilestone)
+	s.mux.HandleFunc("/", s.root)
+	s.mux.HandleFunc("/version", s.buildInfo)
+	s.mux.HandleFunc("/templates", s.listTemplates)
+	s.mux.HandleFunc("/templates/", s.instantiateTemplate)
+	s.mux.HandleFunc("/users/", s.users)
+	s.mux.HandleFunc("/jobs/", s.getJob)
+	s.mux.HandleFunc("/shared/objectives/", s.sharedObjective)
+	s.mux.HandleFunc("/grafana/objectives/", s.grafana)
+	s.mux.HandleFunc("/setgoalvalue", s.setGoalValue)
+	s.mux.HandleFunc("/incrementgoalvalue", s.incrementGoalValue)
+	s.mux.HandleFunc("/incrementgoalvalueifstale", s.incrementGoalValueIfStale)
+	s.mux.HandleFunc("/batchsetgoalvalues", s.batchSetGoalValues)
+	s.mux.HandleFunc("/setgoalstage", s.setGoalStage)
+	s.mux.HandleFunc("/strava/callback", s.stravaCallback)
+	s.mux.HandleFunc("/webhooks/strava", s.stravaWebhook)
+	s.mux.HandleFunc("/tasks/publishstatus", s.job("publishstatus", s.publishStatus))
+	s.mux.HandleFunc("/tasks/onboarding", s.job("onboarding", s.runOnboarding))
+	s.mux.HandleFunc("/tasks/exportmetrics", s.job("exportmetrics", s.exportMetrics))
+	s.mux.HandleFunc("/tasks/bigquerysync", s.job("bigquerysync", s.syncBigQuery))
+	s.mux.HandleFunc("/tasks/import", s.job("import", s.runImports))
+	s.mux.HandleFunc("/tasks/publishsnapshots", s.job("publishsnapshots", s.publishSnapshots))
+	s.mux.HandleFunc("/tasks/purgesandbox", s.job("purgesandbox", s.purgeSandbox))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	l := &requestLog{id: requestID(r), ip: clientIP(r, s.proxies)}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w.Header().Set(RequestIDHeader, l.id)
+	if s.storage.Sandbox() {
+		w.Header().Set("X-Pursuit-Sandbox", "true")
+	}
+	r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, l))
+	s.mux.ServeHTTP(rec, r)
+	logRequest(r, l, rec, time.Since(start))
+	// Only requests with share tokens are recorded for their owner, as
+	// signed-in users make most requests from the app.
+	if l.token != "" {
+		s.storage.recordAPIRequest(l.user, newAPIRequest(r, l.token, rec.status))
+	}
+}
+
+// storageFor returns the storage bound to the context of the request, so
+// that Firestore operations are canceled when the client goes away. Work
+// that outlives the request, such as jobs, uses s.storage instead.
+func (s *Server) storageFor(r *http.Request) *Storage {
+	return s.storage.WithContext(r.Context())
+}
+
+// goalsFor returns the goal store bound to the context of the request,
+// which applies changes once per idempotency key of the request.
+func (s *Server) goalsFor(r *http.Request) goalStore {
+	goals := s.goals.withContext(r.Context())
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		goals = goals.withIdempotencyKey(key)
+	}
+	return goals
+}
+
+// CoalesceIncrements makes the server sum up increments of the same goal
+// that arrive within the window and write them as a single point on the
+// trajectory. Such increments are accepted before they are written.
+func (s *Server) CoalesceIncrements(window time.Duration) {
+	s.coalescer = newIncrementCoalescer(window, func(k goalKey, delta float32) error {
+		return s.goals.IncrementGoalValue(k.User, k.Objective, k.Goal, delta, k.Unit)
+	})
+}
+
+// TrustProxies sets how many proxies in front of the server append the
+// address of their client to X-Forwarded-For, which is one on Cloud Run.
+// The address of the client, which share tokens restrict and lockouts
+// count, is the one that the outermost of them appended. With zero,
+// X-Forwarded-For is ignored.
+func (s *Server) TrustProxies(n int) {
+	s.proxies = n
+}
+
+// SetVersion sets the version of the service that GET / reports, such as
+// the revision of the deployment.
+func (s *Server) SetVersion(version string) {
+	s.version = version
+}
+
+// UseDigestTemplates makes the server render digests with the given
+// templates.
+func (s *Server) UseDigestTemplates(t *DigestTemplates) {
+	s.digests = t
+}
+
+// UseIDTokenVerifier makes the server accept requests of signed-in users
+// whose ID tokens the verifier accepts. Without it, only requests with
+// share tokens are served.
+func (s *Server) UseIDTokenVerifier(auth IDTokenVerifier) {
+	s.auth = auth
+}
+
+// UsePushSender makes the server send push notifications, such as the
+// onboarding sequence, through the given sender.
+func (s *Server) UsePushSender(push PushSender) {
+	s.push = push
+}
+
+// UseExportBucket makes the server generate exports in the background
+// and hand them out as signed URLs of the bucket.
+func (s *Server) UseExportBucket(b *ExportBucket) {
+	s.exports = b
+}
+
+// SyncBigQuery makes the server stream trajectories into BigQuery when
+// the sync task runs.
+func (s *Server) SyncBigQuery(b *BigQuerySync) {
+	s.bigquery = b
+}
+
+// Flush writes all pending coalesced increments.
+func (s *Server) Flush() {
+	if s.coalescer != nil {
+		s.coalescer.flushAll()
+	}
+}
+
+// listTemplates serves GET /templates?category=...
+func (s *Server) listTemplates(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if _, err := s.authenticate(r); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	templates, err := s.storageFor(r).ListTemplates(r.URL.Query().Get("category"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// instantiateTemplate serves POST /templates/{template}/instantiate
+func (s *Server) instantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path)
+	if len(parts) != 3 || parts[2] != "instantiate" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	userID, err := s.authenticate(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	var req struct {
+		// User is optional, and must be the signed-in user.
+		User string
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.User != "" {
+		resolved, err := s.storageFor(r).ResolveUser(req.User)
+		if err == nil && resolved != userID {
+			err = fmt.Errorf("Cannot access user %q: %w", req.User, ErrForbidden)
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	objectiveID, err := s.storageFor(r).InstantiateTemplate(userID, parts[1])
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"objective": objectiveID})
+}
+
+// goalRequest is the body of requests that update the value of a goal.
+type goalRequest struct {
+	User      string  `json:"user"`
+	Objective string  `json:"objective"`
+	Goal      string  `json:"goal"`
+	Value     float32 `json:"value"`
+	Delta     float32 `json:"delta"`
+	// Unit of Value or Delta, optional.
+	Unit string `json:"unit"`
+	// MaxAge in seconds, for conditional increments.
+	MaxAge float64 `json:"maxAge"`
+	// Stage to move the goal to, see SetGoalStage.
+	Stage string `json:"stage"`
+}
+
+// decodeGoalRequest parses the body of a POST request that refers to a
+// goal. Requests act on behalf of the user whose ID token they carry as
+// bearer token, or on behalf of the owner of a share token that allows
+// to write the goal. The user in the body is optional and must match.
+// With an ID token, the objective and the goal may be named by their
+// slugs; share tokens are tied to IDs. It replies with an error and
+// returns false if the request is invalid.
+func (s *Server) decodeGoalRequest(w http.ResponseWriter, r *http.Request, req *goalRequest) bool {
+	if !allowMethod(w, r, http.MethodPost) {
+		return false
+	}
+	if err := decodeJSON(r.Body, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	token := bearerToken(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("Missing ID token or share token"))
+		return false
+	}
+	var v validation
+	v.require("objective", req.Objective)
+	v.require("goal", req.Goal)
+	v.check(req.MaxAge >= 0, "maxAge", "negative")
+	v.check(len(r.Header.Get(IdempotencyKeyHeader)) <= maxIdempotencyKey, IdempotencyKeyHeader, "longer than %d characters", maxIdempotencyKey)
+	if err := v.err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	var userID string
+	var err error
+	if isIDToken(token) {
+		userID, err = s.authenticate(r)
+		if err == nil && (req.User == "" || req.User == userID) {
+			req.Objective, req.Goal, err = s.goalsFor(r).resolveIDs(userID, req.Objective, req.Goal)
+		}
+	} else {
+		userID, err = s.authorize(r, token, AbilityWrite, req.Objective, req.Goal)
+	}
+	if err == nil && req.User != "" && req.User != userID {
+		err = fmt.Errorf("Cannot access user %q: %w", req.User, ErrForbidden)
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return false
+	}
+	req.User = userID
+	noteTarget(r, req.Objective, req.Goal)
+	return true
+}
+
+// setGoalValue serves POST /setgoalvalue
+func (s *Server) setGoalValue(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	if !s.decodeGoalRequest(w, r, &req) {
+		return
+	}
+	if err := s.goalsFor(r).SetGoalValue(req.User, req.Objective, req.Goal, req.Value, req.Unit); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// incrementGoalValue serves POST /incrementgoalvalue
+func (s *Server) incrementGoalValue(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	if !s.decodeGoalRequest(w, r, &req) {
+		return
+	}
+	// Increments with an idempotency key are written right away, since
+	// coalesced increments cannot be deduplicated.
+	if s.coalescer != nil && r.Header.Get(IdempotencyKeyHeader) == "" {
+		s.coalescer.add(goalKey{req.User, req.Objective, req.Goal, req.Unit}, req.Delta)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := s.goalsFor(r).IncrementGoalValue(req.User, req.Objective, req.Goal, req.Delta, req.Unit); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// incrementGoalValueIfStale serves POST /incrementgoalvalueifstale
+func (s *Server) incrementGoalValueIfStale(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	if !s.decodeGoalRequest(w, r, &req) {
+		return
+	}
+	if req.MaxAge == 0 {
+		writeError(w, http.StatusBadRequest, invalidField("maxAge", "missing"))
+		return
+	}
+	maxAge := time.Duration(req.MaxAge * float64(time.Second))
+	incremented, err := s.goalsFor(r).IncrementGoalValueIfStale(req.User, req.Objective, req.Goal, req.Delta, req.Unit, maxAge)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"incremented": incremented})
+}
+
+// setGoalStage serves POST /setgoalstage, which moves a goal to another
+// stage of its lifecycle, e.g. to complete or archive it.
+func (s *Server) setGoalStage(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	if !s.decodeGoalRequest(w, r, &req) {
+		return
+	}
+	if req.Stage == "" {
+		writeError(w, http.StatusBadRequest, invalidField("stage", "missing"))
+		return
+	}
+	if err := s.goalsFor(r).SetGoalStage(req.User, req.Objective, req.Goal, req.Stage); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchResult is the outcome of a value of a batch, with the status code
+// that the value would have gotten from POST /setgoalvalue.
+type BatchResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchSetGoalValues serves POST /batchsetgoalvalues, which sets the
+// values of several goals of the signed-in user at once and reports the
+// outcome of each. Share tokens are tied to single goals, so batches need
+// an ID token. Batches do not support idempotency keys.
+func (s *Server) batchSetGoalValues(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if r.Header.Get(IdempotencyKeyHeader) != "" {
+		writeError(w, http.StatusBadRequest, errors.New("Batches do not support idempotency keys"))
+		return
+	}
+	userID, err := s.authenticate(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	var req struct {
+		// User is optional, and must be the signed-in user.
+		User    string
+		Updates []GoalValue
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.User != "" && req.User != userID {
+		writeStorageError(w, fmt.Errorf("Cannot access user %q: %w", req.User, ErrForbidden))
+		return
+	}
+	errs, err := s.goalsFor(r).SetGoalValues(userID, req.Updates)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	results := make([]BatchResult, len(errs))
+	for i, err := range errs {
+		results[i] = BatchResult{Status: http.StatusNoContent}
+		if err != nil {
+			results[i] = BatchResult{Status: errorStatus(err), Error: err.Error()}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string][]BatchResult{"results": results})
+}
+
+// publishStatus serves POST /tasks/publishstatus, which is meant to be
+// triggered daily by Cloud Scheduler.
+func (s *Server) publishStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	updates, err := s.storageFor(r).ListStatusUpdates()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	failed := []string{}
+	for _, u := range updates {
+		if err := s.publishStatusUpdate(s.goalsFor(r), u, now); err != nil {
+			logf(r.Context(), severityError, "Error publishing status update %s/%s: %v", u.User, u.ID, err)
+			failed = append(failed, u.User+"/"+u.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"published": len(updates) - len(failed),
+		"failed":    failed,
+	})
+}
+
+func (s *Server) publishStatusUpdate(goals goalStore, u StatusUpdateEntry, now int64) error {
+	objective, err := goals.readObjective(u.User, u.Objective)
+	if err != nil {
+		return err
+	}
+	g, ok := objective.Goals[u.Goal]
+	if !ok {
+		return fmt.Errorf("No such goal: %q", u.Goal)
+	}
+	return s.publisher.publish(u.StatusUpdate, g, now)
+}
+
+// exportMetrics serves POST /tasks/exportmetrics, which is meant to be
+// triggered every few minutes by Cloud Scheduler.
+func (s *Server) exportMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	exports, err := s.storageFor(r).ListMetricExports()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	exported := 0
+	failed := []string{}
+	for _, m := range exports {
+		n, err := s.storageFor(r).ExportMetrics(s.webhooks, m)
+		if err != nil {
+			logf(r.Context(), severityError, "Error exporting metrics %s/%s: %v", m.User, m.ID, err)
+			failed = append(failed, m.User+"/"+m.ID)
+			continue
+		}
+		exported += n
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"exported": exported,
+		"failed":   failed,
+	})
+}
+
+// runImports serves POST /tasks/import, which is meant to be triggered
+// every few minutes by Cloud Scheduler.
+func (s *Server) runImports(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	imports, err := s.storageFor(r).ListImports()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	imported := 0
+	failed := []string{}
+	for _, e := range imports {
+		n, err := s.storageFor(r).RunImport(e)
+		imported += n
+		if err != nil {
+			logf(r.Context(), severityError, "Error importing %s/%s from %s: %v", e.User, e.ID, e.Source, err)
+			failed = append(failed, e.User+"/"+e.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"imported": imported,
+		"failed":   failed,
+	})
+}
+
+// syncBigQuery serves POST /tasks/bigquerysync, which is meant to be
+// triggered every few minutes by Cloud Scheduler.
+func (s *Server) syncBigQuery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.bigquery == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("BigQuery is not configured"))
+		return
+	}
+	synced, err := s.storageFor(r).SyncBigQuery(s.bigquery)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"synced": synced})
+}
+
+// purgeSandbox serves POST /tasks/purgesandbox, which is meant to be
+// triggered nightly by Cloud Scheduler for the sandbox.
+func (s *Server) purgeSandbox(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	deleted, err := s.storageFor(r).PurgeNamespace()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+// runOnboarding serves POST /tasks/onboarding, which is meant to be
+// triggered daily by Cloud Scheduler.
+func (s *Server) runOnboarding(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.push == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Push notifications are not configured"))
+		return
+	}
+	sent, err := s.storageFor(r).RunOnboarding(s.push)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+// users routes requests under /users/{user}/.
+func (s *Server) users(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path)
+	if len(parts) < 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authenticateUser(w, r, parts[1], routeScope(r.Method, parts)) {
+		return
+	}
+	if !s.resolvePath(w, r, parts) {
+		return
+	}
+	if len(parts) >= 4 && parts[2] == "objectives" {
+		var goalID string
+		if len(parts) >= 6 && parts[4] == "goals" {
+			goalID = parts[5]
+		}
+		noteTarget(r, parts[3], goalID)
+	}
+	switch {
+	case len(parts) == 3 && parts[2] == "merge":
+		s.mergeUser(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "events":
+		s.listEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "search":
+		s.search(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "stream":
+		s.stream(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "events" && parts[3] == "replay":
+		s.replayEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "tokens":
+		s.tokens(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "requests":
+		s.listAPIRequests(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "audit":
+		s.auditLog(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "digest" && parts[3] == "preview":
+		s.previewDigest(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "onboarding":
+		s.updateOnboarding(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "experiments":
+		s.experiments(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "devices":
+		s.devices(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "strava":
+		s.stravaConnection(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "strava" && parts[3] == "authorize":
+		s.authorizeStrava(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "objectives":
+		s.objectives(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "goals":
+		s.listGoals(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "objectives":
+		s.objective(w, r, parts[1], parts[3])
+	case len(parts) == 4 && parts[2] == "devices":
+		s.device(w, r, parts[1], parts[3])
+	case len(parts) == 4 && parts[2] == "tokens":
+		s.revokeToken(w, r, parts[1], parts[3])
+	case len(parts) == 3 && parts[2] == "export.parquet":
+		s.exportParquet(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "export":
+		s.exportUser(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "import":
+		s.importUser(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "exports":
+		s.createExport(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "imports":
+		s.listImportStatus(w, r, parts[1])
+	case len(parts) == 5 && parts[2] == "imports" && parts[4] == "sync":
+		s.syncImport(w, r, parts[1], parts[3])
+	case len(parts) == 3 && parts[2] == "conflicts":
+		s.listConflicts(w, r, parts[1])
+	case len(parts) == 5 && parts[2] == "conflicts" && parts[4] == "resolve":
+		s.resolveConflict(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "goals":
+		s.addGoal(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "webhooks":
+		s.notificationHooks(w, r, parts[1], parts[3])
+	case len(parts) == 6 && parts[2] == "objectives" && parts[4] == "webhooks":
+		s.deleteNotificationHook(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "checkins":
+		s.checkIns(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "export.csv":
+		s.exportCSV(w, r, parts[1], parts[3], "")
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "reviews":
+		s.reviews(w, r, parts[1], parts[3])
+	case len(parts) == 6 && parts[2] == "objectives" && parts[4] == "reviews":
+		s.review(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "reviews" && parts[6] == "close":
+		s.closeReview(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "description":
+		s.description(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[2] == "objectives" && parts[4] == "edits":
+		s.applyEdits(w, r, parts[1], parts[3])
+	case len(parts) == 6 && parts[2] == "objectives" && parts[4] == "goals":
+		s.goal(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "export.csv":
+		s.exportCSV(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "heatmap":
+		s.goalHeatmap(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "forecast":
+		s.forecastGoal(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "mute":
+		s.muteGoal(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "suggestion":
+		s.suggestTarget(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 7 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "hooks":
+		s.goalHooks(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 8 && parts[2] == "objectives" && parts[4] == "goals" && parts[6] == "hooks":
+		s.deleteGoalHook(w, r, parts[1], parts[3], parts[5], parts[7])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// resolvePath replaces the slugs of an objective and a goal in the path
+// segments of a request below /users/{user}/objectives by their IDs.
+// Paths that create an objective or a goal under the given ID are left
+// alone. It replies with an error and returns false if the IDs cannot be
+// resolved.
+func (s *Server) resolvePath(w http.ResponseWriter, r *http.Request, parts []string) bool {
+	if len(parts) < 4 || parts[2] != "objectives" {
+		return true
+	}
+	if r.Method == http.MethodPost && (len(parts) == 4 || len(parts) == 6 && parts[4] == "goals") {
+		return true
+	}
+	var goalRef string
+	if len(parts) >= 6 && parts[4] == "goals" {
+		goalRef = parts[5]
+	}
+	objectiveID, goalID, err := s.goalsFor(r).resolveIDs(parts[1], parts[3], goalRef)
+	if err != nil {
+		writeStorageError(w, err)
+		return false
+	}
+	parts[3] = objectiveID
+	if goalRef != "" {
+		parts[5] = goalID
+	}
+	return true
+}
+
+// mergeUser serves POST /users/{user}/merge, which merges the user into
+// the user Into. Since the data ends up in the account of Into, the
+// request must carry the ID token of Into as IntoToken, besides being
+// authorized for the user.
+func (s *Server) mergeUser(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Into      string
+		IntoToken string
+		DryRun    bool
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Into == "" {
+		writeError(w, http.StatusBadRequest, invalidField("into", "missing"))
+		return
+	}
+	if req.IntoToken == "" {
+		writeError(w, http.StatusBadRequest, invalidField("intoToken", "missing"))
+		return
+	}
+	if err := s.verifyUser(req.IntoToken, req.Into); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	report, err := s.storageFor(r).MergeUsers(userID, req.Into, req.DryRun)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if !req.DryRun {
+		s.audit(r, userID, AuditUserMerged, req.Into)
+		s.audit(r, req.Into, AuditUserMergedFrom, userID)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// listEvents serves GET /users/{user}/events?type=...&goal=...&since=...&limit=...
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	f, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := s.storageFor(r).ListEvents(userID, f)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// replayEvents serves POST /users/{user}/events/replay, which sends the
+// selected events to a webhook target again.
+func (s *Server) replayEvents(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Target string
+		EventFilter
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var v validation
+	if req.Target == "" {
+		v.invalid("target", "missing")
+	} else if err := validateTarget(req.Target); err != nil {
+		v.invalid("target", "wanted an HTTP(S) URL")
+	}
+	if err := v.err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := s.storageFor(r).ListEvents(userID, req.EventFilter)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, replayEvents(s.webhooks, req.Target, events))
+}
+
+// parseEventFilter reads an event filter from the query of a request.
+func parseEventFilter(r *http.Request) (EventFilter, error) {
+	q := r.URL.Query()
+	f := EventFilter{Type: q.Get("type"), Goal: q.Get("goal")}
+	var v validation
+	if s := q.Get("since"); s != "" {
+		since, err := strconv.ParseInt(s, 10, 64)
+		v.check(err == nil, "since", "wanted milliseconds since the epoch, got %q", s)
+		f.Since = since
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		v.check(err == nil && limit >= 0, "limit", "wanted a non-negative integer, got %q", s)
+		f.Limit = limit
+	}
+	return f, v.err()
+}
+
+// forecastGoal serves
+// GET /users/{user}/objectives/{objective}/goals/{goal}/forecast?tz=...
+// The optional time zone determines the day of the week of values.
+func (s *Server) forecastGoal(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	loc, err := time.LoadLocation(r.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, invalidField("tz", "unknown time zone %q", r.URL.Query().Get("tz")))
+		return
+	}
+	objective, err := s.goalsFor(r).readObjective(userID, objectiveID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	g, ok := objective.Goals[goalID]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such goal: %q", goalID))
+		return
+	}
+	f, err := ForecastGoal(g, time.Now().UnixNano()/1000/1000, loc)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+// goalHeatmap serves GET
+// /users/{user}/objectives/{objective}/goals/{goal}/heatmap?year=...&tz=...,
+// the activity on a goal per day of a year, see NewHeatmap. The year
+// defaults to the current one, and days are in the time zone tz, UTC by
+// default.
+func (s *Server) goalHeatmap(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	var v validation
+	loc, err := time.LoadLocation(r.URL.Query().Get("tz"))
+	v.check(err == nil, "tz", "unknown time zone %q", r.URL.Query().Get("tz"))
+	if loc == nil {
+		loc = time.UTC
+	}
+	year := time.Now().In(loc).Year()
+	if y := r.URL.Query().Get("year"); y != "" {
+		year, err = strconv.Atoi(y)
+		v.check(err == nil && year >= 1970 && year <= 9999, "year", "wanted 1970 to 9999, got %q", y)
+	}
+	if err := v.err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	objective, err := s.goalsFor(r).readObjective(userID, objectiveID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	g, ok := objective.Goals[goalID]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such goal: %q", goalID))
+		return
+	}
+	writeJSON(w, http.StatusOK, NewHeatmap(g, year, loc))
+}
+
+// muteGoal serves PUT and DELETE
+// /users/{user}/objectives/{objective}/goals/{goal}/mute, which mute and
+// unmute notifications about a goal.
+func (s *Server) muteGoal(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	var m *Mute
+	switch r.Method {
+	case http.MethodPut:
+		m = &Mute{}
+		if err := decodeJSON(r.Body, m); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var v validation
+		for i, k := range m.Kinds {
+			switch k {
+			case NotificationOffTrack, NotificationReminder, NotificationDigest, NotificationMilestone, NotificationGoalHook:
+			default:
+				v.check(false, fmt.Sprintf("kinds[%d]", i), "unknown notification kind %q", k)
+			}
+		}
+		if err := v.err(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	if err := s.goalsFor(r).MuteGoal(userID, objectiveID, goalID, m); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// goalHooks serves GET and POST
+// /users/{user}/objectives/{objective}/goals/{goal}/hooks, which list and
+// attach the webhooks that events of the goal are sent to. POST takes a
+// GoalHook with the URL and, optionally, the types of events to send.
+func (s *Server) goalHooks(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := s.storageFor(r).ListGoalHooks(userID, objectiveID, goalID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	case http.MethodPost:
+		var h GoalHook
+		if err := decodeJSON(r.Body, &h); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		e, err := s.storageFor(r).CreateGoalHook(userID, objectiveID, goalID, h)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.Header().Set("Location", r.URL.Path+"/"+e.ID)
+		writeJSON(w, http.StatusCreated, e)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// deleteGoalHook serves DELETE
+// /users/{user}/objectives/{objective}/goals/{goal}/hooks/{hook}
+func (s *Server) deleteGoalHook(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID, id string) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	if err := s.storageFor(r).DeleteGoalHook(userID, objectiveID, goalID, id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// suggestTarget serves
+// GET /users/{user}/objectives/{objective}/goals/{goal}/suggestion
+func (s *Server) suggestTarget(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	objective, err := s.goalsFor(r).readObjective(userID, objectiveID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	g, ok := objective.Goals[goalID]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such goal: %q", goalID))
+		return
+	}
+	if len(g.Trajectory) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Goal %q has no values", goalID))
+		return
+	}
+	writeJSON(w, http.StatusOK, SuggestTarget(g))
+}
+
+// tokens serves GET and POST /users/{user}/tokens, which list and create
+// share tokens. The secret of a token is only returned when it is created.
+func (s *Server) tokens(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := s.storageFor(r).ListTokens(userID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tokens)
+	case http.MethodPost:
+		var req struct {
+			Description     string
+			Scopes          []Scope
+			APIScopes       []string
+			AllowedNetworks []string
+			Expires         int64
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		secret, entry, err := s.storageFor(r).CreateToken(userID, ShareToken{
+			Description:     req.Description,
+			Scopes:          req.Scopes,
+			APIScopes:       req.APIScopes,
+			AllowedNetworks: req.AllowedNetworks,
+			Expires:         req.Expires,
+		})
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		s.audit(r, userID, AuditTokenCreated, entry.ID)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"token": secret,
+			"id":    entry.ID,
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// listAPIRequests serves GET /users/{user}/requests, the recent requests
+// made with share tokens of the user.
+func (s *Server) listAPIRequests(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	requests, err := s.storageFor(r).ListAPIRequests(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// previewDigest serves GET /users/{user}/digest/preview?format=..., which
+// renders the digest of the user as of now without sending it. The format
+// is either "html", the default, or "text".
+func (s *Server) previewDigest(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "html" && format != "text" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Unknown format: %q", format))
+		return
+	}
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	d := NewDigest(userID, objectives, now)
+	d.CheckIns, err = s.storageFor(r).DigestCheckIns(userID, objectives, now)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	_, d.Variants, err = s.storageFor(r).GetExperiments(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	html := format != "text"
+	digest, err := s.digests.Render(d, html)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if html {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	io.WriteString(w, digest)
+}
+
+// exportParquet serves GET /users/{user}/export.parquet, the trajectories
+// of all goals of the user as a Parquet file.
+func (s *Server) exportParquet(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditExportRequested, r.URL.Path)
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", `attachment; filename="pursuit.parquet"`)
+	if err := WriteParquet(w, trajectoryRows(objectives)); err != nil {
+		logf(r.Context(), severityError, "Error writing Parquet export of user %q: %v", userID, err)
+	}
+}
+
+// maxImportBody limits the size of user data that can be imported.
+const maxImportBody = 32 << 20
+
+// exportUser serves GET /users/{user}/export, all objectives of the user
+// as a JSON backup, see UserData.
+func (s *Server) exportUser(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditExportRequested, r.URL.Path)
+	w.Header().Set("Content-Disposition", `attachment; filename="pursuit.json"`)
+	writeJSON(w, http.StatusOK, newUserData(objectives, time.Now().UnixNano()/1000/1000))
+}
+
+// importUser serves POST /users/{user}/import?overwrite=..., which
+// restores objectives from a JSON backup made by /users/{user}/export.
+// Existing objectives are only replaced if overwrite is true. It replies
+// with an ImportReport.
+func (s *Server) importUser(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var d UserData
+	if err := decodeJSON(http.MaxBytesReader(w, r.Body, maxImportBody), &d); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	report, err := s.storageFor(r).ImportUser(userID, d, r.URL.Query().Get("overwrite") == "true")
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditUserImported, "")
+	writeJSON(w, http.StatusOK, report)
+}
+
+// exportCSV serves GET /users/{user}/objectives/{objective}/export.csv
+// and GET /users/{user}/objectives/{objective}/goals/{goal}/export.csv,
+// the trajectories of the goals of an objective or of one goal as CSV.
+func (s *Server) exportCSV(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	o, err := s.goalsFor(r).readObjective(userID, objectiveID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	rows, err := objectiveRows(objectiveID, o, goalID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditExportRequested, r.URL.Path)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="trajectory.csv"`)
+	if err := WriteCSV(w, rows); err != nil {
+		logf(r.Context(), severityError, "Error writing CSV export of user %q: %v", userID, err)
+	}
+}
+
+// applyEdits serves POST /users/{user}/objectives/{objective}/edits, which
+// replays edits that a client queued while offline. Edits that conflict
+// with changes by other clients are not applied, but returned.
+func (s *Server) applyEdits(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var edits []MetadataEdit
+	if err := decodeJSON(r.Body, &edits); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conflicts, err := s.storageFor(r).ApplyEdits(userID, objectiveID, edits)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"conflicts": conflicts})
+}
+
+// listConflicts serves GET /users/{user}/conflicts
+func (s *Server) listConflicts(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	conflicts, err := s.storageFor(r).ListConflicts(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conflicts)
+}
+
+// listImportStatus serves GET /users/{user}/imports
+func (s *Server) listImportStatus(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	statuses, err := s.storageFor(r).ListImportStatus(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// createExport serves POST /users/{user}/exports, which starts a job that
+// writes the trajectories of all goals of the user as a Parquet file into
+// the export bucket. The result of the job is an Export with a signed URL.
+func (s *Server) createExport(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.exports == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Exports are not configured"))
+		return
+	}
+	id, err := s.jobs.enqueue("export", userID, func(progress func(float64)) (interface{}, error) {
+		objectives, err := s.goals.ListObjectives(userID)
+		if err != nil {
+			return nil, err
+		}
+		progress(0.5)
+		return s.exports.ExportParquet(userID, trajectoryRows(objectives), time.Now())
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditExportRequested, r.URL.Path)
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, map[string]string{"job": id})
+}
+
+// syncImport serves POST /users/{user}/imports/{import}/sync, which
+// starts a job that runs the import. The result of the job is the new
+// status of the import.
+func (s *Server) syncImport(w http.ResponseWriter, r *http.Request, userID, importID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	id, err := s.jobs.enqueue("sync-import", userID, func(func(float64)) (interface{}, error) {
+		return s.storage.SyncImport(userID, importID)
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, map[string]string{"job": id})
+}
+
+// getJob serves GET /jobs/{job} to the user who started the job.
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	userID, err := s.authenticate(r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	job, err := s.storageFor(r).GetJob(userID, parts[1])
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// resolveConflict serves POST /users/{user}/conflicts/{conflict}/resolve
+func (s *Server) resolveConflict(w http.ResponseWriter, r *http.Request, userID, conflictID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Value string
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.storageFor(r).ResolveConflict(userID, conflictID, req.Value); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateOnboarding serves PUT /users/{user}/onboarding, which lets users
+// opt out of onboarding notifications.
+func (s *Server) updateOnboarding(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodPut) {
+		return
+	}
+	var req struct {
+		OptOut bool
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.storageFor(r).SetOnboardingOptOut(userID, req.OptOut); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// experiments serves GET and PUT /users/{user}/experiments. GET replies
+// with whether the user opted in to experiments and the variant of each
+// experiment for the user, and PUT lets users opt in or out.
+func (s *Server) experiments(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		state, variants, err := s.storageFor(r).GetExperiments(userID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			ExperimentsState
+			Variants map[string]string `json:"variants"`
+		}{state, variants})
+	case http.MethodPut:
+		var req ExperimentsState
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.storageFor(r).SetExperimentsOptIn(userID, req.OptIn); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// listGoals serves GET /users/{user}/goals, the goals of all objectives
+// of a user with their current values.
+func (s *Server) listGoals(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, goalEntries(objectives))
+}
+
+// listObjectives serves GET /users/{user}/objectives
+func (s *Server) listObjectives(w http.ResponseWriter, r *http.Request, userID string) {
+	objectives, err := s.goalsFor(r).ListObjectives(userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, objectives)
+}
+
+// objectives serves GET and POST /users/{user}/objectives, which list the
+// objectives of a user and add an objective under a generated ID. POST
+// replies with the ID and the slug of the new objective.
+func (s *Server) objectives(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listObjectives(w, r, userID)
+	case http.MethodPost:
+		var o Objective
+		if err := decodeJSON(r.Body, &o); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		id, slug, err := s.storageFor(r).AddObjective(userID, o)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": id, "slug": slug})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// addGoal serves POST /users/{user}/objectives/{objective}/goals, which
+// adds a goal to an existing objective under a generated ID. It replies
+// with the ID and the slug of the new goal.
+func (s *Server) addGoal(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var g Goal
+	if err := decodeJSON(r.Body, &g); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id, slug, err := s.storageFor(r).AddGoal(userID, objectiveID, g)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id, "slug": slug})
+}
+
+// objective serves GET, POST, PUT, PATCH and DELETE
+// /users/{user}/objectives/{objective}, which read, create, replace,
+// patch and delete an objective. PATCH takes a JSON merge patch.
+func (s *Server) objective(w http.ResponseWriter, r *http.Request, userID, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		o, err := s.storageFor(r).GetObjective(userID, id)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodPost, http.MethodPut:
+		var o Objective
+		if err := decodeJSON(r.Body, &o); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := s.storageFor(r).CreateObjective(userID, id, o); err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if err := s.storageFor(r).UpdateObjective(userID, id, o); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		patch, ok := readMergePatch(w, r)
+		if !ok {
+			return
+		}
+		if err := s.storageFor(r).PatchObjective(userID, id, patch); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.storageFor(r).DeleteObjective(userID, id); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// goal serves GET, POST, PATCH and DELETE
+// /users/{user}/objectives/{objective}/goals/{goal}, which read, add,
+// change and delete a goal of an existing objective. PATCH takes either a
+// GoalChange or, with the content type application/merge-patch+json, a
+// JSON merge patch of the goal.
+func (s *Server) goal(w http.ResponseWriter, r *http.Request, userID, objectiveID, goalID string) {
+	switch r.Method {
+	case http.MethodGet:
+		o, err := s.storageFor(r).GetObjective(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		g, ok := o.Goals[goalID]
+		if !ok {
+			writeStorageError(w, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound))
+			return
+		}
+		writeJSON(w, http.StatusOK, g)
+	case http.MethodPost:
+		var g Goal
+		if err := decodeJSON(r.Body, &g); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.storageFor(r).CreateGoal(userID, objectiveID, goalID, g); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPatch:
+		if isMergePatch(r) {
+			patch, ok := readMergePatch(w, r)
+			if !ok {
+				return
+			}
+			if err := s.storageFor(r).PatchGoal(userID, objectiveID, goalID, patch); err != nil {
+				writeStorageError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var c GoalChange
+		if err := decodeJSON(r.Body, &c); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.storageFor(r).ChangeGoal(userID, objectiveID, goalID, c); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.storageFor(r).DeleteGoal(userID, objectiveID, goalID); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// maxMergePatch limits the size of merge patches.
+const maxMergePatch = 1 << 20
+
+func isMergePatch(r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == MergePatchType
+}
+
+// readMergePatch reads the JSON merge patch in the body of the request.
+// Other content types are rejected.
+func readMergePatch(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if !isMergePatch(r) {
+		w.Header().Set("Accept-Patch", MergePatchType)
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content type must be %s", MergePatchType))
+		return nil, false
+	}
+	patch, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMergePatch))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return patch, true
+}
+
+// devices serves GET and POST /users/{user}/devices, which list and
+// register devices for push notifications.
+func (s *Server) devices(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		devices, err := s.storageFor(r).ListDevices(userID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, devices)
+	case http.MethodPost:
+		var req struct {
+			Token    string
+			Label    string
+			Platform string
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		id, err := s.storageFor(r).RegisterDevice(userID, req.Token, req.Label, req.Platform)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// device serves PATCH and DELETE /users/{user}/devices/{device}, which
+// change the label of a device or whether it receives reminders, and
+// revoke it.
+func (s *Server) device(w http.ResponseWriter, r *http.Request, userID, id string) {
+	switch r.Method {
+	case http.MethodPatch:
+		var u DeviceUpdate
+		if err := decodeJSON(r.Body, &u); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.storageFor(r).UpdateDevice(userID, id, u); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.storageFor(r).RevokeDevice(userID, id); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// revokeToken serves DELETE /users/{user}/tokens/{token}
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request, userID, id string) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	if err := s.storageFor(r).RevokeToken(userID, id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	s.audit(r, userID, AuditTokenRevoked, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notificationHooks serves GET and POST
+// /users/{user}/objectives/{objective}/webhooks, which list and register
+// the webhook URLs that milestones and records of the goals of the
+// objective are sent to. POST replies with the secret that signs the
+// deliveries, which cannot be read later.
+func (s *Server) notificationHooks(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := s.storageFor(r).ListNotificationHooks(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	case http.MethodPost:
+		var req struct {
+			URL string
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		secret, entry, err := s.storageFor(r).CreateNotificationHook(userID, objectiveID, req.URL)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"secret": secret,
+			"id":     entry.ID,
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// checkIns serves GET and POST
+// /users/{user}/objectives/{objective}/checkins. GET replies with the
+// check-in questions of the objective, the check-ins of the latest weeks
+// (?weeks=..., 12 by default) and the trends of the scale questions. POST
+// submits the answers of the current week.
+func (s *Server) checkIns(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	switch r.Method {
+	case http.MethodGet:
+		weeks := 12
+		if v := r.URL.Query().Get("weeks"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 53 {
+				writeError(w, http.StatusBadRequest, invalidField("weeks", "wanted 1 to 53, got %q", v))
+				return
+			}
+			weeks = n
+		}
+		o, err := s.storageFor(r).getObjective(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		checkIns, err := s.storageFor(r).ListCheckIns(userID, objectiveID, weeks)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"questions": o.CheckInQuestions,
+			"checkIns":  checkIns,
+			"trends":    CheckInTrends(o.CheckInQuestions, checkIns),
+		})
+	case http.MethodPost:
+		var req struct {
+			Answers     map[string]CheckInAnswer
+			PrivateNote *EncryptedNote
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		c, err := s.storageFor(r).SubmitCheckIn(userID, objectiveID, req.Answers, req.PrivateNote, time.Now().UnixNano()/1000/1000)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// reviews serves GET and POST /users/{user}/objectives/{objective}/reviews.
+// GET lists the reviews of the objective, latest first, and POST opens a
+// new one.
+func (s *Server) reviews(w http.ResponseWriter, r *http.Request, userID, objectiveID string) {
+	switch r.Method {
+	case http.MethodGet:
+		reviews, err := s.storageFor(r).ListReviews(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, reviews)
+	case http.MethodPost:
+		e, err := s.storageFor(r).OpenReview(userID, objectiveID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.Header().Set("Location", r.URL.Path+"/"+e.ID)
+		writeJSON(w, http.StatusCreated, e)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// review serves GET and PATCH
+// /users/{user}/objectives/{objective}/reviews/{review}. PATCH fills in
+// an open review with a ReviewChange.
+func (s *Server) review(w http.ResponseWriter, r *http.Request, userID, objectiveID, reviewID string) {
+	switch r.Method {
+	case http.MethodGet:
+		e, err := s.storageFor(r).GetReview(userID, objectiveID, reviewID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	case http.MethodPatch:
+		var c ReviewChange
+		if err := decodeJSON(r.Body, &c); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		e, err := s.storageFor(r).UpdateReview(userID, objectiveID, reviewID, c)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// closeReview serves POST
+// /users/{user}/objectives/{objective}/reviews/{review}/close. It replies
+// with the closed review and, as rollover, the draft of the objective of
+// the next period with the goals that carry over.
+func (s *Server) closeReview(w http.ResponseWriter, r *http.Request, userID, objectiveID, reviewID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	e, next, err := s.storageFor(r).CloseReview(userID, objectiveID, reviewID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"review":   e,
+		"rollover": next,
+	})
+}
+
+// deleteNotificationHook serves DELETE
+// /users/{user}/objectives/{objective}/webhooks/{hook}
+func (s *Server) deleteNotificationHook(w http.ResponseWriter, r *http.Request, userID, objectiveID, id string) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	if err := s.storageFor(r).DeleteNotificationHook(userID, objectiveID, id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sharedObjective serves GET /shared/objectives/{objective}?token=...,
+// the target of public links. The token may also be sent as bearer token.
+// GET /shared/objectives/{objective}/stream?token=...&name=... streams the
+// objective and its viewers as /users/{user}/stream does, with the
+// collaborator as a viewer under the optional name.
+// /shared/objectives/{objective}/description reads and edits the
+// description as /users/{user}/objectives/{objective}/description does,
+// where editing needs a token that allows writing.
+func (s *Server) sharedObjective(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path)
+	if len(parts) != 3 && (len(parts) != 4 || (parts[3] != "stream" && parts[3] != "description")) {
+		http.NotFound(w, r)
+		return
+	}
+	ability := AbilityRead
+	if len(parts) == 4 && parts[3] == "description" {
+		// Collaborators with a token that allows writing edit the
+		// description together.
+		if r.Method == http.MethodPost {
+			ability = AbilityWrite
+		}
+	} else if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	token := bearerToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("Missing token"))
+		return
+	}
+	userID, err := s.authorize(r, token, ability, parts[2], "")
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(parts) == 4 && parts[3] == "description" {
+		s.description(w, r, userID, parts[2])
+		return
+	}
+	if len(parts) == 4 {
+		viewer := &Viewer{ID: tokenID(token), Name: r.URL.Query().Get("name")}
+		s.streamObjectives(w, r, userID, parts[2], viewer, true)
+		return
+	}
+	objective, updated, err := s.publicObjective(r, userID, parts[2])
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	// Views embedded on pages revalidate with the update time of the
+	// objective, which is cheaper than downloading it again.
+	etag := fmt.Sprintf(`"%d"`, updated.UnixNano())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(publicReadTTL/time.Second)))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, objective)
+}
+
+// publicObjective reads an objective for a public endpoint, which may
+// serve it from the read cache.
+func (s *Server) publicObjective(r *http.Request, userID, objectiveID string) (Objective, time.Time, error) {
+	return s.reads.objective(userID, objectiveID, func() (Objective, time.Time, error) {
+		return s.storageFor(r).readObjectiveVersion(userID, objectiveID)
+	})
+}
+
+// grafana serves the SimpleJSON data source protocol for Grafana over the
+// goals of an objective, with /grafana/objectives/{objective} as the URL of
+// the data source. Requests need a share token that allows reading the
+// objective.
+func (s *Server) grafana(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r.URL.Path)
+	if len(parts) != 3 && len(parts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+	token := bearerToken(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("Missing token"))
+		return
+	}
+	userID, err := s.authorize(r, token, AbilityRead, parts[2], "")
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(parts) == 3 {
+		// Grafana tests the data source with a request to its URL.
+		if allowMethod(w, r, http.MethodGet) {
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+	objective, _, err := s.publicObjective(r, userID, parts[2])
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	switch parts[3] {
+	case "search":
+		if allowMethod(w, r, http.MethodPost) {
+			writeJSON(w, http.StatusOK, grafanaSearch(objective))
+		}
+	case "query":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		var q GrafanaQuery
+		if err := decodeJSON(r.Body, &q); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, grafanaQuery(objective, q))
+	case "annotations":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		var q GrafanaAnnotationQuery
+		if err := decodeJSON(r.Body, &q); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, grafanaAnnotations(objective, q))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize checks a share token through the storage and notes the owner
+// of the token in the log of the request. Clients that keep failing are
+// locked out per source IP and per token with increasing delays.
+func (s *Server) authorize(r *http.Request, secret, ability, objectiveID, goalID string) (string, error) {
+	return s.checkToken(r, secret, objectiveID, goalID, func(storage *Storage) (string, error) {
+		return storage.Authorize(secret, sourceIP(r), ability, objectiveID, goalID)
+	})
+}
+
+// authorizeAPI checks that a share token grants an API scope, as
+// authorize does for abilities.
+func (s *Server) authorizeAPI(r *http.Request, secret, scope string) (string, error) {
+	return s.checkToken(r, secret, "", "", func(storage *Storage) (string, error) {
+		return storage.AuthorizeAPI(secret, sourceIP(r), scope)
+	})
+}
+
+// checkToken runs a check of a share token for authorize and
+// authorizeAPI. The objective and goal label lockout events. Requests
+// that change data count towards the rate limit of the owner of the token.
+func (s *Server) checkToken(r *http.Request, secret, objectiveID, goalID string, check func(*Storage) (string, error)) (string, error) {
+	ip := sourceIP(r)
+	ipKey, tokenKey := lockoutKeys(ip, secret)
+	if wait := s.lockouts.wait(ipKey, tokenKey); wait > 0 {
+		return "", &LockedOutError{wait}
+	}
+	userID, err := check(s.storageFor(r))
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok && userID != "" {
+		l.user = userID
+		l.token = tokenID(secret)
+	}
+	if objectiveID != "" {
+		noteTarget(r, objectiveID, goalID)
+	}
+	switch {
+	case err == nil:
+		s.lockouts.succeed(tokenKey)
+		err = s.limitRate(r, userID)
+	case errors.Is(err, ErrForbidden):
+		// Failures are counted per token only for tokens that exist, as
+		// guessed secrets never repeat and would only fill the lockouts.
+		keys := []string{ipKey}
+		if userID != "" {
+			keys = append(keys, tokenKey)
+		}
+		if locked := s.lockouts.fail(keys...); locked > 0 {
+			logf(r.Context(), severityWarning, "Security: locked out %s and token %q for %v after failed attempts", ip, tokenID(secret), locked)
+			if userID != "" {
+				s.storageFor(r).recordEvent(userID, Event{
+					Type:      EventSecurityLockout,
+					Objective: objectiveID,
+					Goal:      goalID,
+					Date:      time.Now().UnixNano() / 1000 / 1000,
+				})
+				s.audit(r, userID, AuditLockout, tokenID(secret))
+			}
+		}
+	}
+	return userID, err
+}
+
+// bearerToken returns the bearer token of a request, if any.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// allowMethod replies with an error unless the request uses the given
+// method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return false
+	}
+	return true
+}
+
+// pathParts splits an URL path into its non-empty segments.
+func pathParts(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// APIVersion is the version of the JSON wire format of the API, which
+// responses carry in the X-Pursuit-API-Version header. It is bumped
+// whenever fields are renamed, removed or change their type, see
+// wire_test.go. Version 2 switched from Go field names to camelCase.
+const APIVersion = 2
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Pursuit-API-Version", strconv.Itoa(APIVersion))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError replies with the error as JSON. Validation errors list the
+// invalid fields as well, see ValidationError. The error goes into the log
+// entry of the request.
+func writeError(w http.ResponseWriter, status int, err error) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.err = err
+	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		writeJSON(w, status, struct {
+			Error  string       `json:"error"`
+			Fields []FieldError `json:"fields"`
+		}{err.Error(), invalid.Fields})
+		return
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// errorStatus maps errors returned from Storage to status codes.
+func errorStatus(err error) int {
+	var unavailable *UnavailableError
+	var lockedOut *LockedOutError
+	var rateLimited *RateLimitedError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidValue):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &lockedOut), errors.As(err, &rateLimited):
+		return http.StatusTooManyRequests
+	case errors.As(err, &unavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeStorageError replies with the status code of an error returned
+// from Storage, and tells clients when to retry if the error is temporary.
+func writeStorageError(w http.ResponseWriter, err error) {
+	var unavailable *UnavailableError
+	var lockedOut *LockedOutError
+	var rateLimited *RateLimitedError
+	switch {
+	case errors.As(err, &lockedOut):
+		seconds := int(math.Ceil(lockedOut.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	case errors.As(err, &rateLimited):
+		seconds := int(math.Ceil(rateLimited.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	case errors.As(err, &unavailable):
+		seconds := int(math.Ceil(unavailable.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	writeError(w, errorStatus(err), err)
+}
@@ -0,0 +1,326 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Objectives and goals that the storage creates under a generated ID get
+// a slug derived from their name, such as running-distance-2025, which
+// endpoints accept in place of the ID. Slugs of objectives are unique per
+// user and indexed in users/{user}/slugs/{slug}; slugs of goals are
+// unique within their objective. IDs take precedence over slugs, and
+// slugs cannot be changed once assigned.
+
+// maxSlugLength bounds the length of slugs, before a suffix that makes
+// them unique.
+const maxSlugLength = 60
+
+// maxSlugAttempts bounds the suffixes that are tried to make a slug
+// unique, after which the ID is appended instead.
+const maxSlugAttempts = 20
+
+// IDGenerator generates IDs of new objectives and goals.
+type IDGenerator func() string
+
+// UseIDGenerator makes the storage generate the IDs of new objectives and
+// goals with g instead of random Firestore IDs. It must be called before
+// the storage is used.
+func (s *Storage) UseIDGenerator(g IDGenerator) {
+	s.ids = g
+}
+
+// newID returns an ID for a new objective or goal.
+func (s Storage) newID() string {
+	if s.ids != nil {
+		return s.ids()
+	}
+	return s.collection("users").NewDoc().ID
+}
+
+// Slugify turns a name into a slug of lowercase ASCII letters and digits
+// separated by single hyphens. Other characters separate words. It
+// returns an empty string if the name has no letters or digits.
+func Slugify(name string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(name) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			separate = true
+			continue
+		}
+		if separate && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		separate = false
+		b.WriteRune(r)
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// uniqueSlug returns the slug of name, or fallback if the name has none,
+// with the first suffix -2, -3, … that makes it not taken. If all of them
+// are taken, the ID is appended instead.
+func uniqueSlug(name, fallback, id string, taken func(slug string) (bool, error)) (string, error) {
+	base := Slugify(name)
+	if base == "" {
+		base = fallback
+	}
+	for i := 1; i <= maxSlugAttempts; i++ {
+		slug := base
+		if i > 1 {
+			slug += "-" + strconv.Itoa(i)
+		}
+		t, err := taken(slug)
+		if err != nil {
+			return "", err
+		}
+		if !t {
+			return slug, nil
+		}
+	}
+	return base + "-" + strings.ToLower(id), nil
+}
+
+// goalSlugTaken reports whether a goal of the objective has the slug as
+// ID or slug.
+func goalSlugTaken(o Objective, slug string) bool {
+	for id, g := range o.Goals {
+		if id == slug || g.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// assignGoalSlugs gives the goals of the objective that have no slug a
+// unique one. The goals are copied, since they may belong to the caller.
+func assignGoalSlugs(o *Objective) {
+	goals := make(map[string]Goal, len(o.Goals))
+	for id, g := range o.Goals {
+		goals[id] = g
+	}
+	o.Goals = goals
+	for id, g := range goals {
+		if g.Slug != "" {
+			continue
+		}
+		g.Slug, _ = uniqueSlug(g.Name, "goal", id, func(slug string) (bool, error) {
+			return goalSlugTaken(*o, slug), nil
+		})
+		goals[id] = g
+	}
+}
+
+// resolveGoalID returns the ID of the goal of the objective that ref
+// names, either by its ID or its slug, or ref if no goal matches.
+func (o Objective) resolveGoalID(ref string) string {
+	if _, ok := o.Goals[ref]; ok {
+		return ref
+	}
+	for id, g := range o.Goals {
+		if g.Slug == ref {
+			return id
+		}
+	}
+	return ref
+}
+
+// keepSlugs replaces the slugs of o and its goals with the stored ones,
+// so that replacing an objective cannot change them.
+func keepSlugs(o *Objective, stored Objective) {
+	o.Slug = stored.Slug
+	for id, g := range o.Goals {
+		if s, ok := stored.Goals[id]; ok {
+			g.Slug = s.Slug
+			o.Goals[id] = g
+		}
+	}
+}
+
+// checkSlugMask rejects field masks that touch a slug.
+func checkSlugMask(mask FieldMask) error {
+	for _, path := range mask {
+		parts := strings.Split(path, ".")
+		if path == "slug" || (len(parts) == 3 && parts[0] == "goals" && parts[2] == "slug") {
+			return fmt.Errorf("Field %q is a slug, which cannot be changed: %w", path, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// slugEntry is an entry of the slug index of the objectives of a user.
+type slugEntry struct {
+	Objective string `firestore:"objective"`
+}
+
+// slugRef returns the entry of the slug index of a user for the slug.
+func (s Storage) slugRef(userID, slug string) *firestore.DocumentRef {
+	return s.collection("users").Doc(userID).Collection("slugs").Doc(slug)
+}
+
+// reserveSlug picks a unique slug for a new objective of a user within a
+// transaction and adds it to the slug index. Slugs are also kept apart
+// from the IDs of objectives, and entries of the index whose objective
+// no longer has the slug are reused.
+func (s Storage) reserveSlug(tx *firestore.Transaction, userID, objectiveID, name string) (string, error) {
+	objectives := s.collection("users").Doc(userID).Collection("objectives")
+	slug, err := uniqueSlug(name, "objective", objectiveID, func(slug string) (bool, error) {
+		docs, err := tx.GetAll([]*firestore.DocumentRef{objectives.Doc(slug), s.slugRef(userID, slug)})
+		if err != nil {
+			return false, fmt.Errorf("Error reading slug index: %w", err)
+		}
+		if docs[0].Exists() {
+			return true, nil
+		}
+		if !docs[1].Exists() {
+			return false, nil
+		}
+		var e slugEntry
+		if err := docs[1].DataTo(&e); err != nil {
+			return false, fmt.Errorf("Error reading slug index: %w", err)
+		}
+		doc, err := tx.Get(objectives.Doc(e.Objective))
+		if doc != nil && !doc.Exists() {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return false, fmt.Errorf("Error reading objective: %w", err)
+		}
+		return o.Slug == slug, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return slug, tx.Set(s.slugRef(userID, slug), slugEntry{objectiveID})
+}
+
+// AddObjective stores a new objective of a user under a generated ID, at
+// the latest schema version, and gives it and its goals slugs. It returns
+// the ID and the slug of the objective.
+func (s Storage) AddObjective(userID string, o Objective) (string, string, error) {
+	if err := validateObjective(o); err != nil {
+		return "", "", err
+	}
+	o.SchemaVersion = LatestSchemaVersion
+	assignGoalSlugs(&o)
+	id := s.newID()
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(id)
+	err := s.transaction("AddObjective", func(tx *firestore.Transaction) (err error) {
+		o.Slug, err = s.reserveSlug(tx, userID, id, o.Name)
+		if err != nil {
+			return err
+		}
+		return tx.Create(ref, o)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return id, o.Slug, nil
+}
+
+// AddGoal adds a new goal to an existing objective of a user under a
+// generated ID, and gives it a slug. It returns the ID and the slug of
+// the goal.
+func (s Storage) AddGoal(userID, objectiveID string, g Goal) (string, string, error) {
+	id := s.newID()
+	err := s.modifyObjective("AddGoal", userID, objectiveID, func(o *Objective) error {
+		g.Slug, _ = uniqueSlug(g.Name, "goal", id, func(slug string) (bool, error) {
+			return goalSlugTaken(*o, slug), nil
+		})
+		return o.AddGoal(id, g)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return id, g.Slug, nil
+}
+
+// resolveIDs returns the IDs of the objective of a user and of its goal
+// that objectiveRef and goalRef name, either by ID or by slug. IDs take
+// precedence. References that match nothing are returned unchanged, so
+// that the caller reports them as missing. An empty goalRef is returned
+// as is.
+func (s Storage) resolveIDs(userID, objectiveRef, goalRef string) (string, string, error) {
+	objectives := s.collection("users").Doc(userID).Collection("objectives")
+	objectiveID := objectiveRef
+	var o *Objective
+	err := s.do("resolveIDs", func(ctx context.Context) error {
+		docs, err := s.client.GetAll(ctx, []*firestore.DocumentRef{objectives.Doc(objectiveRef), s.slugRef(userID, objectiveRef)})
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		doc := docs[0]
+		if !doc.Exists() && docs[1].Exists() {
+			var e slugEntry
+			if err := docs[1].DataTo(&e); err != nil {
+				return fmt.Errorf("Error reading slug index: %w", err)
+			}
+			doc, err = objectives.Doc(e.Objective).Get(ctx)
+			if doc != nil && !doc.Exists() {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("Error reading objective: %w", err)
+			}
+		}
+		if !doc.Exists() {
+			return nil
+		}
+		o = &Objective{}
+		if err := doc.DataTo(o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		if doc.Ref.ID != objectiveRef && o.Slug != objectiveRef {
+			// The index is stale.
+			o = nil
+			return nil
+		}
+		objectiveID = doc.Ref.ID
+		return nil
+	})
+	if err != nil || o == nil || goalRef == "" {
+		return objectiveID, goalRef, err
+	}
+	return objectiveID, o.resolveGoalID(goalRef), nil
+}
+
+// ResolveIDs returns the IDs of the objective of a user and of its goal
+// that objectiveRef and goalRef name, either by ID or by slug, see
+// resolveIDs.
+func (s Storage) ResolveIDs(userID, objectiveRef, goalRef string) (string, string, error) {
+	return s.resolveIDs(userID, objectiveRef, goalRef)
+}
+
+// resolveIDs returns the IDs of the objective of a user and of its goal
+// that the references name, see Storage.resolveIDs.
+func (m *memoryGoalStore) resolveIDs(userID, objectiveRef, goalRef string) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objectives[userID][objectiveRef]
+	objectiveID := objectiveRef
+	if !ok {
+		for id, candidate := range m.objectives[userID] {
+			if candidate.Slug == objectiveRef {
+				o, ok, objectiveID = candidate, true, id
+				break
+			}
+		}
+	}
+	if !ok || goalRef == "" {
+		return objectiveID, goalRef, nil
+	}
+	return objectiveID, o.resolveGoalID(goalRef), nil
+}
@@ -0,0 +1,95 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Running distance 2025":   "running-distance-2025",
+		"  Read -- books!  ":      "read-books",
+		"Äpfel essen":             "pfel-essen",
+		"???":                     "",
+		strings.Repeat("ab ", 40): strings.TrimRight(strings.Repeat("ab-", 20), "-"),
+	}
+	for name, want := range tests {
+		if got := Slugify(name); got != want {
+			t.Errorf("Slugify(%q) = %q; wanted %q", name, got, want)
+		}
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	taken := map[string]bool{"run": true, "run-2": true}
+	slug, err := uniqueSlug("Run", "goal", "ID", func(slug string) (bool, error) {
+		return taken[slug], nil
+	})
+	if err != nil || slug != "run-3" {
+		t.Errorf("slug was %q, %v; wanted run-3", slug, err)
+	}
+	slug, _ = uniqueSlug("", "goal", "ID", func(string) (bool, error) { return true, nil })
+	if slug != "goal-id" {
+		t.Errorf("slug was %q; wanted goal-id", slug)
+	}
+}
+
+func TestAssignGoalSlugs(t *testing.T) {
+	goals := map[string]Goal{
+		"a": {Name: "Run"},
+		"b": {Name: "Run"},
+		"c": {Name: "Swim", Slug: "laps"},
+	}
+	o := Objective{Goals: goals}
+	assignGoalSlugs(&o)
+	if a, b := o.Goals["a"].Slug, o.Goals["b"].Slug; a == b || !strings.HasPrefix(a, "run") || !strings.HasPrefix(b, "run") {
+		t.Errorf("slugs were %q and %q; wanted distinct slugs of Run", a, b)
+	}
+	if o.Goals["c"].Slug != "laps" {
+		t.Errorf("existing slug was replaced by %q", o.Goals["c"].Slug)
+	}
+	if goals["a"].Slug != "" {
+		t.Errorf("goals of the caller were changed")
+	}
+	if id := o.resolveGoalID("laps"); id != "c" {
+		t.Errorf("laps resolved to %q; wanted c", id)
+	}
+	if id := o.resolveGoalID("a"); id != "a" {
+		t.Errorf("a resolved to %q; wanted a", id)
+	}
+}
+
+func TestCheckSlugMask(t *testing.T) {
+	for _, path := range []string{"slug", "goals.run.slug"} {
+		if err := checkSlugMask(FieldMask{path}); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("mask %q was accepted", path)
+		}
+	}
+	if err := checkSlugMask(FieldMask{"name", "goals.slug.name"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetGoalValueHandlerBySlug(t *testing.T) {
+	s, goals := newMemoryServer()
+	goals.PutObjective("alice", "x7Kq", Objective{Name: "Reading", Slug: "reading", Goals: map[string]Goal{
+		"p3Zt": {Name: "Books", Slug: "books", Target: 12},
+	}})
+	body := `{"objective": "reading", "goal": "books", "value": 2}`
+	r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.setGoalValue(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	o, _ := goals.readObjective("alice", "x7Kq")
+	if tr := o.Goals["p3Zt"].Trajectory; len(tr) != 1 || tr[0].Value != 2 {
+		t.Errorf("trajectory was %+v; wanted 2", tr)
+	}
+}
@@ -0,0 +1,152 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotPrefix is where snapshots are stored in the snapshot bucket.
+const snapshotPrefix = "shared/"
+
+// snapshotName returns the name of the snapshot of an objective shared by
+// a token. Tokens are identified by the hex SHA-256 hash of their secret,
+// so only holders of the secret can find the snapshot.
+func snapshotName(tokenID, objectiveID string) string {
+	return snapshotPrefix + tokenID + "/" + objectiveID + ".json"
+}
+
+// badgeName returns the name of the badge of a goal shared by a token.
+func badgeName(tokenID, objectiveID, goalID string) string {
+	return snapshotPrefix + tokenID + "/" + objectiveID + "/" + goalID + ".json"
+}
+
+// sharedObjectives returns the objectives that each token allows to read
+// as a whole at the given date, by token ID. Tokens that are limited to
+// single goals cannot read shared views, so they are left out.
+func sharedObjectives(tokens []ShareTokenEntry, now int64) map[string][]string {
+	shared := map[string][]string{}
+	for _, t := range tokens {
+		for _, scope := range t.Scopes {
+			if scope.Goal == "" && t.Allows(AbilityRead, scope.Objective, "", now) {
+				shared[t.ID] = append(shared[t.ID], scope.Objective)
+			}
+		}
+	}
+	return shared
+}
+
+// PublishSnapshots makes the server write snapshots of shared objectives
+// and badges of their goals into a public bucket when the snapshot task
+// runs, so that a CDN in front of the bucket can serve shared views and
+// badges without touching Firestore. The snapshot of an objective has the
+// same content as GET /shared/objectives/{objective}.
+func (s *Server) PublishSnapshots(b *ExportBucket) {
+	s.snapshots = b
+}
+
+// publishSnapshots serves POST /tasks/publishsnapshots, which is meant to
+// be triggered by Cloud Scheduler, e.g. every few minutes. It writes the
+// snapshots of all objectives shared by tokens, and deletes snapshots of
+// tokens that were revoked, expired or no longer share the objective, and
+// of objectives and goals that were deleted.
+func (s *Server) publishSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if s.snapshots == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Snapshots are not configured"))
+		return
+	}
+	storage := s.storageFor(r)
+	tokens, err := storage.ListAllTokens()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	users := map[string]string{}
+	for _, t := range tokens {
+		users[t.ID] = t.User
+	}
+	shared := sharedObjectives(tokens, now)
+	ids := make([]string, 0, len(shared))
+	for id := range shared {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	published := map[string]bool{}
+	// kept holds the prefixes of snapshots whose objective could not be
+	// read, which keep their previous version.
+	kept := map[string]bool{}
+	failed := []string{}
+	for _, id := range ids {
+		for _, objectiveID := range shared[id] {
+			names, err := s.publishSnapshot(storage, users[id], id, objectiveID, now)
+			for _, name := range names {
+				published[name] = true
+			}
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				logf(r.Context(), severityError, "Error publishing snapshot of %s/%s: %v", users[id], objectiveID, err)
+				failed = append(failed, users[id]+"/"+objectiveID)
+				kept[strings.TrimSuffix(snapshotName(id, objectiveID), ".json")] = true
+			}
+		}
+	}
+	existing, err := s.snapshots.list(snapshotPrefix)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	deleted := 0
+	for _, name := range existing {
+		if published[name] || kept[strings.TrimSuffix(name, ".json")] || kept[path.Dir(name)] {
+			continue
+		}
+		if err := s.snapshots.delete(name); err != nil {
+			logf(r.Context(), severityError, "Error deleting snapshot %s: %v", name, err)
+			continue
+		}
+		deleted++
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"published": len(published),
+		"deleted":   deleted,
+		"failed":    failed,
+	})
+}
+
+// publishSnapshot writes the snapshot of an objective and the badges of
+// its goals, and returns the names of the objects that it wrote.
+func (s *Server) publishSnapshot(storage *Storage, userID, tokenID, objectiveID string, now int64) ([]string, error) {
+	o, err := storage.GetObjective(userID, objectiveID)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	name := snapshotName(tokenID, objectiveID)
+	if err := s.snapshots.upload(name, "application/json", writeJSONTo(o)); err != nil {
+		return names, err
+	}
+	names = append(names, name)
+	for goalID, g := range o.Goals {
+		name := badgeName(tokenID, objectiveID, goalID)
+		if err := s.snapshots.upload(name, "application/json", writeJSONTo(NewBadge(g, now))); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// writeJSONTo returns a function that writes v as JSON.
+func writeJSONTo(v interface{}) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	}
+}
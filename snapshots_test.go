@@ -0,0 +1,24 @@
+package pursuit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSharedObjectives(t *testing.T) {
+	tokens := []ShareTokenEntry{
+		{"a", ShareToken{User: "alice", Scopes: []Scope{
+			{Ability: AbilityRead, Objective: "fitness"},
+			{Ability: AbilityWrite, Objective: "reading", Goal: "books"},
+		}}},
+		{"b", ShareToken{User: "bob", Expires: 5, Scopes: []Scope{{Ability: AbilityRead, Objective: "garden"}}}},
+		{"c", ShareToken{User: "carol", Scopes: []Scope{{Ability: AbilityWrite, Objective: "music"}}}},
+	}
+
+	got := sharedObjectives(tokens, 10)
+
+	want := map[string][]string{"a": {"fitness"}, "c": {"music"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; wanted %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package pursuit
+
+import (
+	"errors"
+	"time"
+)
+
+// Source policies decide how values that imports write into a goal
+// interact with values that the user entered. Values written through the
+// API or the web app count as manual. An empty policy is the same as
+// SourcePolicyIntegrationWins.
+const (
+	// SourcePolicyIntegrationWins writes every imported value, so that
+	// the next sync replaces a manual correction.
+	SourcePolicyIntegrationWins = "integration-wins"
+	// SourcePolicyManualWins ignores imported values for manualHold after
+	// a manual value, so that a correction sticks until the data source
+	// has caught up.
+	SourcePolicyManualWins = "manual-wins"
+	// SourcePolicyMergeBySource turns each imported value into an
+	// increment by the change since the previous reading of its source, so
+	// that manual corrections persist as an offset.
+	SourcePolicyMergeBySource = "merge-by-source"
+)
+
+// manualHold is how long a manual value wins over imported values under
+// SourcePolicyManualWins.
+const manualHold = 24 * time.Hour
+
+func validSourcePolicy(policy string) bool {
+	switch policy {
+	case "", SourcePolicyIntegrationWins, SourcePolicyManualWins, SourcePolicyMergeBySource:
+		return true
+	}
+	return false
+}
+
+// ImportValue adds a value of a data source to the trajectory of the goal
+// according to its source policy, using the current timestamp. If
+// increment is true, the value is a delta. It reports whether the value
+// was added.
+func (g *Goal) ImportValue(source string, value float32, increment bool) (bool, error) {
+	now := time.Now().UnixNano() / 1000 / 1000
+	if n := len(g.Trajectory); n > 0 && g.SourcePolicy == SourcePolicyManualWins {
+		latest := g.Trajectory[n-1]
+		if latest.Source == "" && now-latest.Date < manualHold.Milliseconds() {
+			return false, nil
+		}
+	}
+	var err error
+	switch {
+	case increment:
+		err = g.IncrementValue(value)
+	case g.SourcePolicy == SourcePolicyMergeBySource:
+		previous, ok := g.SourceReadings[source]
+		if !ok {
+			err = g.SetValue(value)
+		} else {
+			err = g.IncrementValue(value - previous)
+		}
+		if err == nil {
+			if g.SourceReadings == nil {
+				g.SourceReadings = map[string]float32{}
+			}
+			g.SourceReadings[source] = value
+		}
+	default:
+		err = g.SetValue(value)
+	}
+	if err != nil {
+		return false, err
+	}
+	g.Trajectory[len(g.Trajectory)-1].Source = source
+	return true, nil
+}
+
+// ImportGoalValue adds a value of a data source to the trajectory of the
+// goal, see Goal.ImportValue.
+func (o *Objective) ImportGoalValue(goalID, source string, value float32, increment bool) (bool, error) {
+	g, err := o.valueGoal(goalID)
+	if err != nil {
+		return false, err
+	}
+	imported, err := g.ImportValue(source, value, increment)
+	if err != nil {
+		return false, err
+	}
+	o.Goals[goalID] = g
+	return imported, nil
+}
+
+// ImportGoalValue adds a value of a data source to the trajectory of the
+// goal of a user according to the source policy of the goal. The value
+// is converted from unit, which may be empty, into the unit of the goal.
+// It reports whether the value was added.
+func (s Storage) ImportGoalValue(userID, objectiveID, goalID, source string, value float32, unit string, increment bool) (bool, error) {
+	var imported bool
+	var converted float32
+	g, err := s.updateGoal("ImportGoalValue", userID, objectiveID, goalID, idempotencyPayload(source, value, unit, increment), func(o *Objective) (_ bool, err error) {
+		converted, err = o.ConvertGoalValue(goalID, value, unit)
+		if err != nil {
+			return false, err
+		}
+		imported, err = o.ImportGoalValue(goalID, source, converted, increment)
+		return imported, err
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return replayed.changed, nil
+	}
+	if err != nil || !imported {
+		return imported, err
+	}
+	if increment {
+		s.recordEvent(userID, newGoalEvent(EventGoalIncremented, objectiveID, goalID, g, converted))
+	} else {
+		s.recordEvent(userID, newGoalEvent(EventGoalSet, objectiveID, goalID, g, 0))
+	}
+	return true, nil
+}
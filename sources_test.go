@@ -0,0 +1,73 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImportValueIntegrationWins(t *testing.T) {
+	g := Goal{}
+	g.SetValue(10)
+
+	imported, err := g.ImportValue("fitbit", 7, false)
+
+	if err != nil || !imported {
+		t.Fatalf("value was not imported: %v", err)
+	}
+	if latest := g.Trajectory[1]; latest.Value != 7 || latest.Source != "fitbit" {
+		t.Errorf("latest value was %+v; wanted 7 from fitbit", latest)
+	}
+}
+
+func TestImportValueManualWins(t *testing.T) {
+	g := Goal{SourcePolicy: SourcePolicyManualWins}
+	g.SetValue(10)
+
+	imported, err := g.ImportValue("fitbit", 7, false)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if imported || len(g.Trajectory) != 1 {
+		t.Errorf("imported value replaced a recent manual one: %+v", g.Trajectory)
+	}
+}
+
+func TestImportValueManualWinsAfterHold(t *testing.T) {
+	old := time.Now().Add(-2*manualHold).UnixNano() / 1000 / 1000
+	g := Goal{SourcePolicy: SourcePolicyManualWins, Trajectory: Trajectory{{Date: old, Value: 10}}}
+
+	imported, err := g.ImportValue("fitbit", 7, false)
+
+	if err != nil || !imported {
+		t.Fatalf("value was not imported: %v", err)
+	}
+	if g.Trajectory[1].Value != 7 {
+		t.Errorf("latest value was %v; wanted 7", g.Trajectory[1].Value)
+	}
+}
+
+func TestImportValueMergeBySource(t *testing.T) {
+	g := Goal{SourcePolicy: SourcePolicyMergeBySource}
+	g.ImportValue("fitbit", 100, false)
+	// A manual correction of +5.
+	g.SetValue(105)
+
+	g.ImportValue("fitbit", 120, false)
+
+	if latest := g.Trajectory[len(g.Trajectory)-1]; latest.Value != 125 || latest.Source != "fitbit" {
+		t.Errorf("latest value was %+v; wanted 125 from fitbit", latest)
+	}
+	if g.SourceReadings["fitbit"] != 120 {
+		t.Errorf("reading of fitbit was %v; wanted 120", g.SourceReadings["fitbit"])
+	}
+}
+
+func TestValidateObjectiveRejectsUnknownSourcePolicy(t *testing.T) {
+	o := Objective{Name: "Fitness", Goals: map[string]Goal{"run": {Target: 1, SourcePolicy: "newest-wins"}}}
+
+	if err := validateObjective(o); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("error was %v; wanted ErrInvalidValue", err)
+	}
+}
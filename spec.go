@@ -0,0 +1,369 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Spec declares objectives and goals of a user in YAML, so that they can
+// be kept under version control and applied with pursuit apply:
+//
+//	objectives:
+//	  - id: fitness-2026
+//	    name: Fitness 2026
+//	    goals:
+//	      - id: run
+//	        name: Run
+//	        unit: km
+//	        target: 1000
+//	        start: 2026-01-01
+//	        end: 2027-01-01
+//
+// Objectives that are not in the spec are left alone. Goals of objectives
+// in the spec that are missing from the spec are archived rather than
+// deleted, so that their trajectories are kept.
+type Spec struct {
+	Objectives []ObjectiveSpec `json:"objectives"`
+}
+
+// ObjectiveSpec declares an objective.
+type ObjectiveSpec struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Goals       []GoalSpec `json:"goals"`
+}
+
+// GoalSpec declares a goal. Start and end are dates such as 2026-01-01 in
+// UTC.
+type GoalSpec struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Unit        string  `json:"unit"`
+	Target      float32 `json:"target"`
+	Start       string  `json:"start"`
+	End         string  `json:"end"`
+	Aggregation string  `json:"aggregation"`
+}
+
+// Actions of spec changes.
+const (
+	SpecCreate  = "create"
+	SpecUpdate  = "update"
+	SpecArchive = "archive"
+)
+
+// SpecChange is a change that applying a spec makes to an objective, or
+// to one of its goals if Goal is set.
+type SpecChange struct {
+	Action    string
+	Objective string
+	Goal      string
+	// Fields describes the updated fields, e.g. "target: 900 -> 1000".
+	Fields []string
+}
+
+func (c SpecChange) String() string {
+	path := c.Objective
+	if c.Goal != "" {
+		path += "/" + c.Goal
+	}
+	switch c.Action {
+	case SpecCreate:
+		return "+ " + path
+	case SpecArchive:
+		return "- " + path + " (archive)"
+	default:
+		return "~ " + path + ": " + strings.Join(c.Fields, ", ")
+	}
+}
+
+// ParseSpec parses and validates a spec.
+func ParseSpec(data []byte) (Spec, error) {
+	v, err := decodeYAML(data)
+	if err != nil {
+		return Spec{}, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Spec{}, err
+	}
+	var spec Spec
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return Spec{}, err
+	}
+	objectives := map[string]bool{}
+	for _, o := range spec.Objectives {
+		if o.ID == "" {
+			return Spec{}, fmt.Errorf("Objective %q without id: %w", o.Name, ErrInvalidValue)
+		}
+		if objectives[o.ID] {
+			return Spec{}, fmt.Errorf("Duplicate objective: %q: %w", o.ID, ErrInvalidValue)
+		}
+		objectives[o.ID] = true
+		goals := map[string]bool{}
+		for _, g := range o.Goals {
+			if g.ID == "" {
+				return Spec{}, fmt.Errorf("Goal %q of objective %q without id: %w", g.Name, o.ID, ErrInvalidValue)
+			}
+			if goals[g.ID] {
+				return Spec{}, fmt.Errorf("Duplicate goal: %q: %w", o.ID+"/"+g.ID, ErrInvalidValue)
+			}
+			goals[g.ID] = true
+			if _, err := g.goal(); err != nil {
+				return Spec{}, fmt.Errorf("Goal %q: %w", o.ID+"/"+g.ID, err)
+			}
+		}
+	}
+	return spec, nil
+}
+
+// goal returns the declared fields of the goal.
+func (g GoalSpec) goal() (Goal, error) {
+	start, err := time.Parse("2006-01-02", g.Start)
+	if err != nil {
+		return Goal{}, fmt.Errorf("Invalid start: %q: %w", g.Start, ErrInvalidValue)
+	}
+	end, err := time.Parse("2006-01-02", g.End)
+	if err != nil {
+		return Goal{}, fmt.Errorf("Invalid end: %q: %w", g.End, ErrInvalidValue)
+	}
+	if !end.After(start) {
+		return Goal{}, fmt.Errorf("End is not after start: %w", ErrInvalidValue)
+	}
+	if g.Target <= 0 || !isFinite(g.Target) {
+		return Goal{}, fmt.Errorf("Invalid target: %v: %w", g.Target, ErrInvalidValue)
+	}
+	switch g.Aggregation {
+	case "", AggregationLatest, AggregationMaximum, AggregationAverage:
+	default:
+		return Goal{}, fmt.Errorf("Unknown aggregation: %q: %w", g.Aggregation, ErrInvalidValue)
+	}
+	return Goal{
+		Name:        g.Name,
+		Unit:        g.Unit,
+		Target:      g.Target,
+		Start:       start.UnixNano() / 1000 / 1000,
+		End:         end.UnixNano() / 1000 / 1000,
+		Aggregation: g.Aggregation,
+	}, nil
+}
+
+// diffSpec compares a parsed spec with the current objectives of a user.
+// It returns the changes and the objectives that need to be written to
+// apply them.
+func diffSpec(spec Spec, current map[string]Objective) ([]SpecChange, map[string]Objective) {
+	var changes []SpecChange
+	updated := map[string]Objective{}
+	for _, objSpec := range spec.Objectives {
+		o, exists := current[objSpec.ID]
+		if !exists {
+			changes = append(changes, SpecChange{Action: SpecCreate, Objective: objSpec.ID})
+			o = Objective{SchemaVersion: LatestSchemaVersion}
+		}
+		o = copyObjective(o)
+		var fields []string
+		fields = diffString(fields, "name", &o.Name, objSpec.Name)
+		fields = diffString(fields, "description", &o.Description, objSpec.Description)
+		if exists && len(fields) > 0 {
+			changes = append(changes, SpecChange{Action: SpecUpdate, Objective: objSpec.ID, Fields: fields})
+		}
+		changed := !exists || len(fields) > 0
+
+		declared := map[string]bool{}
+		for _, gs := range objSpec.Goals {
+			declared[gs.ID] = true
+			want, _ := gs.goal()
+			g, ok := o.Goals[gs.ID]
+			if !ok {
+				changes = append(changes, SpecChange{Action: SpecCreate, Objective: objSpec.ID, Goal: gs.ID})
+				want.Stage = StagePledged
+				want.Trajectory = Trajectory{{Date: want.Start, Value: 0}}
+				o.Goals[gs.ID] = want
+				changed = true
+				continue
+			}
+			var fields []string
+			fields = diffString(fields, "name", &g.Name, want.Name)
+			fields = diffString(fields, "unit", &g.Unit, want.Unit)
+			fields = diffString(fields, "aggregation", &g.Aggregation, want.Aggregation)
+			if g.Target != want.Target {
+				fields = append(fields, fmt.Sprintf("target: %g -> %g", g.Target, want.Target))
+				g.Target = want.Target
+			}
+			if g.Start != want.Start {
+				fields = append(fields, fmt.Sprintf("start: %s -> %s", formatSpecDate(g.Start), formatSpecDate(want.Start)))
+				// The baseline moves along with the start, as in the web
+				// application.
+				if len(g.Trajectory) > 0 && g.Trajectory[0].Date == g.Start {
+					g.Trajectory = append(Trajectory{}, g.Trajectory...)
+					g.Trajectory[0].Date = want.Start
+					sort.SliceStable(g.Trajectory, func(i, j int) bool {
+						return g.Trajectory[i].Date < g.Trajectory[j].Date
+					})
+				}
+				g.Start = want.Start
+			}
+			if g.End != want.End {
+				fields = append(fields, fmt.Sprintf("end: %s -> %s", formatSpecDate(g.End), formatSpecDate(want.End)))
+				g.End = want.End
+			}
+			if g.Stage == StageArchived {
+				fields = append(fields, "stage: archived -> pledged")
+				g.Stage = StagePledged
+			}
+			if len(fields) > 0 {
+				changes = append(changes, SpecChange{Action: SpecUpdate, Objective: objSpec.ID, Goal: gs.ID, Fields: fields})
+				o.Goals[gs.ID] = g
+				changed = true
+			}
+		}
+
+		ids := make([]string, 0, len(o.Goals))
+		for id := range o.Goals {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			g := o.Goals[id]
+			if declared[id] || g.Stage == StageArchived {
+				continue
+			}
+			changes = append(changes, SpecChange{Action: SpecArchive, Objective: objSpec.ID, Goal: id})
+			g.Stage = StageArchived
+			o.Goals[id] = g
+			changed = true
+		}
+		if changed {
+			updated[objSpec.ID] = o
+		}
+	}
+	return changes, updated
+}
+
+// copyObjective copies the objective, so that its goals and their
+// trajectories can be changed without changing the original.
+func copyObjective(o Objective) Objective {
+	goals := make(map[string]Goal, len(o.Goals))
+	for id, g := range o.Goals {
+		g.Trajectory = append(Trajectory(nil), g.Trajectory...)
+		g.Plan = append([]float32(nil), g.Plan...)
+		goals[id] = g
+	}
+	o.Goals = goals
+	return o
+}
+
+func diffString(fields []string, name string, field *string, want string) []string {
+	if *field == want {
+		return fields
+	}
+	fields = append(fields, fmt.Sprintf("%s: %q -> %q", name, *field, want))
+	*field = want
+	return fields
+}
+
+func formatSpecDate(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("2006-01-02")
+}
+
+// SpecPlan holds the changes that applying a spec would make to the
+// objectives of a user, and the objectives to write to apply them.
+type SpecPlan struct {
+	User    string
+	Changes []SpecChange
+	// order lists the IDs of updated objectives in the order of the spec.
+	order   []string
+	updated map[string]Objective
+	// read holds the update times of the objectives when they were read,
+	// so that objectives that changed since then are not overwritten.
+	read map[string]time.Time
+}
+
+// Plan lists the changes for review. Creations are additions, and
+// archiving goals counts as destroying them, as it removes them from
+// views.
+func (p SpecPlan) Plan() Plan {
+	var plan Plan
+	for _, c := range p.Changes {
+		switch c.Action {
+		case SpecCreate:
+			plan.Add = append(plan.Add, c.String())
+		case SpecArchive:
+			plan.Destroy = append(plan.Destroy, c.String())
+		default:
+			plan.Change = append(plan.Change, c.String())
+		}
+	}
+	return plan
+}
+
+// PlanSpec compares a spec with the current objectives of a user.
+func (s Storage) PlanSpec(userID string, spec Spec) (SpecPlan, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("PlanSpec", func(ctx context.Context) (err error) {
+		docs, err = s.collection("users").Doc(userID).Collection("objectives").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return SpecPlan{}, fmt.Errorf("Error listing objectives: %w", err)
+	}
+	current := make(map[string]Objective, len(docs))
+	read := make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return SpecPlan{}, fmt.Errorf("Error reading objective %q: %w", doc.Ref.ID, err)
+		}
+		current[doc.Ref.ID] = o
+		read[doc.Ref.ID] = doc.UpdateTime
+	}
+	changes, updated := diffSpec(spec, current)
+	var order []string
+	for _, objSpec := range spec.Objectives {
+		if _, ok := updated[objSpec.ID]; ok {
+			order = append(order, objSpec.ID)
+		}
+	}
+	return SpecPlan{userID, changes, order, updated, read}, nil
+}
+
+// ApplySpecPlan writes the objectives of the plan. Objectives that were
+// changed since the plan was made, e.g. by new values on trajectories,
+// are not overwritten; the spec needs to be planned again instead.
+func (s Storage) ApplySpecPlan(p SpecPlan) error {
+	for _, id := range p.order {
+		ref := s.collection("users").Doc(p.User).Collection("objectives").Doc(id)
+		err := s.transaction("ApplySpecPlan", func(tx *firestore.Transaction) error {
+			doc, err := tx.Get(ref)
+			read, existed := p.read[id]
+			switch {
+			case doc != nil && !doc.Exists():
+				if existed {
+					return fmt.Errorf("Objective %q was deleted since the plan was made", id)
+				}
+				return tx.Create(ref, p.updated[id])
+			case err != nil:
+				return err
+			case !existed:
+				return fmt.Errorf("Objective %q was created since the plan was made", id)
+			case !doc.UpdateTime.Equal(read):
+				return fmt.Errorf("Objective %q was changed since the plan was made", id)
+			}
+			return tx.Set(ref, p.updated[id])
+		})
+		if err != nil {
+			return fmt.Errorf("Error writing objective %q: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package pursuit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testSpec = `objectives:
+  - id: fitness
+    name: Fitness
+    goals:
+      - id: run
+        name: Run
+        unit: km
+        target: 1000
+        start: 2026-01-01
+        end: 2027-01-01
+      - id: swim
+        name: Swim
+        target: 50
+        start: 2026-01-01
+        end: 2027-01-01
+`
+
+func changeStrings(changes []SpecChange) []string {
+	s := []string{}
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spec.Objectives) != 1 || len(spec.Objectives[0].Goals) != 2 || spec.Objectives[0].Goals[0].Target != 1000 {
+		t.Errorf("spec was %+v", spec)
+	}
+}
+
+func TestParseSpecInvalid(t *testing.T) {
+	for _, data := range []string{
+		"objectives:\n  - name: No ID\n",
+		"objectives:\n  - id: a\n  - id: a\n",
+		"objectives:\n  - id: a\n    goals:\n      - id: g\n        target: 1\n        start: 2026-02-01\n        end: 2026-01-01\n",
+		"objectives:\n  - id: a\n    goals:\n      - id: g\n        target: 0\n        start: 2026-01-01\n        end: 2026-02-01\n",
+		"objectives:\n  - id: a\n    color: red\n",
+	} {
+		if _, err := ParseSpec([]byte(data)); err == nil {
+			t.Errorf("parsing %q succeeded; wanted an error", data)
+		}
+	}
+	if _, err := ParseSpec([]byte("objectives:\n  - name: No ID\n")); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("parsing spec without ID returned %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestDiffSpecCreates(t *testing.T) {
+	spec, _ := ParseSpec([]byte(testSpec))
+
+	changes, updated := diffSpec(spec, map[string]Objective{})
+
+	got := strings.Join(changeStrings(changes), "\n")
+	want := "+ fitness\n+ fitness/run\n+ fitness/swim"
+	if got != want {
+		t.Errorf("changes were\n%s\nwanted\n%s", got, want)
+	}
+	run := updated["fitness"].Goals["run"]
+	if run.Stage != "pledged" || len(run.Trajectory) != 1 || run.Trajectory[0].Date != run.Start {
+		t.Errorf("created goal was %+v", run)
+	}
+}
+
+func TestDiffSpecUpdatesAndArchives(t *testing.T) {
+	spec, _ := ParseSpec([]byte(testSpec))
+	current := map[string]Objective{"fitness": {Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Unit: "km", Target: 900, Start: 1767225600000, End: 1798761600000, Stage: "pledged",
+			Trajectory: Trajectory{{Date: 1767225600000, Value: 0}, {Date: 1767312000000, Value: 5}}},
+		"swim": {Name: "Swim", Target: 50, Start: 1767225600000, End: 1798761600000, Stage: "archived"},
+		"bike": {Name: "Bike", Target: 10, Stage: "pledged"},
+	}}}
+
+	changes, updated := diffSpec(spec, current)
+
+	got := strings.Join(changeStrings(changes), "\n")
+	want := "~ fitness/run: target: 900 -> 1000\n~ fitness/swim: stage: archived -> pledged\n- fitness/bike (archive)"
+	if got != want {
+		t.Errorf("changes were\n%s\nwanted\n%s", got, want)
+	}
+	if updated["fitness"].Goals["run"].Target != 1000 || len(updated["fitness"].Goals["run"].Trajectory) != 2 {
+		t.Errorf("updated goal was %+v", updated["fitness"].Goals["run"])
+	}
+	if current["fitness"].Goals["run"].Target != 900 {
+		t.Errorf("planning changed the current objective")
+	}
+}
+
+func TestDiffSpecNoChanges(t *testing.T) {
+	spec, _ := ParseSpec([]byte(testSpec))
+	_, updated := diffSpec(spec, map[string]Objective{})
+
+	changes, again := diffSpec(spec, updated)
+
+	if len(changes) != 0 || len(again) != 0 {
+		t.Errorf("applying the spec twice planned %v", changeStrings(changes))
+	}
+}
@@ -0,0 +1,76 @@
+package pursuit
+
+import "fmt"
+
+// Stages of the lifecycle of a goal. Goals without a stage are pledged.
+const (
+	// StageDraft goals are being planned, and are hidden by default.
+	StageDraft = "draft"
+	// StagePledged goals are active.
+	StagePledged = "pledged"
+	// StagePaused goals are on hold, and left out of digests.
+	StagePaused = "paused"
+	// StageCompleted goals were achieved.
+	StageCompleted = "completed"
+	// StageArchived goals were abandoned or are no longer of interest,
+	// and are hidden by default.
+	StageArchived = "archived"
+)
+
+// stageTransitions lists the stages that a goal may move to from each
+// stage. Goals can always be archived, and archived goals can be pledged
+// again.
+var stageTransitions = map[string][]string{
+	StageDraft:     {StagePledged, StageArchived},
+	StagePledged:   {StageDraft, StagePaused, StageCompleted, StageArchived},
+	StagePaused:    {StagePledged, StageCompleted, StageArchived},
+	StageCompleted: {StagePledged, StageArchived},
+	StageArchived:  {StagePledged},
+}
+
+// validStage reports whether the stage is known. The empty stage is
+// valid, and means StagePledged.
+func validStage(stage string) bool {
+	_, ok := stageTransitions[stage]
+	return ok || stage == ""
+}
+
+// stageOf returns the stage of the goal, StagePledged if it has none.
+func (g Goal) stageOf() string {
+	if g.Stage == "" {
+		return StagePledged
+	}
+	return g.Stage
+}
+
+// isInactive reports whether the goal is paused, completed or archived.
+func (g Goal) isInactive() bool {
+	s := g.stageOf()
+	return s == StagePaused || s == StageCompleted || s == StageArchived
+}
+
+// SetGoalStage moves the goal to a stage, if its current stage allows it,
+// see stageTransitions. Moving a goal to its current stage does nothing.
+func (o *Objective) SetGoalStage(goalID, stage string) error {
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	if !validStage(stage) || stage == "" {
+		return fmt.Errorf("Unknown stage: %q: %w", stage, ErrInvalidValue)
+	}
+	from := g.stageOf()
+	if from == stage {
+		return nil
+	}
+	allowed := false
+	for _, to := range stageTransitions[from] {
+		allowed = allowed || to == stage
+	}
+	if !allowed {
+		return fmt.Errorf("Goal %q cannot move from %s to %s: %w", goalID, from, stage, ErrInvalidValue)
+	}
+	g.Stage = stage
+	o.Goals[goalID] = g
+	return nil
+}
@@ -0,0 +1,57 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetGoalStage(t *testing.T) {
+	o := Objective{Goals: map[string]Goal{"run": {Name: "Run"}}}
+	for _, c := range []struct {
+		stage string
+		err   error
+	}{
+		{StagePaused, nil},
+		{StagePaused, nil},
+		{StageDraft, ErrInvalidValue},
+		{StageCompleted, nil},
+		{StageArchived, nil},
+		{StageCompleted, ErrInvalidValue},
+		{StagePledged, nil},
+		{"abandoned", ErrInvalidValue},
+		{"", ErrInvalidValue},
+	} {
+		before := o.Goals["run"].Stage
+		err := o.SetGoalStage("run", c.stage)
+		if !errors.Is(err, c.err) || (err == nil) != (c.err == nil) {
+			t.Errorf("moving from %q to %q was %v; wanted %v", before, c.stage, err, c.err)
+		}
+		if err == nil && o.Goals["run"].Stage != c.stage {
+			t.Errorf("stage was %q; wanted %q", o.Goals["run"].Stage, c.stage)
+		}
+	}
+	if err := o.SetGoalStage("swim", StagePaused); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error was %v; wanted ErrNotFound", err)
+	}
+}
+
+func TestSetGoalStageHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	body := `{"objective": "fitness", "goal": "run", "stage": "completed"}`
+	r := httptest.NewRequest(http.MethodPost, "/setgoalstage", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.setGoalStage(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	o, _ := goals.readObjective("alice", "fitness")
+	if stage := o.Goals["run"].Stage; stage != StageCompleted {
+		t.Errorf("stage was %q; wanted completed", stage)
+	}
+}
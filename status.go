@@ -0,0 +1,200 @@
+package pursuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// StatusUpdate for Firestore serialization/deserialization. A status
+// update publishes the progress of a goal to an external service, such
+// as the status of a Slack user or a badge served from a GitHub gist.
+type StatusUpdate struct {
+	// Kind is either "slack" or "gist".
+	Kind      string `firestore:"kind" json:"kind"`
+	Objective string `firestore:"objective" json:"objective"`
+	Goal      string `firestore:"goal" json:"goal"`
+	Token     string `firestore:"token" json:"-"`
+	GistID    string `firestore:"gistId,omitempty" json:"gistId,omitempty"`
+	Filename  string `firestore:"filename,omitempty" json:"filename,omitempty"`
+}
+
+// StatusUpdateEntry is a status update together with the user that
+// configured it.
+type StatusUpdateEntry struct {
+	User string `json:"user"`
+	ID   string `json:"id"`
+	StatusUpdate
+}
+
+// StatusText summarizes the progress of a goal in a single line.
+func StatusText(g Goal) string {
+	text := fmt.Sprintf("%s: %s", g.Name, progressText(g))
+	if g.Unit != "" {
+		text += fmt.Sprintf(" (%g of %g %s)", roundTo(g.Current(), 1), g.Target, g.Unit)
+	}
+	return text
+}
+
+// progressText formats the progress of a goal as a percentage, or as
+// "n/a" if there is none, such as for a goal without values or whose
+// target is its baseline.
+func progressText(g Goal) string {
+	p := float64(g.Progress())
+	if math.IsNaN(p) || math.IsInf(p, 0) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.0f%%", 100*p)
+}
+
+// Badge is a shields.io endpoint badge,
+// see https://shields.io/endpoint.
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// NewBadge creates a badge showing the progress of a goal, colored by
+// whether the goal is on track by the given date.
+func NewBadge(g Goal, now int64) Badge {
+	color := "orange"
+	if g.IsOnTrack(now) {
+		color = "green"
+	}
+	return Badge{
+		SchemaVersion: 1,
+		Label:         g.Name,
+		Message:       progressText(g),
+		Color:         color,
+	}
+}
+
+// statusPublisher sends status updates to Slack and GitHub.
+type statusPublisher struct {
+	client    *http.Client
+	slackURL  string
+	githubURL string
+}
+
+func newStatusPublisher() statusPublisher {
+	return statusPublisher{
+		client:    http.DefaultClient,
+		slackURL:  "https://slack.com/api",
+		githubURL: "https://api.github.com",
+	}
+}
+
+// publish sends the progress of the goal as configured by the update.
+func (p statusPublisher) publish(u StatusUpdate, g Goal, now int64) error {
+	switch u.Kind {
+	case "slack":
+		return p.publishSlack(u, g)
+	case "gist":
+		return p.publishGist(u, g, now)
+	default:
+		return fmt.Errorf("Unknown status update kind: %q", u.Kind)
+	}
+}
+
+func (p statusPublisher) publishSlack(u StatusUpdate, g Goal) error {
+	body := map[string]interface{}{
+		"profile": map[string]string{
+			"status_text":  StatusText(g),
+			"status_emoji": ":dart:",
+		},
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := p.do(http.MethodPost, p.slackURL+"/users.profile.set", "Bearer "+u.Token, body, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("Error updating Slack status: %s", resp.Error)
+	}
+	return nil
+}
+
+func (p statusPublisher) publishGist(u StatusUpdate, g Goal, now int64) error {
+	// Gist IDs are hex, and are checked so that a stored ID cannot point
+	// the token of the user at other GitHub API paths.
+	if u.GistID == "" || strings.Trim(strings.ToLower(u.GistID), "0123456789abcdef") != "" {
+		return fmt.Errorf("Invalid gist ID %q: %w", u.GistID, ErrInvalidValue)
+	}
+	content, err := json.Marshal(NewBadge(g, now))
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"files": map[string]interface{}{
+			u.Filename: map[string]string{"content": string(content)},
+		},
+	}
+	return p.do(http.MethodPatch, p.githubURL+"/gists/"+url.PathEscape(u.GistID), "token "+u.Token, body, nil)
+}
+
+// do sends a JSON request and decodes the JSON response into out, unless
+// out is nil.
+func (p statusPublisher) do(method, url, authorization string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Error publishing status to %s: %s", url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ListStatusUpdates returns the status updates configured by all users.
+func (s Storage) ListStatusUpdates() ([]StatusUpdateEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListStatusUpdates", func(ctx context.Context) (err error) {
+		docs, err = s.client.CollectionGroup("statusUpdates").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing status updates: %w", err)
+	}
+	updates := make([]StatusUpdateEntry, 0, len(docs))
+	for _, doc := range docs {
+		if !s.inNamespace(doc.Ref) {
+			continue
+		}
+		var u StatusUpdate
+		if err := doc.DataTo(&u); err != nil {
+			return nil, fmt.Errorf("Error reading status update %q: %w", doc.Ref.ID, err)
+		}
+		updates = append(updates, StatusUpdateEntry{doc.Ref.Parent.Parent.ID, doc.Ref.ID, u})
+	}
+	return updates, nil
+}
+
+func roundTo(f float32, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(float64(f)*p) / p
+}
@@ -0,0 +1,135 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusText(t *testing.T) {
+	g := Goal{
+		Name:       "Distance",
+		Target:     500,
+		Unit:       "km",
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 1, Value: 210}},
+	}
+
+	want := "Distance: 42% (210 of 500 km)"
+	if got := StatusText(g); got != want {
+		t.Errorf("status was %q; wanted %q", got, want)
+	}
+}
+
+func TestStatusTextWithoutDistance(t *testing.T) {
+	g := Goal{Name: "Distance", Target: 500, Trajectory: Trajectory{{Date: 0, Value: 500}}}
+
+	if got, want := StatusText(g), "Distance: n/a"; got != want {
+		t.Errorf("status was %q; wanted %q", got, want)
+	}
+	if b := NewBadge(g, 0); b.Message != "n/a" {
+		t.Errorf("badge message was %q; wanted n/a", b.Message)
+	}
+}
+
+func TestNewBadge(t *testing.T) {
+	g := Goal{
+		Name:       "Distance",
+		Start:      0,
+		End:        10,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 5, Value: 60}},
+	}
+
+	b := NewBadge(g, 5)
+
+	if b.Message != "60%" || b.Color != "green" {
+		t.Errorf("badge was %+v; wanted green 60%%", b)
+	}
+}
+
+func TestPublishSlack(t *testing.T) {
+	var body map[string]map[string]string
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+	p := statusPublisher{client: srv.Client(), slackURL: srv.URL}
+	g := Goal{Name: "Distance", Target: 10, Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 1, Value: 5}}}
+
+	err := p.publish(StatusUpdate{Kind: "slack", Token: "xoxp"}, g, 0)
+
+	if err != nil {
+		t.Fatalf("wanted no error, got %v", err)
+	}
+	if auth != "Bearer xoxp" {
+		t.Errorf("authorization was %q; wanted %q", auth, "Bearer xoxp")
+	}
+	if body["profile"]["status_text"] != "Distance: 50%" {
+		t.Errorf("status text was %q; wanted %q", body["profile"]["status_text"], "Distance: 50%")
+	}
+}
+
+func TestPublishSlackError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+	}))
+	defer srv.Close()
+	p := statusPublisher{client: srv.Client(), slackURL: srv.URL}
+
+	err := p.publish(StatusUpdate{Kind: "slack"}, Goal{}, 0)
+
+	if err == nil {
+		t.Errorf("wanted error, got none")
+	}
+}
+
+func TestPublishGist(t *testing.T) {
+	var path string
+	var body struct {
+		Files map[string]struct {
+			Content string
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+	p := statusPublisher{client: srv.Client(), githubURL: srv.URL}
+	g := Goal{Name: "Distance", Target: 10, Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 1, Value: 5}}}
+
+	err := p.publish(StatusUpdate{Kind: "gist", GistID: "abc", Filename: "badge.json"}, g, 0)
+
+	if err != nil {
+		t.Fatalf("wanted no error, got %v", err)
+	}
+	if path != "/gists/abc" {
+		t.Errorf("path was %q; wanted /gists/abc", path)
+	}
+	var b Badge
+	json.Unmarshal([]byte(body.Files["badge.json"].Content), &b)
+	if b.Message != "50%" {
+		t.Errorf("badge message was %q; wanted 50%%", b.Message)
+	}
+}
+
+func TestPublishGistRejectsInvalidID(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+	p := statusPublisher{client: srv.Client(), githubURL: srv.URL}
+	g := Goal{Name: "Distance", Target: 10}
+
+	err := p.publish(StatusUpdate{Kind: "gist", GistID: "../user/keys", Filename: "badge.json"}, g, 0)
+
+	if !errors.Is(err, ErrInvalidValue) || called {
+		t.Errorf("error was %v, GitHub called: %v; wanted ErrInvalidValue", err, called)
+	}
+}
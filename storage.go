@@ -2,71 +2,461 @@ package pursuit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"time"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	firebase "firebase.google.com/go"
 )
 
+// ErrNotFound is wrapped by errors about documents that do not exist.
+var ErrNotFound = errors.New("Not found")
+
 // Storage provides an interface to serialization/deserialization of
 // objectives in Firestore.
 type Storage struct {
-	client *firestore.Client
-	ctx    context.Context
+	client   *firestore.Client
+	ctx      context.Context
+	breakers *circuitBreakers
+	// namespace separates the data of a sandbox from real data, see
+	// UseNamespace.
+	namespace string
+	// environment labels events and exported data, see Environment.
+	environment string
+	// events is called with each recorded event, see HandleEvents.
+	events func(userID string, e EventEntry)
+	// trajectories keeps new values of goals in subcollections, see
+	// UseTrajectorySubcollection.
+	trajectories bool
+	// idempotencyKey makes changes of goals apply once, see
+	// WithIdempotencyKey.
+	idempotencyKey string
+	// ids generates the IDs of new objectives and goals, see
+	// UseIDGenerator.
+	ids IDGenerator
+	// milestones is called with goals that reach a milestone or break a
+	// record, see NotifyMilestones.
+	milestones func(userID string, e Event)
+	// auditKey signs the entries of audit logs, see UseAuditKey.
+	auditKey []byte
 }
 
-// NewStorage creates client for a particular project.
+// NewStorage creates client for a particular project. It exits if the
+// client cannot be created; see NewStorageWithConfig.
 func NewStorage(projectID string) *Storage {
-	ctx := context.Background()
-	conf := &firebase.Config{ProjectID: projectID}
-	app, err := firebase.NewApp(ctx, conf)
+	s, err := NewStorageWithConfig(StorageConfig{ProjectID: projectID})
 	if err != nil {
 		log.Fatalln(err)
 	}
+	return s
+}
+
+// StorageConfig selects the Firestore database that a storage uses, which
+// is always the (default) database of the project.
+type StorageConfig struct {
+	ProjectID string
+	// Environment labels events and exported data, e.g. EnvStaging. It is
+	// EnvProduction if empty.
+	Environment string
+	// EmulatorHost is the host:port of a Firestore emulator to use instead
+	// of Firestore. If empty, the FIRESTORE_EMULATOR_HOST environment
+	// variable is respected.
+	EmulatorHost string
+	// CredentialsFile is the path of a service account key to use instead
+	// of the application default credentials.
+	CredentialsFile string
+}
+
+// emulatorCredentials authorize requests to the Firestore emulator, as the
+// Firestore client does for FIRESTORE_EMULATOR_HOST.
+type emulatorCredentials struct{}
+
+func (emulatorCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer owner"}, nil
+}
+
+func (emulatorCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// NewStorageWithConfig creates a client for the database of the config.
+func NewStorageWithConfig(c StorageConfig) (*Storage, error) {
+	ctx := context.Background()
+	if c.EmulatorHost != "" {
+		conn, err := grpc.Dial(c.EmulatorHost, grpc.WithInsecure(), grpc.WithPerRPCCredentials(emulatorCredentials{}))
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to the Firestore emulator at %s: %w", c.EmulatorHost, err)
+		}
+		client, err := firestore.NewClient(ctx, c.ProjectID, option.WithGRPCConn(conn))
+		if err != nil {
+			return nil, fmt.Errorf("Error creating Firestore client: %w", err)
+		}
+		return NewStorageWithClient(client, c.Environment), nil
+	}
+	var opts []option.ClientOption
+	if c.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(c.CredentialsFile))
+	}
+	conf := &firebase.Config{ProjectID: c.ProjectID}
+	app, err := firebase.NewApp(ctx, conf, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Firebase app: %w", err)
+	}
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("Error creating Firestore client: %w", err)
 	}
-	return &Storage{client, ctx}
+	return NewStorageWithClient(client, c.Environment), nil
+}
+
+// NewStorageWithClient creates a storage that uses an existing Firestore
+// client, such as one that tests connect to an emulator. The environment
+// is as in StorageConfig.
+func NewStorageWithClient(client *firestore.Client, environment string) *Storage {
+	return &Storage{client: client, ctx: context.Background(), breakers: newCircuitBreakers(), environment: environment}
 }
 
 // SetGoalValue adds a new value to the trajectory of the goal,
-// using the current timestamp.
-func (s Storage) SetGoalValue(userID, objectiveID, goalID string, value float32) error {
-	objective, err := s.readObjective(userID, objectiveID)
+// using the current timestamp. The value is converted from unit,
+// which may be empty, into the unit of the goal.
+func (s Storage) SetGoalValue(userID, objectiveID, goalID string, value float32, unit string) error {
+	g, err := s.updateGoal("SetGoalValue", userID, objectiveID, goalID, idempotencyPayload(value, unit), func(o *Objective) (bool, error) {
+		value, err := o.ConvertGoalValue(goalID, value, unit)
+		if err != nil {
+			return false, err
+		}
+		return true, o.SetGoalValue(goalID, value)
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	objective.SetGoalValue(goalID, value)
-	return s.writeObjective(userID, objectiveID, objective)
+	s.recordEvent(userID, newGoalEvent(EventGoalSet, objectiveID, goalID, g, 0))
+	return nil
 }
 
 // IncrementGoalValue adds a new value to the trajectory of the goal,
-// using the current timestamp.
-func (s Storage) IncrementGoalValue(userID, objectiveID, goalID string, delta float32) error {
-	objective, err := s.readObjective(userID, objectiveID)
+// using the current timestamp. The delta is converted from unit,
+// which may be empty, into the unit of the goal.
+func (s Storage) IncrementGoalValue(userID, objectiveID, goalID string, delta float32, unit string) error {
+	var converted float32
+	g, err := s.updateGoal("IncrementGoalValue", userID, objectiveID, goalID, idempotencyPayload(delta, unit), func(o *Objective) (_ bool, err error) {
+		converted, err = o.ConvertGoalValue(goalID, delta, unit)
+		if err != nil {
+			return false, err
+		}
+		return true, o.IncrementGoalValue(goalID, converted)
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	objective.IncrementGoalValue(goalID, delta)
-	return s.writeObjective(userID, objectiveID, objective)
+	s.recordEvent(userID, newGoalEvent(EventGoalIncremented, objectiveID, goalID, g, converted))
+	return nil
+}
+
+// MuteGoal mutes notifications about the goal, or unmutes them if m is
+// nil.
+func (s Storage) MuteGoal(userID, objectiveID, goalID string, m *Mute) error {
+	_, err := s.updateGoal("MuteGoal", userID, objectiveID, goalID, idempotencyPayload(m), func(o *Objective) (bool, error) {
+		return true, o.MuteGoal(goalID, m)
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return nil
+	}
+	return err
+}
+
+// SetGoalStage moves the goal to a stage, see Objective.SetGoalStage.
+func (s Storage) SetGoalStage(userID, objectiveID, goalID, stage string) error {
+	_, err := s.updateGoal("SetGoalStage", userID, objectiveID, goalID, idempotencyPayload(stage), func(o *Objective) (bool, error) {
+		return true, o.SetGoalStage(goalID, stage)
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return nil
+	}
+	return err
+}
+
+// updateGoal applies f to an objective and writes the goal back in a
+// transaction, so that concurrent changes to the goal, such as two
+// increments, are not lost. Only the goal is written, and only if it
+// changed. If trajectories are kept in subcollections, f sees the values
+// in the subcollection after the inline ones, and values that f adds are
+// appended to the subcollection. f reports the result of the change, such as whether
+// a conditional increment incremented the value. If the storage has an
+// idempotency key that was already used for the same change, identified
+// by op and payload, f is not applied and a *replayedError with the
+// result of the first change is returned. Goals that reach a milestone
+// or break a record are notified once the change is written, and
+// composite goals of the goal are recomputed. It returns the updated
+// goal.
+func (s Storage) updateGoal(op, userID, objectiveID, goalID, payload string, f func(o *Objective) (bool, error)) (Goal, error) {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	var g Goal
+	var milestone int
+	var records []string
+	err := s.transaction(op, func(tx *firestore.Transaction) error {
+		milestone = 0
+		records = nil
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return fmt.Errorf("Error reading objective: %w", err)
+		}
+		request := op + "/" + objectiveID + "/" + goalID
+		keyRef, replayed, err := s.readIdempotencyKey(tx, userID, request, payload)
+		if err != nil {
+			return err
+		}
+		if replayed != nil {
+			g = o.Goals[goalID]
+			return &replayedError{replayed.Changed}
+		}
+		before, ok := o.Goals[goalID]
+		// In subcollection mode, loaded holds the lengths of the
+		// trajectories read, and inline the values stored inline, of
+		// the goal and of the goals that its composites depend on.
+		loaded := map[string]int{}
+		inline := map[string]Trajectory{}
+		if ok && s.trajectories {
+			for _, id := range append([]string{goalID}, o.compositeGoals(goalID)...) {
+				goal, ok := o.Goals[id]
+				if _, done := loaded[id]; done || !ok {
+					continue
+				}
+				t, err := s.readTrajectory(tx, userID, objectiveID, id)
+				if err != nil {
+					return err
+				}
+				inline[id] = goal.Trajectory
+				goal.Trajectory = append(append(Trajectory{}, goal.Trajectory...), t...)
+				o.Goals[id] = goal
+				loaded[id] = len(goal.Trajectory)
+			}
+		}
+		changed, err := f(&o)
+		if err != nil {
+			return err
+		}
+		g = o.Goals[goalID]
+		milestone = g.updateMilestone()
+		records = g.updateRecords()
+		o.Goals[goalID] = g
+		composites := o.recomputeComposites(goalID)
+		stored := g
+		if s.trajectories {
+			for _, id := range append([]string{goalID}, composites...) {
+				points := s.trajectoryRef(userID, objectiveID, id)
+				for _, v := range o.Goals[id].Trajectory[loaded[id]:] {
+					if err := tx.Create(points.NewDoc(), v); err != nil {
+						return err
+					}
+				}
+				c := o.Goals[id]
+				c.Trajectory = inline[id]
+				o.Goals[id] = c
+			}
+			stored.Trajectory = before.Trajectory
+		}
+		unchanged := reflect.DeepEqual(stored, before)
+		if keyRef != nil {
+			err := tx.Create(keyRef, idempotencyRecord{
+				Request:  request,
+				Payload:  payload,
+				Changed:  changed,
+				ExpireAt: time.Now().Add(idempotencyRetention),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if unchanged {
+			return nil
+		}
+		updates := []firestore.Update{{FieldPath: firestore.FieldPath{"goals", goalID}, Value: stored}}
+		for _, id := range composites {
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"goals", id}, Value: o.Goals[id]})
+		}
+		return tx.Update(ref, updates)
+	})
+	if err == nil && milestone > 0 {
+		s.notifyGoalEvent(userID, g, newMilestoneEvent(objectiveID, goalID, g, milestone))
+	}
+	if err == nil {
+		for _, kind := range records {
+			s.notifyGoalEvent(userID, g, newRecordEvent(objectiveID, goalID, g, kind))
+		}
+	}
+	return g, err
+}
+
+// IncrementGoalValueIfStale increments the value of the goal unless the
+// latest value is more recent than maxAge. The check and the update are
+// done in a single transaction, so that concurrent sensors cannot both
+// add a reading. It reports whether the value was incremented.
+func (s Storage) IncrementGoalValueIfStale(userID, objectiveID, goalID string, delta float32, unit string, maxAge time.Duration) (bool, error) {
+	var incremented bool
+	var converted float32
+	g, err := s.updateGoal("IncrementGoalValueIfStale", userID, objectiveID, goalID, idempotencyPayload(delta, unit, maxAge), func(o *Objective) (_ bool, err error) {
+		converted, err = o.ConvertGoalValue(goalID, delta, unit)
+		if err != nil {
+			return false, err
+		}
+		incremented, err = o.IncrementGoalValueIfStale(goalID, converted, maxAge)
+		return incremented, err
+	})
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return replayed.changed, nil
+	}
+	if err != nil || !incremented {
+		return incremented, err
+	}
+	s.recordEvent(userID, newGoalEvent(EventGoalIncremented, objectiveID, goalID, g, converted))
+	return true, nil
+}
+
+// ObjectiveEntry is an objective together with its ID.
+type ObjectiveEntry struct {
+	ID string `json:"id"`
+	Objective
+}
+
+// ListObjectives returns all objectives of a user, ordered by ID.
+func (s Storage) ListObjectives(userID string) ([]ObjectiveEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListObjectives", func(ctx context.Context) (err error) {
+		docs, err = s.collection("users").Doc(userID).Collection("objectives").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing objectives: %w", err)
+	}
+	objectives := make([]ObjectiveEntry, 0, len(docs))
+	for _, doc := range docs {
+		var o Objective
+		if err := doc.DataTo(&o); err != nil {
+			return nil, fmt.Errorf("Error reading objective %q: %w", doc.Ref.ID, err)
+		}
+		if _, err := s.loadTrajectories(userID, doc.Ref.ID, &o); err != nil {
+			return nil, err
+		}
+		objectives = append(objectives, ObjectiveEntry{doc.Ref.ID, o})
+	}
+	return objectives, nil
 }
 
 func (s Storage) readObjective(userID string, objectiveID string) (Objective, error) {
-	ref := s.client.Collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
-	doc, err := ref.Get(s.ctx)
+	objective, _, err := s.readObjectiveVersion(userID, objectiveID)
+	return objective, err
+}
+
+// readObjectiveVersion reads an objective together with the update time of
+// its document, or of the latest value in its trajectory subcollections.
+func (s Storage) readObjectiveVersion(userID string, objectiveID string) (Objective, time.Time, error) {
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	var doc *firestore.DocumentSnapshot
+	err := s.do("readObjective", func(ctx context.Context) (err error) {
+		doc, err = ref.Get(ctx)
+		return err
+	})
 	if err != nil {
-		return Objective{}, fmt.Errorf("Error reading objective: %v", err)
+		return Objective{}, time.Time{}, objectiveReadError(objectiveID, err)
 	}
 	var objective Objective
 	doc.DataTo(&objective)
-	return objective, nil
+	// Values appended to trajectory subcollections do not change the
+	// update time of the objective.
+	written, err := s.loadTrajectories(userID, objectiveID, &objective)
+	if err != nil {
+		return Objective{}, time.Time{}, err
+	}
+	if written.After(doc.UpdateTime) {
+		return objective, written, nil
+	}
+	return objective, doc.UpdateTime, nil
 }
 
-func (s Storage) writeObjective(userID string, objectiveID string, objective Objective) error {
-	ref := s.client.Collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
-	_, err := ref.Set(s.ctx, objective)
-	return err
+// objectiveReadError describes an error reading the document of an
+// objective. Objectives that do not exist are reported with ErrNotFound,
+// as memoryGoalStore does, so that handlers reply with 404.
+func objectiveReadError(objectiveID string, err error) error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if status.Code(e) == codes.NotFound {
+			return fmt.Errorf("No such objective: %q: %w", objectiveID, ErrNotFound)
+		}
+	}
+	return fmt.Errorf("Error reading objective: %w", err)
+}
+
+// collection returns a top-level collection of the namespace of the
+// storage.
+func (s Storage) collection(path string) *firestore.CollectionRef {
+	if s.namespace == "" {
+		return s.client.Collection(path)
+	}
+	return s.client.Collection("namespaces").Doc(s.namespace).Collection(path)
+}
+
+// inNamespace reports whether a document, e.g. one found by a collection
+// group query, belongs to the namespace of the storage.
+func (s Storage) inNamespace(ref *firestore.DocumentRef) bool {
+	for ref.Parent.Parent != nil {
+		ref = ref.Parent.Parent
+	}
+	if s.namespace == "" {
+		return ref.Parent.ID != "namespaces"
+	}
+	return ref.Parent.ID == "namespaces" && ref.ID == s.namespace
+}
+
+// do runs a Firestore operation with a deadline, unless the circuit
+// breaker of the operation is open.
+func (s Storage) do(op string, f func(ctx context.Context) error) error {
+	return s.breakers.call(s.ctx, op, func() error {
+		ctx, cancel := context.WithTimeout(s.ctx, s.breakers.timeout)
+		defer cancel()
+		return f(ctx)
+	})
+}
+
+// WithContext returns a copy of the storage whose operations run in ctx,
+// such as the context of a request, so that they are canceled along with
+// it. Each operation is still bounded by the timeout of its circuit
+// breaker.
+func (s Storage) WithContext(ctx context.Context) *Storage {
+	s.ctx = ctx
+	return &s
+}
+
+// transaction runs f in a Firestore transaction through do.
+func (s Storage) transaction(op string, f func(tx *firestore.Transaction) error) error {
+	return s.do(op, func(ctx context.Context) error {
+		return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			return f(tx)
+		})
+	})
 }
@@ -0,0 +1,25 @@
+package pursuit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestObjectiveReadError(t *testing.T) {
+	notFound := fmt.Errorf("Error getting document: %w", status.Error(codes.NotFound, "missing"))
+	if err := objectiveReadError("fitness", notFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error for missing objective was %v; wanted ErrNotFound", err)
+	}
+	if code := errorStatus(objectiveReadError("fitness", notFound)); code != http.StatusNotFound {
+		t.Errorf("status for missing objective was %d; wanted 404", code)
+	}
+	unavailable := status.Error(codes.Unavailable, "brownout")
+	if err := objectiveReadError("fitness", unavailable); errors.Is(err, ErrNotFound) || !errors.Is(err, unavailable) {
+		t.Errorf("error for outage was %v; wanted the outage", err)
+	}
+}
@@ -0,0 +1,595 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Strava connects Strava accounts of users, so that the distance and
+// elevation gain of their activities are added to goals as soon as the
+// activities are uploaded, see https://developers.strava.com/docs/.
+//
+// Users authorize access to their activities with OAuth. Strava then
+// sends an event for each new activity to /webhooks/strava, whose
+// requests StravaVerifier checks. Changed and deleted activities are not
+// reflected in the goals.
+type Strava struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the public URL of /strava/callback, which must match
+	// the authorization callback domain of the Strava application.
+	RedirectURL string
+	client      *http.Client
+	oauthURL    string
+	apiURL      string
+}
+
+// NewStrava creates the Strava integration for an application registered
+// at https://www.strava.com/settings/api.
+func NewStrava(clientID, clientSecret, redirectURL string) *Strava {
+	return &Strava{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		oauthURL:     "https://www.strava.com/oauth",
+		apiURL:       "https://www.strava.com/api/v3",
+	}
+}
+
+// stravaStateTTL is how long users have to authorize access on Strava.
+const stravaStateTTL = 15 * time.Minute
+
+// state returns the OAuth state that ties an authorization to the user
+// who started it. It is signed with the client secret and expires after
+// stravaStateTTL.
+func (s *Strava) state(userID string, now time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." +
+		strconv.FormatInt(now.Add(stravaStateTTL).Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.ClientSecret))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// userOfState returns the user who started the authorization with the
+// state.
+func (s *Strava) userOfState(state string, now time.Time) (string, error) {
+	i := strings.LastIndex(state, ".")
+	if i < 0 {
+		return "", fmt.Errorf("Invalid state: %w", ErrForbidden)
+	}
+	payload := state[:i]
+	mac := hmac.New(sha256.New, []byte(s.ClientSecret))
+	mac.Write([]byte(payload))
+	if !hmac.Equal([]byte(state[i+1:]), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return "", fmt.Errorf("Invalid state: %w", ErrForbidden)
+	}
+	parts := strings.SplitN(payload, ".", 2)
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(parts) != 2 {
+		return "", fmt.Errorf("Invalid state: %w", ErrForbidden)
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", fmt.Errorf("Expired state, authorize again: %w", ErrForbidden)
+	}
+	return string(user), nil
+}
+
+// AuthorizeURL returns the Strava page on which a user grants access to
+// their activities.
+func (s *Strava) AuthorizeURL(userID string, now time.Time) string {
+	q := url.Values{
+		"client_id":       {s.ClientID},
+		"redirect_uri":    {s.RedirectURL},
+		"response_type":   {"code"},
+		"approval_prompt": {"auto"},
+		"scope":           {"activity:read_all"},
+		"state":           {s.state(userID, now)},
+	}
+	return s.oauthURL + "/authorize?" + q.Encode()
+}
+
+// StravaToken is an OAuth token of a Strava athlete.
+type StravaToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresAt in seconds since the epoch.
+	ExpiresAt int64 `json:"expires_at"`
+	Athlete   struct {
+		ID int64 `json:"id"`
+	} `json:"athlete"`
+}
+
+// token requests a token with the grant, either an authorization code or
+// a refresh token.
+func (s *Strava) token(grantType, grant string) (StravaToken, error) {
+	form := url.Values{
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"grant_type":    {grantType},
+	}
+	if grantType == "authorization_code" {
+		form.Set("code", grant)
+	} else {
+		form.Set("refresh_token", grant)
+	}
+	var t StravaToken
+	resp, err := s.client.PostForm(s.oauthURL+"/token", form)
+	if err != nil {
+		return t, fmt.Errorf("Error requesting Strava token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return t, fmt.Errorf("Error requesting Strava token: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return t, fmt.Errorf("Error reading Strava token: %v", err)
+	}
+	return t, nil
+}
+
+// StravaActivity is the part of a Strava activity that goals use.
+// Distances are in meters.
+type StravaActivity struct {
+	ID                 int64     `json:"id"`
+	SportType          string    `json:"sport_type"`
+	Distance           float32   `json:"distance"`
+	TotalElevationGain float32   `json:"total_elevation_gain"`
+	StartDate          time.Time `json:"start_date"`
+}
+
+// activity fetches an activity with the access token of its athlete.
+func (s *Strava) activity(accessToken string, id int64) (StravaActivity, error) {
+	var a StravaActivity
+	req, err := http.NewRequest(http.MethodGet, s.apiURL+"/activities/"+strconv.FormatInt(id, 10), nil)
+	if err != nil {
+		return a, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return a, fmt.Errorf("Error fetching Strava activity %d: %v", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return a, fmt.Errorf("Error fetching Strava activity %d: %s", id, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return a, fmt.Errorf("Error reading Strava activity %d: %v", id, err)
+	}
+	return a, nil
+}
+
+// deauthorize revokes the access of the application to an athlete.
+func (s *Strava) deauthorize(accessToken string) error {
+	resp, err := s.client.PostForm(s.oauthURL+"/deauthorize", url.Values{"access_token": {accessToken}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Metrics of Strava activities that can be mapped to goals. A metric can
+// be limited to a sport type by prefixing it, such as Run.distance.
+const (
+	StravaDistance      = "distance"
+	StravaElevationGain = "elevationGain"
+)
+
+// stravaMeasurements returns the metrics of an activity, both for all
+// sport types and for the sport type of the activity, in meters.
+func stravaMeasurements(a StravaActivity) []Measurement {
+	id := strconv.FormatInt(a.ID, 10)
+	date := a.StartDate.UnixNano() / 1000 / 1000
+	var measurements []Measurement
+	values := []struct {
+		metric string
+		value  float32
+	}{{StravaDistance, a.Distance}, {StravaElevationGain, a.TotalElevationGain}}
+	for _, v := range values {
+		if v.value <= 0 {
+			continue
+		}
+		for _, m := range []string{v.metric, a.SportType + "." + v.metric} {
+			measurements = append(measurements, Measurement{ID: id, Metric: m, Date: date, Value: v.value, Unit: "m", Increment: true})
+		}
+	}
+	return measurements
+}
+
+// validStravaMetric reports whether the metric can be mapped to a goal.
+func validStravaMetric(metric string) bool {
+	if i := strings.LastIndex(metric, "."); i >= 0 {
+		if i == 0 {
+			return false
+		}
+		metric = metric[i+1:]
+	}
+	return metric == StravaDistance || metric == StravaElevationGain
+}
+
+// StravaConnection for Firestore serialization/deserialization. A
+// connection holds the tokens of a Strava athlete who authorized a user,
+// and the goals that the metrics of their activities are added to.
+// Connections are stored in stravaAthletes/{athlete}, since Strava
+// events name the athlete rather than the user.
+type StravaConnection struct {
+	User         string `firestore:"user" json:"-"`
+	AccessToken  string `firestore:"accessToken" json:"-"`
+	RefreshToken string `firestore:"refreshToken" json:"-"`
+	// ExpiresAt in seconds since the epoch.
+	ExpiresAt int64 `firestore:"expiresAt" json:"-"`
+	// Goals maps metrics to goals, see StravaDistance.
+	Goals map[string]ImportTarget `firestore:"goals" json:"goals"`
+	// Connected in milliseconds since the epoch.
+	Connected int64 `firestore:"connected" json:"connected"`
+}
+
+// StravaConnectionEntry is a Strava connection together with the ID of
+// the athlete.
+type StravaConnectionEntry struct {
+	Athlete int64 `json:"athlete"`
+	StravaConnection
+}
+
+func (s Storage) stravaAthleteRef(athleteID int64) *firestore.DocumentRef {
+	return s.collection("stravaAthletes").Doc(strconv.FormatInt(athleteID, 10))
+}
+
+// ConnectStrava stores the token of a Strava athlete who authorized a
+// user. Reconnecting keeps the mapped goals, unless the athlete was
+// connected to another user before.
+func (s Storage) ConnectStrava(userID string, t StravaToken) error {
+	ref := s.stravaAthleteRef(t.Athlete.ID)
+	return s.transaction("ConnectStrava", func(tx *firestore.Transaction) error {
+		var c StravaConnection
+		doc, err := tx.Get(ref)
+		if doc == nil || doc.Exists() {
+			if err != nil {
+				return fmt.Errorf("Error reading Strava connection: %w", err)
+			}
+			if err := doc.DataTo(&c); err != nil {
+				return fmt.Errorf("Error reading Strava connection: %w", err)
+			}
+		}
+		if c.User != userID || c.Goals == nil {
+			c.Goals = map[string]ImportTarget{}
+		}
+		c.User = userID
+		c.AccessToken = t.AccessToken
+		c.RefreshToken = t.RefreshToken
+		c.ExpiresAt = t.ExpiresAt
+		c.Connected = time.Now().UnixNano() / 1000 / 1000
+		return tx.Set(ref, c)
+	})
+}
+
+// stravaAthlete returns the connection of a Strava athlete.
+func (s Storage) stravaAthlete(athleteID int64) (StravaConnection, error) {
+	var c StravaConnection
+	err := s.do("stravaAthlete", func(ctx context.Context) error {
+		doc, err := s.stravaAthleteRef(athleteID).Get(ctx)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such Strava athlete: %d: %w", athleteID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading Strava connection: %w", err)
+		}
+		return doc.DataTo(&c)
+	})
+	return c, err
+}
+
+// GetStravaConnection returns the Strava connection of a user.
+func (s Storage) GetStravaConnection(userID string) (StravaConnectionEntry, error) {
+	q := s.collection("stravaAthletes").Where("user", "==", userID).Limit(1)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("GetStravaConnection", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return StravaConnectionEntry{}, fmt.Errorf("Error reading Strava connection: %w", err)
+	}
+	if len(docs) == 0 {
+		return StravaConnectionEntry{}, fmt.Errorf("No Strava connection: %w", ErrNotFound)
+	}
+	athleteID, err := strconv.ParseInt(docs[0].Ref.ID, 10, 64)
+	if err != nil {
+		return StravaConnectionEntry{}, fmt.Errorf("Invalid Strava athlete: %q", docs[0].Ref.ID)
+	}
+	var c StravaConnection
+	if err := docs[0].DataTo(&c); err != nil {
+		return StravaConnectionEntry{}, fmt.Errorf("Error reading Strava connection: %w", err)
+	}
+	return StravaConnectionEntry{athleteID, c}, nil
+}
+
+// SetStravaGoals replaces the goals that the metrics of the Strava
+// activities of a user are added to. The goals must exist.
+func (s Storage) SetStravaGoals(userID string, goals map[string]ImportTarget) error {
+	for metric, t := range goals {
+		if !validStravaMetric(metric) {
+			return fmt.Errorf("Unknown Strava metric: %q: %w", metric, ErrInvalidValue)
+		}
+		o, err := s.getObjective(userID, t.Objective)
+		if err != nil {
+			return err
+		}
+		if _, err := o.valueGoal(t.Goal); err != nil {
+			return err
+		}
+	}
+	c, err := s.GetStravaConnection(userID)
+	if err != nil {
+		return err
+	}
+	return s.do("SetStravaGoals", func(ctx context.Context) error {
+		_, err := s.stravaAthleteRef(c.Athlete).Update(ctx, []firestore.Update{{Path: "goals", Value: goals}})
+		return err
+	})
+}
+
+// disconnectStrava deletes the connection of a Strava athlete.
+func (s Storage) disconnectStrava(athleteID int64) error {
+	return s.do("disconnectStrava", func(ctx context.Context) error {
+		_, err := s.stravaAthleteRef(athleteID).Delete(ctx)
+		return err
+	})
+}
+
+// freshStravaToken returns the access token of a connection, refreshed
+// and stored if it expires within a minute.
+func (s Storage) freshStravaToken(strava *Strava, athleteID int64, c StravaConnection) (string, error) {
+	if time.Now().Add(time.Minute).Unix() < c.ExpiresAt {
+		return c.AccessToken, nil
+	}
+	t, err := strava.token("refresh_token", c.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	err = s.do("freshStravaToken", func(ctx context.Context) error {
+		_, err := s.stravaAthleteRef(athleteID).Update(ctx, []firestore.Update{
+			{Path: "accessToken", Value: t.AccessToken},
+			{Path: "refreshToken", Value: t.RefreshToken},
+			{Path: "expiresAt", Value: t.ExpiresAt},
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error storing Strava token: %w", err)
+	}
+	return t.AccessToken, nil
+}
+
+// importStravaActivity adds the metrics of a new activity of an athlete
+// to the mapped goals. Each metric is added once per activity, even if
+// Strava sends the event again. It returns the number of changed goals.
+func (s Storage) importStravaActivity(strava *Strava, athleteID, activityID int64) (int, error) {
+	c, err := s.stravaAthlete(athleteID)
+	if err != nil {
+		return 0, err
+	}
+	if len(c.Goals) == 0 {
+		return 0, nil
+	}
+	token, err := s.freshStravaToken(strava, athleteID, c)
+	if err != nil {
+		return 0, err
+	}
+	a, err := strava.activity(token, activityID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range stravaMeasurements(a) {
+		t, ok := c.Goals[m.Metric]
+		if !ok {
+			continue
+		}
+		key := "strava-" + m.ID + "-" + m.Metric
+		imported, err := s.WithIdempotencyKey(key).ImportGoalValue(c.User, t.Objective, t.Goal, "strava", m.Value, m.Unit, m.Increment)
+		if err != nil {
+			return n, fmt.Errorf("Error importing Strava %s of activity %d into %s/%s: %w", m.Metric, activityID, t.Objective, t.Goal, err)
+		}
+		if imported {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// StravaEvent is an event of a Strava webhook subscription, see
+// https://developers.strava.com/docs/webhooks/.
+type StravaEvent struct {
+	ObjectType string `json:"object_type"`
+	ObjectID   int64  `json:"object_id"`
+	AspectType string `json:"aspect_type"`
+	OwnerID    int64  `json:"owner_id"`
+	// Updates holds the changed fields of updates, such as "authorized":
+	// "false" when an athlete revokes access.
+	Updates map[string]string `json:"updates"`
+}
+
+// UseStrava enables connecting Strava accounts, see Strava.
+func (s *Server) UseStrava(strava *Strava) {
+	s.strava = strava
+}
+
+// stravaConnection serves GET, PUT and DELETE /users/{user}/strava. GET
+// returns the connected athlete and the goals that their activities are
+// added to, which PUT replaces, e.g. with
+// {"goals": {"Run.distance": {"objective": "fitness", "goal": "run"}}}.
+// DELETE revokes the access to Strava.
+func (s *Server) stravaConnection(w http.ResponseWriter, r *http.Request, userID string) {
+	if s.strava == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Strava is not configured"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		c, err := s.storageFor(r).GetStravaConnection(userID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodPut:
+		var req struct {
+			Goals map[string]ImportTarget
+		}
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Goals == nil {
+			req.Goals = map[string]ImportTarget{}
+		}
+		if err := s.storageFor(r).SetStravaGoals(userID, req.Goals); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		c, err := s.storageFor(r).GetStravaConnection(userID)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if err := s.strava.deauthorize(c.AccessToken); err != nil {
+			logf(r.Context(), severityError, "Error deauthorizing Strava athlete %d of user %q: %v", c.Athlete, userID, err)
+		}
+		if err := s.storageFor(r).disconnectStrava(c.Athlete); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// authorizeStrava serves GET /users/{user}/strava/authorize, which
+// replies with the URL of the Strava page that the user must visit to
+// connect their account. Strava then redirects to /strava/callback.
+func (s *Server) authorizeStrava(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.strava == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Strava is not configured"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"url": s.strava.AuthorizeURL(userID, time.Now())})
+}
+
+// stravaCallback serves GET /strava/callback, to which Strava redirects
+// after a user authorized access. The signed state names the user, so the
+// request needs no other authentication.
+func (s *Server) stravaCallback(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.strava == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Strava is not configured"))
+		return
+	}
+	q := r.URL.Query()
+	userID, err := s.strava.userOfState(q.Get("state"), time.Now())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Strava access was not granted: %s", e))
+		return
+	}
+	if !strings.Contains(q.Get("scope"), "activity:read") {
+		writeError(w, http.StatusForbidden, errors.New("Strava access to activities was not granted"))
+		return
+	}
+	t, err := s.strava.token("authorization_code", q.Get("code"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if err := s.storageFor(r).ConnectStrava(userID, t); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Strava is connected. You can close this page.")
+}
+
+// stravaWebhook serves /webhooks/strava. GET answers the handshake of the
+// subscription, and POST receives its events. New activities are
+// imported by background jobs, which are retried if they fail, since
+// Strava expects a reply within two seconds.
+func (s *Server) stravaWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	if s.strava == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Strava is not configured"))
+		return
+	}
+	body, err := VerifyWebhook("strava", r)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]string{"hub.challenge": r.URL.Query().Get("hub.challenge")})
+		return
+	}
+	var e StravaEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case e.ObjectType == "athlete" && e.Updates["authorized"] == "false":
+		if err := s.storageFor(r).disconnectStrava(e.OwnerID); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	case e.ObjectType == "activity" && e.AspectType == "create":
+		c, err := s.storageFor(r).stravaAthlete(e.OwnerID)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		_, err = s.jobs.enqueue("strava", c.User, func(func(float64)) (interface{}, error) {
+			return s.storage.importStravaActivity(s.strava, e.OwnerID, e.ObjectID)
+		})
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,107 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStravaState(t *testing.T) {
+	s := NewStrava("1", "secret", "https://example.com/strava/callback")
+	now := time.Unix(1600000000, 0)
+	state := s.state("user.1", now)
+
+	if user, err := s.userOfState(state, now.Add(time.Minute)); err != nil || user != "user.1" {
+		t.Errorf("user was %q, %v; wanted user.1", user, err)
+	}
+	if _, err := s.userOfState(state, now.Add(stravaStateTTL+time.Second)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expired state was accepted: %v", err)
+	}
+	other := NewStrava("1", "other", "")
+	if _, err := other.userOfState(state, now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("state signed with another secret was accepted: %v", err)
+	}
+	if _, err := s.userOfState("dXNlcg."+state[strings.Index(state, ".")+1:], now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("state of another user was accepted: %v", err)
+	}
+
+	u, err := url.Parse(s.AuthorizeURL("user.1", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("state") != state || q.Get("scope") != "activity:read_all" || q.Get("redirect_uri") != s.RedirectURL {
+		t.Errorf("authorize URL was %s", u)
+	}
+}
+
+func TestStravaTokenAndActivity(t *testing.T) {
+	var form url.Values
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			r.ParseForm()
+			form = r.PostForm
+			w.Write([]byte(`{"access_token": "a", "refresh_token": "r", "expires_at": 1600000000, "athlete": {"id": 42}}`))
+		case "/api/v3/activities/7":
+			authorization = r.Header.Get("Authorization")
+			w.Write([]byte(`{"id": 7, "sport_type": "Run", "distance": 5012.5, "total_elevation_gain": 40, "start_date": "2020-09-13T12:00:00Z"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	s := NewStrava("1", "secret", "")
+	s.client, s.oauthURL, s.apiURL = srv.Client(), srv.URL+"/oauth", srv.URL+"/api/v3"
+
+	tok, err := s.token("authorization_code", "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "a" || tok.Athlete.ID != 42 || form.Get("code") != "code" || form.Get("client_secret") != "secret" {
+		t.Errorf("token was %+v for form %v", tok, form)
+	}
+	a, err := s.activity("a", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer a" || a.Distance != 5012.5 || a.SportType != "Run" {
+		t.Errorf("activity was %+v, authorized with %q", a, authorization)
+	}
+	if _, err := s.activity("a", 8); err == nil {
+		t.Errorf("missing activity was fetched")
+	}
+}
+
+func TestStravaMeasurements(t *testing.T) {
+	a := StravaActivity{ID: 7, SportType: "Ride", Distance: 20000, StartDate: time.Unix(1600000000, 0)}
+
+	measurements := stravaMeasurements(a)
+
+	if len(measurements) != 2 {
+		t.Fatalf("measurements were %+v; wanted distance without elevation gain", measurements)
+	}
+	want := Measurement{ID: "7", Metric: "Ride.distance", Date: 1600000000000, Value: 20000, Unit: "m", Increment: true}
+	if measurements[0].Metric != StravaDistance || measurements[1] != want {
+		t.Errorf("measurements were %+v", measurements)
+	}
+}
+
+func TestValidStravaMetric(t *testing.T) {
+	for metric, want := range map[string]bool{
+		"distance":           true,
+		"elevationGain":      true,
+		"Run.distance":       true,
+		"TrailRun.elevation": false,
+		".distance":          false,
+		"heartrate":          false,
+	} {
+		if got := validStravaMetric(metric); got != want {
+			t.Errorf("validStravaMetric(%q) was %v; wanted %v", metric, got, want)
+		}
+	}
+}
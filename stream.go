@@ -0,0 +1,158 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Types of the Server-Sent Events of the stream of a user.
+const (
+	// StreamObjective carries an ObjectiveEntry that was added or changed.
+	StreamObjective = "objective"
+	// StreamRemoved carries the ID of an objective that was deleted.
+	StreamRemoved = "removed"
+)
+
+// WatchObjectives calls f with each objective of a user, and then again
+// whenever an objective changes, until the context of the storage is done
+// or f fails. Objectives that are deleted are passed as nil. It listens to
+// the objectives with a Firestore snapshot listener, so values that are
+// only written to trajectory subcollections do not trigger a call.
+func (s Storage) WatchObjectives(userID string, f func(id string, o *Objective) error) error {
+	it := s.collection("users").Doc(userID).Collection("objectives").Snapshots(s.ctx)
+	defer it.Stop()
+	for {
+		snap, err := it.Next()
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error watching objectives: %w", err)
+		}
+		for _, c := range snap.Changes {
+			if c.Kind == firestore.DocumentRemoved {
+				if err := f(c.Doc.Ref.ID, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			var o Objective
+			if err := c.Doc.DataTo(&o); err != nil {
+				return fmt.Errorf("Error reading objective %q: %w", c.Doc.Ref.ID, err)
+			}
+			if _, err := s.loadTrajectories(userID, c.Doc.Ref.ID, &o); err != nil {
+				return err
+			}
+			if err := f(c.Doc.Ref.ID, &o); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event of the type with v as JSON data.
+func writeEvent(w io.Writer, eventType string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, b)
+	return err
+}
+
+// stream serves GET /users/{user}/stream?token=...&viewing=...&name=..., a
+// stream of Server-Sent Events that sends each objective of the user, and
+// then each objective that changes from any device as it changes, see
+// StreamObjective and StreamRemoved. It also sends the viewers of the
+// objectives as they come and go, see StreamPresence. With viewing, the
+// user is a viewer of that objective while the stream is open, under the
+// optional name. The stream ends when the client goes away or the request
+// times out, upon which EventSource clients reconnect and get all
+// objectives again. Since EventSource cannot send an Authorization header,
+// browsers pass the ID token as token parameter instead, and open a new
+// stream with a fresh token once it expires.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, userID string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	var viewer *Viewer
+	if q.Get("viewing") != "" {
+		viewer = &Viewer{ID: userID, Name: q.Get("name")}
+	}
+	s.streamObjectives(w, r, userID, q.Get("viewing"), viewer, false)
+}
+
+// streamObjectives serves a stream of the objectives of a user and their
+// presence, or only of one objective if only is set. The viewer, if any,
+// views the objective while the stream is open.
+func (s *Server) streamObjectives(w http.ResponseWriter, r *http.Request, userID, objectiveID string, viewer *Viewer, only bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	watcher, stop := s.presence.watch(userID)
+	defer stop()
+	if viewer != nil {
+		defer s.presence.join(userID, objectiveID, *viewer)()
+	}
+	type change struct {
+		id string
+		o  *Objective
+	}
+	ctx := r.Context()
+	changes := make(chan change)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.storageFor(r).WatchObjectives(userID, func(id string, o *Objective) error {
+			select {
+			case changes <- change{id, o}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	for {
+		var err error
+		select {
+		case c := <-changes:
+			switch {
+			case only && c.id != objectiveID:
+				continue
+			case c.o == nil:
+				err = writeEvent(w, StreamRemoved, map[string]string{"id": c.id})
+			default:
+				err = writeEvent(w, StreamObjective, ObjectiveEntry{c.id, *c.o})
+			}
+		case <-watcher.notify:
+			for _, p := range s.presence.take(userID, watcher) {
+				if err == nil && (!only || p.Objective == objectiveID) {
+					err = writeEvent(w, StreamPresence, p)
+				}
+			}
+		case err := <-done:
+			if err != nil && ctx.Err() == nil {
+				logf(ctx, severityError, "Error streaming objectives of user %q: %v", userID, err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,28 @@
+package pursuit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteEvent(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeEvent(&b, StreamRemoved, map[string]string{"id": "fitness"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "event: removed\ndata: {\"id\":\"fitness\"}\n\n"
+	if b.String() != want {
+		t.Errorf("event was %q; wanted %q", b.String(), want)
+	}
+}
+
+func TestStatusRecorderFlushes(t *testing.T) {
+	w := httptest.NewRecorder()
+	var rec http.ResponseWriter = &statusRecorder{ResponseWriter: w}
+	rec.(http.Flusher).Flush()
+	if !w.Flushed {
+		t.Errorf("flush did not reach the response writer")
+	}
+}
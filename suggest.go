@@ -0,0 +1,55 @@
+package pursuit
+
+import "math"
+
+// suggestionGrowth is how much more than achieved in the last period is
+// suggested for the next period, if the last target was reached.
+const suggestionGrowth = 0.1
+
+// Suggestion is a target for the next period of a goal.
+type Suggestion struct {
+	// Start and End of the next period in milliseconds since the epoch.
+	// The next period follows the last one and has the same length.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Baseline is the current value of the goal, from which the next period
+	// starts, and Target the suggested target.
+	Baseline float32 `json:"baseline"`
+	Target   float32 `json:"target"`
+	// Achieved is the amount of progress in the last period, and
+	// Attainment the fraction of the last target that it covered.
+	Achieved   float32 `json:"achieved"`
+	Attainment float32 `json:"attainment"`
+}
+
+// SuggestTarget suggests a realistic target for the period after the end
+// of the goal. If the last target was reached, the suggestion is 10% more
+// than what was achieved. Otherwise, it is the lesser of the last planned
+// amount and 10% more than what was achieved, so that missed targets do
+// not keep growing. Without any progress, the last planned amount is
+// suggested again.
+func SuggestTarget(g Goal) Suggestion {
+	baseline := g.Baseline()
+	current := g.Current()
+	planned := g.Target - baseline
+	achieved := current - baseline
+	s := Suggestion{
+		Start:      g.End,
+		End:        g.End + (g.End - g.Start),
+		Baseline:   current,
+		Achieved:   achieved,
+		Attainment: g.Progress(),
+	}
+	if planned == 0 {
+		s.Attainment = 1
+	}
+	amount := planned
+	if achieved*planned > 0 {
+		amount = achieved * (1 + suggestionGrowth)
+		if s.Attainment < 1 && math.Abs(float64(amount)) > math.Abs(float64(planned)) {
+			amount = planned
+		}
+	}
+	s.Target = float32(roundTo(current+amount, 1))
+	return s
+}
@@ -0,0 +1,84 @@
+package pursuit
+
+import "testing"
+
+func TestSuggestTargetAfterReachingTarget(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        10,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 9, Value: 120}},
+	}
+
+	s := SuggestTarget(g)
+
+	if s.Start != 10 || s.End != 20 {
+		t.Errorf("period was [%d, %d]; wanted [10, 20]", s.Start, s.End)
+	}
+	if s.Baseline != 120 || s.Target != 252 {
+		t.Errorf("suggested %f to %f; wanted 120 to 252", s.Baseline, s.Target)
+	}
+	if s.Attainment != 1.2 {
+		t.Errorf("attainment was %f; wanted 1.2", s.Attainment)
+	}
+}
+
+func TestSuggestTargetAfterMissingTarget(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        10,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 9, Value: 60}},
+	}
+
+	s := SuggestTarget(g)
+
+	if s.Target != 126 {
+		t.Errorf("target was %f; wanted 126", s.Target)
+	}
+}
+
+func TestSuggestTargetAfterNearlyReachingTarget(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        10,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}, {Date: 9, Value: 95}},
+	}
+
+	s := SuggestTarget(g)
+
+	if s.Target != 195 {
+		t.Errorf("target was %f; wanted 195", s.Target)
+	}
+}
+
+func TestSuggestTargetDecreasing(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        10,
+		Target:     70,
+		Trajectory: Trajectory{{Date: 0, Value: 80}, {Date: 9, Value: 70}},
+	}
+
+	s := SuggestTarget(g)
+
+	if s.Target != 59 {
+		t.Errorf("target was %f; wanted 59", s.Target)
+	}
+}
+
+func TestSuggestTargetWithoutProgress(t *testing.T) {
+	g := Goal{
+		Start:      0,
+		End:        10,
+		Target:     100,
+		Trajectory: Trajectory{{Date: 0, Value: 0}},
+	}
+
+	s := SuggestTarget(g)
+
+	if s.Target != 100 {
+		t.Errorf("target was %f; wanted 100", s.Target)
+	}
+}
@@ -0,0 +1,130 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Template for Firestore serialization/deserialization. Templates are
+// curated by administrators in the top-level templates collection and
+// can be copied into the objectives of any user.
+type Template struct {
+	Name        string                  `firestore:"name,omitempty" json:"name,omitempty"`
+	Description string                  `firestore:"description,omitempty" json:"description,omitempty"`
+	Category    string                  `firestore:"category,omitempty" json:"category,omitempty"`
+	Popularity  int64                   `firestore:"popularity" json:"popularity"`
+	Goals       map[string]TemplateGoal `firestore:"goals,omitempty" json:"goals,omitempty"`
+}
+
+// TemplateGoal for Firestore serialization/deserialization. Unlike a
+// goal, a template goal has no fixed start and end date but a duration
+// that starts counting when the template is instantiated.
+type TemplateGoal struct {
+	Name     string  `firestore:"name,omitempty" json:"name,omitempty"`
+	Unit     string  `firestore:"unit,omitempty" json:"unit,omitempty"`
+	Target   float32 `firestore:"target,omitempty" json:"target,omitempty"`
+	Baseline float32 `firestore:"baseline,omitempty" json:"baseline,omitempty"`
+	Days     int64   `firestore:"days,omitempty" json:"days,omitempty"`
+}
+
+// TemplateEntry is a template together with its document ID, as listed
+// in the catalog.
+type TemplateEntry struct {
+	ID string `json:"id"`
+	Template
+}
+
+// Instantiate creates a new objective from the template. All goals are
+// pledged and start at the given date (in milliseconds since the epoch),
+// with the baseline as the only point on their trajectory.
+func (t Template) Instantiate(now int64) Objective {
+	o := Objective{
+		Name:          t.Name,
+		Description:   t.Description,
+		Goals:         map[string]Goal{},
+		SchemaVersion: LatestSchemaVersion,
+	}
+	for id, tg := range t.Goals {
+		o.Goals[id] = Goal{
+			Name:   tg.Name,
+			Stage:  StagePledged,
+			Start:  now,
+			End:    now + tg.Days*24*60*60*1000,
+			Target: tg.Target,
+			Unit:   tg.Unit,
+			Trajectory: Trajectory{
+				{Date: now, Value: tg.Baseline},
+			},
+		}
+	}
+	return o
+}
+
+// ListTemplates returns the templates of the catalog, most popular
+// first. If category is not empty, only templates of that category are
+// returned.
+func (s Storage) ListTemplates(category string) ([]TemplateEntry, error) {
+	q := s.collection("templates").Query
+	if category != "" {
+		q = q.Where("category", "==", category)
+	}
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListTemplates", func(ctx context.Context) (err error) {
+		docs, err = q.OrderBy("popularity", firestore.Desc).Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing templates: %w", err)
+	}
+	templates := make([]TemplateEntry, 0, len(docs))
+	for _, doc := range docs {
+		var t Template
+		if err := doc.DataTo(&t); err != nil {
+			return nil, fmt.Errorf("Error reading template %q: %w", doc.Ref.ID, err)
+		}
+		templates = append(templates, TemplateEntry{doc.Ref.ID, t})
+	}
+	return templates, nil
+}
+
+// InstantiateTemplate copies the template into a new objective of the
+// user, which gets slugs like those of AddObjective, and counts the
+// instantiation towards the popularity of the template. It returns the
+// ID of the new objective.
+func (s Storage) InstantiateTemplate(userID, templateID string) (string, error) {
+	templateRef := s.collection("templates").Doc(templateID)
+	objectiveRef := s.collection("users").Doc(userID).Collection("objectives").Doc(s.newID())
+	err := s.transaction("InstantiateTemplate", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(templateRef)
+		if err != nil {
+			if doc != nil && !doc.Exists() {
+				return fmt.Errorf("No such template: %q: %w", templateID, ErrNotFound)
+			}
+			return fmt.Errorf("Error reading template: %w", err)
+		}
+		var t Template
+		if err := doc.DataTo(&t); err != nil {
+			return fmt.Errorf("Error reading template: %w", err)
+		}
+		now := time.Now().UnixNano() / 1000 / 1000
+		o := t.Instantiate(now)
+		assignGoalSlugs(&o)
+		o.Slug, err = s.reserveSlug(tx, userID, objectiveRef.ID, o.Name)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(objectiveRef, o); err != nil {
+			return err
+		}
+		return tx.Update(templateRef, []firestore.Update{
+			{Path: "popularity", Value: firestore.Increment(1)},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return objectiveRef.ID, nil
+}
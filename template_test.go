@@ -0,0 +1,45 @@
+package pursuit
+
+import (
+	"testing"
+)
+
+func TestInstantiateTemplate(t *testing.T) {
+	tmpl := Template{
+		Name:        "Marathon",
+		Description: "Run a marathon.",
+		Goals: map[string]TemplateGoal{
+			"distance": {Name: "Distance", Unit: "km", Target: 500, Days: 7},
+		},
+	}
+
+	o := tmpl.Instantiate(1000)
+
+	if o.Name != "Marathon" {
+		t.Errorf("name was %q; wanted %q", o.Name, "Marathon")
+	}
+	g := o.Goals["distance"]
+	if g.Start != 1000 || g.End != 1000+7*24*60*60*1000 {
+		t.Errorf("goal spans [%d, %d]; wanted [1000, %d]", g.Start, g.End, 1000+7*24*60*60*1000)
+	}
+	if g.Stage != "pledged" {
+		t.Errorf("stage was %q; wanted pledged", g.Stage)
+	}
+	if len(g.Trajectory) != 1 || g.Trajectory[0].Value != 0 {
+		t.Errorf("trajectory was %v; wanted a single zero", g.Trajectory)
+	}
+}
+
+func TestInstantiateTemplateBaseline(t *testing.T) {
+	tmpl := Template{
+		Goals: map[string]TemplateGoal{
+			"weight": {Target: 70, Baseline: 80},
+		},
+	}
+
+	o := tmpl.Instantiate(1000)
+
+	if o.Goals["weight"].Trajectory[0].Value != 80 {
+		t.Errorf("baseline was %f; wanted 80", o.Goals["weight"].Trajectory[0].Value)
+	}
+}
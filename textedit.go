@@ -0,0 +1,167 @@
+package pursuit
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// TextOp is a component of a TextEdit. It either keeps the next Retain
+// characters of the text, inserts Insert, or deletes the next Delete
+// characters. Characters are Unicode code points, not bytes or UTF-16
+// code units.
+type TextOp struct {
+	Retain int    `firestore:"retain,omitempty" json:"retain,omitempty"`
+	Insert string `firestore:"insert,omitempty" json:"insert,omitempty"`
+	Delete int    `firestore:"delete,omitempty" json:"delete,omitempty"`
+}
+
+// TextEdit is an operational transform of a text: its ops walk the whole
+// text from start to end. Concurrent edits of the same text are
+// reconciled with transformText.
+type TextEdit []TextOp
+
+// validate checks that each op does exactly one thing.
+func (e TextEdit) validate() error {
+	for i, op := range e {
+		n := 0
+		for _, set := range []bool{op.Retain != 0, op.Insert != "", op.Delete != 0} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 || op.Retain < 0 || op.Delete < 0 {
+			return fmt.Errorf("Op %d must retain, insert or delete: %w", i, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// baseLen returns the length of the texts that the edit applies to.
+func (e TextEdit) baseLen() int {
+	n := 0
+	for _, op := range e {
+		n += op.Retain + op.Delete
+	}
+	return n
+}
+
+// apply returns the text with the edit applied.
+func (e TextEdit) apply(text string) (string, error) {
+	if n := utf8.RuneCountInString(text); e.baseLen() != n {
+		return "", fmt.Errorf("Edit of %d characters does not apply to %d: %w", e.baseLen(), n, ErrInvalidValue)
+	}
+	runes := []rune(text)
+	var out []rune
+	i := 0
+	for _, op := range e {
+		switch {
+		case op.Retain > 0:
+			out = append(out, runes[i:i+op.Retain]...)
+			i += op.Retain
+		case op.Insert != "":
+			out = append(out, []rune(op.Insert)...)
+		default:
+			i += op.Delete
+		}
+	}
+	return string(out), nil
+}
+
+// add appends an op to the edit, merging it into the last op if they do
+// the same thing.
+func (e TextEdit) add(op TextOp) TextEdit {
+	if len(e) > 0 {
+		last := &e[len(e)-1]
+		switch {
+		case op.Retain > 0 && last.Retain > 0:
+			last.Retain += op.Retain
+			return e
+		case op.Insert != "" && last.Insert != "":
+			last.Insert += op.Insert
+			return e
+		case op.Delete > 0 && last.Delete > 0:
+			last.Delete += op.Delete
+			return e
+		}
+	}
+	return append(e, op)
+}
+
+// transformText transforms two concurrent edits a and b of the same text
+// into a2 and b2, so that applying a and then b2 has the same result as
+// applying b and then a2. Where both insert at the same position, the
+// insertion of a comes first.
+func transformText(a, b TextEdit) (TextEdit, TextEdit, error) {
+	if a.baseLen() != b.baseLen() {
+		return nil, nil, fmt.Errorf("Edits of %d and %d characters are not concurrent: %w", a.baseLen(), b.baseLen(), ErrInvalidValue)
+	}
+	a2, b2 := TextEdit{}, TextEdit{}
+	// i and j point to the next ops of a and b, whose remaining parts are
+	// opA and opB.
+	i, j := 0, 0
+	var opA, opB *TextOp
+	next := func(e TextEdit, k *int) *TextOp {
+		if *k >= len(e) {
+			return nil
+		}
+		op := e[*k]
+		*k++
+		return &op
+	}
+	opA, opB = next(a, &i), next(b, &j)
+	for opA != nil || opB != nil {
+		if opA != nil && opA.Insert != "" {
+			a2 = a2.add(*opA)
+			b2 = b2.add(TextOp{Retain: utf8.RuneCountInString(opA.Insert)})
+			opA = next(a, &i)
+			continue
+		}
+		if opB != nil && opB.Insert != "" {
+			a2 = a2.add(TextOp{Retain: utf8.RuneCountInString(opB.Insert)})
+			b2 = b2.add(*opB)
+			opB = next(b, &j)
+			continue
+		}
+		// Both are retains or deletes, since the base lengths are equal.
+		lenA, lenB := opA.Retain+opA.Delete, opB.Retain+opB.Delete
+		m := lenA
+		if lenB < m {
+			m = lenB
+		}
+		switch {
+		case opA.Retain > 0 && opB.Retain > 0:
+			a2 = a2.add(TextOp{Retain: m})
+			b2 = b2.add(TextOp{Retain: m})
+		case opA.Delete > 0 && opB.Retain > 0:
+			a2 = a2.add(TextOp{Delete: m})
+		case opA.Retain > 0 && opB.Delete > 0:
+			b2 = b2.add(TextOp{Delete: m})
+		}
+		// Deletes of the same characters by both cancel out.
+		opA, opB = shorten(opA, m), shorten(opB, m)
+		if opA == nil {
+			opA = next(a, &i)
+		}
+		if opB == nil {
+			opB = next(b, &j)
+		}
+	}
+	return a2, b2, nil
+}
+
+// shorten removes n characters from a retain or delete, and returns nil
+// if none remain.
+func shorten(op *TextOp, n int) *TextOp {
+	if op.Retain > 0 {
+		op.Retain -= n
+		if op.Retain == 0 {
+			return nil
+		}
+		return op
+	}
+	op.Delete -= n
+	if op.Delete == 0 {
+		return nil
+	}
+	return op
+}
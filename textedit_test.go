@@ -0,0 +1,106 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTextEditApply(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		edit TextEdit
+		want string
+	}{
+		{"", TextEdit{}, ""},
+		{"", TextEdit{{Insert: "Run"}}, "Run"},
+		{"Run", TextEdit{{Retain: 3}, {Insert: " more"}}, "Run more"},
+		{"Run more", TextEdit{{Delete: 3}, {Insert: "Walk"}, {Retain: 5}}, "Walk more"},
+		{"Läufe: 5", TextEdit{{Retain: 7}, {Delete: 1}, {Insert: "6"}}, "Läufe: 6"},
+	} {
+		got, err := c.edit.apply(c.text)
+		if err != nil {
+			t.Errorf("%v.apply(%q) failed: %v", c.edit, c.text, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%v.apply(%q) was %q; wanted %q", c.edit, c.text, got, c.want)
+		}
+	}
+	if _, err := (TextEdit{{Retain: 2}}).apply("Run"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("edit of the wrong length was applied: %v", err)
+	}
+}
+
+func TestTextEditValidate(t *testing.T) {
+	if err := (TextEdit{{Retain: 1}, {Insert: "a"}, {Delete: 1}}).validate(); err != nil {
+		t.Errorf("valid edit was rejected: %v", err)
+	}
+	for name, e := range map[string]TextEdit{
+		"empty op":          {{}},
+		"retain and insert": {{Retain: 1, Insert: "a"}},
+		"negative retain":   {{Retain: -1}},
+		"negative delete":   {{Delete: -1}},
+	} {
+		if err := e.validate(); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("edit with %s was accepted: %v", name, err)
+		}
+	}
+}
+
+func TestTransformTextConverges(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		a, b TextEdit
+		want string
+	}{
+		{
+			"Run 5k",
+			TextEdit{{Insert: "Slowly "}, {Retain: 6}},
+			TextEdit{{Retain: 4}, {Delete: 1}, {Insert: "10"}, {Retain: 1}},
+			"Slowly Run 10k",
+		},
+		{
+			// Both insert at the same position, where a comes first.
+			"Run",
+			TextEdit{{Retain: 3}, {Insert: " daily"}},
+			TextEdit{{Retain: 3}, {Insert: " far"}},
+			"Run daily far",
+		},
+		{
+			// Both delete overlapping characters.
+			"Run every day",
+			TextEdit{{Retain: 3}, {Delete: 6}, {Retain: 4}},
+			TextEdit{{Retain: 4}, {Delete: 6}, {Retain: 3}},
+			"Runday",
+		},
+		{
+			"Läufe",
+			TextEdit{{Delete: 5}, {Insert: "Runs"}},
+			TextEdit{{Retain: 1}, {Insert: "ä"}, {Retain: 4}},
+			"äRuns",
+		},
+	} {
+		a2, b2, err := transformText(c.a, c.b)
+		if err != nil {
+			t.Errorf("transformText(%v, %v) failed: %v", c.a, c.b, err)
+			continue
+		}
+		ab := mustApply(t, b2, mustApply(t, c.a, c.text))
+		ba := mustApply(t, a2, mustApply(t, c.b, c.text))
+		if ab != c.want || ba != c.want {
+			t.Errorf("transformText(%v, %v) gave %q and %q; wanted %q", c.a, c.b, ab, ba, c.want)
+		}
+	}
+	if _, _, err := transformText(TextEdit{{Retain: 1}}, TextEdit{{Retain: 2}}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("edits of different texts were transformed: %v", err)
+	}
+}
+
+func mustApply(t *testing.T, e TextEdit, text string) string {
+	t.Helper()
+	s, err := e.apply(text)
+	if err != nil {
+		t.Fatalf("%v.apply(%q) failed: %v", e, text, err)
+	}
+	return s
+}
@@ -0,0 +1,311 @@
+package pursuit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ErrForbidden is wrapped by errors about tokens that do not grant access.
+var ErrForbidden = errors.New("Forbidden")
+
+// Abilities that a share token can grant. Writing implies reading.
+const (
+	AbilityRead  = "read"
+	AbilityWrite = "write"
+)
+
+// API scopes that a share token can grant on the API of its user, below
+// /users/{user}, and on all objectives at once. Unlike abilities, writing
+// values does not imply reading them, so that a leaked logging key does
+// not give away the history of the goals. ScopeAdmin grants everything.
+const (
+	ScopeObjectivesRead     = "objectives:read"
+	ScopeValuesWrite        = "values:write"
+	ScopeIntegrationsManage = "integrations:manage"
+	ScopeAdmin              = "admin"
+)
+
+// Scope grants an ability on an objective, or only on one of its goals if
+// Goal is set.
+type Scope struct {
+	Ability   string `firestore:"ability" json:"ability"`
+	Objective string `firestore:"objective" json:"objective"`
+	Goal      string `firestore:"goal,omitempty" json:"goal,omitempty"`
+}
+
+// ShareToken is a capability to access some objectives of a user without
+// signing in, e.g. for public links or Shortcuts. Tokens are stored in
+// tokens/{id}, where the ID is the SHA-256 hash of the secret, so that the
+// secret itself is only known to whoever created the token.
+type ShareToken struct {
+	User        string  `firestore:"user" json:"user"`
+	Description string  `firestore:"description,omitempty" json:"description,omitempty"`
+	Scopes      []Scope `firestore:"scopes" json:"scopes"`
+	// APIScopes are the API scopes that the token grants, such as
+	// ScopeValuesWrite.
+	APIScopes []string `firestore:"apiScopes,omitempty" json:"apiScopes,omitempty"`
+	// AllowedNetworks restricts the token to requests from these CIDR
+	// ranges, such as 203.0.113.0/24, for automations that run on fixed
+	// infrastructure. Tokens without networks are allowed from anywhere.
+	AllowedNetworks []string `firestore:"allowedNetworks,omitempty" json:"allowedNetworks,omitempty"`
+	// Created and Expires in milliseconds since the epoch. Tokens with a
+	// zero expiry do not expire.
+	Created int64 `firestore:"created" json:"created"`
+	Expires int64 `firestore:"expires,omitempty" json:"expires,omitempty"`
+}
+
+// ShareTokenEntry is a share token together with its ID.
+type ShareTokenEntry struct {
+	ID string `json:"id"`
+	ShareToken
+}
+
+// validate checks that the scopes and networks of the token are
+// well-formed.
+func (t ShareToken) validate() error {
+	if len(t.Scopes) == 0 && len(t.APIScopes) == 0 {
+		return fmt.Errorf("Missing scopes: %w", ErrInvalidValue)
+	}
+	for _, s := range t.APIScopes {
+		if s != ScopeObjectivesRead && s != ScopeValuesWrite && s != ScopeIntegrationsManage && s != ScopeAdmin {
+			return fmt.Errorf("Unknown API scope: %q: %w", s, ErrInvalidValue)
+		}
+	}
+	for _, s := range t.Scopes {
+		if s.Ability != AbilityRead && s.Ability != AbilityWrite {
+			return fmt.Errorf("Unknown ability: %q: %w", s.Ability, ErrInvalidValue)
+		}
+		if s.Objective == "" {
+			return fmt.Errorf("Scope without objective: %w", ErrInvalidValue)
+		}
+	}
+	for _, n := range t.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("Invalid network: %q: %w", n, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// AllowsFrom reports whether the token may be used from the IP address.
+func (t ShareToken) AllowsFrom(ip string) bool {
+	if len(t.AllowedNetworks) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range t.AllowedNetworks {
+		if _, network, err := net.ParseCIDR(n); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the token grants the ability on the goal of the
+// objective at the given date. An empty goal asks for access to the whole
+// objective. ScopeObjectivesRead grants reading and ScopeValuesWrite
+// grants writing all objectives.
+func (t ShareToken) Allows(ability, objectiveID, goalID string, now int64) bool {
+	if t.Expires != 0 && now >= t.Expires {
+		return false
+	}
+	if (ability == AbilityRead && t.AllowsAPI(ScopeObjectivesRead, now)) || (ability == AbilityWrite && t.AllowsAPI(ScopeValuesWrite, now)) {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s.Objective != objectiveID || (s.Goal != "" && s.Goal != goalID) {
+			continue
+		}
+		if s.Ability == ability || s.Ability == AbilityWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAPI reports whether the token grants the API scope at the given
+// date.
+func (t ShareToken) AllowsAPI(scope string, now int64) bool {
+	if t.Expires != 0 && now >= t.Expires {
+		return false
+	}
+	for _, s := range t.APIScopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenID derives the ID under which a token is stored from its secret.
+func tokenID(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateToken stores a new share token of a user and returns its secret,
+// which cannot be recovered later. Secrets of tokens in the sandbox start
+// with test_.
+func (s Storage) CreateToken(userID string, t ShareToken) (string, ShareTokenEntry, error) {
+	t.User = userID
+	t.Created = time.Now().UnixNano() / 1000 / 1000
+	if err := t.validate(); err != nil {
+		return "", ShareTokenEntry{}, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", ShareTokenEntry{}, err
+	}
+	secret := hex.EncodeToString(b)
+	if s.Sandbox() {
+		secret = testTokenPrefix + secret
+	}
+	id := tokenID(secret)
+	ref := s.collection("tokens").Doc(id)
+	err := s.do("CreateToken", func(ctx context.Context) error {
+		_, err := ref.Create(ctx, t)
+		return err
+	})
+	if err != nil {
+		return "", ShareTokenEntry{}, fmt.Errorf("Error creating token: %w", err)
+	}
+	return secret, ShareTokenEntry{id, t}, nil
+}
+
+// ListTokens returns the share tokens of a user, without their secrets.
+func (s Storage) ListTokens(userID string) ([]ShareTokenEntry, error) {
+	q := s.collection("tokens").Where("user", "==", userID)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListTokens", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tokens: %w", err)
+	}
+	tokens := make([]ShareTokenEntry, 0, len(docs))
+	for _, doc := range docs {
+		var t ShareToken
+		if err := doc.DataTo(&t); err != nil {
+			return nil, fmt.Errorf("Error reading token %q: %w", doc.Ref.ID, err)
+		}
+		tokens = append(tokens, ShareTokenEntry{doc.Ref.ID, t})
+	}
+	return tokens, nil
+}
+
+// ListAllTokens returns the share tokens of all users, without their
+// secrets.
+func (s Storage) ListAllTokens() ([]ShareTokenEntry, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("ListAllTokens", func(ctx context.Context) (err error) {
+		docs, err = s.collection("tokens").Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tokens: %w", err)
+	}
+	tokens := make([]ShareTokenEntry, 0, len(docs))
+	for _, doc := range docs {
+		var t ShareToken
+		if err := doc.DataTo(&t); err != nil {
+			return nil, fmt.Errorf("Error reading token %q: %w", doc.Ref.ID, err)
+		}
+		tokens = append(tokens, ShareTokenEntry{doc.Ref.ID, t})
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes a share token of a user.
+func (s Storage) RevokeToken(userID, id string) error {
+	ref := s.collection("tokens").Doc(id)
+	return s.transaction("RevokeToken", func(tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if doc != nil && !doc.Exists() {
+			return fmt.Errorf("No such token: %q: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading token: %w", err)
+		}
+		var t ShareToken
+		if err := doc.DataTo(&t); err != nil {
+			return fmt.Errorf("Error reading token: %w", err)
+		}
+		if t.User != userID {
+			return fmt.Errorf("No such token: %q: %w", id, ErrNotFound)
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Authorize checks that the secret belongs to a share token that grants
+// the ability on the goal of the objective, and returns the user who owns
+// the token. The request must come from the IP address ip, which the
+// networks of the token must allow. The user is also returned if the token
+// exists but does not grant the ability, so that such attempts can be
+// logged for the user.
+func (s Storage) Authorize(secret, ip, ability, objectiveID, goalID string) (string, error) {
+	t, err := s.readToken(secret, ip)
+	if err != nil {
+		return t.User, err
+	}
+	now := time.Now().UnixNano() / 1000 / 1000
+	if !t.Allows(ability, objectiveID, goalID, now) {
+		return t.User, fmt.Errorf("Token does not allow to %s %q: %w", ability, objectiveID, ErrForbidden)
+	}
+	return t.User, nil
+}
+
+// AuthorizeAPI checks that the secret belongs to a share token that grants
+// the API scope, and returns the user who owns the token. As with
+// Authorize, the user is also returned if the token exists but does not
+// grant the scope.
+func (s Storage) AuthorizeAPI(secret, ip, scope string) (string, error) {
+	t, err := s.readToken(secret, ip)
+	if err != nil {
+		return t.User, err
+	}
+	if !t.AllowsAPI(scope, time.Now().UnixNano()/1000/1000) {
+		return t.User, fmt.Errorf("Token does not grant %s: %w", scope, ErrForbidden)
+	}
+	return t.User, nil
+}
+
+// readToken returns the share token with the secret. Tokens that do not
+// allow the IP address are returned together with an error.
+func (s Storage) readToken(secret, ip string) (ShareToken, error) {
+	if err := s.checkTokenEnvironment(secret); err != nil {
+		return ShareToken{}, err
+	}
+	ref := s.collection("tokens").Doc(tokenID(secret))
+	var doc *firestore.DocumentSnapshot
+	err := s.do("Authorize", func(ctx context.Context) (err error) {
+		doc, err = ref.Get(ctx)
+		return err
+	})
+	if doc != nil && !doc.Exists() {
+		return ShareToken{}, fmt.Errorf("Unknown token: %w", ErrForbidden)
+	}
+	if err != nil {
+		return ShareToken{}, fmt.Errorf("Error reading token: %w", err)
+	}
+	var t ShareToken
+	if err := doc.DataTo(&t); err != nil {
+		return ShareToken{}, fmt.Errorf("Error reading token: %w", err)
+	}
+	if !t.AllowsFrom(ip) {
+		return t, fmt.Errorf("Token is not allowed from %s: %w", ip, ErrForbidden)
+	}
+	return t, nil
+}
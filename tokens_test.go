@@ -0,0 +1,146 @@
+package pursuit
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShareTokenAllows(t *testing.T) {
+	token := ShareToken{
+		Scopes: []Scope{
+			{Ability: AbilityRead, Objective: "o1"},
+			{Ability: AbilityWrite, Objective: "o2", Goal: "g"},
+		},
+		Expires: 100,
+	}
+
+	for _, c := range []struct {
+		ability, objective, goal string
+		now                      int64
+		want                     bool
+	}{
+		{AbilityRead, "o1", "", 0, true},
+		{AbilityRead, "o1", "g", 0, true},
+		{AbilityWrite, "o1", "g", 0, false},
+		{AbilityWrite, "o2", "g", 0, true},
+		{AbilityRead, "o2", "g", 0, true},
+		{AbilityWrite, "o2", "h", 0, false},
+		{AbilityRead, "o2", "", 0, false},
+		{AbilityRead, "o3", "", 0, false},
+		{AbilityRead, "o1", "", 100, false},
+	} {
+		if got := token.Allows(c.ability, c.objective, c.goal, c.now); got != c.want {
+			t.Errorf("Allows(%q, %q, %q, %d) was %v; wanted %v", c.ability, c.objective, c.goal, c.now, got, c.want)
+		}
+	}
+}
+
+func TestShareTokenAllowsAPI(t *testing.T) {
+	logging := ShareToken{APIScopes: []string{ScopeValuesWrite}, Expires: 100}
+	admin := ShareToken{APIScopes: []string{ScopeAdmin}}
+
+	if !logging.AllowsAPI(ScopeValuesWrite, 0) || logging.AllowsAPI(ScopeObjectivesRead, 0) || logging.AllowsAPI(ScopeValuesWrite, 100) {
+		t.Errorf("logging token granted the wrong API scopes")
+	}
+	if !logging.Allows(AbilityWrite, "o", "g", 0) || logging.Allows(AbilityRead, "o", "", 0) {
+		t.Errorf("logging token granted the wrong abilities")
+	}
+	if !admin.AllowsAPI(ScopeIntegrationsManage, 0) || !admin.Allows(AbilityRead, "o", "", 0) {
+		t.Errorf("admin token did not grant everything")
+	}
+}
+
+func TestRouteScope(t *testing.T) {
+	for _, c := range []struct {
+		method, path, want string
+	}{
+		{"GET", "/users/u/objectives/o/goals/g", ScopeObjectivesRead},
+		{"GET", "/users/u/export", ScopeObjectivesRead},
+		{"POST", "/users/u/exports", ScopeObjectivesRead},
+		{"PUT", "/users/u/objectives/o/goals/g", ScopeAdmin},
+		{"POST", "/users/u/objectives/o/checkins", ScopeValuesWrite},
+		{"GET", "/users/u/tokens", ScopeAdmin},
+		{"POST", "/users/u/tokens", ScopeAdmin},
+		{"DELETE", "/users/u/strava", ScopeIntegrationsManage},
+		{"GET", "/users/u/objectives/o/webhooks", ScopeIntegrationsManage},
+		{"GET", "/users/u/objectives/o/goals/g/hooks", ScopeIntegrationsManage},
+		{"DELETE", "/users/u/objectives/o/goals/g/hooks/h", ScopeIntegrationsManage},
+		{"POST", "/users/u/merge", ScopeAdmin},
+		{"GET", "/users/u/audit", ScopeAdmin},
+	} {
+		if got := routeScope(c.method, pathParts(c.path)); got != c.want {
+			t.Errorf("scope of %s %s was %q; wanted %q", c.method, c.path, got, c.want)
+		}
+	}
+}
+
+func TestShareTokenWithoutExpiry(t *testing.T) {
+	token := ShareToken{Scopes: []Scope{{Ability: AbilityRead, Objective: "o"}}}
+
+	if !token.Allows(AbilityRead, "o", "", 1<<60) {
+		t.Errorf("token without expiry has expired")
+	}
+}
+
+func TestShareTokenValidate(t *testing.T) {
+	for _, scopes := range [][]Scope{
+		nil,
+		{{Ability: "admin", Objective: "o"}},
+		{{Ability: AbilityRead}},
+	} {
+		err := ShareToken{Scopes: scopes}.validate()
+		if !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("validate of %+v was %v; wanted ErrInvalidValue", scopes, err)
+		}
+	}
+	if err := (ShareToken{APIScopes: []string{"values:delete"}}).validate(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("unknown API scope was accepted: %v", err)
+	}
+	if err := (ShareToken{APIScopes: []string{ScopeValuesWrite}}).validate(); err != nil {
+		t.Errorf("token with only API scopes was rejected: %v", err)
+	}
+}
+
+func TestTokenIDIsStable(t *testing.T) {
+	if tokenID("secret") != tokenID("secret") || tokenID("secret") == tokenID("other") {
+		t.Errorf("token IDs are not derived from secrets")
+	}
+	if tokenID("secret") == "secret" {
+		t.Errorf("token ID is the secret")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if got := bearerToken(r); got != "" {
+		t.Errorf("token was %q; wanted none", got)
+	}
+	r.Header.Set("Authorization", "Bearer abc")
+	if got := bearerToken(r); got != "abc" {
+		t.Errorf("token was %q; wanted %q", got, "abc")
+	}
+}
+
+func TestShareTokenAllowsFrom(t *testing.T) {
+	token := ShareToken{AllowedNetworks: []string{"203.0.113.0/24", "2001:db8::/32"}}
+	for _, c := range []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.7", true},
+		{"2001:db8::1", true},
+		{"198.51.100.7", false},
+		{"", false},
+	} {
+		if got := token.AllowsFrom(c.ip); got != c.want {
+			t.Errorf("AllowsFrom(%q) was %v; wanted %v", c.ip, got, c.want)
+		}
+	}
+	if !(ShareToken{}).AllowsFrom("198.51.100.7") {
+		t.Errorf("token without networks was not allowed from anywhere")
+	}
+	if err := (ShareToken{APIScopes: []string{ScopeAdmin}, AllowedNetworks: []string{"203.0.113.7"}}).validate(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("validate accepted an address without prefix length: %v", err)
+	}
+}
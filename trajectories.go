@@ -0,0 +1,175 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// UseTrajectorySubcollection stores new values of goals as documents in
+// the subcollection users/{user}/objectives/{objective}/goals/{goal}/trajectory
+// instead of inline in the objective, which keeps objectives far from the
+// size limit of Firestore documents and makes writing a value append a
+// small document instead of rewriting the whole trajectory. Values that
+// are already inline stay there and are read along with the
+// subcollection, so the mode can be turned on for existing data. It must
+// be called before the storage is used.
+//
+// In this mode, trajectories only change through the value endpoints:
+// UpdateObjective keeps the stored trajectories, and field masks that
+// touch a trajectory are rejected.
+func (s *Storage) UseTrajectorySubcollection() {
+	s.trajectories = true
+}
+
+// trajectoryRef returns the subcollection with the values of a goal.
+func (s Storage) trajectoryRef(userID, objectiveID, goalID string) *firestore.CollectionRef {
+	return s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID).
+		Collection("goals").Doc(goalID).Collection("trajectory")
+}
+
+// readTrajectory reads the values of a goal in the subcollection within
+// a transaction, oldest first.
+func (s Storage) readTrajectory(tx *firestore.Transaction, userID, objectiveID, goalID string) (Trajectory, error) {
+	q := s.trajectoryRef(userID, objectiveID, goalID).OrderBy("date", firestore.Asc)
+	docs, err := tx.Documents(q).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading trajectory: %w", err)
+	}
+	t := make(Trajectory, 0, len(docs))
+	for _, doc := range docs {
+		var v DateValue
+		if err := doc.DataTo(&v); err != nil {
+			return nil, fmt.Errorf("Error reading trajectory: %w", err)
+		}
+		t = append(t, v)
+	}
+	return t, nil
+}
+
+// ReadTrajectory returns the values of a goal of a user with dates in
+// [from, to), in milliseconds since the epoch, oldest first. A to of 0
+// reads up to the latest value. Only the values in the range are read
+// from the subcollection.
+func (s Storage) ReadTrajectory(userID, objectiveID, goalID string, from, to int64) (Trajectory, error) {
+	o, err := s.getObjective(userID, objectiveID)
+	if err != nil {
+		return nil, err
+	}
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return nil, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	var t Trajectory
+	for _, v := range g.Trajectory {
+		if v.Date >= from && (to == 0 || v.Date < to) {
+			t = append(t, v)
+		}
+	}
+	if !s.trajectories {
+		return t, nil
+	}
+	points, _, err := s.readTrajectoryRange(userID, objectiveID, goalID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(t, points...), nil
+}
+
+// readTrajectoryRange reads the values of a goal in the subcollection
+// with dates in [from, to), oldest first. A to of 0 has no upper bound.
+// It also returns when the latest of the values was written.
+func (s Storage) readTrajectoryRange(userID, objectiveID, goalID string, from, to int64) (Trajectory, time.Time, error) {
+	q := s.trajectoryRef(userID, objectiveID, goalID).Where("date", ">=", from)
+	if to != 0 {
+		q = q.Where("date", "<", to)
+	}
+	q = q.OrderBy("date", firestore.Asc)
+	var docs []*firestore.DocumentSnapshot
+	err := s.do("readTrajectory", func(ctx context.Context) (err error) {
+		docs, err = q.Documents(ctx).GetAll()
+		return err
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("Error reading trajectory: %w", err)
+	}
+	t := make(Trajectory, 0, len(docs))
+	var written time.Time
+	for _, doc := range docs {
+		var v DateValue
+		if err := doc.DataTo(&v); err != nil {
+			return nil, time.Time{}, fmt.Errorf("Error reading trajectory: %w", err)
+		}
+		t = append(t, v)
+		if doc.UpdateTime.After(written) {
+			written = doc.UpdateTime
+		}
+	}
+	return t, written, nil
+}
+
+// loadTrajectories appends the values in the subcollections to the inline
+// trajectories of the goals of an objective, if the storage keeps
+// trajectories in subcollections. It returns when the latest of the
+// values was written, which is zero if there are none.
+func (s Storage) loadTrajectories(userID, objectiveID string, o *Objective) (time.Time, error) {
+	var latest time.Time
+	if !s.trajectories {
+		return latest, nil
+	}
+	for id, g := range o.Goals {
+		t, written, err := s.readTrajectoryRange(userID, objectiveID, id, 0, 0)
+		if err != nil {
+			return latest, err
+		}
+		g.Trajectory = append(g.Trajectory, t...)
+		o.Goals[id] = g
+		if written.After(latest) {
+			latest = written
+		}
+	}
+	return latest, nil
+}
+
+// keepInlineTrajectories replaces the trajectories of the goals of o with
+// those stored in the objective, so that writing o does not copy values
+// from the subcollections inline. Goals that are new have no trajectory.
+func keepInlineTrajectories(o *Objective, stored Objective) {
+	for id, g := range o.Goals {
+		g.Trajectory = stored.Goals[id].Trajectory
+		o.Goals[id] = g
+	}
+}
+
+// checkTrajectoryMask rejects field masks that touch a trajectory if the
+// storage keeps trajectories in subcollections.
+func (s Storage) checkTrajectoryMask(mask FieldMask) error {
+	if !s.trajectories {
+		return nil
+	}
+	for _, path := range mask {
+		parts := strings.Split(path, ".")
+		if path == "goals" || (len(parts) == 2 && parts[0] == "goals") || (len(parts) > 2 && parts[2] == "trajectory") {
+			return fmt.Errorf("Field %q holds a trajectory, which only changes through its values: %w", path, ErrInvalidValue)
+		}
+	}
+	return nil
+}
+
+// deleteTrajectories deletes the subcollections with the values of the
+// goals of an objective, or of a single goal if goalID is not empty.
+// Firestore does not delete subcollections along with their parent.
+func (s Storage) deleteTrajectories(userID, objectiveID, goalID string) error {
+	if !s.trajectories {
+		return nil
+	}
+	ref := s.collection("users").Doc(userID).Collection("objectives").Doc(objectiveID)
+	if goalID != "" {
+		ref = ref.Collection("goals").Doc(goalID)
+	}
+	_, err := s.purge(ref)
+	return err
+}
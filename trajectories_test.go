@@ -0,0 +1,45 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTrajectoryMask(t *testing.T) {
+	s := Storage{trajectories: true}
+	for _, path := range []string{"goals", "goals.run", "goals.run.trajectory"} {
+		if err := s.checkTrajectoryMask(FieldMask{path}); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("mask %q: error was %v; wanted ErrInvalidValue", path, err)
+		}
+	}
+	for _, path := range []string{"name", "goals.run.target", "goals.run.mute.until"} {
+		if err := s.checkTrajectoryMask(FieldMask{path}); err != nil {
+			t.Errorf("mask %q was rejected: %v", path, err)
+		}
+	}
+	if err := (Storage{}).checkTrajectoryMask(FieldMask{"goals.run.trajectory"}); err != nil {
+		t.Errorf("mask was rejected with inline trajectories: %v", err)
+	}
+}
+
+func TestKeepInlineTrajectories(t *testing.T) {
+	stored := Objective{Goals: map[string]Goal{
+		"run": {Trajectory: Trajectory{{Date: 1, Value: 2}}},
+	}}
+	o := Objective{Goals: map[string]Goal{
+		"run":  {Name: "Run", Trajectory: Trajectory{{Date: 1, Value: 2}, {Date: 3, Value: 4}}},
+		"swim": {Name: "Swim", Trajectory: Trajectory{{Date: 5, Value: 6}}},
+	}}
+
+	keepInlineTrajectories(&o, stored)
+
+	if tr := o.Goals["run"].Trajectory; len(tr) != 1 || tr[0].Date != 1 {
+		t.Errorf("trajectory of run was %+v; wanted the stored one", tr)
+	}
+	if tr := o.Goals["swim"].Trajectory; tr != nil {
+		t.Errorf("trajectory of new goal swim was %+v; wanted none", tr)
+	}
+	if o.Goals["run"].Name != "Run" {
+		t.Errorf("name of run was %q; wanted Run", o.Goals["run"].Name)
+	}
+}
@@ -0,0 +1,107 @@
+package pursuit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unit is a known unit of measurement. Values in units of the same
+// dimension are converted by the ratio of their factors.
+type unit struct {
+	dimension string
+	factor    float64
+}
+
+// units maps lower-case unit symbols and names to known units. Only units
+// that are related by a constant factor are listed, so that increments can
+// be converted just like absolute values.
+var units = map[string]unit{
+	"m":          {"length", 1},
+	"meter":      {"length", 1},
+	"meters":     {"length", 1},
+	"metre":      {"length", 1},
+	"metres":     {"length", 1},
+	"km":         {"length", 1000},
+	"kilometer":  {"length", 1000},
+	"kilometers": {"length", 1000},
+	"kilometre":  {"length", 1000},
+	"kilometres": {"length", 1000},
+	"mi":         {"length", 1609.344},
+	"mile":       {"length", 1609.344},
+	"miles":      {"length", 1609.344},
+	"ft":         {"length", 0.3048},
+	"feet":       {"length", 0.3048},
+	"yd":         {"length", 0.9144},
+	"yards":      {"length", 0.9144},
+	"s":          {"time", 1},
+	"sec":        {"time", 1},
+	"seconds":    {"time", 1},
+	"min":        {"time", 60},
+	"minutes":    {"time", 60},
+	"h":          {"time", 3600},
+	"hours":      {"time", 3600},
+	"g":          {"mass", 1},
+	"grams":      {"mass", 1},
+	"kg":         {"mass", 1000},
+	"lb":         {"mass", 453.59237},
+	"lbs":        {"mass", 453.59237},
+	"pounds":     {"mass", 453.59237},
+	"kcal":       {"energy", 1},
+	"cal":        {"energy", 1},
+	"kj":         {"energy", 1 / 4.184},
+}
+
+// conversionFactor returns the factor by which values in unit from are
+// multiplied to get values in unit to.
+func conversionFactor(from, to string) (float32, error) {
+	from = strings.ToLower(strings.TrimSpace(from))
+	to = strings.ToLower(strings.TrimSpace(to))
+	if from == to {
+		return 1, nil
+	}
+	f, fok := units[from]
+	t, tok := units[to]
+	if !fok || !tok || f.dimension != t.dimension {
+		return 0, fmt.Errorf("Cannot convert %q to %q: %w", from, to, ErrInvalidValue)
+	}
+	return float32(f.factor / t.factor), nil
+}
+
+// ConvertValue converts a value sent by an integration into the unit of
+// the goal. A goal without a unit adopts the unit of the first value that
+// has one. The unit and its conversion factor are remembered, so that
+// later values without a unit, e.g. raw sensor readings, are converted in
+// the same way.
+func (g *Goal) ConvertValue(value float32, unit string) (float32, error) {
+	if unit == "" {
+		unit = g.InputUnit
+	}
+	if unit == "" {
+		return value, nil
+	}
+	if g.Unit == "" {
+		g.Unit = unit
+	}
+	factor, err := conversionFactor(unit, g.Unit)
+	if err != nil {
+		return 0, err
+	}
+	g.InputUnit = unit
+	g.InputScale = factor
+	return value * factor, nil
+}
+
+// ConvertGoalValue converts a value or a delta into the unit of the goal,
+// see Goal.ConvertValue.
+func (o *Objective) ConvertGoalValue(goalID string, value float32, unit string) (float32, error) {
+	g, ok := o.Goals[goalID]
+	if !ok {
+		return 0, fmt.Errorf("No such goal: %q: %w", goalID, ErrNotFound)
+	}
+	value, err := g.ConvertValue(value, unit)
+	if err != nil {
+		return 0, err
+	}
+	o.Goals[goalID] = g
+	return value, nil
+}
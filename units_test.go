@@ -0,0 +1,81 @@
+package pursuit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertValueInfersUnit(t *testing.T) {
+	g := Goal{}
+
+	value, err := g.ConvertValue(5000, "m")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 5000 {
+		t.Errorf("value was %f; wanted 5000", value)
+	}
+	if g.Unit != "m" {
+		t.Errorf("unit was %q; wanted \"m\"", g.Unit)
+	}
+}
+
+func TestConvertValueRemembersInputUnit(t *testing.T) {
+	g := Goal{Unit: "km"}
+
+	value, err := g.ConvertValue(5000, "m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 5 {
+		t.Errorf("value was %f; wanted 5", value)
+	}
+
+	value, err = g.ConvertValue(2500, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 2.5 {
+		t.Errorf("raw value was %f; wanted 2.5", value)
+	}
+	if g.InputUnit != "m" || g.InputScale != 0.001 {
+		t.Errorf("input unit was %q with scale %f; wanted \"m\" with scale 0.001", g.InputUnit, g.InputScale)
+	}
+}
+
+func TestConvertValueWithoutUnit(t *testing.T) {
+	g := Goal{Unit: "pages"}
+
+	value, err := g.ConvertValue(12, "")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 12 {
+		t.Errorf("value was %f; wanted 12", value)
+	}
+	if g.InputUnit != "" {
+		t.Errorf("input unit was %q; wanted none", g.InputUnit)
+	}
+}
+
+func TestConvertValueIncompatibleUnits(t *testing.T) {
+	g := Goal{Unit: "km"}
+
+	_, err := g.ConvertValue(60, "min")
+
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got error %v; wanted ErrInvalidValue", err)
+	}
+}
+
+func TestConvertGoalValueNotExists(t *testing.T) {
+	o := Objective{Goals: map[string]Goal{}}
+
+	_, err := o.ConvertGoalValue("abc", 1, "m")
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v; wanted ErrNotFound", err)
+	}
+}
@@ -0,0 +1,141 @@
+package pursuit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// UserDataVersion is the version of the format of UserData. It is bumped
+// whenever the format changes, so that older documents can still be
+// imported.
+const UserDataVersion = 1
+
+// UserData is a backup of all objectives of a user, including their goals
+// and trajectories, that can be imported into this or another account.
+type UserData struct {
+	Version int `json:"version"`
+	// Exported in milliseconds since the epoch.
+	Exported   int64            `json:"exported"`
+	Objectives []ObjectiveEntry `json:"objectives"`
+}
+
+// ImportReport describes what importing user data changed.
+type ImportReport struct {
+	// Created and Replaced are the IDs of the objectives that were
+	// imported, sorted.
+	Created  []string `json:"created"`
+	Replaced []string `json:"replaced"`
+}
+
+// ExportUser returns all objectives of a user as user data.
+func (s Storage) ExportUser(userID string) (UserData, error) {
+	objectives, err := s.ListObjectives(userID)
+	if err != nil {
+		return UserData{}, err
+	}
+	return newUserData(objectives, time.Now().UnixNano()/1000/1000), nil
+}
+
+func newUserData(objectives []ObjectiveEntry, now int64) UserData {
+	return UserData{Version: UserDataVersion, Exported: now, Objectives: objectives}
+}
+
+// validate checks that the user data can be imported: its version is
+// known, and its objectives have distinct IDs and are valid.
+func (d UserData) validate() error {
+	if d.Version < 1 || d.Version > UserDataVersion {
+		return fmt.Errorf("Unknown user data version %d, wanted 1 to %d: %w", d.Version, UserDataVersion, ErrInvalidValue)
+	}
+	ids := map[string]bool{}
+	for _, o := range d.Objectives {
+		if o.ID == "" {
+			return fmt.Errorf("Missing objective ID: %w", ErrInvalidValue)
+		}
+		if ids[o.ID] {
+			return fmt.Errorf("Duplicate objective %q: %w", o.ID, ErrInvalidValue)
+		}
+		ids[o.ID] = true
+		if err := validateObjective(o.Objective); err != nil {
+			return fmt.Errorf("Objective %q: %w", o.ID, err)
+		}
+	}
+	return nil
+}
+
+// ImportUser restores objectives of a user from user data, each under its
+// exported ID. Objectives that exist already are replaced if overwrite is
+// true, and otherwise fail the import before anything is written.
+// Objectives of the user that are not in the data are left alone. Slugs
+// are kept unless another objective of the user has taken them, in which
+// case a new one is assigned.
+func (s Storage) ImportUser(userID string, d UserData, overwrite bool) (ImportReport, error) {
+	report := ImportReport{Created: []string{}, Replaced: []string{}}
+	if err := d.validate(); err != nil {
+		return report, err
+	}
+	objectives := s.collection("users").Doc(userID).Collection("objectives")
+	refs := make([]*firestore.DocumentRef, len(d.Objectives))
+	for i, o := range d.Objectives {
+		refs[i] = objectives.Doc(o.ID)
+	}
+	var existing []*firestore.DocumentSnapshot
+	err := s.do("ImportUser", func(ctx context.Context) (err error) {
+		existing, err = s.client.GetAll(ctx, refs)
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("Error reading objectives: %w", err)
+	}
+	for i, doc := range existing {
+		if doc.Exists() && !overwrite {
+			return report, fmt.Errorf("Objective %q: %w", d.Objectives[i].ID, ErrAlreadyExists)
+		}
+	}
+
+	for i, e := range d.Objectives {
+		o := e.Objective
+		o.SchemaVersion = LatestSchemaVersion
+		var replaced *Objective
+		err := s.transaction("ImportUser", func(tx *firestore.Transaction) error {
+			replaced = nil
+			doc, err := tx.Get(refs[i])
+			if doc == nil || doc.Exists() {
+				if err != nil {
+					return fmt.Errorf("Error reading objective: %w", err)
+				}
+				replaced = &Objective{}
+				if err := doc.DataTo(replaced); err != nil {
+					return fmt.Errorf("Error reading objective: %w", err)
+				}
+			}
+			if e.Slug != "" && (replaced == nil || replaced.Slug != e.Slug) {
+				if o.Slug, err = s.reserveSlug(tx, userID, e.ID, e.Slug); err != nil {
+					return err
+				}
+			}
+			return tx.Set(refs[i], o)
+		})
+		if err != nil {
+			return report, fmt.Errorf("Error importing objective %q: %w", e.ID, err)
+		}
+		if replaced == nil {
+			report.Created = append(report.Created, e.ID)
+			continue
+		}
+		// Values in the trajectory subcollections of the replaced
+		// objective would be appended to the imported trajectories.
+		for goalID := range replaced.Goals {
+			if err := s.deleteTrajectories(userID, e.ID, goalID); err != nil {
+				return report, err
+			}
+		}
+		report.Replaced = append(report.Replaced, e.ID)
+	}
+	sort.Strings(report.Created)
+	sort.Strings(report.Replaced)
+	return report, nil
+}
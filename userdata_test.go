@@ -0,0 +1,55 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUserDataValidate(t *testing.T) {
+	fitness := ObjectiveEntry{"fitness", Objective{Name: "Fitness", Goals: map[string]Goal{"run": {Name: "Run", Target: 100}}}}
+	if err := newUserData([]ObjectiveEntry{fitness}, 0).validate(); err != nil {
+		t.Error(err)
+	}
+	for name, d := range map[string]UserData{
+		"future version":      {Version: UserDataVersion + 1},
+		"missing version":     {},
+		"missing ID":          {Version: 1, Objectives: []ObjectiveEntry{{"", fitness.Objective}}},
+		"duplicate objective": {Version: 1, Objectives: []ObjectiveEntry{fitness, fitness}},
+		"invalid objective":   {Version: 1, Objectives: []ObjectiveEntry{{"reading", Objective{}}}},
+	} {
+		if err := d.validate(); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("%s was accepted: %v", name, err)
+		}
+	}
+}
+
+func TestExportUserHandler(t *testing.T) {
+	s, goals := newMemoryServer()
+	goals.PutObjective("alice", "fitness", Objective{Name: "Fitness", Goals: map[string]Goal{
+		"run": {Name: "Run", Target: 100, Unit: "km", Trajectory: Trajectory{{Date: 1, Value: 5}}},
+	}})
+	r := httptest.NewRequest(http.MethodGet, "/users/alice/export", nil)
+	r.Header.Set("Authorization", "Bearer a.alice.c")
+	w := httptest.NewRecorder()
+
+	s.users(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status was %d: %s", w.Code, w.Body)
+	}
+	var d UserData
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.validate(); err != nil {
+		t.Errorf("export could not be imported: %v", err)
+	}
+	want, _ := goals.readObjective("alice", "fitness")
+	if d.Version != UserDataVersion || len(d.Objectives) != 1 || d.Objectives[0].ID != "fitness" || !reflect.DeepEqual(d.Objectives[0].Objective, want) {
+		t.Errorf("export was %+v", d)
+	}
+}
@@ -0,0 +1,118 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// FieldError describes a field of a request that is missing or invalid.
+// Fields of the body are named as in JSON, and query parameters by their
+// names.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists all fields of a request that are missing or
+// invalid. It wraps ErrInvalidValue, and writeError replies with the
+// fields, so that clients can point at each of them.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "Invalid request: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidValue
+}
+
+// validation collects the invalid fields of a request, so that they are
+// reported together rather than one per request.
+type validation struct {
+	fields []FieldError
+}
+
+// require reports the field as missing if its value is empty.
+func (v *validation) require(field, value string) {
+	if value == "" {
+		v.invalid(field, "missing")
+	}
+}
+
+// check reports the field as invalid with the message unless ok.
+func (v *validation) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.invalid(field, fmt.Sprintf(format, args...))
+	}
+}
+
+// invalid reports the field as invalid with the message.
+func (v *validation) invalid(field, message string) {
+	v.fields = append(v.fields, FieldError{field, message})
+}
+
+// err returns a *ValidationError with the invalid fields, or nil if there
+// are none.
+func (v *validation) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{v.fields}
+}
+
+// invalidField returns a *ValidationError about a single field.
+func invalidField(field, format string, args ...interface{}) error {
+	var v validation
+	v.invalid(field, fmt.Sprintf(format, args...))
+	return v.err()
+}
+
+// decodeJSON decodes the JSON body of a request into v. Values of the
+// wrong type are reported as a *ValidationError naming their field.
+func decodeJSON(body io.Reader, v interface{}) error {
+	err := json.NewDecoder(body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return invalidField(jsonFieldName(v, typeErr.Field), "wanted %s, got %s", typeErr.Type, typeErr.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid JSON: %v: %w", err, ErrInvalidValue)
+	}
+	return nil
+}
+
+// jsonFieldName returns the name in JSON of the top-level field of the
+// struct that v points to, given a key that matched it. Keys match fields
+// regardless of case, so that errors name the field the same way whatever
+// case the request used.
+func jsonFieldName(v interface{}, key string) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || strings.Contains(key, ".") {
+		return key
+	}
+	t = t.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return key
+}
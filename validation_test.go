@@ -0,0 +1,55 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidationErrorResponse(t *testing.T) {
+	s, _ := newMemoryServer()
+	for body, want := range map[string][]FieldError{
+		`{"value": 5, "maxAge": -1}`:                            {{"objective", "missing"}, {"goal", "missing"}, {"maxAge", "negative"}},
+		`{"objective": "fitness", "goal": "run", "value": "5"}`: {{"value", "wanted float32, got string"}},
+		`{"Objective": 1}`:                                      {{"objective", "wanted string, got number"}},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/setgoalvalue", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer a.alice.c")
+		w := httptest.NewRecorder()
+
+		s.setGoalValue(w, r)
+
+		var resp struct {
+			Error  string
+			Fields []FieldError
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusBadRequest || resp.Error == "" || len(resp.Fields) != len(want) {
+			t.Fatalf("%s: response was %d %+v; wanted %+v", body, w.Code, resp, want)
+		}
+		for i, f := range want {
+			if resp.Fields[i] != f {
+				t.Errorf("%s: field %d was %+v; wanted %+v", body, i, resp.Fields[i], f)
+			}
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	var v validation
+	if err := v.err(); err != nil {
+		t.Errorf("error was %v; wanted none", err)
+	}
+	v.require("name", "")
+	v.require("unit", "km")
+	v.check(false, "target", "wanted at least %d", 1)
+	err := v.err()
+	if !errors.Is(err, ErrInvalidValue) || err.Error() != "Invalid request: name: missing; target: wanted at least 1" {
+		t.Errorf("error was %v", err)
+	}
+}
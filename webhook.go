@@ -0,0 +1,261 @@
+package pursuit
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookVerifier checks that an inbound webhook request was sent by the
+// integration it claims to come from, usually by checking a signature
+// over the body. Errors wrap ErrForbidden.
+type WebhookVerifier interface {
+	Verify(r *http.Request, body []byte, now time.Time) error
+}
+
+// webhookWindow is how far the timestamp of a signed webhook request may
+// be from now. Signatures are remembered for that long, so that a request
+// cannot be replayed within the window either.
+const webhookWindow = 5 * time.Minute
+
+// maxWebhookBody limits the size of webhook requests.
+const maxWebhookBody = 1 << 20
+
+var webhookVerifiers = struct {
+	sync.Mutex
+	m map[string]WebhookVerifier
+}{m: map[string]WebhookVerifier{}}
+
+// RegisterWebhookVerifier makes VerifyWebhook check requests of the
+// integration with the verifier. It panics if the integration is
+// registered twice.
+func RegisterWebhookVerifier(integration string, v WebhookVerifier) {
+	webhookVerifiers.Lock()
+	defer webhookVerifiers.Unlock()
+	if _, ok := webhookVerifiers.m[integration]; ok {
+		panic("pursuit: webhook verifier registered twice: " + integration)
+	}
+	webhookVerifiers.m[integration] = v
+}
+
+// VerifyWebhook reads the body of an inbound webhook request of the
+// integration and returns it if the request is authentic. Requests of
+// integrations without a registered verifier are rejected.
+func VerifyWebhook(integration string, r *http.Request) ([]byte, error) {
+	webhookVerifiers.Lock()
+	v, ok := webhookVerifiers.m[integration]
+	webhookVerifiers.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No webhook verifier for %q: %w", integration, ErrForbidden)
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading webhook: %v: %w", err, ErrInvalidValue)
+	}
+	if err := v.Verify(r, body, time.Now()); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// replayGuard rejects signatures that were already seen within the
+// webhook window.
+type replayGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// check verifies that the timestamp is within the window and that the
+// signature was not seen before, and remembers it.
+func (g *replayGuard) check(signature string, ts, now time.Time) error {
+	if ts.Before(now.Add(-webhookWindow)) || ts.After(now.Add(webhookWindow)) {
+		return fmt.Errorf("Webhook timestamp %v is outside of the replay window: %w", ts, ErrForbidden)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen == nil {
+		g.seen = map[string]time.Time{}
+	}
+	for sig, expires := range g.seen {
+		if now.After(expires) {
+			delete(g.seen, sig)
+		}
+	}
+	if _, ok := g.seen[signature]; ok {
+		return fmt.Errorf("Webhook was replayed: %w", ErrForbidden)
+	}
+	g.seen[signature] = ts.Add(webhookWindow)
+	return nil
+}
+
+// unixTimestamp parses a timestamp header in seconds since the epoch.
+func unixTimestamp(header string) (time.Time, error) {
+	secs, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid webhook timestamp %q: %w", header, ErrForbidden)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// SlackVerifier checks the signature of Slack requests, see
+// https://api.slack.com/authentication/verifying-requests-from-slack.
+type SlackVerifier struct {
+	SigningSecret string
+	replays       replayGuard
+}
+
+func (v *SlackVerifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	header := r.Header.Get("X-Slack-Request-Timestamp")
+	ts, err := unixTimestamp(header)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(v.SigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", header, body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	got := r.Header.Get("X-Slack-Signature")
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		return fmt.Errorf("Invalid Slack signature: %w", ErrForbidden)
+	}
+	return v.replays.check(got, ts, now)
+}
+
+// TelegramVerifier checks the secret token that Telegram sends with
+// updates, see https://core.telegram.org/bots/api#setwebhook. Telegram
+// does not sign or timestamp updates, so replays are not detected.
+type TelegramVerifier struct {
+	SecretToken string
+}
+
+func (v *TelegramVerifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if v.SecretToken == "" || subtle.ConstantTimeCompare([]byte(got), []byte(v.SecretToken)) != 1 {
+		return fmt.Errorf("Invalid Telegram secret token: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// TwilioVerifier checks the signature of Twilio requests, see
+// https://www.twilio.com/docs/usage/security. Signatures cover the URL
+// that Twilio called, which is BaseURL followed by the path and query of
+// the request. Twilio does not timestamp requests, so replays are not
+// detected.
+type TwilioVerifier struct {
+	AuthToken string
+	// BaseURL is the public URL of the server, e.g. https://example.com.
+	BaseURL string
+}
+
+func (v *TwilioVerifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	data := strings.TrimRight(v.BaseURL, "/") + r.URL.RequestURI()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		params, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Errorf("Invalid Twilio request: %v: %w", err, ErrForbidden)
+		}
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, value := range params[k] {
+				data += k + value
+			}
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(v.AuthToken))
+	mac.Write([]byte(data))
+	want := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Twilio-Signature")), []byte(want)) {
+		return fmt.Errorf("Invalid Twilio signature: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// SendGridVerifier checks the signature of SendGrid event webhooks, see
+// https://docs.sendgrid.com/for-developers/tracking-events/getting-started-event-webhook-security-features.
+type SendGridVerifier struct {
+	// PublicKey is the verification key shown by SendGrid, in base64.
+	PublicKey string
+	replays   replayGuard
+}
+
+func (v *SendGridVerifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	der, err := base64.StdEncoding.DecodeString(v.PublicKey)
+	if err != nil {
+		return fmt.Errorf("Invalid SendGrid public key: %v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("Invalid SendGrid public key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("Invalid SendGrid public key: not an ECDSA key")
+	}
+	header := r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	ts, err := unixTimestamp(header)
+	if err != nil {
+		return err
+	}
+	signature := r.Header.Get("X-Twilio-Email-Event-Webhook-Signature")
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("Invalid SendGrid signature: %w", ErrForbidden)
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return fmt.Errorf("Invalid SendGrid signature: %w", ErrForbidden)
+	}
+	hash := sha256.Sum256(append([]byte(header), body...))
+	if !ecdsa.Verify(key, hash[:], rs.R, rs.S) {
+		return fmt.Errorf("Invalid SendGrid signature: %w", ErrForbidden)
+	}
+	return v.replays.check(signature, ts, now)
+}
+
+// StravaVerifier checks Strava webhook requests, see
+// https://developers.strava.com/docs/webhooks/. Strava does not sign
+// events, so only events of the subscription are accepted, and the
+// subscription handshake must present the verify token.
+type StravaVerifier struct {
+	VerifyToken    string
+	SubscriptionID int64
+}
+
+func (v *StravaVerifier) Verify(r *http.Request, body []byte, now time.Time) error {
+	if r.Method == http.MethodGet {
+		got := r.URL.Query().Get("hub.verify_token")
+		if v.VerifyToken == "" || subtle.ConstantTimeCompare([]byte(got), []byte(v.VerifyToken)) != 1 {
+			return fmt.Errorf("Invalid Strava verify token: %w", ErrForbidden)
+		}
+		return nil
+	}
+	var event struct {
+		SubscriptionID int64 `json:"subscription_id"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil || event.SubscriptionID != v.SubscriptionID {
+		return fmt.Errorf("Strava event of unknown subscription: %w", ErrForbidden)
+	}
+	return nil
+}
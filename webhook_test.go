@@ -0,0 +1,143 @@
+package pursuit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackVerifier(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	v := &SlackVerifier{SigningSecret: "secret"}
+	body := "token=x&command=/pursuit"
+	verify := func(ts time.Time, secret string) error {
+		r := httptest.NewRequest("POST", "/webhooks/slack", strings.NewReader(body))
+		header := strconv.FormatInt(ts.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("v0:" + header + ":" + body))
+		r.Header.Set("X-Slack-Request-Timestamp", header)
+		r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+		return v.Verify(r, []byte(body), now)
+	}
+
+	if err := verify(now, "secret"); err != nil {
+		t.Errorf("valid request was rejected: %v", err)
+	}
+	if err := verify(now, "secret"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("replayed request was not rejected: %v", err)
+	}
+	if err := verify(now.Add(-10*time.Minute), "secret"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("old request was not rejected: %v", err)
+	}
+	if err := verify(now.Add(time.Second), "wrong"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("request with wrong signature was not rejected: %v", err)
+	}
+}
+
+func TestTelegramVerifier(t *testing.T) {
+	v := &TelegramVerifier{SecretToken: "secret"}
+	r := httptest.NewRequest("POST", "/webhooks/telegram", nil)
+
+	if err := v.Verify(r, nil, time.Now()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("request without token was not rejected: %v", err)
+	}
+	r.Header.Set("X-Telegram-Bot-Api-Secret-Token", "secret")
+	if err := v.Verify(r, nil, time.Now()); err != nil {
+		t.Errorf("valid request was rejected: %v", err)
+	}
+}
+
+func TestTwilioVerifier(t *testing.T) {
+	v := &TwilioVerifier{AuthToken: "token", BaseURL: "https://example.com/"}
+	body := "To=%2B18005551212&Body=ran+5km&From=%2B12349013030"
+	r := httptest.NewRequest("POST", "/webhooks/twilio?foo=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mac := hmac.New(sha1.New, []byte("token"))
+	mac.Write([]byte("https://example.com/webhooks/twilio?foo=1Bodyran 5kmFrom+12349013030To+18005551212"))
+	r.Header.Set("X-Twilio-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	if err := v.Verify(r, []byte(body), time.Now()); err != nil {
+		t.Errorf("valid request was rejected: %v", err)
+	}
+	if err := v.Verify(r, []byte(body+"&Extra=1"), time.Now()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("tampered request was not rejected: %v", err)
+	}
+}
+
+func TestSendGridVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &SendGridVerifier{PublicKey: base64.StdEncoding.EncodeToString(der)}
+	now := time.Unix(1600000000, 0)
+	body := `[{"event":"delivered"}]`
+	header := strconv.FormatInt(now.Unix(), 10)
+	hash := sha256.Sum256([]byte(header + body))
+	rs, ss, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{rs, ss})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/webhooks/sendgrid", strings.NewReader(body))
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", header)
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", base64.StdEncoding.EncodeToString(sig))
+
+	if err := v.Verify(r, []byte(body), now); err != nil {
+		t.Errorf("valid request was rejected: %v", err)
+	}
+	if err := v.Verify(r, []byte(body), now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("replayed request was not rejected: %v", err)
+	}
+	if err := v.Verify(r, []byte(`[]`), now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("tampered request was not rejected: %v", err)
+	}
+}
+
+func TestStravaVerifier(t *testing.T) {
+	v := &StravaVerifier{VerifyToken: "token", SubscriptionID: 42}
+
+	r := httptest.NewRequest("GET", "/webhooks/strava?hub.mode=subscribe&hub.verify_token=token", nil)
+	if err := v.Verify(r, nil, time.Now()); err != nil {
+		t.Errorf("valid handshake was rejected: %v", err)
+	}
+	r = httptest.NewRequest("GET", "/webhooks/strava?hub.mode=subscribe&hub.verify_token=wrong", nil)
+	if err := v.Verify(r, nil, time.Now()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("handshake with wrong token was not rejected: %v", err)
+	}
+	r = httptest.NewRequest("POST", "/webhooks/strava", nil)
+	if err := v.Verify(r, []byte(`{"subscription_id": 42}`), time.Now()); err != nil {
+		t.Errorf("valid event was rejected: %v", err)
+	}
+	if err := v.Verify(r, []byte(`{"subscription_id": 7}`), time.Now()); !errors.Is(err, ErrForbidden) {
+		t.Errorf("event of other subscription was not rejected: %v", err)
+	}
+}
+
+func TestVerifyWebhookUnknownIntegration(t *testing.T) {
+	r := httptest.NewRequest("POST", "/webhooks/unknown", strings.NewReader("{}"))
+	if _, err := VerifyWebhook("unknown", r); !errors.Is(err, ErrForbidden) {
+		t.Errorf("request of unknown integration was not rejected: %v", err)
+	}
+}
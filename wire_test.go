@@ -0,0 +1,63 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestWireFormat locks the JSON form of the types that the API returns.
+// If it fails because a field was renamed, removed or changed its type,
+// bump APIVersion and update the expectations. Added fields only need the
+// expectations updated.
+func TestWireFormat(t *testing.T) {
+	if APIVersion != 2 {
+		t.Errorf("APIVersion is %d; update the expectations of TestWireFormat", APIVersion)
+	}
+	target := float32(5)
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{
+			ObjectiveEntry{"fitness", Objective{
+				Name:          "Fitness",
+				Goals:         map[string]Goal{"run": {Name: "Run", Stage: "pledged", Start: 1, End: 2, Target: 10, Unit: "km", Trajectory: Trajectory{{Date: 1, Value: 2}}, Mute: &Mute{Until: 3}}},
+				SchemaVersion: 2,
+			}},
+			`{"id":"fitness","name":"Fitness","goals":{"run":{"name":"Run","stage":"pledged","start":1,"end":2,"target":10,"unit":"km","trajectory":[{"date":1,"value":2}],"mute":{"until":3}}},"schemaVersion":2}`,
+		},
+		{
+			EventEntry{"e", Event{Type: EventGoalSet, Objective: "o", Goal: "g", Value: 1, Date: 2}},
+			`{"id":"e","type":"goal.set","objective":"o","goal":"g","value":1,"date":2}`,
+		},
+		{
+			ConflictEntry{"c", Conflict{Objective: "o", Field: "name", Base: "a", Local: "b", Remote: "c", Created: 1}},
+			`{"id":"c","objective":"o","field":"name","base":"a","local":"b","remote":"c","created":1}`,
+		},
+		{
+			ImportStatus{ID: "i", Source: "fitbit", Synced: 1, Count: 2, Next: 3},
+			`{"id":"i","source":"fitbit","synced":1,"count":2,"next":3}`,
+		},
+		{
+			JobEntry{"j", Job{Kind: "export", User: "u", State: JobSucceeded, Attempts: 1, Progress: 1, Result: Export{"https://example.com", 4}, Created: 1, Updated: 2}},
+			`{"id":"j","kind":"export","user":"u","state":"succeeded","attempts":1,"progress":1,"result":{"url":"https://example.com","expires":4},"created":1,"updated":2}`,
+		},
+		{
+			GoalChange{Target: &target},
+			`{"target":5}`,
+		},
+		{
+			MergeReport{From: "a", Into: "b", Moved: map[string]string{"o": "o"}, Profile: []string{}},
+			`{"from":"a","into":"b","dryRun":false,"moved":{"o":"o"},"profile":[],"documents":0}`,
+		},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%T was encoded as\n%s\nwanted\n%s", tt.v, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package pursuit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// decodeYAML parses the subset of YAML that is needed for specs: block
+// mappings and sequences, flow sequences of scalars, plain and quoted
+// scalars, and comments. Anchors, tags, multi-line scalars and flow
+// mappings are not supported. Mappings are decoded as
+// map[string]interface{}, sequences as []interface{}, and scalars as
+// string, float64, bool or nil.
+func decodeYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{len(text) - len(trimmed), trimmed, i + 1})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	num    int
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isYAMLSeqItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		key, rest, ok := splitYAMLEntry(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+		if rest != "" {
+			v, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", l.num, err)
+			}
+			m[key] = v
+			continue
+		}
+		m[key] = nil
+		if p.pos < len(p.lines) {
+			// Sequences may be indented as deep as their key.
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isYAMLSeqItem(next.text)) {
+				v, err := p.parseBlock(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSeqItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			var v interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			seq = append(seq, v)
+			continue
+		}
+		if _, _, ok := splitYAMLEntry(rest); ok || isYAMLSeqItem(rest) {
+			// The item is a block that starts on the line of the dash,
+			// indented by the column of its first character.
+			col := l.indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{col, rest, l.num}
+			v, err := p.parseBlock(col)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		v, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", l.num, err)
+		}
+		seq = append(seq, v)
+		p.pos++
+	}
+	return seq, nil
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLEntry splits "key: value" into key and value. The value is
+// empty if it follows on the next lines.
+func splitYAMLEntry(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.Index(text[1:], text[:1])
+		if end < 0 || !strings.HasPrefix(text[end+2:], ":") {
+			return "", "", false
+		}
+		rest := text[end+3:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return text[1 : end+1], strings.TrimSpace(rest), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return text[:len(text)-1], "", true
+	}
+	i := strings.Index(text, ": ")
+	if i <= 0 || strings.HasPrefix(text, "[") {
+		return "", "", false
+	}
+	return text[:i], strings.TrimSpace(text[i+2:]), true
+}
+
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return s, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("invalid flow sequence %s", text)
+		}
+		seq := []interface{}{}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return seq, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			v, err := parseYAMLScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	}
+	switch text {
+	case "null", "~":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f, nil
+	}
+	return text, nil
+}
+
+// stripYAMLComment removes a comment that starts with # at the beginning
+// of the line or after a space, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
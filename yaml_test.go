@@ -0,0 +1,48 @@
+package pursuit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	data := `# Goals for 2026
+objectives:
+- id: fitness
+  name: "Fitness: 2026"  # quoted because of the colon
+  tags: [a, 'b c', 3]
+  goals:
+    - id: run
+      target: 1000
+      done: false
+      note: Don't stop # comment
+    -
+      id: swim
+      unit: ~
+`
+
+	v, err := decodeYAML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(v)
+	want := `{"objectives":[{"goals":[{"done":false,"id":"run","note":"Don't stop","target":1000},{"id":"swim","unit":null}],"id":"fitness","name":"Fitness: 2026","tags":["a","b c",3]}]}`
+	if string(b) != want {
+		t.Errorf("decoded\n%s\nwanted\n%s", b, want)
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	for _, data := range []string{
+		"a: 1\n  b: 2\n",
+		"a: 1\na: 2\n",
+		"a:\n\tb: 1\n",
+		"a: 'unterminated\n",
+		"just a line\n",
+	} {
+		if v, err := decodeYAML([]byte(data)); err == nil {
+			t.Errorf("decoding %q returned %v; wanted an error", data, v)
+		}
+	}
+}